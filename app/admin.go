@@ -3,11 +3,17 @@ package app
 import (
 	"cmp"
 	"context"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
 	"slices"
+	"strconv"
 	"strings"
 	"text/tabwriter"
 	"time"
@@ -17,7 +23,23 @@ import (
 	"golang.org/x/exp/maps"
 )
 
-const pingTimeout = 5 * time.Second
+const (
+	defaultPingTimeout = 5 * time.Second
+	maxPingTimeout     = time.Minute
+	defaultAdminPrefix = "/netbird/"
+	nodeStatusTimeout  = 3 * time.Second
+	// defaultMaxConcurrentPings bounds admin-driven dials through the tunnel.
+	defaultMaxConcurrentPings = 16
+
+	icmpv4EchoRequest = 8
+	icmpv6EchoRequest = 128
+	ipProtoICMPv6     = 58
+)
+
+// envAdminPrefix is the environment variable overriding the path prefix of
+// the admin endpoints. Caddy registers admin routes before any config is
+// provisioned, so the prefix can't be an app option.
+const envAdminPrefix = "NETBIRD_ADMIN_PREFIX"
 
 func init() {
 	caddy.RegisterModule(adminAPI{})
@@ -25,7 +47,9 @@ func init() {
 
 // adminAPI serves NetBird status and control endpoints on the Caddy admin API.
 type adminAPI struct {
-	app    *App
+	app *App
+	// prefix is the path prefix the routes were registered under.
+	prefix string
 	logger *zap.Logger
 }
 
@@ -51,24 +75,51 @@ func (a *adminAPI) Provision(ctx caddy.Context) error {
 	return nil
 }
 
-// Routes returns the admin routes for the NetBird endpoints.
+// Routes returns the admin routes for the NetBird endpoints. Caddy calls it
+// on a new module before any config is provisioned, so the prefix is read
+// from the environment rather than the app config.
 func (a *adminAPI) Routes() []caddy.AdminRoute {
+	a.prefix = adminPrefix(os.Getenv(envAdminPrefix))
 	return []caddy.AdminRoute{
 		{
-			Pattern: "/netbird/",
+			Pattern: a.prefix,
 			Handler: caddy.AdminHandlerFunc(a.handleAPI),
 		},
 	}
 }
 
-// handleAPI routes requests to the appropriate handler.
+// adminPrefix returns prefix with a leading and trailing slash, or the
+// default prefix if it is empty.
+func adminPrefix(prefix string) string {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return defaultAdminPrefix
+	}
+	return "/" + prefix + "/"
+}
+
+// handleAPI routes requests to the appropriate handler and writes any
+// handler error as a JSON error envelope.
 func (a *adminAPI) handleAPI(w http.ResponseWriter, r *http.Request) error {
+	if err := a.route(w, r); err != nil {
+		writeError(w, err)
+	}
+	return nil
+}
+
+// route dispatches the request to the handler for its path and method.
+func (a *adminAPI) route(w http.ResponseWriter, r *http.Request) error {
 	if a.app == nil {
-		http.Error(w, "netbird app not configured", http.StatusServiceUnavailable)
-		return nil
+		return caddy.APIError{
+			HTTPStatus: http.StatusServiceUnavailable,
+			Err:        errors.New("netbird app not configured"),
+		}
+	}
+	if err := a.authorize(r); err != nil {
+		return err
 	}
 
-	path := strings.TrimPrefix(r.URL.Path, "/netbird/")
+	path := strings.TrimPrefix(r.URL.Path, cmp.Or(a.prefix, defaultAdminPrefix))
 	switch {
 	case path == "status" && r.Method == http.MethodGet:
 		return a.handleStatus(w, r)
@@ -76,6 +127,30 @@ func (a *adminAPI) handleAPI(w http.ResponseWriter, r *http.Request) error {
 		return a.handleSetLogLevel(w, r)
 	case path == "ping" && r.Method == http.MethodPost:
 		return a.handlePing(w, r)
+	case path == "bench" && r.Method == http.MethodPost:
+		return a.handleBench(w, r)
+	case path == "scan" && r.Method == http.MethodPost:
+		return a.handleScan(w, r)
+	case path == "export" && r.Method == http.MethodGet:
+		return a.handleExport(w, r)
+	case path == "port" && r.Method == http.MethodGet:
+		return a.handlePort(w, r)
+	case path == "diag" && r.Method == http.MethodGet:
+		return a.handleDiag(w, r)
+	case path == "reload" && r.Method == http.MethodPost:
+		return a.handleReload(w, r)
+	case path == "refresh" && r.Method == http.MethodPost:
+		return a.handleRefresh(w, r)
+	case path == "start" && r.Method == http.MethodPost:
+		return a.handleStart(w, r)
+	case path == "events" && r.Method == http.MethodGet:
+		return a.handleEvents(w, r)
+	case path == "history" && r.Method == http.MethodGet:
+		return a.handleHistory(w, r)
+	case path == "topology" && r.Method == http.MethodGet:
+		return a.handleTopology(w, r)
+	case path == "scaling" && r.Method == http.MethodGet:
+		return a.handleScaling(w, r)
 	default:
 		return caddy.APIError{
 			HTTPStatus: http.StatusNotFound,
@@ -91,17 +166,34 @@ type statusResponse struct {
 }
 
 type nodeStatus struct {
+	// Error is set if the node's status could not be collected.
+	Error      string           `json:"error,omitempty"`
 	Local      localStatus      `json:"local"`
 	Management managementStatus `json:"management"`
 	Signal     signalStatus     `json:"signal"`
 	Relays     []relayStatus    `json:"relays"`
 	Peers      []peerStatus     `json:"peers"`
+	// PeersTotal is the number of peers matching the filters, before pagination.
+	PeersTotal int `json:"peersTotal"`
+	// PeerCounts counts all peers of the node by connection type,
+	// regardless of filters and pagination.
+	PeerCounts peerCounts `json:"peerCounts"`
+	// NextOffset is the offset of the next page, unset on the last page.
+	NextOffset *int `json:"nextOffset,omitempty"`
+	// Usage is the approximate resource usage of the connections proxied
+	// through the node by transports and L4 handlers.
+	Usage usageStatus `json:"usage"`
+	// LastError is the last start, stop or connectivity error of the
+	// node's client, if any, even if it has recovered since.
+	LastError *nodeError `json:"lastError,omitempty"`
 }
 
 type localStatus struct {
 	IP     string   `json:"ip"`
 	FQDN   string   `json:"fqdn"`
 	Routes []string `json:"routes,omitempty"`
+	// WireguardPort is the local WireGuard listen port, 0 if not known yet.
+	WireguardPort int `json:"wireguardPort,omitempty"`
 }
 
 type managementStatus struct {
@@ -122,6 +214,12 @@ type relayStatus struct {
 	Error     string `json:"error,omitempty"`
 }
 
+type peerCounts struct {
+	P2P          int `json:"p2p"`
+	Relayed      int `json:"relayed"`
+	Disconnected int `json:"disconnected"`
+}
+
 type peerStatus struct {
 	IP            string        `json:"ip"`
 	FQDN          string        `json:"fqdn"`
@@ -134,103 +232,354 @@ type peerStatus struct {
 	Routes        []string      `json:"routes,omitempty"`
 	RelayAddress  string        `json:"relayAddress,omitempty"`
 	ICELocal      string        `json:"iceLocal,omitempty"`
+	ICELocalType  string        `json:"iceLocalType,omitempty"`
 	ICERemote     string        `json:"iceRemote,omitempty"`
+	ICERemoteType string        `json:"iceRemoteType,omitempty"`
 }
 
 // handleStatus returns the status of all NetBird nodes.
-// Default output is human-readable text; use ?format=json for JSON.
+// Default output is human-readable text; use ?format=json for JSON or
+// ?format=ndjson for one JSON object per node and line.
+// Use ?advertises=<cidr> to only list peers routing the given network.
+// Use ?limit=<n>&offset=<n> to return a page of each node's peers.
+// Use ?verbose=true to add the relay of relayed peers and the ICE
+// candidates of direct peers to the text output.
 func (a *adminAPI) handleStatus(w http.ResponseWriter, r *http.Request) error {
-	resp := a.collectStatus()
+	offset, limit, err := parsePage(r.URL.Query())
+	if err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        err,
+		}
+	}
+	verbose := false
+	if v := r.URL.Query().Get("verbose"); v != "" {
+		if verbose, err = strconv.ParseBool(v); err != nil {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        fmt.Errorf("invalid verbose: %w", err),
+			}
+		}
+	}
 
-	if r.URL.Query().Get("format") == "json" {
+	resp := a.app.collectStatus(r.Context())
+
+	if advertises := r.URL.Query().Get("advertises"); advertises != "" {
+		prefix, err := parsePrefix(advertises)
+		if err != nil {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        fmt.Errorf("invalid advertises filter: %w", err),
+			}
+		}
+		filterPeersByRoute(resp, prefix)
+	}
+
+	paginatePeers(resp, offset, limit)
+
+	switch r.URL.Query().Get("format") {
+	case "json":
 		w.Header().Set("Content-Type", "application/json")
 		return json.NewEncoder(w).Encode(resp)
+	case "ndjson":
+		return writeStatusNDJSON(w, resp)
 	}
 
-	return a.writeStatusText(w, resp)
+	return a.writeStatusText(w, resp, verbose)
 }
 
-func (a *adminAPI) collectStatus() statusResponse {
-	resp := statusResponse{
-		Nodes: make(map[nodeName]*nodeStatus),
-	}
+// nodeStatusLine is a node's status in the NDJSON output, named inline.
+type nodeStatusLine struct {
+	Node nodeName `json:"node"`
+	*nodeStatus
+}
 
-	a.app.pool.Range(func(key, val any) bool {
-		name := key.(string)
-		mc := val.(*ManagedClient)
+// writeStatusNDJSON writes the status of each node, ordered by name, as a
+// JSON object on its own line, flushing after each so consumers can
+// process the nodes as they arrive.
+func writeStatusNDJSON(w http.ResponseWriter, resp statusResponse) error {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
 
-		fullStatus, err := mc.Client().Status()
-		if err != nil {
-			a.logger.Warn("get status", zap.String("node", name), zap.Error(err))
-			return true
+	names := maps.Keys(resp.Nodes)
+	slices.Sort(names)
+	for _, name := range names {
+		if err := enc.Encode(nodeStatusLine{Node: name, nodeStatus: resp.Nodes[name]}); err != nil {
+			return err
+		}
+		if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
 		}
+	}
+	return nil
+}
 
-		localRoutes := sortedKeys(fullStatus.LocalPeerState.Routes)
+// collectStatus gathers the status of all pooled nodes concurrently. A node
+// that doesn't respond within nodeStatusTimeout is reported with an error
+// instead of delaying the whole response.
+func (a *App) collectStatus(ctx context.Context) statusResponse {
+	clients := make(map[nodeName]*ManagedClient)
+	a.rangeNodes(func(name nodeName, mc *ManagedClient) bool {
+		clients[name] = mc
+		return true
+	})
 
-		ns := &nodeStatus{
-			Local: localStatus{
-				IP:     fullStatus.LocalPeerState.IP,
-				FQDN:   fullStatus.LocalPeerState.FQDN,
-				Routes: localRoutes,
-			},
-			Management: managementStatus{
-				URL:       fullStatus.ManagementState.URL,
-				Connected: fullStatus.ManagementState.Connected,
-			},
-			Signal: signalStatus{
-				URL:       fullStatus.SignalState.URL,
-				Connected: fullStatus.SignalState.Connected,
-			},
-		}
+	nodes := collectNodeStatuses(ctx, maps.Keys(clients), nodeStatusTimeout, func(name nodeName) (*nodeStatus, error) {
+		return clients[name].nodeStatus()
+	})
 
-		if fullStatus.ManagementState.Error != nil {
-			ns.Management.Error = fullStatus.ManagementState.Error.Error()
+	for name, ns := range nodes {
+		ns.LastError = clients[name].lastError()
+		if ns.Error != "" {
+			a.logger.Warn("get status", zap.String("node", name), zap.String("error", ns.Error))
+			continue
 		}
-		if fullStatus.SignalState.Error != nil {
-			ns.Signal.Error = fullStatus.SignalState.Error.Error()
+		ns.Usage = a.usageOf(name)
+		ns.PeerCounts = countPeers(ns.Peers)
+	}
+
+	return statusResponse{Nodes: nodes}
+}
+
+// countPeers counts peers by connection type. Peers that aren't connected,
+// including those still connecting, count as disconnected.
+func countPeers(peers []peerStatus) peerCounts {
+	var counts peerCounts
+	for _, p := range peers {
+		switch {
+		case p.ConnStatus != "Connected":
+			counts.Disconnected++
+		case p.Relayed:
+			counts.Relayed++
+		default:
+			counts.P2P++
 		}
+	}
+	return counts
+}
+
+// collectNodeStatuses calls fetch for each node in parallel and waits at most
+// timeout (bounded by ctx) for each result. Failed or timed-out nodes get a
+// status with only the Error field set.
+func collectNodeStatuses(ctx context.Context, names []nodeName, timeout time.Duration, fetch func(nodeName) (*nodeStatus, error)) map[nodeName]*nodeStatus {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 
-		for _, r := range fullStatus.Relays {
-			rs := relayStatus{
-				URI:       r.URI,
-				Available: r.Err == nil,
+	type result struct {
+		name nodeName
+		ns   *nodeStatus
+		err  error
+	}
+
+	// Buffered so goroutines of timed-out nodes can finish without a receiver.
+	results := make(chan result, len(names))
+	for _, name := range names {
+		go func() {
+			ns, err := fetch(name)
+			results <- result{name: name, ns: ns, err: err}
+		}()
+	}
+
+	nodes := make(map[nodeName]*nodeStatus, len(names))
+	for range names {
+		select {
+		case res := <-results:
+			if res.err != nil {
+				nodes[res.name] = &nodeStatus{Error: res.err.Error()}
+			} else {
+				nodes[res.name] = res.ns
 			}
-			if r.Err != nil {
-				rs.Error = r.Err.Error()
+		case <-ctx.Done():
+			for _, name := range names {
+				if _, ok := nodes[name]; !ok {
+					nodes[name] = &nodeStatus{Error: fmt.Sprintf("status collection timed out: %v", ctx.Err())}
+				}
 			}
-			ns.Relays = append(ns.Relays, rs)
-		}
-
-		for _, p := range fullStatus.Peers {
-			ns.Peers = append(ns.Peers, peerStatus{
-				IP:            p.IP,
-				FQDN:          p.FQDN,
-				ConnStatus:    p.ConnStatus.String(),
-				Relayed:       p.Relayed,
-				Latency:       p.Latency,
-				LastHandshake: p.LastWireguardHandshake,
-				BytesTx:       p.BytesTx,
-				BytesRx:       p.BytesRx,
-				Routes:        sortedKeys(p.GetRoutes()),
-				RelayAddress:  p.RelayServerAddress,
-				ICELocal:      p.LocalIceCandidateEndpoint,
-				ICERemote:     p.RemoteIceCandidateEndpoint,
-			})
-		}
-
-		slices.SortFunc(ns.Peers, func(a, b peerStatus) int {
-			return cmp.Compare(a.FQDN, b.FQDN)
+			return nodes
+		}
+	}
+	return nodes
+}
+
+// nodeStatus converts the client's full status into the admin API
+// representation. The status is shared with other callers for up to the
+// status cache TTL.
+func (mc *ManagedClient) nodeStatus() (*nodeStatus, error) {
+	fullStatus, err := cachedStatus(&mc.status, mc.statusMaxAge, time.Now(), mc.Client().Status)
+	if err != nil {
+		return nil, err
+	}
+
+	localRoutes := sortedKeys(fullStatus.LocalPeerState.Routes)
+
+	ns := &nodeStatus{
+		Local: localStatus{
+			IP:     fullStatus.LocalPeerState.IP,
+			FQDN:   fullStatus.LocalPeerState.FQDN,
+			Routes: localRoutes,
+		},
+		Management: managementStatus{
+			URL:       fullStatus.ManagementState.URL,
+			Connected: fullStatus.ManagementState.Connected,
+		},
+		Signal: signalStatus{
+			URL:       fullStatus.SignalState.URL,
+			Connected: fullStatus.SignalState.Connected,
+		},
+	}
+
+	if fullStatus.ManagementState.Error != nil {
+		ns.Management.Error = fullStatus.ManagementState.Error.Error()
+	}
+	if fullStatus.SignalState.Error != nil {
+		ns.Signal.Error = fullStatus.SignalState.Error.Error()
+	}
+
+	for _, r := range fullStatus.Relays {
+		rs := relayStatus{
+			URI:       r.URI,
+			Available: r.Err == nil,
+		}
+		if r.Err != nil {
+			rs.Error = r.Err.Error()
+		}
+		ns.Relays = append(ns.Relays, rs)
+	}
+
+	for _, p := range fullStatus.Peers {
+		ns.Peers = append(ns.Peers, peerStatus{
+			IP:            p.IP,
+			FQDN:          p.FQDN,
+			ConnStatus:    p.ConnStatus.String(),
+			Relayed:       p.Relayed,
+			Latency:       p.Latency,
+			LastHandshake: p.LastWireguardHandshake,
+			BytesTx:       p.BytesTx,
+			BytesRx:       p.BytesRx,
+			Routes:        sortedKeys(p.GetRoutes()),
+			RelayAddress:  p.RelayServerAddress,
+			ICELocal:      p.LocalIceCandidateEndpoint,
+			ICELocalType:  p.LocalIceCandidateType,
+			ICERemote:     p.RemoteIceCandidateEndpoint,
+			ICERemoteType: p.RemoteIceCandidateType,
 		})
+	}
 
-		resp.Nodes[name] = ns
-		return true
+	slices.SortFunc(ns.Peers, func(a, b peerStatus) int {
+		return cmp.Compare(a.FQDN, b.FQDN)
 	})
 
-	return resp
+	var configuredPort int
+	if cfg, err := mc.Client().GetConfig(); err == nil {
+		configuredPort = cfg.WgPort
+	}
+	ns.Local.WireguardPort = wireguardPort(configuredPort, ns.Peers)
+
+	return ns, nil
+}
+
+// wireguardPort determines the local WireGuard listen port. The configured
+// port is only a preference: it is 0 for a random port and NetBird picks
+// another one if it is taken. ICE host candidates share the WireGuard
+// socket, so their port is preferred when a peer is connected via one.
+func wireguardPort(configured int, peers []peerStatus) int {
+	for _, p := range peers {
+		if p.ICELocalType != "host" || p.ICELocal == "" {
+			continue
+		}
+		addrPort, err := netip.ParseAddrPort(p.ICELocal)
+		if err != nil {
+			continue
+		}
+		return int(addrPort.Port())
+	}
+	return configured
+}
+
+// parsePrefix parses a CIDR, treating a bare IP address as a single-host prefix.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return prefix.Masked(), nil
+}
+
+// filterPeersByRoute drops peers that don't advertise a route covering prefix.
+func filterPeersByRoute(resp statusResponse, prefix netip.Prefix) {
+	for _, ns := range resp.Nodes {
+		ns.Peers = slices.DeleteFunc(ns.Peers, func(p peerStatus) bool {
+			return !routesContain(p.Routes, prefix)
+		})
+	}
+}
+
+// routesContain reports whether any route fully contains prefix.
+// Routes that are not CIDRs (e.g. domain routes) are ignored.
+func routesContain(routes []string, prefix netip.Prefix) bool {
+	for _, route := range routes {
+		routePrefix, err := netip.ParsePrefix(route)
+		if err != nil {
+			continue
+		}
+		if routePrefix.Bits() <= prefix.Bits() && routePrefix.Contains(prefix.Addr()) {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePage parses the offset and limit query parameters. A limit of 0 means
+// no limit.
+func parsePage(q url.Values) (offset, limit int, err error) {
+	if v := q.Get("offset"); v != "" {
+		offset, err = strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("invalid offset %q: must be a non-negative integer", v)
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			return 0, 0, fmt.Errorf("invalid limit %q: must be a positive integer", v)
+		}
+	}
+	return offset, limit, nil
+}
+
+// paginatePeers records the peer count of each node and trims its peers to
+// the requested page. Peers are already sorted, so pages are stable.
+func paginatePeers(resp statusResponse, offset, limit int) {
+	for _, ns := range resp.Nodes {
+		ns.PeersTotal = len(ns.Peers)
+		start, end, next := pageBounds(ns.PeersTotal, offset, limit)
+		ns.Peers = ns.Peers[start:end]
+		if next > 0 {
+			ns.NextOffset = &next
+		}
+	}
+}
+
+// pageBounds returns the slice bounds of the page at offset with at most
+// limit items, and the offset of the next page, or 0 if there is none.
+func pageBounds(total, offset, limit int) (start, end, next int) {
+	start = min(offset, total)
+	end = total
+	if limit > 0 && limit < total-start {
+		end = start + limit
+	}
+	if end < total {
+		next = end
+	}
+	return start, end, next
 }
 
 // writeStatusText writes a human-readable status output similar to `netbird status`.
-func (a *adminAPI) writeStatusText(w http.ResponseWriter, resp statusResponse) error {
+func (a *adminAPI) writeStatusText(w http.ResponseWriter, resp statusResponse, verbose bool) error {
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 
 	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
@@ -241,13 +590,28 @@ func (a *adminAPI) writeStatusText(w http.ResponseWriter, resp statusResponse) e
 	for _, name := range names {
 		ns := resp.Nodes[name]
 		fmt.Fprintf(tw, "Node: %s\n", name)
+		if ns.LastError != nil {
+			fmt.Fprintf(tw, "  Last error:\t%s (%s ago)\n", ns.LastError.Message,
+				time.Since(ns.LastError.Time).Round(time.Second))
+		}
+		if ns.Error != "" {
+			fmt.Fprintf(tw, "  Error:\t%s\n\n", ns.Error)
+			continue
+		}
 		fmt.Fprintf(tw, "  NetBird IP:\t%s\n", ns.Local.IP)
 		fmt.Fprintf(tw, "  FQDN:\t%s\n", ns.Local.FQDN)
+		if ns.Local.WireguardPort != 0 {
+			fmt.Fprintf(tw, "  WireGuard port:\t%d\n", ns.Local.WireguardPort)
+		}
 		if len(ns.Local.Routes) > 0 {
 			fmt.Fprintf(tw, "  Routes:\t%s\n", strings.Join(ns.Local.Routes, ", "))
 		}
 		fmt.Fprintf(tw, "  Management:\t%s\t%s\n", ns.Management.URL, connectedStr(ns.Management.Connected))
 		fmt.Fprintf(tw, "  Signal:\t%s\t%s\n", ns.Signal.URL, connectedStr(ns.Signal.Connected))
+		fmt.Fprintf(tw, "  Connections:\t%d (%d goroutines, %s buffers)\n",
+			ns.Usage.ActiveConns, ns.Usage.Goroutines, formatBytes(ns.Usage.BufferBytes))
+		fmt.Fprintf(tw, "  Peer summary:\t%d P2P, %d relayed, %d disconnected\n",
+			ns.PeerCounts.P2P, ns.PeerCounts.Relayed, ns.PeerCounts.Disconnected)
 
 		for _, r := range ns.Relays {
 			status := "Available"
@@ -258,46 +622,106 @@ func (a *adminAPI) writeStatusText(w http.ResponseWriter, resp statusResponse) e
 		}
 
 		fmt.Fprintln(tw)
-		fmt.Fprintf(tw, "  Peers (%d):\n", len(ns.Peers))
-		fmt.Fprintf(tw, "  FQDN\tIP\tStatus\tLatency\tTransfer\tConn\tHandshake\tRoutes\n")
-		fmt.Fprintf(tw, "  ----\t--\t------\t-------\t--------\t----\t---------\t------\n")
+		if ns.PeersTotal > len(ns.Peers) {
+			fmt.Fprintf(tw, "  Peers (%d of %d):\n", len(ns.Peers), ns.PeersTotal)
+		} else {
+			fmt.Fprintf(tw, "  Peers (%d):\n", len(ns.Peers))
+		}
+		writeTableRow(tw, peerColumns(verbose))
+		writeTableRow(tw, underline(peerColumns(verbose)))
 
 		for _, p := range ns.Peers {
-			connType := "P2P"
-			if p.Relayed {
-				connType = "Relayed"
-			}
-			if p.ConnStatus != "Connected" {
-				connType = "-"
-			}
+			writeTableRow(tw, peerRow(p, verbose))
+		}
+		fmt.Fprintln(tw)
+	}
 
-			latency := "-"
-			if p.Latency > 0 {
-				latency = p.Latency.Round(time.Microsecond).String()
-			}
+	return tw.Flush()
+}
 
-			transfer := "-"
-			if p.BytesTx > 0 || p.BytesRx > 0 {
-				transfer = fmt.Sprintf("%s/%s", formatBytes(p.BytesRx), formatBytes(p.BytesTx))
-			}
+// peerColumns returns the column names of the peer table. Verbose output
+// adds the relay and the ICE candidates of each peer.
+func peerColumns(verbose bool) []string {
+	cols := []string{"FQDN", "IP", "Status", "Latency", "Transfer", "Conn"}
+	if verbose {
+		cols = append(cols, "Relay", "ICE local", "ICE remote")
+	}
+	return append(cols, "Handshake", "Routes")
+}
 
-			handshake := "-"
-			if !p.LastHandshake.IsZero() {
-				handshake = time.Since(p.LastHandshake).Round(time.Second).String() + " ago"
-			}
+// peerRow returns the cells of a peer in the order of peerColumns.
+func peerRow(p peerStatus, verbose bool) []string {
+	connected := p.ConnStatus == "Connected"
 
-			routes := "-"
-			if len(p.Routes) > 0 {
-				routes = strings.Join(p.Routes, ", ")
-			}
+	connType := "P2P"
+	if p.Relayed {
+		connType = "Relayed"
+	}
+	if !connected {
+		connType = "-"
+	}
+
+	latency := "-"
+	if p.Latency > 0 {
+		latency = p.Latency.Round(time.Microsecond).String()
+	}
 
-			fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
-				p.FQDN, p.IP, p.ConnStatus, latency, transfer, connType, handshake, routes)
+	transfer := "-"
+	if p.BytesTx > 0 || p.BytesRx > 0 {
+		transfer = fmt.Sprintf("%s/%s", formatBytes(p.BytesRx), formatBytes(p.BytesTx))
+	}
+
+	handshake := "-"
+	if !p.LastHandshake.IsZero() {
+		handshake = time.Since(p.LastHandshake).Round(time.Second).String() + " ago"
+	}
+
+	routes := "-"
+	if len(p.Routes) > 0 {
+		routes = strings.Join(p.Routes, ", ")
+	}
+
+	row := []string{p.FQDN, p.IP, p.ConnStatus, latency, transfer, connType}
+	if verbose {
+		relay := "-"
+		if p.Relayed && connected && p.RelayAddress != "" {
+			relay = p.RelayAddress
 		}
-		fmt.Fprintln(tw)
+		iceLocal, iceRemote := "-", "-"
+		if connected && !p.Relayed {
+			iceLocal = iceCandidate(p.ICELocal, p.ICELocalType)
+			iceRemote = iceCandidate(p.ICERemote, p.ICERemoteType)
+		}
+		row = append(row, relay, iceLocal, iceRemote)
 	}
+	return append(row, handshake, routes)
+}
 
-	return tw.Flush()
+// iceCandidate formats an ICE candidate endpoint with its type, e.g.
+// "203.0.113.5:51820 (srflx)", or "-" if unknown.
+func iceCandidate(endpoint, typ string) string {
+	switch {
+	case endpoint == "":
+		return "-"
+	case typ == "":
+		return endpoint
+	default:
+		return endpoint + " (" + typ + ")"
+	}
+}
+
+// underline returns a row of dashes as wide as each of cols.
+func underline(cols []string) []string {
+	dashes := make([]string, len(cols))
+	for i, col := range cols {
+		dashes[i] = strings.Repeat("-", len(col))
+	}
+	return dashes
+}
+
+// writeTableRow writes the cells of an indented tabwriter row.
+func writeTableRow(tw *tabwriter.Writer, cells []string) {
+	fmt.Fprintf(tw, "  %s\n", strings.Join(cells, "\t"))
 }
 
 func connectedStr(connected bool) string {
@@ -326,6 +750,45 @@ func formatBytes(b int64) string {
 	}
 }
 
+type portResponse struct {
+	Node string `json:"node"`
+	Port int    `json:"port"`
+}
+
+// handlePort returns the WireGuard listen port of a node, which is useful
+// to open the right UDP port when the port is assigned randomly.
+func (a *adminAPI) handlePort(w http.ResponseWriter, r *http.Request) error {
+	name := r.URL.Query().Get("node")
+	if name == "" {
+		name = a.app.DefaultNodeName()
+	}
+
+	mc, ok := a.app.lookupClient(name)
+	if !ok {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("node %q not found", name),
+		}
+	}
+
+	ns, err := mc.nodeStatus()
+	if err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusInternalServerError,
+			Err:        fmt.Errorf("get status of node %q: %w", name, err),
+		}
+	}
+	if ns.Local.WireguardPort == 0 {
+		return caddy.APIError{
+			HTTPStatus: http.StatusServiceUnavailable,
+			Err:        fmt.Errorf("wireguard port of node %q not known yet", name),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(portResponse{Node: name, Port: ns.Local.WireguardPort})
+}
+
 type logLevelRequest struct {
 	Level string `json:"level"`
 }
@@ -341,10 +804,10 @@ func (a *adminAPI) handleSetLogLevel(w http.ResponseWriter, r *http.Request) err
 	}
 
 	var errs []error
-	a.app.pool.Range(func(key, val any) bool {
+	a.app.pool.Range(func(_, val any) bool {
 		mc := val.(*ManagedClient)
 		if err := mc.Client().SetLogLevel(req.Level); err != nil {
-			errs = append(errs, fmt.Errorf("node %s: %w", key, err))
+			errs = append(errs, fmt.Errorf("node %s: %w", mc.name, err))
 		}
 		return true
 	})
@@ -368,12 +831,32 @@ type pingRequest struct {
 	Address string `json:"address"`
 	// Network is "tcp", "udp", or "ping" (ICMP). Default: "tcp".
 	Network string `json:"network,omitempty"`
+	// Timeout overrides the configured ping timeout, e.g. "10s".
+	// Capped at one minute.
+	Timeout caddy.Duration `json:"timeout,omitempty"`
+	// Probe is sent to UDP targets, which must answer with any datagram
+	// for the ping to succeed. Without it, UDP pings succeed once the
+	// socket is set up, as a UDP dial doesn't contact the target.
+	Probe string `json:"probe,omitempty"`
 }
 
 type pingResponse struct {
 	Reachable bool          `json:"reachable"`
 	Latency   time.Duration `json:"latency"`
 	Error     string        `json:"error,omitempty"`
+	// Peer is set if the target is a known peer of the node.
+	Peer *pingPeer `json:"peer,omitempty"`
+}
+
+// pingPeer describes the peer a ping target belongs to, as seen in the
+// node's status after the ping.
+type pingPeer struct {
+	FQDN       string `json:"fqdn"`
+	IP         string `json:"ip"`
+	ConnStatus string `json:"connStatus"`
+	// ConnType is "P2P" or "Relayed" for connected peers.
+	ConnType string        `json:"connType,omitempty"`
+	Latency  time.Duration `json:"latency"`
 }
 
 // handlePing performs a TCP, UDP, or ICMP ping through the NetBird network and measures RTT.
@@ -393,11 +876,17 @@ func (a *adminAPI) handlePing(w http.ResponseWriter, r *http.Request) error {
 		}
 	}
 	if req.Node == "" {
-		req.Node = "default"
+		req.Node = a.app.DefaultNodeName()
 	}
 	if req.Network == "" {
 		req.Network = "tcp"
 	}
+	if req.Timeout < 0 {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        fmt.Errorf("timeout must not be negative"),
+		}
+	}
 
 	switch req.Network {
 	case "tcp", "udp", "ping":
@@ -407,8 +896,14 @@ func (a *adminAPI) handlePing(w http.ResponseWriter, r *http.Request) error {
 			Err:        fmt.Errorf("unsupported network %q: use tcp, udp, or ping", req.Network),
 		}
 	}
+	if req.Probe != "" && req.Network != "udp" {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        fmt.Errorf("probe is only supported for udp pings"),
+		}
+	}
 
-	mc, ok := a.app.LookupClient(req.Node)
+	mc, ok := a.app.lookupClient(req.Node)
 	if !ok {
 		return caddy.APIError{
 			HTTPStatus: http.StatusNotFound,
@@ -416,56 +911,165 @@ func (a *adminAPI) handlePing(w http.ResponseWriter, r *http.Request) error {
 		}
 	}
 
-	ctx, cancel := context.WithTimeout(r.Context(), pingTimeout)
+	release, ok := a.app.acquirePing()
+	if !ok {
+		return caddy.APIError{
+			HTTPStatus: http.StatusTooManyRequests,
+			Err:        errors.New("too many concurrent pings"),
+		}
+	}
+	defer release()
+
+	timeout := a.requestPingTimeout(time.Duration(req.Timeout))
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
 	defer cancel()
 
 	var resp pingResponse
 	if req.Network == "ping" {
-		resp = a.doPingICMP(ctx, mc, req.Address)
+		resp = a.doPingICMP(ctx, mc, req.Address, timeout)
 	} else {
-		resp = a.doPingDial(ctx, mc, req.Network, req.Address)
+		resp = a.doPingDial(ctx, mc.Client().DialContext, req.Network, req.Address, req.Probe, timeout)
+	}
+
+	if ns, err := mc.nodeStatus(); err == nil {
+		resp.Peer = matchPeer(ns.Peers, req.Address)
+	} else {
+		a.logger.Debug("get status for ping peer info", zap.Error(err))
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	return json.NewEncoder(w).Encode(resp)
 }
 
-// doPingDial measures RTT via a TCP or UDP dial.
-func (a *adminAPI) doPingDial(ctx context.Context, mc *ManagedClient, network, address string) pingResponse {
+// matchPeer returns the peer whose IP or FQDN is the host of address, or
+// nil if the target is not a known peer.
+func matchPeer(peers []peerStatus, address string) *pingPeer {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	for _, p := range peers {
+		if host != p.IP && host != strings.ToLower(strings.TrimSuffix(p.FQDN, ".")) {
+			continue
+		}
+		info := &pingPeer{
+			FQDN:       p.FQDN,
+			IP:         p.IP,
+			ConnStatus: p.ConnStatus,
+			Latency:    p.Latency,
+		}
+		if p.ConnStatus == "Connected" {
+			info.ConnType = "P2P"
+			if p.Relayed {
+				info.ConnType = "Relayed"
+			}
+		}
+		return info
+	}
+	return nil
+}
+
+// acquirePing takes a slot for a ping operation without waiting. It reports
+// false if MaxConcurrentPings operations are already in flight.
+func (a *App) acquirePing() (release func(), ok bool) {
+	if a.pingSlots == nil {
+		// Not provisioned.
+		return func() {}, true
+	}
+
+	select {
+	case a.pingSlots <- struct{}{}:
+		return func() { <-a.pingSlots }, true
+	default:
+		return nil, false
+	}
+}
+
+// pingTimeout returns the configured ping timeout or the default.
+func (a *adminAPI) pingTimeout() time.Duration {
+	if a.app != nil && a.app.PingTimeout > 0 {
+		return time.Duration(a.app.PingTimeout)
+	}
+	return defaultPingTimeout
+}
+
+// requestPingTimeout returns the timeout for a ping request. A positive
+// requested value takes precedence over the configured default and is
+// clamped to maxPingTimeout.
+func (a *adminAPI) requestPingTimeout(requested time.Duration) time.Duration {
+	if requested <= 0 {
+		return a.pingTimeout()
+	}
+	return min(requested, maxPingTimeout)
+}
+
+// pingDeadline returns the deadline of ctx, or now plus fallback if ctx has none.
+// All reads and writes of a ping share this deadline so the whole operation
+// is bounded by the ping timeout.
+func pingDeadline(ctx context.Context, now time.Time, fallback time.Duration) time.Time {
+	if deadline, ok := ctx.Deadline(); ok {
+		return deadline
+	}
+	return now.Add(fallback)
+}
+
+// pingDialFunc dials a ping target, e.g. through a node's client.
+type pingDialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// doPingDial measures RTT via a TCP or UDP dial. A TCP dial completes the
+// handshake with the target. A UDP dial only sets up the socket, so with a
+// probe it is sent and the RTT of the first response is measured instead.
+func (a *adminAPI) doPingDial(ctx context.Context, dial pingDialFunc, network, address, probe string, timeout time.Duration) pingResponse {
 	start := time.Now()
-	conn, err := mc.Client().DialContext(ctx, network, address)
+	conn, err := dial(ctx, network, address)
 	latency := time.Since(start)
 
 	if err != nil {
 		return pingResponse{Error: err.Error()}
 	}
-	conn.Close()
+	defer conn.Close()
 
-	return pingResponse{Reachable: true, Latency: latency}
+	if probe == "" {
+		return pingResponse{Reachable: true, Latency: latency}
+	}
+
+	start = time.Now()
+	if err := conn.SetDeadline(pingDeadline(ctx, start, timeout)); err != nil {
+		return pingResponse{Error: fmt.Sprintf("set deadline: %v", err)}
+	}
+	if _, err := conn.Write([]byte(probe)); err != nil {
+		return pingResponse{Error: fmt.Sprintf("write probe: %v", err)}
+	}
+	buf := make([]byte, 1500)
+	if _, err := conn.Read(buf); err != nil {
+		return pingResponse{Error: fmt.Sprintf("read probe response: %v", err)}
+	}
+	return pingResponse{Reachable: true, Latency: time.Since(start)}
 }
 
 // doPingICMP sends an ICMP echo request through the NetBird network using the "ping" network type.
-func (a *adminAPI) doPingICMP(ctx context.Context, mc *ManagedClient, address string) pingResponse {
+func (a *adminAPI) doPingICMP(ctx context.Context, mc *ManagedClient, address string, timeout time.Duration) pingResponse {
 	conn, err := mc.Client().DialContext(ctx, "ping", address)
 	if err != nil {
 		return pingResponse{Error: err.Error()}
 	}
 	defer conn.Close()
 
-	icmpReq := make([]byte, 8)
-	icmpReq[0] = 8
-	icmpReq[2], icmpReq[3] = icmpChecksum(icmpReq)
+	icmpReq := echoRequest(netAddrIP(conn.LocalAddr()), netAddrIP(conn.RemoteAddr()))
 
 	start := time.Now()
 
+	if err := conn.SetDeadline(pingDeadline(ctx, start, timeout)); err != nil {
+		return pingResponse{Error: fmt.Sprintf("set deadline: %v", err)}
+	}
+
 	if _, err := conn.Write(icmpReq); err != nil {
 		return pingResponse{Error: fmt.Sprintf("write echo request: %v", err)}
 	}
 
 	buf := make([]byte, 1500)
-	if err := conn.SetReadDeadline(start.Add(pingTimeout)); err != nil {
-		return pingResponse{Error: fmt.Sprintf("set read deadline: %v", err)}
-	}
 	if _, err := conn.Read(buf); err != nil {
 		return pingResponse{Error: fmt.Sprintf("read echo reply: %v", err)}
 	}
@@ -474,6 +1078,43 @@ func (a *adminAPI) doPingICMP(ctx context.Context, mc *ManagedClient, address st
 	return pingResponse{Reachable: true, Latency: latency}
 }
 
+// echoRequest builds an ICMP echo request for the address family of dst:
+// ICMPv4 for IPv4 and ICMPv6 for IPv6 targets.
+func echoRequest(src, dst netip.Addr) []byte {
+	req := make([]byte, 8)
+	if !dst.Is6() || dst.Is4In6() {
+		req[0] = icmpv4EchoRequest
+		req[2], req[3] = icmpChecksum(req)
+		return req
+	}
+
+	req[0] = icmpv6EchoRequest
+	req[2], req[3] = icmpChecksum(append(icmpv6PseudoHeader(src, dst, len(req)), req...))
+	return req
+}
+
+// icmpv6PseudoHeader returns the IPv6 pseudo-header covered by ICMPv6
+// checksums (RFC 4443, section 2.3).
+func icmpv6PseudoHeader(src, dst netip.Addr, length int) []byte {
+	header := make([]byte, 0, 40)
+	header = append(header, src.AsSlice()...)
+	header = append(header, dst.AsSlice()...)
+	header = binary.BigEndian.AppendUint32(header, uint32(length))
+	return append(header, 0, 0, 0, ipProtoICMPv6)
+}
+
+// netAddrIP returns the IP of a net.Addr, or the zero Addr if it has none.
+func netAddrIP(addr net.Addr) netip.Addr {
+	if addr == nil {
+		return netip.Addr{}
+	}
+	ip, err := netip.ParseAddr(addr.String())
+	if err != nil {
+		return netip.Addr{}
+	}
+	return ip
+}
+
 // icmpChecksum computes the ICMP checksum per RFC 1071.
 func icmpChecksum(data []byte) (byte, byte) {
 	var sum uint32
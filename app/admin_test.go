@@ -1,9 +1,23 @@
 package app
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
 )
 
 func TestFormatBytes(t *testing.T) {
@@ -81,3 +95,723 @@ func TestSortedKeys(t *testing.T) {
 func TestSortedKeys_Empty(t *testing.T) {
 	assert.Empty(t, sortedKeys(nil))
 }
+
+func TestRoutes_BeforeProvision(t *testing.T) {
+	routes := new(adminAPI).Routes()
+	require.Len(t, routes, 1)
+	assert.Equal(t, "/netbird/", routes[0].Pattern, "caddy registers routes before provisioning")
+}
+
+func TestLoad_AdminRoutes(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	require.NoError(t, ln.Close())
+
+	cfg := `{
+		"admin": {"listen": "` + addr + `", "config": {"persist": false}},
+		"apps": {"netbird": {}}
+	}`
+	require.NoError(t, caddy.Load([]byte(cfg), true))
+	t.Cleanup(func() { _ = caddy.Stop() })
+
+	resp, err := http.Get("http://" + addr + "/netbird/status?format=json")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestAdminPrefix(t *testing.T) {
+	assert.Equal(t, "/netbird/", adminPrefix(""))
+	assert.Equal(t, "/netbird/", adminPrefix("/"))
+	assert.Equal(t, "/nb/", adminPrefix("nb"))
+	assert.Equal(t, "/nb/", adminPrefix("/nb/"))
+	assert.Equal(t, "/tools/nb/", adminPrefix("/tools/nb"))
+}
+
+func TestLoad_AdminRoutesCustomPrefix(t *testing.T) {
+	t.Setenv(envAdminPrefix, "/nb/")
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	require.NoError(t, ln.Close())
+
+	cfg := `{
+		"admin": {"listen": "` + addr + `", "config": {"persist": false}},
+		"apps": {"netbird": {}}
+	}`
+	require.NoError(t, caddy.Load([]byte(cfg), true))
+	t.Cleanup(func() { _ = caddy.Stop() })
+
+	resp, err := http.Get("http://" + addr + "/nb/status?format=json")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = http.Get("http://" + addr + "/netbird/status?format=json")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "the default prefix is replaced")
+}
+
+func TestPingTimeout(t *testing.T) {
+	api := &adminAPI{}
+	assert.Equal(t, defaultPingTimeout, api.pingTimeout(), "no app uses default")
+
+	api.app = &App{}
+	assert.Equal(t, defaultPingTimeout, api.pingTimeout(), "unset option uses default")
+
+	api.app.PingTimeout = caddy.Duration(30 * time.Second)
+	assert.Equal(t, 30*time.Second, api.pingTimeout())
+}
+
+func TestPingDeadline(t *testing.T) {
+	now := time.Now()
+
+	t.Run("uses context deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		want, _ := ctx.Deadline()
+		assert.Equal(t, want, pingDeadline(ctx, now, time.Minute))
+	})
+
+	t.Run("falls back without context deadline", func(t *testing.T) {
+		assert.Equal(t, now.Add(time.Minute), pingDeadline(context.Background(), now, time.Minute))
+	})
+}
+
+func TestRequestPingTimeout(t *testing.T) {
+	api := &adminAPI{app: &App{PingTimeout: caddy.Duration(10 * time.Second)}}
+
+	tests := []struct {
+		name      string
+		requested time.Duration
+		want      time.Duration
+	}{
+		{"unset uses app default", 0, 10 * time.Second},
+		{"request takes precedence", 2 * time.Second, 2 * time.Second},
+		{"request above app default", 30 * time.Second, 30 * time.Second},
+		{"request clamped to max", time.Hour, maxPingTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, api.requestPingTimeout(tt.requested))
+		})
+	}
+}
+
+func TestPingRequest_DecodeTimeout(t *testing.T) {
+	var req pingRequest
+	require.NoError(t, json.Unmarshal([]byte(`{"address":"10.0.0.1:22","timeout":"15s"}`), &req))
+	assert.Equal(t, 15*time.Second, time.Duration(req.Timeout))
+}
+
+func TestHandlePing_NegativeTimeout(t *testing.T) {
+	api := &adminAPI{app: &App{pool: caddy.NewUsagePool()}}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/netbird/ping",
+		strings.NewReader(`{"address":"10.0.0.1:22","timeout":"-1s"}`))
+
+	err := api.handlePing(rec, req)
+	var apiErr caddy.APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusBadRequest, apiErr.HTTPStatus)
+}
+
+func TestHandlePing_ProbeRequiresUDP(t *testing.T) {
+	api := &adminAPI{app: &App{pool: caddy.NewUsagePool()}}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/netbird/ping",
+		strings.NewReader(`{"address":"10.0.0.1:22","network":"tcp","probe":"hello"}`))
+
+	err := api.handlePing(rec, req)
+	var apiErr caddy.APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusBadRequest, apiErr.HTTPStatus)
+}
+
+func TestDoPingDial(t *testing.T) {
+	api := &adminAPI{}
+	var d net.Dialer
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	// A port nothing listens on, for TCP and UDP alike.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closed := ln.Addr().String()
+	require.NoError(t, ln.Close())
+
+	echo, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := echo.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = echo.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer silent.Close()
+
+	t.Run("tcp fails without listener", func(t *testing.T) {
+		resp := api.doPingDial(ctx, d.DialContext, "tcp", closed, "", time.Second)
+		assert.False(t, resp.Reachable)
+		assert.NotEmpty(t, resp.Error)
+	})
+
+	t.Run("udp without probe succeeds without listener", func(t *testing.T) {
+		resp := api.doPingDial(ctx, d.DialContext, "udp", closed, "", time.Second)
+		assert.True(t, resp.Reachable, "a udp dial doesn't contact the target")
+	})
+
+	t.Run("udp probe answered", func(t *testing.T) {
+		resp := api.doPingDial(ctx, d.DialContext, "udp", echo.LocalAddr().String(), "ping", time.Second)
+		assert.True(t, resp.Reachable)
+		assert.Empty(t, resp.Error)
+		assert.Positive(t, resp.Latency)
+	})
+
+	t.Run("udp probe unanswered", func(t *testing.T) {
+		shortCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+
+		resp := api.doPingDial(shortCtx, d.DialContext, "udp", silent.LocalAddr().String(), "ping", time.Second)
+		assert.False(t, resp.Reachable)
+		assert.Contains(t, resp.Error, "read probe response")
+	})
+}
+
+func TestParsePrefix(t *testing.T) {
+	prefix, err := parsePrefix("10.1.2.3/8")
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.0/8", prefix.String())
+
+	prefix, err = parsePrefix("10.1.2.3")
+	require.NoError(t, err)
+	assert.Equal(t, "10.1.2.3/32", prefix.String())
+
+	_, err = parsePrefix("not-a-cidr")
+	require.Error(t, err)
+}
+
+func TestRoutesContain(t *testing.T) {
+	tests := []struct {
+		name   string
+		routes []string
+		query  string
+		want   bool
+	}{
+		{"exact match", []string{"10.0.0.0/8"}, "10.0.0.0/8", true},
+		{"route covers narrower query", []string{"10.0.0.0/8"}, "10.1.2.0/24", true},
+		{"route covers host", []string{"192.168.1.0/24"}, "192.168.1.50/32", true},
+		{"narrower route does not cover wider query", []string{"10.1.0.0/16"}, "10.0.0.0/8", false},
+		{"disjoint route", []string{"172.16.0.0/12"}, "10.0.0.0/8", false},
+		{"one of several overlapping routes", []string{"10.2.0.0/16", "10.1.0.0/16"}, "10.1.5.0/24", true},
+		{"domain routes ignored", []string{"example.com"}, "10.0.0.0/8", false},
+		{"ipv6", []string{"fd00::/8"}, "fd00:1::/32", true},
+		{"no routes", nil, "10.0.0.0/8", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefix, err := parsePrefix(tt.query)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, routesContain(tt.routes, prefix))
+		})
+	}
+}
+
+func TestFilterPeersByRoute(t *testing.T) {
+	resp := statusResponse{Nodes: map[nodeName]*nodeStatus{
+		"web": {Peers: []peerStatus{
+			{FQDN: "wide.netbird.cloud", Routes: []string{"10.0.0.0/8"}},
+			{FQDN: "narrow.netbird.cloud", Routes: []string{"10.1.0.0/16"}},
+			{FQDN: "other.netbird.cloud", Routes: []string{"10.2.0.0/16"}},
+			{FQDN: "none.netbird.cloud"},
+		}},
+	}}
+
+	prefix, err := parsePrefix("10.1.2.0/24")
+	require.NoError(t, err)
+	filterPeersByRoute(resp, prefix)
+
+	var fqdns []string
+	for _, p := range resp.Nodes["web"].Peers {
+		fqdns = append(fqdns, p.FQDN)
+	}
+	assert.Equal(t, []string{"wide.netbird.cloud", "narrow.netbird.cloud"}, fqdns)
+}
+
+func TestCollectNodeStatuses(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	fetch := func(name nodeName) (*nodeStatus, error) {
+		switch name {
+		case "slow":
+			<-release
+			return &nodeStatus{}, nil
+		case "broken":
+			return nil, errors.New("engine not running")
+		default:
+			return &nodeStatus{Local: localStatus{FQDN: name + ".netbird.cloud"}}, nil
+		}
+	}
+
+	start := time.Now()
+	nodes := collectNodeStatuses(context.Background(), []nodeName{"fast", "slow", "broken"}, 50*time.Millisecond, fetch)
+	assert.Less(t, time.Since(start), time.Second, "slow node must not stall collection")
+
+	require.Len(t, nodes, 3)
+	assert.Equal(t, "fast.netbird.cloud", nodes["fast"].Local.FQDN)
+	assert.Empty(t, nodes["fast"].Error)
+	assert.Contains(t, nodes["slow"].Error, "timed out")
+	assert.Equal(t, "engine not running", nodes["broken"].Error)
+}
+
+func TestCollectNodeStatuses_ParentContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	block := make(chan struct{})
+	defer close(block)
+
+	nodes := collectNodeStatuses(ctx, []nodeName{"web"}, time.Minute, func(nodeName) (*nodeStatus, error) {
+		<-block
+		return &nodeStatus{}, nil
+	})
+	require.Contains(t, nodes, "web")
+	assert.NotEmpty(t, nodes["web"].Error)
+}
+
+func TestWriteStatusText_NodeError(t *testing.T) {
+	api := &adminAPI{}
+	rec := httptest.NewRecorder()
+	require.NoError(t, api.writeStatusText(rec, statusResponse{Nodes: map[nodeName]*nodeStatus{
+		"web": {Error: "status collection timed out"},
+	}}, false))
+
+	out := rec.Body.String()
+	assert.Contains(t, out, "Node: web")
+	assert.Contains(t, out, "status collection timed out")
+	assert.NotContains(t, out, "Peers")
+}
+
+func TestWireguardPort(t *testing.T) {
+	tests := []struct {
+		name       string
+		configured int
+		peers      []peerStatus
+		want       int
+	}{
+		{"configured without peers", 51820, nil, 51820},
+		{"random port not known yet", 0, nil, 0},
+		{"host candidate reveals random port", 0, []peerStatus{
+			{ICELocalType: "host", ICELocal: "192.168.1.10:41234"},
+		}, 41234},
+		{"host candidate overrides taken port", 51820, []peerStatus{
+			{ICELocalType: "host", ICELocal: "192.168.1.10:51821"},
+		}, 51821},
+		{"srflx candidate ignored", 0, []peerStatus{
+			{ICELocalType: "srflx", ICELocal: "203.0.113.5:60000"},
+		}, 0},
+		{"relayed peer ignored", 51820, []peerStatus{
+			{Relayed: true},
+			{ICELocalType: "host", ICELocal: "[fd00::1]:51999"},
+		}, 51999},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, wireguardPort(tt.configured, tt.peers))
+		})
+	}
+}
+
+func TestNodeStatus_WireguardPortField(t *testing.T) {
+	data, err := json.Marshal(nodeStatus{Local: localStatus{IP: "100.0.0.1/16", WireguardPort: 51820}})
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"wireguardPort":51820`)
+
+	rec := httptest.NewRecorder()
+	require.NoError(t, (&adminAPI{}).writeStatusText(rec, statusResponse{Nodes: map[nodeName]*nodeStatus{
+		"web": {Local: localStatus{WireguardPort: 51820}},
+	}}, false))
+	assert.Contains(t, rec.Body.String(), "WireGuard port:  51820")
+}
+
+func TestPageBounds(t *testing.T) {
+	tests := []struct {
+		name                     string
+		total, offset, limit     int
+		wantStart, wantEnd, next int
+	}{
+		{"no limit", 5, 0, 0, 0, 5, 0},
+		{"no limit with offset", 5, 2, 0, 2, 5, 0},
+		{"first page", 5, 0, 2, 0, 2, 2},
+		{"middle page", 5, 2, 2, 2, 4, 4},
+		{"last partial page", 5, 4, 2, 4, 5, 0},
+		{"last exact page", 4, 2, 2, 2, 4, 0},
+		{"offset at end", 5, 5, 2, 5, 5, 0},
+		{"offset past end", 5, 9, 2, 5, 5, 0},
+		{"limit larger than total", 3, 0, 10, 0, 3, 0},
+		{"empty", 0, 0, 2, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end, next := pageBounds(tt.total, tt.offset, tt.limit)
+			assert.Equal(t, tt.wantStart, start, "start")
+			assert.Equal(t, tt.wantEnd, end, "end")
+			assert.Equal(t, tt.next, next, "next")
+		})
+	}
+}
+
+func TestParsePage(t *testing.T) {
+	tests := []struct {
+		query      string
+		wantOffset int
+		wantLimit  int
+		wantErr    bool
+	}{
+		{query: "", wantOffset: 0, wantLimit: 0},
+		{query: "limit=50", wantOffset: 0, wantLimit: 50},
+		{query: "offset=100&limit=50", wantOffset: 100, wantLimit: 50},
+		{query: "offset=-1", wantErr: true},
+		{query: "limit=0", wantErr: true},
+		{query: "limit=abc", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			q, err := url.ParseQuery(tt.query)
+			require.NoError(t, err)
+
+			offset, limit, err := parsePage(q)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantOffset, offset)
+			assert.Equal(t, tt.wantLimit, limit)
+		})
+	}
+}
+
+func TestPaginatePeers(t *testing.T) {
+	resp := statusResponse{Nodes: map[nodeName]*nodeStatus{
+		"web": {Peers: []peerStatus{
+			{FQDN: "a.netbird.cloud"},
+			{FQDN: "b.netbird.cloud"},
+			{FQDN: "c.netbird.cloud"},
+		}},
+		"api": {Peers: []peerStatus{
+			{FQDN: "d.netbird.cloud"},
+		}},
+	}}
+
+	paginatePeers(resp, 1, 1)
+
+	web := resp.Nodes["web"]
+	assert.Equal(t, 3, web.PeersTotal)
+	require.Len(t, web.Peers, 1)
+	assert.Equal(t, "b.netbird.cloud", web.Peers[0].FQDN)
+	require.NotNil(t, web.NextOffset)
+	assert.Equal(t, 2, *web.NextOffset)
+
+	api := resp.Nodes["api"]
+	assert.Equal(t, 1, api.PeersTotal)
+	assert.Empty(t, api.Peers)
+	assert.Nil(t, api.NextOffset)
+}
+
+func TestWriteStatusText_PagedPeerCount(t *testing.T) {
+	api := &adminAPI{}
+	rec := httptest.NewRecorder()
+	require.NoError(t, api.writeStatusText(rec, statusResponse{Nodes: map[nodeName]*nodeStatus{
+		"web": {Peers: []peerStatus{{FQDN: "a.netbird.cloud"}}, PeersTotal: 3},
+	}}, false))
+
+	assert.Contains(t, rec.Body.String(), "Peers (1 of 3):")
+}
+
+func TestWriteStatusText_VerboseRelay(t *testing.T) {
+	resp := statusResponse{Nodes: map[nodeName]*nodeStatus{
+		"web": {Peers: []peerStatus{
+			{FQDN: "direct.netbird.cloud", ConnStatus: "Connected"},
+			{FQDN: "relayed.netbird.cloud", ConnStatus: "Connected", Relayed: true, RelayAddress: "rels://relay.netbird.io:443"},
+			{FQDN: "gone.netbird.cloud", ConnStatus: "Idle", Relayed: true, RelayAddress: "rels://stale.netbird.io:443"},
+		}},
+	}}
+
+	rec := httptest.NewRecorder()
+	require.NoError(t, (&adminAPI{}).writeStatusText(rec, resp, false))
+	out := rec.Body.String()
+	assert.NotContains(t, out, "Relay ")
+	assert.NotContains(t, out, "relay.netbird.io")
+
+	rec = httptest.NewRecorder()
+	require.NoError(t, (&adminAPI{}).writeStatusText(rec, resp, true))
+	out = rec.Body.String()
+	assert.Contains(t, out, "Conn     Relay")
+
+	lines := strings.Split(out, "\n")
+	peerLine := func(fqdn string) string {
+		for _, line := range lines {
+			if strings.Contains(line, fqdn) {
+				return line
+			}
+		}
+		t.Fatalf("no line for %s in:\n%s", fqdn, out)
+		return ""
+	}
+	assert.Regexp(t, `Relayed\s+rels://relay\.netbird\.io:443\s`, peerLine("relayed.netbird.cloud"))
+	assert.Regexp(t, `P2P\s+-\s`, peerLine("direct.netbird.cloud"))
+	assert.NotContains(t, peerLine("gone.netbird.cloud"), "stale.netbird.io", "relay of disconnected peers is not shown")
+}
+
+func TestWriteStatusText_VerboseICE(t *testing.T) {
+	resp := statusResponse{Nodes: map[nodeName]*nodeStatus{
+		"web": {Peers: []peerStatus{{
+			FQDN:          "direct.netbird.cloud",
+			ConnStatus:    "Connected",
+			ICELocal:      "192.168.1.10:51820",
+			ICELocalType:  "host",
+			ICERemote:     "203.0.113.5:60000",
+			ICERemoteType: "srflx",
+		}}},
+	}}
+
+	rec := httptest.NewRecorder()
+	require.NoError(t, (&adminAPI{}).writeStatusText(rec, resp, false))
+	out := rec.Body.String()
+	assert.NotContains(t, out, "ICE")
+	assert.NotContains(t, out, "192.168.1.10:51820")
+	assert.NotContains(t, out, "203.0.113.5:60000")
+
+	rec = httptest.NewRecorder()
+	require.NoError(t, (&adminAPI{}).writeStatusText(rec, resp, true))
+	out = rec.Body.String()
+	assert.Contains(t, out, "ICE local")
+	assert.Contains(t, out, "ICE remote")
+	assert.Contains(t, out, "192.168.1.10:51820 (host)")
+	assert.Contains(t, out, "203.0.113.5:60000 (srflx)")
+}
+
+func TestIceCandidate(t *testing.T) {
+	assert.Equal(t, "-", iceCandidate("", "host"))
+	assert.Equal(t, "10.0.0.1:51820", iceCandidate("10.0.0.1:51820", ""))
+	assert.Equal(t, "10.0.0.1:51820 (relay)", iceCandidate("10.0.0.1:51820", "relay"))
+}
+
+func TestWriteStatusNDJSON(t *testing.T) {
+	rec := httptest.NewRecorder()
+	require.NoError(t, writeStatusNDJSON(rec, statusResponse{Nodes: map[nodeName]*nodeStatus{
+		"web": {Local: localStatus{IP: "100.0.0.2/16"}, Peers: []peerStatus{{FQDN: "a.netbird.cloud"}}, PeersTotal: 1},
+		"api": {Local: localStatus{IP: "100.0.0.1/16"}},
+		"db":  {Error: "status collection timed out"},
+	}}))
+
+	assert.Equal(t, "application/x-ndjson", rec.Header().Get("Content-Type"))
+	assert.True(t, rec.Flushed)
+
+	out := rec.Body.String()
+	require.True(t, strings.HasSuffix(out, "\n"), "last line should be terminated")
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	require.Len(t, lines, 3, "one line per node")
+
+	var names []string
+	for _, line := range lines {
+		var obj struct {
+			Node       string       `json:"node"`
+			Error      string       `json:"error"`
+			Local      localStatus  `json:"local"`
+			Peers      []peerStatus `json:"peers"`
+			PeersTotal int          `json:"peersTotal"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(line), &obj), line)
+		names = append(names, obj.Node)
+
+		switch obj.Node {
+		case "web":
+			assert.Equal(t, "100.0.0.2/16", obj.Local.IP)
+			assert.Len(t, obj.Peers, 1)
+			assert.Equal(t, 1, obj.PeersTotal)
+		case "db":
+			assert.Equal(t, "status collection timed out", obj.Error)
+		}
+	}
+	assert.Equal(t, []string{"api", "db", "web"}, names, "nodes should be ordered by name")
+}
+
+func TestHandleStatus_NDJSON(t *testing.T) {
+	api := &adminAPI{app: &App{pool: caddy.NewUsagePool()}, logger: zap.NewNop()}
+	rec := httptest.NewRecorder()
+	require.NoError(t, api.handleStatus(rec, httptest.NewRequest(http.MethodGet, "/netbird/status?format=ndjson", nil)))
+	assert.Equal(t, "application/x-ndjson", rec.Header().Get("Content-Type"))
+	assert.Empty(t, rec.Body.String(), "no nodes, no lines")
+}
+
+func TestHandleStatus_InvalidVerbose(t *testing.T) {
+	api := &adminAPI{app: &App{pool: caddy.NewUsagePool()}, logger: zap.NewNop()}
+	err := api.handleStatus(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/netbird/status?verbose=maybe", nil))
+
+	var apiErr caddy.APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusBadRequest, apiErr.HTTPStatus)
+}
+
+func TestEchoRequest_IPv4(t *testing.T) {
+	req := echoRequest(netip.MustParseAddr("100.64.0.1"), netip.MustParseAddr("100.64.0.2"))
+	assert.Equal(t, []byte{8, 0, 0xf7, 0xff, 0, 0, 0, 0}, req)
+}
+
+func TestEchoRequest_IPv6(t *testing.T) {
+	src := netip.MustParseAddr("fd00::1")
+	dst := netip.MustParseAddr("fd00::2")
+
+	req := echoRequest(src, dst)
+	// Reference packet from golang.org/x/net/icmp with the same pseudo-header.
+	assert.Equal(t, []byte{128, 0, 0x85, 0xb8, 0, 0, 0, 0}, req)
+
+	// The checksum over pseudo-header and packet verifies to zero.
+	hi, lo := icmpChecksum(append(icmpv6PseudoHeader(src, dst, len(req)), req...))
+	assert.Equal(t, []byte{0, 0}, []byte{hi, lo})
+}
+
+func TestEchoRequest_IPv4MappedIPv6(t *testing.T) {
+	req := echoRequest(netip.Addr{}, netip.MustParseAddr("::ffff:100.64.0.2"))
+	assert.Equal(t, byte(icmpv4EchoRequest), req[0])
+}
+
+func TestICMPv6PseudoHeader(t *testing.T) {
+	header := icmpv6PseudoHeader(netip.MustParseAddr("fd00::1"), netip.MustParseAddr("fd00::2"), 8)
+	require.Len(t, header, 40)
+	assert.Equal(t, netip.MustParseAddr("fd00::1").AsSlice(), header[:16])
+	assert.Equal(t, netip.MustParseAddr("fd00::2").AsSlice(), header[16:32])
+	assert.Equal(t, []byte{0, 0, 0, 8, 0, 0, 0, 58}, header[32:])
+}
+
+func TestNetAddrIP(t *testing.T) {
+	assert.False(t, netAddrIP(nil).IsValid())
+	assert.Equal(t, netip.MustParseAddr("fd00::2"), netAddrIP(&net.IPAddr{IP: net.ParseIP("fd00::2")}))
+	assert.False(t, netAddrIP(&net.TCPAddr{IP: net.ParseIP("100.64.0.2"), Port: 80}).IsValid(), "addresses with ports are not plain IPs")
+}
+
+func TestAcquirePing(t *testing.T) {
+	a := &App{pingSlots: make(chan struct{}, 2)}
+
+	release1, ok := a.acquirePing()
+	require.True(t, ok)
+	release2, ok := a.acquirePing()
+	require.True(t, ok)
+	_, ok = a.acquirePing()
+	assert.False(t, ok, "limit reached")
+
+	release1()
+	release3, ok := a.acquirePing()
+	assert.True(t, ok, "released slot is reusable")
+	release2()
+	release3()
+	assert.Empty(t, a.pingSlots)
+}
+
+func TestAcquirePing_Unprovisioned(t *testing.T) {
+	release, ok := (&App{}).acquirePing()
+	require.True(t, ok)
+	release()
+}
+
+func TestHandlePing_TooManyConcurrent(t *testing.T) {
+	a := newReloadTestApp(t, fakeSecretProvider{"WEB_KEY": "web-key", "API_KEY": "api-key"})
+	a.pingSlots = make(chan struct{}, 1)
+	release, ok := a.acquirePing()
+	require.True(t, ok)
+	defer release()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/netbird/ping", strings.NewReader(`{"node":"web","address":"100.64.0.1:80"}`))
+	require.NoError(t, (&adminAPI{app: a}).handleAPI(rec, req))
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Contains(t, rec.Body.String(), "too_many_requests")
+}
+
+func TestParseGlobalOption_MaxConcurrentPings(t *testing.T) {
+	a := parseAndDecode(t, `netbird {
+		max_concurrent_pings 4
+	}`)
+	assert.Equal(t, 4, a.MaxConcurrentPings)
+
+	for _, input := range []string{
+		"netbird {\n max_concurrent_pings\n}",
+		"netbird {\n max_concurrent_pings 0\n}",
+		"netbird {\n max_concurrent_pings many\n}",
+	} {
+		_, err := parseGlobalOption(caddyfile.NewTestDispenser(input), nil)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestMatchPeer(t *testing.T) {
+	peers := []peerStatus{
+		{IP: "100.64.0.2", FQDN: "db.netbird.cloud.", ConnStatus: "Connected", Latency: 3 * time.Millisecond},
+		{IP: "100.64.0.3", FQDN: "web.netbird.cloud", ConnStatus: "Connected", Relayed: true, Latency: 40 * time.Millisecond},
+		{IP: "100.64.0.4", FQDN: "idle.netbird.cloud", ConnStatus: "Idle"},
+	}
+
+	tests := []struct {
+		name    string
+		address string
+		want    *pingPeer
+	}{
+		{"ip with port", "100.64.0.2:5432", &pingPeer{FQDN: "db.netbird.cloud.", IP: "100.64.0.2", ConnStatus: "Connected", ConnType: "P2P", Latency: 3 * time.Millisecond}},
+		{"fqdn without port", "DB.netbird.cloud", &pingPeer{FQDN: "db.netbird.cloud.", IP: "100.64.0.2", ConnStatus: "Connected", ConnType: "P2P", Latency: 3 * time.Millisecond}},
+		{"relayed", "web.netbird.cloud.:443", &pingPeer{FQDN: "web.netbird.cloud", IP: "100.64.0.3", ConnStatus: "Connected", ConnType: "Relayed", Latency: 40 * time.Millisecond}},
+		{"not connected", "100.64.0.4", &pingPeer{FQDN: "idle.netbird.cloud", IP: "100.64.0.4", ConnStatus: "Idle"}},
+		{"unknown", "example.com:443", nil},
+		{"routed address", "10.0.0.5:80", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, matchPeer(peers, tt.address))
+		})
+	}
+}
+
+func TestCountPeers(t *testing.T) {
+	counts := countPeers([]peerStatus{
+		{FQDN: "a.netbird.cloud", ConnStatus: "Connected"},
+		{FQDN: "b.netbird.cloud", ConnStatus: "Connected", Relayed: true},
+		{FQDN: "c.netbird.cloud", ConnStatus: "Connected"},
+		{FQDN: "d.netbird.cloud", ConnStatus: "Connecting"},
+		{FQDN: "e.netbird.cloud", ConnStatus: "Idle", Relayed: true},
+		{FQDN: "f.netbird.cloud", ConnStatus: "Connected", Relayed: true},
+		{FQDN: "g.netbird.cloud", ConnStatus: "Idle"},
+	})
+	assert.Equal(t, peerCounts{P2P: 2, Relayed: 2, Disconnected: 3}, counts)
+
+	assert.Equal(t, peerCounts{}, countPeers(nil))
+}
+
+func TestPeerCounts_Rendered(t *testing.T) {
+	resp := statusResponse{Nodes: map[nodeName]*nodeStatus{
+		"web": {PeerCounts: peerCounts{P2P: 4, Relayed: 1, Disconnected: 2}},
+	}}
+
+	rec := httptest.NewRecorder()
+	require.NoError(t, (&adminAPI{}).writeStatusText(rec, resp, false))
+	assert.Regexp(t, `Peer summary:\s+4 P2P, 1 relayed, 2 disconnected`, rec.Body.String())
+
+	data, err := json.Marshal(resp)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"peerCounts":{"p2p":4,"relayed":1,"disconnected":2}`)
+}
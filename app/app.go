@@ -4,9 +4,14 @@ package app
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"net"
+	"net/netip"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -18,7 +23,10 @@ import (
 	"github.com/netbirdio/netbird/client/embed"
 	log "github.com/sirupsen/logrus"
 	"go.uber.org/zap"
+	"golang.org/x/exp/maps"
+	"gopkg.in/natefinch/lumberjack.v2"
 
+	"github.com/netbirdio/netbird/shared/management/domain"
 	"github.com/netbirdio/netbird/util"
 )
 
@@ -33,10 +41,39 @@ func GlobalApp() *App {
 var (
 	ErrMissingManagementURL = errors.New("management_url is required (set on node or app level)")
 	ErrMissingSetupKey      = errors.New("setup_key is required (set on node or app level)")
+	ErrInvalidMTU           = fmt.Errorf("mtu must be between %d and %d", minMTU, maxMTU)
+	ErrRelayedPeer          = errors.New("peer is only reachable via relay and relays are disabled")
+	ErrInvalidDNSLabels     = errors.New("invalid dns_labels")
+	ErrStartTimeout         = errors.New("netbird client start timed out")
+	ErrStopTimeout          = errors.New("netbird client stop timed out")
+	ErrUnknownNode          = errors.New("node is not defined in the netbird app config")
+	ErrInvalidBandwidth     = errors.New("bandwidth_limit must not be negative")
+	ErrNotForcedRelay       = errors.New("peer is not connected via the forced relay")
+	ErrForceRelayConflict   = errors.New("force_relay and disable_relays are mutually exclusive")
+	ErrInvalidPreSharedKey  = errors.New("invalid pre_shared_key")
+	ErrInvalidMinPeers      = errors.New("min_peers must not be negative")
+	ErrInvalidOnConnect     = errors.New("on_connect requires a command")
+	ErrNodeAccessDenied     = errors.New("node access denied")
 )
 
+// MTU bounds accepted by the NetBird client.
+const (
+	minMTU = 576
+	maxMTU = 8192
+)
+
+// defaultStartTimeout bounds a client start against one management URL.
+const defaultStartTimeout = 30 * time.Second
+
+// stopTimeout bounds stopping a single client.
+const stopTimeout = 10 * time.Second
+
+// defaultNodeName is the node used where none is given, unless
+// DefaultNode names another one.
+const defaultNodeName = "default"
+
 func init() {
-	caddy.RegisterModule(App{})
+	caddy.RegisterModule(new(App))
 	httpcaddyfile.RegisterGlobalOption("netbird", parseGlobalOption)
 }
 
@@ -47,24 +84,119 @@ type App struct {
 	DefaultManagementURL string `json:"management_url,omitempty"`
 	// DefaultSetupKey is the default setup key for all nodes.
 	DefaultSetupKey string `json:"setup_key,omitempty"`
+	// DefaultNode is the node used by transports, L4 handlers and admin
+	// API requests that don't name one (default: "default"). It must be
+	// defined in Nodes.
+	DefaultNode string `json:"default_node,omitempty"`
 	// LogLevel sets the NetBird client log level (default: warn).
 	LogLevel string `json:"log_level,omitempty"`
+	// LogFile writes the NetBird client logs to this file instead of
+	// stderr, separate from Caddy's logs. The file is rotated by size.
+	LogFile string `json:"log_file,omitempty"`
+	// LogFileMaxSize is the size in megabytes at which LogFile is
+	// rotated (default: 15).
+	LogFileMaxSize int `json:"log_file_max_size,omitempty"`
+	// PingTimeout bounds admin ping operations (default: 5s).
+	PingTimeout caddy.Duration `json:"ping_timeout,omitempty"`
+	// HealthCheckInterval is how often the connectivity of running clients
+	// is checked (default: 10s).
+	HealthCheckInterval caddy.Duration `json:"health_check_interval,omitempty"`
+	// HeartbeatInterval enables an info-level log line per running node at
+	// this interval, summarizing its management and signal connectivity
+	// and peer count. Disabled if zero.
+	HeartbeatInterval caddy.Duration `json:"heartbeat_interval,omitempty"`
+	// MaxConcurrentPings limits the admin ping operations in flight at once
+	// (default: 16). Further requests are rejected with 429 Too Many Requests.
+	MaxConcurrentPings int `json:"max_concurrent_pings,omitempty"`
+	// ScanAllow lists the IP addresses and CIDRs the admin scan endpoint
+	// may dial, e.g. the subnet of the backends. Scanning is disabled if
+	// empty.
+	ScanAllow []string `json:"scan_allow,omitempty"`
+	// StatusCacheTTL is how long a node's status is shared among admin API
+	// callers before the client is queried again (default: 1s). A negative
+	// value disables the cache.
+	StatusCacheTTL caddy.Duration `json:"status_cache_ttl,omitempty"`
+	// StatusFile is a path the JSON status of all nodes is written to
+	// periodically, for tools without access to the admin API. The file is
+	// replaced atomically. Disabled if empty.
+	StatusFile string `json:"status_file,omitempty"`
+	// StatusFileInterval is how often the status file is written
+	// (default: 10s).
+	StatusFileInterval caddy.Duration `json:"status_file_interval,omitempty"`
+	// StartTimeout bounds the start of a node's client against each
+	// management URL (default: 30s), so an unreachable management server
+	// can't block Caddy's startup indefinitely.
+	StartTimeout caddy.Duration `json:"start_timeout,omitempty"`
+	// Metrics exports Prometheus metrics through Caddy's metrics endpoint.
+	Metrics bool `json:"metrics,omitempty"`
+	// Statsd is the host:port of a statsd server to send dial metrics to
+	// over UDP. Disabled if empty.
+	Statsd string `json:"statsd,omitempty"`
+	// StatusToken is a bearer token granting access to the read-only admin
+	// API endpoints, e.g. for dashboards. Once set, the read-only endpoints
+	// require it, and mutating endpoints require a client certificate
+	// verified by Caddy's remote admin. May be a secret:// reference.
+	StatusToken string `json:"status_token,omitempty"`
+	// LazyStart starts a node's client when the first transport or L4
+	// handler using it is provisioned (default: true). If false, clients
+	// are only started by App.Start, once the whole config is provisioned,
+	// or by the admin API, so tunnels come up at a well-defined point.
+	// Dials through a client that isn't started fail.
+	LazyStart *bool `json:"lazy_start,omitempty"`
+	// Tenants maps tenant names to the nodes reserved for them, to keep
+	// the transports and L4 handlers of one tenant from using another
+	// tenant's NetBird identity in multi-tenant configs. A transport or
+	// handler with a tenant may only use that tenant's nodes; one without
+	// may only use nodes not listed for any tenant.
+	Tenants map[string][]string `json:"tenants,omitempty"`
+	// ShareIdenticalNodes makes nodes whose resolved configs are identical,
+	// including their hostname, share one client instead of each running
+	// its own, keyed by a hash of the config. A changed config of such a
+	// node can't be applied by the admin reload endpoint.
+	ShareIdenticalNodes bool `json:"share_identical_nodes,omitempty"`
 	// Nodes is a map of named node configurations.
 	Nodes map[string]*Node `json:"nodes,omitempty"`
 
-	pool   *caddy.UsagePool
-	logger *zap.Logger
+	pool          *caddy.UsagePool
+	logger        *zap.Logger
+	secrets       SecretProvider
+	metrics       *metrics
+	statsd        *statsdExporter
+	pingSlots     chan struct{}
+	healthCancel  context.CancelFunc
+	healthDone    chan struct{}
+	heartbeatDone chan struct{}
+	statusToken   string
+
+	// statusFileDone is closed once the status file writer stopped.
+	statusFileDone chan struct{}
+	// scanAllow holds the parsed ScanAllow prefixes.
+	scanAllow []netip.Prefix
+	// logFile is the rotated writer of LogFile, if set.
+	logFile *lumberjack.Logger
+	// shared tracks the node names of clients pooled by config hash.
+	shared sharedClients
+
+	// usage maps node names to the *nodeUsage of their open connections.
+	usage sync.Map
+	// dials maps node names to the *dialCounter of their recent dials.
+	dials sync.Map
 }
 
 // Node is the configuration for a single NetBird client identity.
 type Node struct {
 	// ManagementURL overrides the app-level default.
 	ManagementURL string `json:"management_url,omitempty"`
+	// ManagementURLs lists fallback management servers, tried in order
+	// after ManagementURL if the client fails to start.
+	ManagementURLs []string `json:"management_urls,omitempty"`
 	// SetupKey overrides the app-level default.
 	SetupKey string `json:"setup_key,omitempty"`
 	// Hostname is the device name registered in the NetBird network.
 	Hostname string `json:"hostname,omitempty"`
-	// PreSharedKey is the pre-shared key for the network interface.
+	// PreSharedKey is the pre-shared key for the network interface: a
+	// base64-encoded 32-byte WireGuard key. It applies to all peers; the
+	// embedded client has no per-peer or per-group keys.
 	PreSharedKey string `json:"pre_shared_key,omitempty"`
 	// WireguardPort is the port for the network interface. Use 0 for a random port.
 	WireguardPort *int `json:"wireguard_port,omitempty"`
@@ -72,10 +204,52 @@ type Node struct {
 	// Defaults to true. Set to false for egress nodes that accept
 	// connections from other NetBird peers.
 	BlockInbound *bool `json:"block_inbound,omitempty"`
+	// MTU is the MTU of the network interface. Defaults to 1280 via NetBird.
+	// Raise it (e.g. 1400) to avoid fragmentation of larger datagrams such
+	// as QUIC, lower it if large packets are dropped on the path.
+	MTU uint16 `json:"mtu,omitempty"`
+	// DNSLabels are extra DNS names registered for the peer, shown in the
+	// NetBird dashboard. Useful to identify and group Caddy devices.
+	DNSLabels []string `json:"dns_labels,omitempty"`
+	// DisableRelays rejects dials to peers that are connected via a relay,
+	// enforcing peer-to-peer only connectivity. The embedded client has no
+	// switch to turn relays off, so this is enforced at dial time based on
+	// the connection type seen by the last health check.
+	DisableRelays bool `json:"disable_relays,omitempty"`
+	// ForceRelay is the host:port of a relay, e.g. "relay.netbird.io:443",
+	// that connections to peers must go through, to test relay behavior
+	// deterministically. The embedded client picks relays itself, so this
+	// is enforced at dial time like DisableRelays: dials to peers last seen
+	// connected directly or via another relay fail. Setting the
+	// NB_FORCE_RELAY=true environment variable makes the client connect to
+	// all peers via relay.
+	ForceRelay string `json:"force_relay,omitempty"`
+	// ReconnectMin enables restarting the client when the health check finds
+	// management or signal disconnected for this long. Further restarts back
+	// off exponentially up to ReconnectMax.
+	ReconnectMin caddy.Duration `json:"reconnect_min,omitempty"`
+	// ReconnectMax caps the restart backoff (default: 10x ReconnectMin).
+	ReconnectMax caddy.Duration `json:"reconnect_max,omitempty"`
+	// BandwidthLimit caps the bytes per second read from and, separately,
+	// written to the connections dialed through the node by transports
+	// and L4 handlers, e.g. to control the cost of metered relays. All
+	// connections share the limit. Zero means no limit.
+	BandwidthLimit int64 `json:"bandwidth_limit,omitempty"`
+	// MinPeers makes the health check log a warning, and report the node
+	// in the caddy_netbird_peers_below_min metric, when fewer peers than
+	// this are connected. Zero disables the check.
+	MinPeers int `json:"min_peers,omitempty"`
+	// OnConnect is a command and its arguments, run when the client first
+	// reaches both management and signal after starting, e.g. to register
+	// the node in DNS. The node name, NetBird IP and FQDN are passed in
+	// the NETBIRD_NODE, NETBIRD_IP and NETBIRD_FQDN environment variables
+	// and replace the {netbird.node}, {netbird.ip} and {netbird.fqdn}
+	// placeholders in the arguments.
+	OnConnect []string `json:"on_connect,omitempty"`
 }
 
 // CaddyModule returns the Caddy module information.
-func (App) CaddyModule() caddy.ModuleInfo {
+func (*App) CaddyModule() caddy.ModuleInfo {
 	return caddy.ModuleInfo{
 		ID:  "netbird",
 		New: func() caddy.Module { return new(App) },
@@ -97,55 +271,241 @@ func (a *App) Provision(ctx caddy.Context) error {
 	if err := util.InitLog(logLevel, util.LogConsole); err != nil {
 		return fmt.Errorf("initialize netbird logging: %w", err)
 	}
+	if a.LogFile != "" {
+		a.logFile = newLogFile(a.LogFile, a.LogFileMaxSize)
+		log.SetOutput(a.logFile)
+	}
+	installNodeLogHook()
+
+	pingLimit := a.MaxConcurrentPings
+	if pingLimit <= 0 {
+		pingLimit = defaultMaxConcurrentPings
+	}
+	a.pingSlots = make(chan struct{}, pingLimit)
+
+	scanAllow, err := parsePrefixes(a.ScanAllow)
+	if err != nil {
+		return fmt.Errorf("scan_allow: %w", err)
+	}
+	a.scanAllow = scanAllow
+
+	if a.Metrics {
+		m, err := newMetrics(ctx.GetMetricsRegistry())
+		if err != nil {
+			return err
+		}
+		a.metrics = m
+
+		if err := ctx.GetMetricsRegistry().Register(newPeerLatencyCollector(a.peerLatencies)); err != nil {
+			return fmt.Errorf("register peer latency metric: %w", err)
+		}
+		if err := ctx.GetMetricsRegistry().Register(newScalingCollector(a.scalingSignals)); err != nil {
+			return fmt.Errorf("register scaling metrics: %w", err)
+		}
+		if err := ctx.GetMetricsRegistry().Register(newMinPeersCollector(a.peersBelowMin)); err != nil {
+			return fmt.Errorf("register min peers metric: %w", err)
+		}
+	}
+
+	token, err := resolveSecret(a.secretProvider(), a.StatusToken)
+	if err != nil {
+		return fmt.Errorf("resolve status_token: %w", err)
+	}
+	a.statusToken = token
+
+	if a.Statsd != "" {
+		e, err := newStatsdExporter(a.Statsd, a.logger)
+		if err != nil {
+			return err
+		}
+		a.statsd = e
+	}
 
 	return nil
 }
 
 // Validate ensures each node has a management URL and setup key configured.
+// The errors of all nodes are returned together, in node name order.
 func (a *App) Validate() error {
-	for name, node := range a.Nodes {
-		mgmtURL := node.ManagementURL
-		if mgmtURL == "" {
-			mgmtURL = a.DefaultManagementURL
-		}
-		if mgmtURL == "" {
-			return fmt.Errorf("node %q: %w", name, ErrMissingManagementURL)
+	names := maps.Keys(a.Nodes)
+	slices.Sort(names)
+
+	var errs []error
+	for _, name := range names {
+		if err := a.validateNode(a.Nodes[name]); err != nil {
+			errs = append(errs, fmt.Errorf("node %q: %w", name, err))
 		}
+	}
+	if a.LogFileMaxSize < 0 {
+		errs = append(errs, errors.New("log_file_max_size must not be negative"))
+	}
+	if a.LogFileMaxSize > 0 && a.LogFile == "" {
+		errs = append(errs, errors.New("log_file_max_size requires log_file"))
+	}
+	if a.DefaultNode != "" && !a.HasNode(a.DefaultNode) {
+		errs = append(errs, fmt.Errorf("default_node %q: %w", a.DefaultNode, ErrUnknownNode))
+	}
+	if err := validateTenants(a.Tenants); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
 
-		setupKey := node.SetupKey
-		if setupKey == "" {
-			setupKey = a.DefaultSetupKey
+// validateNode checks a single node config, taking app defaults into account.
+func (a *App) validateNode(node *Node) error {
+	if len(node.managementURLs()) == 0 && a.DefaultManagementURL == "" {
+		return ErrMissingManagementURL
+	}
+
+	setupKey := node.SetupKey
+	if setupKey == "" {
+		setupKey = a.DefaultSetupKey
+	}
+	if setupKey == "" {
+		return ErrMissingSetupKey
+	}
+
+	if node.MTU != 0 && (node.MTU < minMTU || node.MTU > maxMTU) {
+		return ErrInvalidMTU
+	}
+	if node.BandwidthLimit < 0 {
+		return ErrInvalidBandwidth
+	}
+	if node.MinPeers < 0 {
+		return ErrInvalidMinPeers
+	}
+	if len(node.OnConnect) > 0 && node.OnConnect[0] == "" {
+		return ErrInvalidOnConnect
+	}
+	if err := validatePreSharedKey(node.PreSharedKey); err != nil {
+		return err
+	}
+	if node.ForceRelay != "" {
+		if node.DisableRelays {
+			return ErrForceRelayConflict
 		}
-		if setupKey == "" {
-			return fmt.Errorf("node %q: %w", name, ErrMissingSetupKey)
+		if err := validateRelayAddress(node.ForceRelay); err != nil {
+			return fmt.Errorf("invalid force_relay: %w", err)
 		}
 	}
+	return validateDNSLabels(node.DNSLabels)
+}
+
+// validateDNSLabels applies the checks the management server does for
+// extra DNS labels, so a bad label fails at config load instead of login.
+func validateDNSLabels(labels []string) error {
+	if err := domain.ValidateDomainsList(labels); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidDNSLabels, err)
+	}
 	return nil
 }
 
-// Start is a no-op. Clients are started lazily when transports provision.
+// Start launches the background health checker. Clients are started lazily
+// when transports provision, or here if lazy start is disabled.
 func (a *App) Start() error {
+	if !a.StartsLazily() {
+		a.startPooledClients(context.Background())
+	}
+
+	interval := time.Duration(a.HealthCheckInterval)
+	if interval <= 0 {
+		interval = defaultHealthCheckInterval
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	a.healthCancel = cancel
+	a.healthDone = make(chan struct{})
+
+	go func() {
+		defer close(a.healthDone)
+		a.runHealthChecks(ctx, interval)
+	}()
+
+	if hbInterval := time.Duration(a.HeartbeatInterval); hbInterval > 0 {
+		a.heartbeatDone = make(chan struct{})
+		go func() {
+			defer close(a.heartbeatDone)
+			runHeartbeats(ctx, hbInterval, a.logHeartbeats)
+		}()
+	}
+
+	if a.StatusFile != "" {
+		a.statusFileDone = make(chan struct{})
+		go func() {
+			defer close(a.statusFileDone)
+			runStatusFile(ctx, a.statusFileInterval(), a.writeStatusFile)
+		}()
+	}
+
+	if a.statsd != nil {
+		a.statsd.start(statsdFlushInterval)
+	}
 	return nil
 }
 
-// Stop shuts down all NetBird clients in the pool.
+// Stop shuts down the health checker and all NetBird clients in the pool.
+// Clients are stopped concurrently, each for at most stopTimeout, so a node
+// that is slow to stop doesn't hold up the others. Stop returns once all of
+// them are done.
 func (a *App) Stop() error {
 	globalApp.CompareAndSwap(a, nil)
 
-	var errs []error
-	a.pool.Range(func(_ any, val any) bool {
-		if err := val.(*ManagedClient).stop(); err != nil {
-			errs = append(errs, err)
+	if a.healthCancel != nil {
+		a.healthCancel()
+		<-a.healthDone
+		if a.heartbeatDone != nil {
+			<-a.heartbeatDone
+		}
+		if a.statusFileDone != nil {
+			<-a.statusFileDone
 		}
+	}
+
+	clients := make(map[nodeName]*ManagedClient)
+	a.pool.Range(func(_, val any) bool {
+		mc := val.(*ManagedClient)
+		clients[mc.name] = mc
 		return true
 	})
+	return stopNodes(maps.Keys(clients), func(name nodeName) error {
+		return clients[name].stop()
+	})
+}
+
+// Cleanup sends the remaining statsd metrics, closes the exporter and the
+// log file. It runs after Stop, or after a failed Provision.
+func (a *App) Cleanup() error {
+	var errs []error
+	if a.statsd != nil {
+		if err := a.statsd.close(); err != nil {
+			errs = append(errs, fmt.Errorf("close statsd exporter: %w", err))
+		}
+	}
+	if a.logFile != nil {
+		if err := a.logFile.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("close log file: %w", err))
+		}
+	}
 	return errors.Join(errs...)
 }
 
-// GetClient returns a ref-counted ManagedClient for the named node.
-// Each call must be paired with a ReleaseClient call.
-func (a *App) GetClient(nodeName string) (*ManagedClient, error) {
-	val, loaded, err := a.pool.LoadOrNew(nodeName, func() (caddy.Destructor, error) {
+// GetClient returns a ref-counted ManagedClient for the named node, which
+// tenant must be allowed to use, see Tenants. Each successful call must be
+// paired with a ReleaseClient call.
+func (a *App) GetClient(tenant, nodeName string) (*ManagedClient, error) {
+	if err := a.checkNodeAccess(tenant, nodeName); err != nil {
+		return nil, err
+	}
+	return a.getClient(nodeName)
+}
+
+// getClient is GetClient without the tenant check, for the app itself.
+func (a *App) getClient(nodeName string) (*ManagedClient, error) {
+	key, err := a.clientKey(nodeName)
+	if err != nil {
+		return nil, fmt.Errorf("load netbird client %q: %w", nodeName, err)
+	}
+	val, loaded, err := a.pool.LoadOrNew(key, func() (caddy.Destructor, error) {
 		return a.newManagedClient(nodeName)
 	})
 	if err != nil {
@@ -153,24 +513,150 @@ func (a *App) GetClient(nodeName string) (*ManagedClient, error) {
 	}
 
 	mc := val.(*ManagedClient)
-	if !loaded {
+	if key != nodeName {
+		a.shared.acquire(nodeName, key)
+	}
+	switch {
+	case !loaded:
 		a.logger.Info("created netbird client", zap.String("node", nodeName))
+	case mc.name != nodeName:
+		a.logger.Info("sharing netbird client of node with identical config",
+			zap.String("node", nodeName),
+			zap.String("shared_with", mc.name),
+		)
+	}
+	return mc, nil
+}
+
+// StartClient is GetClient followed by Start. If the client fails to start,
+// the reference is released again, so callers only pair a successful call
+// with ReleaseClient.
+func (a *App) StartClient(ctx context.Context, nodeName string) (*ManagedClient, error) {
+	mc, err := a.getClient(nodeName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := mc.Start(ctx); err != nil {
+		if releaseErr := a.ReleaseClient(nodeName); releaseErr != nil {
+			err = errors.Join(err, releaseErr)
+		}
+		return nil, fmt.Errorf("start netbird client %q: %w", nodeName, err)
 	}
 	return mc, nil
 }
 
+// AcquireClient returns a ref-counted ManagedClient for the named node like
+// GetClient, and starts it like StartClient unless lazy start is disabled.
+// Nodes not defined in the config are rejected with ErrUnknownNode.
+// Transports and L4 handlers pass their tenant, which must be allowed to use
+// the node, see Tenants. Each successful call must be paired with a
+// ReleaseClient call.
+func (a *App) AcquireClient(ctx context.Context, tenant, nodeName string) (*ManagedClient, error) {
+	if err := a.checkAcquire(tenant, nodeName); err != nil {
+		return nil, err
+	}
+	if a.StartsLazily() {
+		return a.StartClient(ctx, nodeName)
+	}
+	return a.getClient(nodeName)
+}
+
+// AcquireListenerClient acquires and starts the client of a node for a
+// NetBird listener, with the checks of AcquireClient. Listeners belong to no
+// tenant, so they may only use nodes not reserved for one. They need a
+// running client to listen on, so they can't be used with lazy start
+// disabled. Each successful call must be paired with a ReleaseClient call.
+func (a *App) AcquireListenerClient(ctx context.Context, nodeName string) (*ManagedClient, error) {
+	if err := a.checkAcquire("", nodeName); err != nil {
+		return nil, err
+	}
+	if !a.StartsLazily() {
+		return nil, fmt.Errorf("netbird listener on node %q can't be used with lazy_start false, as clients aren't started while loading the config", nodeName)
+	}
+	return a.StartClient(ctx, nodeName)
+}
+
+// checkAcquire rejects nodes that aren't defined or that tenant may not use,
+// before a client is created for them.
+func (a *App) checkAcquire(tenant, nodeName string) error {
+	if !a.HasNode(nodeName) {
+		return fmt.Errorf("node %q: %w", nodeName, ErrUnknownNode)
+	}
+	return a.checkNodeAccess(tenant, nodeName)
+}
+
+// StartsLazily reports whether clients are started when transports and L4
+// handlers provision, rather than by App.Start.
+func (a *App) StartsLazily() bool {
+	return a.LazyStart == nil || *a.LazyStart
+}
+
+// startPooledClients starts the clients of all pooled nodes concurrently.
+// Failures are logged and kept as the node's last error; such nodes can be
+// started later through the admin API.
+func (a *App) startPooledClients(ctx context.Context) {
+	var wg sync.WaitGroup
+	a.pool.Range(func(_, val any) bool {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			mc := val.(*ManagedClient)
+			if err := mc.Start(ctx); err != nil {
+				a.logger.Error("start netbird client", zap.String("node", mc.name), zap.Error(err))
+			}
+		}()
+		return true
+	})
+	wg.Wait()
+}
+
 // ReleaseClient decrements the ref count for a node's client.
 func (a *App) ReleaseClient(nodeName string) error {
-	_, err := a.pool.Delete(nodeName)
+	key, ok := a.shared.release(nodeName)
+	if !ok {
+		key = nodeName
+	}
+	_, err := a.pool.Delete(key)
 	return err
 }
 
+// DefaultNodeName returns the name of the node used where none is given:
+// DefaultNode if set, "default" otherwise.
+func (a *App) DefaultNodeName() string {
+	if a.DefaultNode != "" {
+		return a.DefaultNode
+	}
+	return defaultNodeName
+}
+
+// HasNode reports whether nodeName is defined in the config. The default
+// node is always known, as it may be configured by the app-level defaults
+// alone.
+func (a *App) HasNode(nodeName string) bool {
+	if nodeName == defaultNodeName {
+		return true
+	}
+	_, ok := a.Nodes[nodeName]
+	return ok
+}
+
 // LookupClient returns the ManagedClient for the named node if it exists in the pool.
 // Unlike GetClient, it does not create a new client or increment the ref count.
-func (a *App) LookupClient(nodeName string) (*ManagedClient, bool) {
+// Nodes tenant may not use are reported as missing, see Tenants.
+func (a *App) LookupClient(tenant, nodeName string) (*ManagedClient, bool) {
+	if a.checkNodeAccess(tenant, nodeName) != nil {
+		return nil, false
+	}
+	return a.lookupClient(nodeName)
+}
+
+// lookupClient is LookupClient without the tenant check, for the app itself.
+func (a *App) lookupClient(nodeName string) (*ManagedClient, bool) {
+	poolKey := a.poolKey(nodeName)
 	var mc *ManagedClient
 	a.pool.Range(func(key, val any) bool {
-		if key.(string) == nodeName {
+		if key.(string) == poolKey {
 			mc = val.(*ManagedClient)
 			return false
 		}
@@ -179,33 +665,120 @@ func (a *App) LookupClient(nodeName string) (*ManagedClient, bool) {
 	return mc, mc != nil
 }
 
+// PeerIP returns the NetBird IP of the peer with the given FQDN, as seen by
+// the last health check of the named node's client. Peers of other nodes
+// aren't considered, as FQDNs may resolve differently in their networks.
+// Like LookupClient, nodes tenant may not use are treated as missing.
+func (a *App) PeerIP(tenant, nodeName, fqdn string) (string, bool) {
+	mc, ok := a.LookupClient(tenant, nodeName)
+	if !ok {
+		return "", false
+	}
+	peers := mc.peerIPs.Load()
+	if peers == nil {
+		return "", false
+	}
+	ip, ok := (*peers)[strings.ToLower(strings.TrimSuffix(fqdn, "."))]
+	return ip, ok
+}
+
 func (a *App) newManagedClient(nodeName string) (*ManagedClient, error) {
-	node := a.resolveNode(nodeName)
+	node, err := a.resolveNodeConfig(nodeName)
+	if err != nil {
+		return nil, err
+	}
+	newClient := a.clientFactory(nodeName, node)
 
-	hostname := node.Hostname
-	if hostname == "" {
-		hostname = "caddy-" + nodeName
+	mgmtURLs := node.managementURLs()
+	if len(mgmtURLs) == 0 {
+		return nil, fmt.Errorf("node %q: %w", nodeName, ErrMissingManagementURL)
+	}
+	client, err := newClient(mgmtURLs[0])
+	if err != nil {
+		return nil, err
 	}
 
-	blockInbound := node.BlockInbound == nil || *node.BlockInbound
-	opts := embed.Options{
-		DeviceName:    hostname,
-		ManagementURL: node.ManagementURL,
-		SetupKey:      node.SetupKey,
-		BlockInbound:  blockInbound,
-		PreSharedKey:  node.PreSharedKey,
-		WireguardPort: node.WireguardPort,
+	mc := &ManagedClient{
+		newClient:     newClient,
+		mgmtURLs:      mgmtURLs,
+		clientURL:     mgmtURLs[0],
+		node:          node,
+		disableRelays: node.DisableRelays,
+		bandwidth:     newBandwidthLimiter(node.BandwidthLimit),
+		minPeers:      node.MinPeers,
+		onConnect:     node.OnConnect,
+		forceRelay:    relayHostPort(node.ForceRelay),
+		dials:         a.dialCounter(nodeName),
+		name:          nodeName,
+		metrics:       a.metrics,
+		statsd:        a.statsd,
+		statusMaxAge:  a.statusCacheTTL(),
+		startTimeout:  a.startTimeout(),
+		logger:        a.logger.With(zap.String("node", nodeName)),
 	}
+	if node.ForceRelay != "" && !forceRelayedEnv() {
+		mc.logger.Warn("force_relay only rejects dials to peers not connected via the relay; set NB_FORCE_RELAY=true to connect to peers via relay",
+			zap.String("relay", node.ForceRelay))
+	}
+	if node.ReconnectMin > 0 {
+		maxDelay := time.Duration(node.ReconnectMax)
+		if maxDelay <= 0 {
+			maxDelay = 10 * time.Duration(node.ReconnectMin)
+		}
+		mc.reconnect = &reconnector{
+			minDelay: time.Duration(node.ReconnectMin),
+			maxDelay: maxDelay,
+		}
+	}
+	mc.client.Store(client)
+	return mc, nil
+}
 
-	client, err := embed.New(opts)
-	if err != nil {
-		return nil, fmt.Errorf("create netbird client: %w", err)
+// resolveNodeConfig returns the node config merged with app defaults and
+// with secret references resolved.
+func (a *App) resolveNodeConfig(nodeName string) (Node, error) {
+	node := a.resolveNode(nodeName)
+	if err := a.resolveSecrets(&node); err != nil {
+		return Node{}, fmt.Errorf("node %q: %w", nodeName, err)
 	}
+	return node, nil
+}
 
-	return &ManagedClient{
-		client: client,
-		logger: a.logger.With(zap.String("node", nodeName)),
-	}, nil
+// clientFactory returns a function creating an embed.Client for the
+// resolved node config and the given management URL.
+func (a *App) clientFactory(nodeName string, node Node) func(mgmtURL string) (*embed.Client, error) {
+	hostname := deviceName(nodeName, node)
+
+	blockInbound := node.BlockInbound == nil || *node.BlockInbound
+	var mtu *uint16
+	if node.MTU != 0 {
+		mtu = &node.MTU
+	}
+	return func(mgmtURL string) (*embed.Client, error) {
+		client, err := embed.New(embed.Options{
+			DeviceName:    hostname,
+			ManagementURL: mgmtURL,
+			SetupKey:      node.SetupKey,
+			BlockInbound:  blockInbound,
+			PreSharedKey:  node.PreSharedKey,
+			WireguardPort: node.WireguardPort,
+			MTU:           mtu,
+			DNSLabels:     node.DNSLabels,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("create netbird client: %w", err)
+		}
+		return client, nil
+	}
+}
+
+// deviceName returns the name the node registers with in the NetBird
+// network: its hostname, or one derived from the node name.
+func deviceName(nodeName string, node Node) string {
+	if node.Hostname != "" {
+		return node.Hostname
+	}
+	return "caddy-" + nodeName
 }
 
 // resolveNode merges app defaults with the named node config.
@@ -215,7 +788,7 @@ func (a *App) resolveNode(name string) Node {
 		node = *n
 	}
 
-	if node.ManagementURL == "" {
+	if len(node.managementURLs()) == 0 {
 		node.ManagementURL = a.DefaultManagementURL
 	}
 	if node.SetupKey == "" {
@@ -224,15 +797,109 @@ func (a *App) resolveNode(name string) Node {
 	return node
 }
 
+// managementURLs returns the primary management URL followed by the
+// fallbacks, skipping empty entries and duplicates.
+func (n Node) managementURLs() []string {
+	var urls []string
+	for _, u := range append([]string{n.ManagementURL}, n.ManagementURLs...) {
+		if u != "" && !slices.Contains(urls, u) {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
 // ManagedClient wraps an embed.Client with lifecycle management and ref-counting.
 type ManagedClient struct {
-	client  *embed.Client
-	logger  *zap.Logger
-	started bool
-	mu      sync.Mutex
+	client    atomic.Pointer[embed.Client]
+	newClient func(mgmtURL string) (*embed.Client, error)
+	mgmtURLs  []string
+	clientURL string
+	// name is the node name the client was created for.
+	name string
+	// node is the resolved config the client was created from.
+	node      Node
+	logger    *zap.Logger
+	started   bool
+	mu        sync.Mutex
+	health    atomic.Pointer[NodeHealth]
+	reconnect *reconnector
+	// restarting is set while the reconnect watcher restarts the client.
+	restarting atomic.Bool
+
+	disableRelays bool
+	// forceRelay is the host:port of the relay dialed peers must be
+	// connected via, empty if any connection is fine.
+	forceRelay string
+	// peerRelays maps the IPs and FQDNs of connected peers to the
+	// host:port of their relay, empty if connected directly, as seen by
+	// the last health check.
+	peerRelays atomic.Pointer[map[string]string]
+	// dials counts the dials through the client for the dial rate.
+	dials *dialCounter
+	// bandwidth limits the connections dialed through the client, nil if
+	// unlimited.
+	bandwidth *bandwidthLimiter
+	// minPeers is the connected peer count below which the health check
+	// warns, zero if disabled.
+	minPeers int
+	// peersBelowMin is whether the connected peers were below minPeers at
+	// the last health check.
+	peersBelowMin atomic.Bool
+	// onConnect is the command run once the client is connected.
+	onConnect []string
+	// connected is whether the client reached management and signal since
+	// it was started.
+	connected atomic.Bool
+	// history holds the recent connectivity changes seen by the health
+	// check.
+	history historyRing
+	// peerRelayed maps the IPs and FQDNs of connected peers to whether they
+	// are connected via relay, as seen by the last health check.
+	peerRelayed atomic.Pointer[map[string]bool]
+	// peerIPs maps peer FQDNs to their NetBird IPs, as seen by the last
+	// health check.
+	peerIPs atomic.Pointer[map[string]string]
+	// peerKeys maps peer NetBird IPs to their public keys, as seen by the
+	// last health check.
+	peerKeys atomic.Pointer[map[string]string]
+
+	metrics *metrics
+	statsd  *statsdExporter
+
+	// status caches the client status for admin API callers for up to
+	// statusMaxAge.
+	status       statusCache
+	statusMaxAge time.Duration
+
+	// startTimeout bounds each attempt to start the client.
+	startTimeout time.Duration
+	// startClient starts an embed client, calling its Start method if nil.
+	startClient func(ctx context.Context, client *embed.Client) error
+	// stopClient stops an embed client, calling its Stop method if nil.
+	stopClient func(ctx context.Context, client *embed.Client) error
+
+	tlsSessionsOnce sync.Once
+	tlsSessions     tls.ClientSessionCache
+
+	// lastErr is the last start, stop or health check error, kept for the
+	// status output. Guarded by errMu.
+	errMu   sync.Mutex
+	lastErr *nodeError
+}
+
+// TLSSessionCache returns the TLS session cache shared by all upstream
+// connections through this node, so sessions are resumed across transports
+// dialing the same backend. Sessions are keyed by server name.
+func (mc *ManagedClient) TLSSessionCache() tls.ClientSessionCache {
+	mc.tlsSessionsOnce.Do(func() {
+		mc.tlsSessions = tls.NewLRUClientSessionCache(0)
+	})
+	return mc.tlsSessions
 }
 
-// Start starts the NetBird client. Idempotent.
+// Start starts the NetBird client, trying each configured management URL
+// in order until one succeeds. Idempotent.
 func (mc *ManagedClient) Start(ctx context.Context) error {
 	mc.mu.Lock()
 	defer mc.mu.Unlock()
@@ -241,17 +908,226 @@ func (mc *ManagedClient) Start(ctx context.Context) error {
 		return nil
 	}
 
-	mc.logger.Info("starting netbird client")
-	if err := mc.client.Start(ctx); err != nil {
-		return fmt.Errorf("start netbird client: %w", err)
+	err := startWithFailover(mc.mgmtURLs, func(mgmtURL string) error {
+		client := mc.client.Load()
+		if mgmtURL != mc.clientURL {
+			newClient, err := mc.newClient(mgmtURL)
+			if err != nil {
+				return err
+			}
+			client = newClient
+			mc.client.Store(client)
+			mc.clientURL = mgmtURL
+		}
+
+		mc.logger.Info("starting netbird client", zap.String("management_url", mgmtURL))
+		start := mc.startClient
+		if start == nil {
+			start = func(ctx context.Context, client *embed.Client) error {
+				return client.Start(ctx)
+			}
+		}
+		err := startWithTimeout(ctx, mc.startTimeout, func(ctx context.Context) error {
+			return start(withLogNode(ctx, mc.name), client)
+		})
+		if err != nil {
+			mc.logger.Warn("start netbird client", zap.String("management_url", mgmtURL), zap.Error(err))
+			mc.stopFailed(client, mgmtURL)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		err = fmt.Errorf("start netbird client: %w", err)
+		mc.setLastError(err)
+		return err
 	}
 	mc.started = true
+	nodeLogs.started(mc.name)
 	return nil
 }
 
+// stopFailed stops a client whose start failed, so nothing it set up keeps
+// running when the next management URL is tried.
+func (mc *ManagedClient) stopFailed(client *embed.Client, mgmtURL string) {
+	ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+	defer cancel()
+
+	if err := mc.stopEmbed(ctx, client); err != nil && !errors.Is(err, embed.ErrClientNotStarted) {
+		mc.logger.Warn("stop netbird client after failed start", zap.String("management_url", mgmtURL), zap.Error(err))
+	}
+}
+
+// stopEmbed stops client with the stopClient hook, or its Stop method.
+func (mc *ManagedClient) stopEmbed(ctx context.Context, client *embed.Client) error {
+	if mc.stopClient != nil {
+		return mc.stopClient(ctx, client)
+	}
+	return client.Stop(ctx)
+}
+
+// startWithFailover calls start for each management URL in order and stops
+// at the first success. If all attempts fail, the errors are joined.
+func startWithFailover(mgmtURLs []string, start func(mgmtURL string) error) error {
+	var errs []error
+	for _, mgmtURL := range mgmtURLs {
+		err := start(mgmtURL)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", mgmtURL, err))
+	}
+	return errors.Join(errs...)
+}
+
+// startWithTimeout calls start with a context that expires after timeout,
+// if positive. If start fails because the deadline passed, the error says
+// so, instead of only reporting the context error.
+func startWithTimeout(ctx context.Context, timeout time.Duration, start func(context.Context) error) error {
+	if timeout <= 0 {
+		return start(ctx)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err := start(ctx)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w after %s: %w", ErrStartTimeout, timeout, err)
+	}
+	return err
+}
+
+// startTimeout returns the configured start timeout or the default.
+func (a *App) startTimeout() time.Duration {
+	if a.StartTimeout <= 0 {
+		return defaultStartTimeout
+	}
+	return time.Duration(a.StartTimeout)
+}
+
 // Client returns the underlying embed.Client.
 func (mc *ManagedClient) Client() *embed.Client {
-	return mc.client
+	return mc.client.Load()
+}
+
+// DialContext dials address through the NetBird network, enforcing the
+// node's relay policy. A peer FQDN is dialed via the peer's NetBird IP.
+func (mc *ManagedClient) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	address = mc.resolvePeer(address)
+	if err := mc.checkRelayPolicy(address); err != nil {
+		return nil, err
+	}
+	mc.dials.add(time.Now())
+	if mc.metrics == nil && mc.statsd == nil {
+		return mc.limitBandwidth(mc.Client().DialContext(ctx, network, address))
+	}
+
+	start := time.Now()
+	conn, err := mc.Client().DialContext(ctx, network, address)
+	elapsed := time.Since(start)
+	mc.metrics.observeDial(ctx, mc.name, network, mc.peerKey(address), elapsed, err)
+	mc.statsd.observeDial(mc.name, network, elapsed, err)
+	return mc.limitBandwidth(conn, err)
+}
+
+// limitBandwidth applies the node's bandwidth limit to a dialed conn.
+func (mc *ManagedClient) limitBandwidth(conn net.Conn, err error) (net.Conn, error) {
+	if err != nil {
+		return nil, err
+	}
+	return mc.bandwidth.wrap(conn), nil
+}
+
+// peerKey returns the public key of the peer at address, or an empty string
+// if it is not a known peer.
+func (mc *ManagedClient) peerKey(address string) string {
+	keys := mc.peerKeys.Load()
+	if keys == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	return (*keys)[host]
+}
+
+// resolvePeer replaces a peer FQDN in address with the peer's NetBird IP
+// from the last health check, so upstreams can be configured by name even if
+// the IP changes. Unknown hosts are returned unchanged and resolved by the
+// client's regular DNS.
+func (mc *ManagedClient) resolvePeer(address string) string {
+	peers := mc.peerIPs.Load()
+	if peers == nil {
+		return address
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		host, port = address, ""
+	}
+	ip, ok := (*peers)[strings.ToLower(strings.TrimSuffix(host, "."))]
+	if !ok {
+		return address
+	}
+	if port == "" {
+		return ip
+	}
+	return net.JoinHostPort(ip, port)
+}
+
+// checkRelayPolicy returns ErrRelayedPeer if relays are disabled and the
+// host of address is a peer last seen connected via relay, and
+// ErrNotForcedRelay if a relay is forced and the peer was last seen
+// connected another way.
+func (mc *ManagedClient) checkRelayPolicy(address string) error {
+	if mc.forceRelay != "" {
+		if relay, known := mc.peerRelay(address); known && relay != mc.forceRelay {
+			return fmt.Errorf("dial %s: %w", address, ErrNotForcedRelay)
+		}
+		return nil
+	}
+	if !mc.disableRelays {
+		return nil
+	}
+	if relayed, _ := mc.PeerRelayed(address); relayed {
+		return fmt.Errorf("dial %s: %w", address, ErrRelayedPeer)
+	}
+	return nil
+}
+
+// peerRelay returns the host:port of the relay the peer at address was
+// connected via at the last health check, empty if connected directly.
+// known is false if the peer wasn't connected or hasn't been checked yet.
+func (mc *ManagedClient) peerRelay(address string) (relay string, known bool) {
+	peers := mc.peerRelays.Load()
+	if peers == nil {
+		return "", false
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	relay, known = (*peers)[strings.ToLower(strings.TrimSuffix(host, "."))]
+	return relay, known
+}
+
+// PeerRelayed reports whether the peer at address (NetBird IP or FQDN, with
+// or without port) was connected via relay at the last health check. known
+// is false if the peer wasn't connected or hasn't been checked yet.
+func (mc *ManagedClient) PeerRelayed(address string) (relayed, known bool) {
+	peers := mc.peerRelayed.Load()
+	if peers == nil {
+		return false, false
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	relayed, known = (*peers)[strings.ToLower(strings.TrimSuffix(host, "."))]
+	return relayed, known
 }
 
 // stop stops the client if running. Idempotent.
@@ -265,12 +1141,19 @@ func (mc *ManagedClient) stop() error {
 
 	mc.logger.Info("stopping netbird client")
 	mc.started = false
+	mc.connected.Store(false)
+	nodeLogs.stopped(mc.name)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
 	defer cancel()
 
-	if err := mc.client.Stop(ctx); err != nil {
-		return fmt.Errorf("stop netbird client: %w", err)
+	if err := mc.stopEmbed(ctx, mc.Client()); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("%w after %s", ErrStopTimeout, stopTimeout)
+		}
+		err = fmt.Errorf("stop netbird client: %w", err)
+		mc.setLastError(err)
+		return err
 	}
 	return nil
 }
@@ -286,6 +1169,7 @@ func (mc *ManagedClient) Destruct() error {
 //	    netbird {
 //	        management_url https://api.netbird.io:443
 //	        setup_key {$NB_SETUP_KEY}
+//	        health_check_interval 10s
 //	        node mynode {
 //	            setup_key {$NB_KEY}
 //	            hostname my-caddy
@@ -313,12 +1197,185 @@ func parseGlobalOption(d *caddyfile.Dispenser, _ any) (any, error) {
 			}
 			app.DefaultSetupKey = d.Val()
 
+		case "default_node":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			app.DefaultNode = d.Val()
+			if d.NextArg() {
+				return nil, d.ArgErr()
+			}
+
 		case "log_level":
 			if !d.NextArg() {
 				return nil, d.ArgErr()
 			}
 			app.LogLevel = d.Val()
 
+		case "log_file":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			app.LogFile = d.Val()
+			if d.NextArg() {
+				return nil, d.ArgErr()
+			}
+
+		case "log_file_max_size":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			n, err := strconv.Atoi(d.Val())
+			if err != nil {
+				return nil, d.Errf("invalid log_file_max_size: %v", err)
+			}
+			if n <= 0 {
+				return nil, d.Errf("log_file_max_size must be positive")
+			}
+			app.LogFileMaxSize = n
+
+		case "ping_timeout":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return nil, d.Errf("invalid ping_timeout: %v", err)
+			}
+			app.PingTimeout = caddy.Duration(dur)
+
+		case "health_check_interval":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return nil, d.Errf("invalid health_check_interval: %v", err)
+			}
+			app.HealthCheckInterval = caddy.Duration(dur)
+
+		case "heartbeat_interval":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return nil, d.Errf("invalid heartbeat_interval: %v", err)
+			}
+			if dur <= 0 {
+				return nil, d.Errf("heartbeat_interval must be positive")
+			}
+			app.HeartbeatInterval = caddy.Duration(dur)
+
+		case "status_file":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			app.StatusFile = d.Val()
+			if d.NextArg() {
+				return nil, d.ArgErr()
+			}
+
+		case "status_file_interval":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return nil, d.Errf("invalid status_file_interval: %v", err)
+			}
+			if dur <= 0 {
+				return nil, d.Errf("status_file_interval must be positive")
+			}
+			app.StatusFileInterval = caddy.Duration(dur)
+
+		case "status_cache_ttl":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return nil, d.Errf("invalid status_cache_ttl: %v", err)
+			}
+			app.StatusCacheTTL = caddy.Duration(dur)
+
+		case "start_timeout":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return nil, d.Errf("invalid start_timeout: %v", err)
+			}
+			if dur <= 0 {
+				return nil, d.Errf("start_timeout must be positive")
+			}
+			app.StartTimeout = caddy.Duration(dur)
+
+		case "metrics":
+			app.Metrics = true
+
+		case "lazy_start":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			val, err := strconv.ParseBool(d.Val())
+			if err != nil {
+				return nil, d.Errf("invalid lazy_start: %v", err)
+			}
+			app.LazyStart = &val
+
+		case "share_identical_nodes":
+			if d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			app.ShareIdenticalNodes = true
+
+		case "tenant":
+			args := d.RemainingArgs()
+			if len(args) < 2 {
+				return nil, d.ArgErr()
+			}
+			if app.Tenants == nil {
+				app.Tenants = make(map[string][]string)
+			}
+			app.Tenants[args[0]] = append(app.Tenants[args[0]], args[1:]...)
+
+		case "status_token":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			app.StatusToken = d.Val()
+
+		case "statsd":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			app.Statsd = d.Val()
+
+		case "max_concurrent_pings":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			n, err := strconv.Atoi(d.Val())
+			if err != nil {
+				return nil, d.Errf("invalid max_concurrent_pings: %v", err)
+			}
+			if n <= 0 {
+				return nil, d.Errf("max_concurrent_pings must be positive")
+			}
+			app.MaxConcurrentPings = n
+
+		case "scan_allow":
+			entries := d.RemainingArgs()
+			if len(entries) == 0 {
+				return nil, d.ArgErr()
+			}
+			if _, err := parsePrefixes(entries); err != nil {
+				return nil, d.Errf("invalid scan_allow: %v", err)
+			}
+			app.ScanAllow = append(app.ScanAllow, entries...)
+
 		case "node":
 			if !d.NextArg() {
 				return nil, d.ArgErr()
@@ -347,11 +1404,18 @@ func parseNode(d *caddyfile.Dispenser) (*Node, error) {
 
 	for d.NextBlock(1) {
 		switch d.Val() {
-		case "management_url":
-			if !d.NextArg() {
+		case "management_url", "management_urls":
+			urls := d.RemainingArgs()
+			if len(urls) == 0 {
 				return nil, d.ArgErr()
 			}
-			node.ManagementURL = d.Val()
+			for _, u := range urls {
+				if node.ManagementURL == "" {
+					node.ManagementURL = u
+				} else {
+					node.ManagementURLs = append(node.ManagementURLs, u)
+				}
+			}
 
 		case "setup_key":
 			if !d.NextArg() {
@@ -369,6 +1433,9 @@ func parseNode(d *caddyfile.Dispenser) (*Node, error) {
 			if !d.NextArg() {
 				return nil, d.ArgErr()
 			}
+			if err := validatePreSharedKey(d.Val()); err != nil {
+				return nil, d.Err(err.Error())
+			}
 			node.PreSharedKey = d.Val()
 
 		case "wireguard_port":
@@ -381,6 +1448,91 @@ func parseNode(d *caddyfile.Dispenser) (*Node, error) {
 			}
 			node.WireguardPort = &port
 
+		case "mtu":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			mtu, err := strconv.ParseUint(d.Val(), 10, 16)
+			if err != nil {
+				return nil, d.Errf("invalid mtu: %v", err)
+			}
+			if mtu < minMTU || mtu > maxMTU {
+				return nil, d.Errf("invalid mtu %d: %v", mtu, ErrInvalidMTU)
+			}
+			node.MTU = uint16(mtu)
+
+		case "dns_labels":
+			labels := d.RemainingArgs()
+			if len(labels) == 0 {
+				return nil, d.ArgErr()
+			}
+			node.DNSLabels = append(node.DNSLabels, labels...)
+			if err := validateDNSLabels(node.DNSLabels); err != nil {
+				return nil, d.Err(err.Error())
+			}
+
+		case "disable_relays":
+			node.DisableRelays = true
+
+		case "force_relay":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			if err := validateRelayAddress(d.Val()); err != nil {
+				return nil, d.Errf("invalid force_relay: %v", err)
+			}
+			node.ForceRelay = d.Val()
+			if d.NextArg() {
+				return nil, d.ArgErr()
+			}
+
+		case "bandwidth_limit":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			limit, err := parseBandwidth(d.Val())
+			if err != nil {
+				return nil, d.Errf("invalid bandwidth_limit: %v", err)
+			}
+			node.BandwidthLimit = limit
+
+		case "min_peers":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			n, err := strconv.Atoi(d.Val())
+			if err != nil {
+				return nil, d.Errf("invalid min_peers: %v", err)
+			}
+			if n < 0 {
+				return nil, d.Errf("invalid min_peers %d: %w", n, ErrInvalidMinPeers)
+			}
+			node.MinPeers = n
+
+		case "on_connect":
+			node.OnConnect = d.RemainingArgs()
+			if len(node.OnConnect) == 0 {
+				return nil, d.ArgErr()
+			}
+
+		case "reconnect_min", "reconnect_max":
+			opt := d.Val()
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return nil, d.Errf("invalid %s: %v", opt, err)
+			}
+			if dur <= 0 {
+				return nil, d.Errf("%s must be positive", opt)
+			}
+			if opt == "reconnect_min" {
+				node.ReconnectMin = caddy.Duration(dur)
+			} else {
+				node.ReconnectMax = caddy.Duration(dur)
+			}
+
 		case "block_inbound":
 			if !d.NextArg() {
 				return nil, d.ArgErr()
@@ -400,7 +1552,8 @@ func parseNode(d *caddyfile.Dispenser) (*Node, error) {
 }
 
 var (
-	_ caddy.App         = (*App)(nil)
-	_ caddy.Provisioner = (*App)(nil)
-	_ caddy.Validator   = (*App)(nil)
+	_ caddy.App          = (*App)(nil)
+	_ caddy.Provisioner  = (*App)(nil)
+	_ caddy.Validator    = (*App)(nil)
+	_ caddy.CleanerUpper = (*App)(nil)
 )
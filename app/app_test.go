@@ -1,14 +1,20 @@
 package app
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"strconv"
 	"testing"
+	"time"
 
+	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/netbirdio/netbird/client/embed"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
 )
 
 // parseAndDecode parses a netbird Caddyfile block and decodes the resulting JSON into an App.
@@ -33,10 +39,11 @@ func TestParseGlobalOption(t *testing.T) {
 		management_url https://api.netbird.io:443
 		setup_key test-key-123
 		log_level debug
+		ping_timeout 10s
 
 		node ingress {
 			hostname caddy-ingress
-			pre_shared_key psk123
+			pre_shared_key ZmFrZS1wc2stZm9yLXRlc3RzLW9ubHktMzItYnl0ZXM=
 			wireguard_port 51820
 		}
 	}`)
@@ -44,11 +51,12 @@ func TestParseGlobalOption(t *testing.T) {
 	assert.Equal(t, "https://api.netbird.io:443", app.DefaultManagementURL)
 	assert.Equal(t, "test-key-123", app.DefaultSetupKey)
 	assert.Equal(t, "debug", app.LogLevel)
+	assert.Equal(t, 10*time.Second, time.Duration(app.PingTimeout))
 
 	require.Contains(t, app.Nodes, "ingress")
 	node := app.Nodes["ingress"]
 	assert.Equal(t, "caddy-ingress", node.Hostname)
-	assert.Equal(t, "psk123", node.PreSharedKey)
+	assert.Equal(t, testPreSharedKey, node.PreSharedKey)
 	require.NotNil(t, node.WireguardPort)
 	assert.Equal(t, 51820, *node.WireguardPort)
 }
@@ -103,7 +111,7 @@ func TestParseGlobalOption_NodeAllOptions(t *testing.T) {
 			management_url https://mgmt.example.com:443
 			setup_key node-key
 			hostname my-caddy
-			pre_shared_key secret
+			pre_shared_key ZmFrZS1wc2stZm9yLXRlc3RzLW9ubHktMzItYnl0ZXM=
 			wireguard_port 51821
 		}
 	}`)
@@ -113,7 +121,7 @@ func TestParseGlobalOption_NodeAllOptions(t *testing.T) {
 	assert.Equal(t, "https://mgmt.example.com:443", node.ManagementURL)
 	assert.Equal(t, "node-key", node.SetupKey)
 	assert.Equal(t, "my-caddy", node.Hostname)
-	assert.Equal(t, "secret", node.PreSharedKey)
+	assert.Equal(t, testPreSharedKey, node.PreSharedKey)
 	require.NotNil(t, node.WireguardPort)
 	assert.Equal(t, 51821, *node.WireguardPort)
 }
@@ -148,13 +156,13 @@ func TestParseGlobalOption_InvalidWireguardPort(t *testing.T) {
 
 func TestValidate(t *testing.T) {
 	tests := []struct {
-		name    string
-		app     App
-		wantErr error
+		name     string
+		app      *App
+		wantErrs []error
 	}{
 		{
 			name: "valid with defaults",
-			app: App{
+			app: &App{
 				DefaultManagementURL: "https://api.netbird.io",
 				DefaultSetupKey:      "key",
 				Nodes:                map[string]*Node{"web": {}},
@@ -162,7 +170,7 @@ func TestValidate(t *testing.T) {
 		},
 		{
 			name: "valid with node override",
-			app: App{
+			app: &App{
 				Nodes: map[string]*Node{
 					"web": {
 						ManagementURL: "https://mgmt.example.com",
@@ -173,23 +181,35 @@ func TestValidate(t *testing.T) {
 		},
 		{
 			name: "missing management_url",
-			app: App{
+			app: &App{
 				DefaultSetupKey: "key",
 				Nodes:           map[string]*Node{"web": {}},
 			},
-			wantErr: ErrMissingManagementURL,
+			wantErrs: []error{ErrMissingManagementURL},
 		},
 		{
 			name: "missing setup_key",
-			app: App{
+			app: &App{
 				DefaultManagementURL: "https://api.netbird.io",
 				Nodes:                map[string]*Node{"web": {}},
 			},
-			wantErr: ErrMissingSetupKey,
+			wantErrs: []error{ErrMissingSetupKey},
+		},
+		{
+			name: "errors of all nodes",
+			app: &App{
+				Nodes: map[string]*Node{
+					"api": {SetupKey: "key"},
+					"web": {ManagementURL: "https://mgmt.example.com"},
+					"db":  {ManagementURL: "https://mgmt.example.com", SetupKey: "key", MTU: 100},
+					"ok":  {ManagementURL: "https://mgmt.example.com", SetupKey: "key"},
+				},
+			},
+			wantErrs: []error{ErrMissingManagementURL, ErrMissingSetupKey, ErrInvalidMTU},
 		},
 		{
 			name: "no nodes is valid",
-			app: App{
+			app: &App{
 				Nodes: map[string]*Node{},
 			},
 		},
@@ -198,10 +218,12 @@ func TestValidate(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			err := tt.app.Validate()
-			if tt.wantErr != nil {
-				require.True(t, errors.Is(err, tt.wantErr), "expected %v, got %v", tt.wantErr, err)
-			} else {
+			if len(tt.wantErrs) == 0 {
 				assert.NoError(t, err)
+				return
+			}
+			for _, wantErr := range tt.wantErrs {
+				require.True(t, errors.Is(err, wantErr), "expected %v, got %v", wantErr, err)
 			}
 		})
 	}
@@ -244,3 +266,541 @@ func TestResolveNode(t *testing.T) {
 		assert.Equal(t, "", node.Hostname)
 	})
 }
+
+func TestParseGlobalOption_ManagementURLList(t *testing.T) {
+	app := parseAndDecode(t, `netbird {
+		setup_key key
+
+		node repeated {
+			management_url https://mgmt1.example.com:443
+			management_url https://mgmt2.example.com:443
+		}
+
+		node list {
+			management_urls https://mgmt1.example.com:443 https://mgmt2.example.com:443 https://mgmt3.example.com:443
+		}
+	}`)
+
+	repeated := app.Nodes["repeated"]
+	require.NotNil(t, repeated)
+	assert.Equal(t, "https://mgmt1.example.com:443", repeated.ManagementURL)
+	assert.Equal(t, []string{"https://mgmt2.example.com:443"}, repeated.ManagementURLs)
+
+	list := app.Nodes["list"]
+	require.NotNil(t, list)
+	assert.Equal(t, []string{
+		"https://mgmt1.example.com:443",
+		"https://mgmt2.example.com:443",
+		"https://mgmt3.example.com:443",
+	}, list.managementURLs())
+}
+
+func TestParseGlobalOption_ManagementURLsMissingArg(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`netbird {
+		node test {
+			management_urls
+		}
+	}`)
+	_, err := parseGlobalOption(d, nil)
+	require.Error(t, err)
+}
+
+func TestNodeManagementURLs(t *testing.T) {
+	assert.Empty(t, Node{}.managementURLs())
+	assert.Equal(t, []string{"https://a"}, Node{ManagementURL: "https://a"}.managementURLs())
+	assert.Equal(t, []string{"https://b", "https://c"}, Node{ManagementURLs: []string{"https://b", "", "https://c"}}.managementURLs())
+	assert.Equal(t, []string{"https://a", "https://b"}, Node{
+		ManagementURL:  "https://a",
+		ManagementURLs: []string{"https://a", "https://b"},
+	}.managementURLs(), "duplicates are skipped")
+}
+
+func TestResolveNode_FallbackURLsOnly(t *testing.T) {
+	app := &App{
+		DefaultManagementURL: "https://default.example.com",
+		DefaultSetupKey:      "key",
+		Nodes: map[string]*Node{
+			"ha": {ManagementURLs: []string{"https://a.example.com", "https://b.example.com"}},
+		},
+	}
+
+	node := app.resolveNode("ha")
+	assert.Empty(t, node.ManagementURL, "explicit fallback list should not be mixed with the default")
+	assert.Equal(t, []string{"https://a.example.com", "https://b.example.com"}, node.managementURLs())
+	assert.NoError(t, app.Validate())
+}
+
+func TestStartWithFailover(t *testing.T) {
+	urls := []string{"https://a", "https://b", "https://c"}
+
+	t.Run("first succeeds", func(t *testing.T) {
+		var tried []string
+		err := startWithFailover(urls, func(u string) error {
+			tried = append(tried, u)
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"https://a"}, tried)
+	})
+
+	t.Run("fails over to next", func(t *testing.T) {
+		var tried []string
+		err := startWithFailover(urls, func(u string) error {
+			tried = append(tried, u)
+			if u == "https://b" {
+				return nil
+			}
+			return errors.New("unreachable")
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"https://a", "https://b"}, tried)
+	})
+
+	t.Run("all fail aggregates errors", func(t *testing.T) {
+		errDown := errors.New("unreachable")
+		err := startWithFailover(urls, func(string) error { return errDown })
+		require.ErrorIs(t, err, errDown)
+		for _, u := range urls {
+			assert.Contains(t, err.Error(), u)
+		}
+	})
+}
+
+func TestManagedClientStart_StopsFailedClient(t *testing.T) {
+	clients := make(map[string]*embed.Client)
+	var stopped []*embed.Client
+	mc := &ManagedClient{
+		name:     "web",
+		mgmtURLs: []string{"https://a", "https://b"},
+		newClient: func(mgmtURL string) (*embed.Client, error) {
+			clients[mgmtURL] = new(embed.Client)
+			return clients[mgmtURL], nil
+		},
+		startClient: func(_ context.Context, client *embed.Client) error {
+			if client == clients["https://a"] {
+				return errors.New("unreachable")
+			}
+			return nil
+		},
+		stopClient: func(ctx context.Context, client *embed.Client) error {
+			_, ok := ctx.Deadline()
+			assert.True(t, ok, "stopping should be bounded")
+			stopped = append(stopped, client)
+			return nil
+		},
+		logger: zap.NewNop(),
+	}
+
+	require.NoError(t, mc.Start(context.Background()))
+	assert.Equal(t, []*embed.Client{clients["https://a"]}, stopped, "the abandoned client should be stopped")
+	assert.Same(t, clients["https://b"], mc.Client())
+
+	require.NoError(t, mc.stop())
+}
+
+func TestParseGlobalOption_MTU(t *testing.T) {
+	app := parseAndDecode(t, `netbird {
+		node quic {
+			mtu 1400
+		}
+	}`)
+	require.Contains(t, app.Nodes, "quic")
+	assert.Equal(t, uint16(1400), app.Nodes["quic"].MTU)
+}
+
+func TestParseGlobalOption_InvalidMTU(t *testing.T) {
+	for _, val := range []string{"abc", "-1", "500", "9000", "70000"} {
+		d := caddyfile.NewTestDispenser(`netbird {
+			node test {
+				mtu ` + val + `
+			}
+		}`)
+		_, err := parseGlobalOption(d, nil)
+		require.Error(t, err, val)
+	}
+}
+
+func TestValidate_MTU(t *testing.T) {
+	for _, tt := range []struct {
+		mtu     uint16
+		wantErr bool
+	}{
+		{0, false},
+		{576, false},
+		{1280, false},
+		{1500, false},
+		{8192, false},
+		{575, true},
+		{8193, true},
+	} {
+		a := &App{
+			DefaultManagementURL: "https://api.netbird.io",
+			DefaultSetupKey:      "key",
+			Nodes:                map[string]*Node{"web": {MTU: tt.mtu}},
+		}
+		err := a.Validate()
+		if tt.wantErr {
+			require.ErrorIs(t, err, ErrInvalidMTU, "mtu %d", tt.mtu)
+		} else {
+			require.NoError(t, err, "mtu %d", tt.mtu)
+		}
+	}
+}
+
+func TestParseGlobalOption_DisableRelays(t *testing.T) {
+	app := parseAndDecode(t, `netbird {
+		node p2p {
+			disable_relays
+		}
+	}`)
+	require.Contains(t, app.Nodes, "p2p")
+	assert.True(t, app.Nodes["p2p"].DisableRelays)
+}
+
+func TestCheckRelayPolicy(t *testing.T) {
+	peers := map[string]bool{
+		"100.0.1.10":                false,
+		"backend.netbird.cloud":     false,
+		"100.0.1.20":                true,
+		"api-backend.netbird.cloud": true,
+	}
+
+	mc := &ManagedClient{disableRelays: true}
+	assert.NoError(t, mc.checkRelayPolicy("100.0.1.20:443"), "unknown relay state allows the dial")
+
+	mc.peerRelayed.Store(&peers)
+	require.ErrorIs(t, mc.checkRelayPolicy("100.0.1.20:443"), ErrRelayedPeer)
+	require.ErrorIs(t, mc.checkRelayPolicy("api-backend.netbird.cloud:8080"), ErrRelayedPeer)
+	require.ErrorIs(t, mc.checkRelayPolicy("api-backend.netbird.cloud."), ErrRelayedPeer, "ping addresses have no port")
+	assert.NoError(t, mc.checkRelayPolicy("100.0.1.10:443"), "direct peers are allowed")
+
+	mc.disableRelays = false
+	assert.NoError(t, mc.checkRelayPolicy("100.0.1.20:443"), "policy is off by default")
+}
+
+func TestPeerRelayed(t *testing.T) {
+	mc := &ManagedClient{}
+	_, known := mc.PeerRelayed("100.0.1.20:443")
+	assert.False(t, known, "not checked yet")
+
+	peers := map[string]bool{
+		"100.0.1.10":                false,
+		"100.0.1.20":                true,
+		"api-backend.netbird.cloud": true,
+	}
+	mc.peerRelayed.Store(&peers)
+
+	relayed, known := mc.PeerRelayed("100.0.1.20:443")
+	assert.True(t, known)
+	assert.True(t, relayed)
+
+	relayed, known = mc.PeerRelayed("100.0.1.10:80")
+	assert.True(t, known)
+	assert.False(t, relayed)
+
+	relayed, known = mc.PeerRelayed("api-backend.netbird.cloud.")
+	assert.True(t, known)
+	assert.True(t, relayed)
+
+	_, known = mc.PeerRelayed("192.168.1.5:80")
+	assert.False(t, known, "non-peer addresses are unknown")
+}
+
+func TestValidate_ErrorsPrefixedAndOrdered(t *testing.T) {
+	a := App{
+		Nodes: map[string]*Node{
+			"web": {SetupKey: "key"},
+			"api": {SetupKey: "key"},
+		},
+	}
+
+	err := a.Validate()
+	require.Error(t, err)
+	assert.Equal(t,
+		`node "api": `+ErrMissingManagementURL.Error()+"\n"+`node "web": `+ErrMissingManagementURL.Error(),
+		err.Error(),
+	)
+}
+
+func TestParseGlobalOption_DNSLabels(t *testing.T) {
+	app := parseAndDecode(t, `netbird {
+		node ingress {
+			dns_labels caddy web.caddy
+			dns_labels edge
+		}
+	}`)
+	require.Contains(t, app.Nodes, "ingress")
+	assert.Equal(t, []string{"caddy", "web.caddy", "edge"}, app.Nodes["ingress"].DNSLabels)
+}
+
+func TestParseGlobalOption_InvalidDNSLabels(t *testing.T) {
+	for _, val := range []string{"", "env=prod", "-caddy", "caddy-", "caddy_labels!", "a..b"} {
+		d := caddyfile.NewTestDispenser(`netbird {
+			node test {
+				dns_labels ` + val + `
+			}
+		}`)
+		_, err := parseGlobalOption(d, nil)
+		require.Error(t, err, val)
+	}
+}
+
+func TestValidate_DNSLabels(t *testing.T) {
+	a := App{
+		DefaultManagementURL: "https://api.netbird.io",
+		DefaultSetupKey:      "key",
+		Nodes: map[string]*Node{
+			"ok":  {DNSLabels: []string{"caddy", "ingress.caddy"}},
+			"bad": {DNSLabels: []string{"not a label"}},
+		},
+	}
+	err := a.Validate()
+	require.ErrorIs(t, err, ErrInvalidDNSLabels)
+	assert.Contains(t, err.Error(), `node "bad"`)
+	assert.NotContains(t, err.Error(), `node "ok"`)
+
+	tooMany := make([]string, 33)
+	for i := range tooMany {
+		tooMany[i] = "label" + strconv.Itoa(i)
+	}
+	a.Nodes = map[string]*Node{"web": {DNSLabels: tooMany}}
+	require.ErrorIs(t, a.Validate(), ErrInvalidDNSLabels)
+}
+
+func TestResolvePeer(t *testing.T) {
+	mc := &ManagedClient{}
+	assert.Equal(t, "db.netbird.cloud:5432", mc.resolvePeer("db.netbird.cloud:5432"), "no peer list yet")
+
+	peers := map[string]string{
+		"db.netbird.cloud":  "100.0.1.10",
+		"web.netbird.cloud": "100.0.1.20",
+	}
+	mc.peerIPs.Store(&peers)
+
+	tests := []struct {
+		address string
+		want    string
+	}{
+		{"db.netbird.cloud:5432", "100.0.1.10:5432"},
+		{"DB.NetBird.Cloud:5432", "100.0.1.10:5432"},
+		{"db.netbird.cloud.:5432", "100.0.1.10:5432"},
+		{"web.netbird.cloud", "100.0.1.20"},
+		{"unknown.netbird.cloud:80", "unknown.netbird.cloud:80"},
+		{"example.com:443", "example.com:443"},
+		{"100.0.1.30:80", "100.0.1.30:80"},
+		{"[fd00::1]:80", "[fd00::1]:80"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			assert.Equal(t, tt.want, mc.resolvePeer(tt.address))
+		})
+	}
+}
+
+func TestResolvePeer_IPChange(t *testing.T) {
+	mc := &ManagedClient{}
+
+	peers := map[string]string{"db.netbird.cloud": "100.0.1.10"}
+	mc.peerIPs.Store(&peers)
+	assert.Equal(t, "100.0.1.10:5432", mc.resolvePeer("db.netbird.cloud:5432"))
+
+	updated := map[string]string{"db.netbird.cloud": "100.0.2.10"}
+	mc.peerIPs.Store(&updated)
+	assert.Equal(t, "100.0.2.10:5432", mc.resolvePeer("db.netbird.cloud:5432"), "latest peer list wins")
+}
+
+func TestStartClient_ReleasesOnFailure(t *testing.T) {
+	a := &App{
+		DefaultManagementURL: "https://api.netbird.io:443",
+		Nodes:                map[string]*Node{"web": {SetupKey: "key"}},
+		pool:                 caddy.NewUsagePool(),
+		logger:               zap.NewNop(),
+	}
+
+	// Hold a reference so the client survives the failed start and can be
+	// made to fail before it reaches the network.
+	mc, err := a.getClient("web")
+	require.NoError(t, err)
+	errDown := errors.New("management down")
+	mc.newClient = func(string) (*embed.Client, error) { return nil, errDown }
+	mc.clientURL = ""
+
+	_, err = a.StartClient(context.Background(), "web")
+	require.ErrorIs(t, err, errDown)
+
+	refs, ok := a.pool.References("web")
+	require.True(t, ok)
+	assert.Equal(t, 1, refs, "failed start must release its reference")
+
+	require.NoError(t, a.ReleaseClient("web"))
+	_, ok = a.lookupClient("web")
+	assert.False(t, ok, "pool must be clean")
+}
+
+func TestReleaseClient_EvictsOnLastReference(t *testing.T) {
+	a := &App{
+		DefaultManagementURL: "https://api.netbird.io:443",
+		Nodes:                map[string]*Node{"web": {SetupKey: "key"}},
+		pool:                 caddy.NewUsagePool(),
+		logger:               zap.NewNop(),
+	}
+
+	first, err := a.getClient("web")
+	require.NoError(t, err)
+	second, err := a.getClient("web")
+	require.NoError(t, err)
+	assert.Same(t, first, second)
+
+	require.NoError(t, a.ReleaseClient("web"))
+	_, ok := a.lookupClient("web")
+	assert.True(t, ok, "client stays while referenced")
+
+	require.NoError(t, a.ReleaseClient("web"))
+	_, ok = a.lookupClient("web")
+	assert.False(t, ok, "client is evicted with the last reference")
+}
+
+func TestApp_HasNode(t *testing.T) {
+	a := &App{Nodes: map[string]*Node{"web": {}}}
+	assert.True(t, a.HasNode("web"))
+	assert.True(t, a.HasNode("default"), "default may be configured by app-level defaults")
+	assert.False(t, a.HasNode("db"))
+}
+
+func TestApp_PeerIP(t *testing.T) {
+	a := &App{pool: caddy.NewUsagePool()}
+	web, db := &ManagedClient{}, &ManagedClient{}
+	_, _, err := a.pool.LoadOrNew("web", func() (caddy.Destructor, error) { return web, nil })
+	require.NoError(t, err)
+	_, _, err = a.pool.LoadOrNew("db", func() (caddy.Destructor, error) { return db, nil })
+	require.NoError(t, err)
+
+	_, ok := a.PeerIP("", "web", "db.netbird.cloud")
+	assert.False(t, ok, "no peer list yet")
+
+	webPeers := map[string]string{"db.netbird.cloud": "100.0.1.10"}
+	web.peerIPs.Store(&webPeers)
+	dbPeers := map[string]string{"db.netbird.cloud": "100.99.0.10"}
+	db.peerIPs.Store(&dbPeers)
+
+	ip, ok := a.PeerIP("", "web", "DB.netbird.cloud.")
+	assert.True(t, ok)
+	assert.Equal(t, "100.0.1.10", ip)
+
+	ip, ok = a.PeerIP("", "db", "db.netbird.cloud")
+	assert.True(t, ok)
+	assert.Equal(t, "100.99.0.10", ip, "each node resolves against its own peers")
+
+	_, ok = a.PeerIP("", "web", "unknown.netbird.cloud")
+	assert.False(t, ok)
+	_, ok = a.PeerIP("", "other", "db.netbird.cloud")
+	assert.False(t, ok, "nodes without a client resolve nothing")
+}
+
+func TestStartWithTimeout(t *testing.T) {
+	t.Run("deadline exceeded", func(t *testing.T) {
+		err := startWithTimeout(context.Background(), 10*time.Millisecond, func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		require.ErrorIs(t, err, ErrStartTimeout)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Contains(t, err.Error(), "10ms")
+	})
+
+	t.Run("start error within deadline", func(t *testing.T) {
+		errDown := errors.New("management down")
+		err := startWithTimeout(context.Background(), time.Minute, func(context.Context) error { return errDown })
+		require.ErrorIs(t, err, errDown)
+		assert.NotErrorIs(t, err, ErrStartTimeout)
+	})
+
+	t.Run("sets deadline", func(t *testing.T) {
+		err := startWithTimeout(context.Background(), time.Minute, func(ctx context.Context) error {
+			deadline, ok := ctx.Deadline()
+			require.True(t, ok)
+			assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, 5*time.Second)
+			return nil
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("parent cancellation is not a timeout", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := startWithTimeout(ctx, time.Minute, func(ctx context.Context) error { return ctx.Err() })
+		require.ErrorIs(t, err, context.Canceled)
+		assert.NotErrorIs(t, err, ErrStartTimeout)
+	})
+
+	t.Run("no timeout", func(t *testing.T) {
+		err := startWithTimeout(context.Background(), 0, func(ctx context.Context) error {
+			_, ok := ctx.Deadline()
+			assert.False(t, ok)
+			return nil
+		})
+		require.NoError(t, err)
+	})
+}
+
+func TestApp_StartTimeout(t *testing.T) {
+	assert.Equal(t, defaultStartTimeout, (&App{}).startTimeout())
+	assert.Equal(t, 5*time.Second, (&App{StartTimeout: caddy.Duration(5 * time.Second)}).startTimeout())
+}
+
+func TestParseGlobalOption_StartTimeout(t *testing.T) {
+	app := parseAndDecode(t, `netbird {
+		start_timeout 45s
+	}`)
+	assert.Equal(t, 45*time.Second, time.Duration(app.StartTimeout))
+
+	for _, input := range []string{
+		"netbird {\n start_timeout\n}",
+		"netbird {\n start_timeout soon\n}",
+		"netbird {\n start_timeout 0s\n}",
+	} {
+		_, err := parseGlobalOption(caddyfile.NewTestDispenser(input), nil)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestDefaultNodeName(t *testing.T) {
+	assert.Equal(t, "default", (&App{}).DefaultNodeName())
+	assert.Equal(t, "web", (&App{DefaultNode: "web"}).DefaultNodeName())
+}
+
+func TestValidate_DefaultNode(t *testing.T) {
+	a := &App{
+		DefaultManagementURL: "https://api.netbird.io",
+		DefaultSetupKey:      "key",
+		DefaultNode:          "web",
+		Nodes:                map[string]*Node{"web": {}},
+	}
+	require.NoError(t, a.Validate())
+
+	a.DefaultNode = "default"
+	require.NoError(t, a.Validate(), "the implicit default node is always known")
+
+	a.DefaultNode = "api"
+	err := a.Validate()
+	require.ErrorIs(t, err, ErrUnknownNode)
+	assert.Contains(t, err.Error(), `default_node "api"`)
+}
+
+func TestParseGlobalOption_DefaultNode(t *testing.T) {
+	app := parseAndDecode(t, `netbird {
+		default_node ingress
+		node ingress {
+			setup_key key
+		}
+	}`)
+	assert.Equal(t, "ingress", app.DefaultNode)
+
+	for _, input := range []string{
+		"netbird {\n default_node\n}",
+		"netbird {\n default_node a b\n}",
+	} {
+		_, err := parseGlobalOption(caddyfile.NewTestDispenser(input), nil)
+		assert.Error(t, err, input)
+	}
+}
@@ -0,0 +1,132 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"golang.org/x/time/rate"
+)
+
+// minBandwidthBurst is the smallest token bucket size of a bandwidth limit.
+// It fits the largest UDP datagram, so datagrams are never split.
+const minBandwidthBurst = 64 << 10
+
+// bandwidthLimiter caps the bytes read from and written to all connections
+// dialed through a node, each direction with its own token bucket.
+type bandwidthLimiter struct {
+	in  *rate.Limiter
+	out *rate.Limiter
+}
+
+// newBandwidthLimiter returns a limiter allowing bytesPerSec in each
+// direction, or nil if bytesPerSec is not positive.
+func newBandwidthLimiter(bytesPerSec int64) *bandwidthLimiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	burst := int(max(bytesPerSec, minBandwidthBurst))
+	return &bandwidthLimiter{
+		in:  rate.NewLimiter(rate.Limit(bytesPerSec), burst),
+		out: rate.NewLimiter(rate.Limit(bytesPerSec), burst),
+	}
+}
+
+// wrap returns conn with reads and writes subject to the limits. A nil
+// limiter returns conn unchanged.
+func (l *bandwidthLimiter) wrap(conn net.Conn) net.Conn {
+	if l == nil {
+		return conn
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &rateLimitedConn{Conn: conn, in: l.in, out: l.out, ctx: ctx, cancel: cancel}
+}
+
+// rateLimitedConn is a net.Conn whose reads and writes wait for tokens of
+// the node's bandwidth limiters. Close stops pending waits.
+type rateLimitedConn struct {
+	net.Conn
+	in     *rate.Limiter
+	out    *rate.Limiter
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Read reads from the conn and then waits until the bytes read are within
+// the limit, so the peer is slowed down by backpressure.
+func (c *rateLimitedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		if werr := waitBytes(c.ctx, c.in, n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+// Write writes p in chunks of at most the bucket size, each once the limit
+// allows it. Writes that fit the bucket, such as datagrams, aren't split.
+func (c *rateLimitedConn) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		chunk := p[:min(len(p), c.out.Burst())]
+		if err := c.out.WaitN(c.ctx, len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// CloseWrite shuts down the writing side of the conn if it supports
+// half-closing, so replies still arrive after the client is done sending.
+// Other conns are closed.
+func (c *rateLimitedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Close()
+}
+
+// Close closes the conn and aborts waits for the limiters.
+func (c *rateLimitedConn) Close() error {
+	c.cancel()
+	return c.Conn.Close()
+}
+
+// waitBytes waits until n bytes are within the limit, in steps of at most
+// the bucket size.
+func waitBytes(ctx context.Context, l *rate.Limiter, n int) error {
+	for n > 0 {
+		step := min(n, l.Burst())
+		if err := l.WaitN(ctx, step); err != nil {
+			return err
+		}
+		n -= step
+	}
+	return nil
+}
+
+// parseBandwidth parses a bandwidth such as "10MB/s" or "512KiB/s" into
+// bytes per second. Units follow humanize: "MB" is 10^6, "MiB" 2^20 bytes.
+func parseBandwidth(s string) (int64, error) {
+	amount, ok := strings.CutSuffix(s, "/s")
+	if !ok {
+		return 0, fmt.Errorf("bandwidth %q must be given per second, e.g. 10MB/s", s)
+	}
+	n, err := humanize.ParseBytes(amount)
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 || n > 1<<62 {
+		return 0, fmt.Errorf("bandwidth %q out of range", s)
+	}
+	return int64(n), nil
+}
@@ -0,0 +1,181 @@
+package app
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+)
+
+func TestParseBandwidth(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want int64
+	}{
+		{"10MB/s", 10_000_000},
+		{"512KiB/s", 512 << 10},
+		{"1 GB/s", 1_000_000_000},
+		{"100/s", 100},
+	} {
+		got, err := parseBandwidth(tt.in)
+		require.NoError(t, err, tt.in)
+		assert.Equal(t, tt.want, got, tt.in)
+	}
+
+	for _, in := range []string{"10MB", "0MB/s", "fast/s", "/s", "-1MB/s"} {
+		_, err := parseBandwidth(in)
+		assert.Error(t, err, in)
+	}
+}
+
+func TestNewBandwidthLimiter(t *testing.T) {
+	assert.Nil(t, newBandwidthLimiter(0))
+
+	small := newBandwidthLimiter(1000)
+	assert.Equal(t, minBandwidthBurst, small.out.Burst(), "bucket should fit a datagram")
+
+	large := newBandwidthLimiter(10_000_000)
+	assert.Equal(t, 10_000_000, large.in.Burst())
+
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	assert.Same(t, conn, (*bandwidthLimiter)(nil).wrap(conn), "nil limiter should not wrap")
+}
+
+// limitedPipe returns a conn limited to 100 KB/s with a 10 KB bucket in
+// both directions, and its peer.
+func limitedPipe(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+	l := &bandwidthLimiter{
+		in:  rate.NewLimiter(100_000, 10_000),
+		out: rate.NewLimiter(100_000, 10_000),
+	}
+	conn, peer := net.Pipe()
+	t.Cleanup(func() {
+		conn.Close()
+		peer.Close()
+	})
+	return l.wrap(conn), peer
+}
+
+func TestRateLimitedConn_Write(t *testing.T) {
+	conn, peer := limitedPipe(t)
+	go func() { _, _ = io.Copy(io.Discard, peer) }()
+
+	start := time.Now()
+	n, err := conn.Write(make([]byte, 30_000))
+	elapsed := time.Since(start)
+	require.NoError(t, err)
+	assert.Equal(t, 30_000, n)
+	// The first 10 KB are in the bucket, the other 20 KB take 200ms.
+	assert.GreaterOrEqual(t, elapsed, 150*time.Millisecond)
+	assert.Less(t, elapsed, 2*time.Second)
+}
+
+func TestRateLimitedConn_Read(t *testing.T) {
+	conn, peer := limitedPipe(t)
+	go func() { _, _ = peer.Write(make([]byte, 30_000)) }()
+
+	start := time.Now()
+	buf := make([]byte, 30_000)
+	_, err := io.ReadFull(conn, buf)
+	elapsed := time.Since(start)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, elapsed, 150*time.Millisecond)
+	assert.Less(t, elapsed, 2*time.Second)
+}
+
+func TestRateLimitedConn_CloseStopsWait(t *testing.T) {
+	conn, peer := limitedPipe(t)
+	go func() { _, _ = io.Copy(io.Discard, peer) }()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(make([]byte, 1_000_000))
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, conn.Close())
+
+	select {
+	case err := <-done:
+		assert.Error(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("write still waiting after close")
+	}
+}
+
+func TestRateLimitedConn_CloseWrite(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	go func() {
+		server, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer server.Close()
+		data, _ := io.ReadAll(server)
+		_, _ = server.Write(append([]byte("re: "), data...))
+	}()
+
+	raw, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	conn := newBandwidthLimiter(1_000_000).wrap(raw)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	cw, ok := conn.(interface{ CloseWrite() error })
+	require.True(t, ok, "limited conns must support half-closing")
+	require.NoError(t, cw.CloseWrite())
+
+	reply, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "re: ping", string(reply), "the reply must arrive after half-closing")
+}
+
+func TestRateLimitedConn_CloseWriteUnsupported(t *testing.T) {
+	conn, peer := limitedPipe(t)
+	require.NoError(t, conn.(interface{ CloseWrite() error }).CloseWrite())
+
+	_, err := peer.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF, "conns without half-close are closed")
+}
+
+func TestParseGlobalOption_BandwidthLimit(t *testing.T) {
+	app := parseAndDecode(t, `netbird {
+		node metered {
+			bandwidth_limit 10MB/s
+		}
+	}`)
+	require.Contains(t, app.Nodes, "metered")
+	assert.Equal(t, int64(10_000_000), app.Nodes["metered"].BandwidthLimit)
+}
+
+func TestParseGlobalOption_InvalidBandwidthLimit(t *testing.T) {
+	for _, val := range []string{"", "10MB", "lots/s"} {
+		d := caddyfile.NewTestDispenser(`netbird {
+			node test {
+				bandwidth_limit ` + val + `
+			}
+		}`)
+		_, err := parseGlobalOption(d, nil)
+		require.Error(t, err, val)
+	}
+}
+
+func TestValidate_BandwidthLimit(t *testing.T) {
+	a := &App{
+		DefaultManagementURL: "https://api.netbird.io",
+		DefaultSetupKey:      "key",
+		Nodes:                map[string]*Node{"web": {BandwidthLimit: -1}},
+	}
+	require.ErrorIs(t, a.Validate(), ErrInvalidBandwidth)
+}
@@ -0,0 +1,187 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+const (
+	defaultBenchDuration = 5 * time.Second
+	maxBenchDuration     = 30 * time.Second
+	// maxBenchBytes bounds the data transferred by a single benchmark.
+	maxBenchBytes = 1 << 30
+	// benchBufferSize is the size of the writes of an upload benchmark.
+	benchBufferSize = 32 << 10
+)
+
+// Benchmark directions.
+const (
+	benchUpload   = "upload"
+	benchDownload = "download"
+)
+
+type benchRequest struct {
+	// Node is the name of the NetBird node to dial from.
+	Node string `json:"node"`
+	// Address is the host:port of the TCP backend to measure.
+	Address string `json:"address"`
+	// Duration is how long to transfer data, e.g. "10s". Defaults to five
+	// seconds, capped at 30 seconds.
+	Duration caddy.Duration `json:"duration,omitempty"`
+	// Mode is "upload" to send data to a backend discarding it, or
+	// "download" to read what a backend sends. Default: "upload".
+	Mode string `json:"mode,omitempty"`
+}
+
+type benchResponse struct {
+	// Bytes is the number of bytes transferred.
+	Bytes int64 `json:"bytes"`
+	// Duration is how long the transfer took, in nanoseconds.
+	Duration time.Duration `json:"duration"`
+	// Mbps is the throughput in megabits per second.
+	Mbps  float64 `json:"mbps"`
+	Error string  `json:"error,omitempty"`
+}
+
+// handleBench measures the throughput of a TCP connection through the
+// NetBird network to a backend, bounded in duration and bytes.
+func (a *adminAPI) handleBench(w http.ResponseWriter, r *http.Request) error {
+	var req benchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        fmt.Errorf("decode request: %w", err),
+		}
+	}
+
+	if req.Address == "" {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errors.New("address is required"),
+		}
+	}
+	if req.Node == "" {
+		req.Node = a.app.DefaultNodeName()
+	}
+	if req.Mode == "" {
+		req.Mode = benchUpload
+	}
+	if req.Mode != benchUpload && req.Mode != benchDownload {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        fmt.Errorf("unsupported mode %q: use upload or download", req.Mode),
+		}
+	}
+	if req.Duration < 0 {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errors.New("duration must not be negative"),
+		}
+	}
+
+	mc, ok := a.app.lookupClient(req.Node)
+	if !ok {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("node %q not found", req.Node),
+		}
+	}
+
+	release, ok := a.app.acquirePing()
+	if !ok {
+		return caddy.APIError{
+			HTTPStatus: http.StatusTooManyRequests,
+			Err:        errors.New("too many concurrent pings and benchmarks"),
+		}
+	}
+	defer release()
+
+	resp := a.doBench(r.Context(), mc.Client().DialContext, req.Address, req.Mode, benchDuration(time.Duration(req.Duration)))
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(resp)
+}
+
+// benchDuration returns the duration of a benchmark: the requested one,
+// clamped to maxBenchDuration, or the default.
+func benchDuration(requested time.Duration) time.Duration {
+	if requested <= 0 {
+		return defaultBenchDuration
+	}
+	return min(requested, maxBenchDuration)
+}
+
+// doBench dials address within the ping timeout and measures the
+// throughput of the connection for duration.
+func (a *adminAPI) doBench(ctx context.Context, dial pingDialFunc, address, mode string, duration time.Duration) benchResponse {
+	dialCtx, cancel := context.WithTimeout(ctx, a.pingTimeout())
+	conn, err := dial(dialCtx, "tcp", address)
+	cancel()
+	if err != nil {
+		return benchResponse{Error: err.Error()}
+	}
+	defer conn.Close()
+
+	// Abort the transfer if the admin request goes away.
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	return measureThroughput(conn, mode, duration, maxBenchBytes)
+}
+
+// measureThroughput sends data to conn, or reads from it in download mode,
+// until duration elapsed, maxBytes were transferred or the peer closed the
+// connection. Reaching a bound ends the measurement normally.
+func measureThroughput(conn net.Conn, mode string, duration time.Duration, maxBytes int64) benchResponse {
+	start := time.Now()
+	if err := conn.SetDeadline(start.Add(duration)); err != nil {
+		return benchResponse{Error: fmt.Sprintf("set deadline: %v", err)}
+	}
+
+	var n int64
+	var err error
+	if mode == benchDownload {
+		n, err = io.CopyN(io.Discard, conn, maxBytes)
+	} else {
+		n, err = writeZeros(conn, maxBytes)
+	}
+	elapsed := time.Since(start)
+
+	resp := benchResponse{Bytes: n, Duration: elapsed, Mbps: mbps(n, elapsed)}
+	if err != nil && !errors.Is(err, os.ErrDeadlineExceeded) && !errors.Is(err, io.EOF) {
+		resp.Error = err.Error()
+	}
+	return resp
+}
+
+// writeZeros writes up to maxBytes zero bytes to w in benchBufferSize chunks.
+func writeZeros(w io.Writer, maxBytes int64) (int64, error) {
+	buf := make([]byte, benchBufferSize)
+	var total int64
+	for total < maxBytes {
+		n, err := w.Write(buf[:min(int64(len(buf)), maxBytes-total)])
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// mbps returns the throughput of transferring n bytes in d, in megabits
+// per second.
+func mbps(n int64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(n) * 8 / d.Seconds() / 1e6
+}
@@ -0,0 +1,169 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMbps(t *testing.T) {
+	assert.InDelta(t, 8.0, mbps(1_000_000, time.Second), 1e-9)
+	assert.InDelta(t, 100.0, mbps(12_500_000, time.Second), 1e-9)
+	assert.InDelta(t, 16.0, mbps(1_000_000, 500*time.Millisecond), 1e-9)
+	assert.Zero(t, mbps(1_000_000, 0))
+	assert.Zero(t, mbps(0, time.Second))
+}
+
+func TestBenchDuration(t *testing.T) {
+	assert.Equal(t, defaultBenchDuration, benchDuration(0))
+	assert.Equal(t, 2*time.Second, benchDuration(2*time.Second))
+	assert.Equal(t, maxBenchDuration, benchDuration(time.Hour))
+}
+
+// listenTCP serves each connection accepted on a local TCP listener with
+// handle and returns the listener address.
+func listenTCP(t *testing.T, handle func(net.Conn)) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestMeasureThroughput_DownloadUntilEOF(t *testing.T) {
+	addr := listenTCP(t, func(conn net.Conn) {
+		_, _ = conn.Write(make([]byte, 1<<20))
+	})
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	resp := measureThroughput(conn, benchDownload, 5*time.Second, maxBenchBytes)
+	assert.Empty(t, resp.Error)
+	assert.Equal(t, int64(1<<20), resp.Bytes)
+	assert.Less(t, resp.Duration, 5*time.Second, "peer closing should end the measurement")
+	assert.InDelta(t, mbps(resp.Bytes, resp.Duration), resp.Mbps, 1e-9)
+}
+
+func TestMeasureThroughput_UploadByteBound(t *testing.T) {
+	received := make(chan int64, 1)
+	addr := listenTCP(t, func(conn net.Conn) {
+		n, _ := io.Copy(io.Discard, conn)
+		received <- n
+	})
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+
+	const limit = 3*benchBufferSize + 100
+	resp := measureThroughput(conn, benchUpload, 5*time.Second, limit)
+	require.NoError(t, conn.Close())
+	assert.Empty(t, resp.Error)
+	assert.Equal(t, int64(limit), resp.Bytes)
+	assert.Equal(t, int64(limit), <-received)
+	assert.Positive(t, resp.Mbps)
+}
+
+func TestMeasureThroughput_DurationBound(t *testing.T) {
+	// The backend sends nothing, so only the duration ends the download.
+	addr := listenTCP(t, func(conn net.Conn) {
+		_, _ = io.Copy(io.Discard, conn)
+	})
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	resp := measureThroughput(conn, benchDownload, 100*time.Millisecond, maxBenchBytes)
+	assert.Empty(t, resp.Error, "reaching the duration is not an error")
+	assert.Zero(t, resp.Bytes)
+	assert.GreaterOrEqual(t, resp.Duration, 100*time.Millisecond)
+	assert.Less(t, resp.Duration, 2*time.Second)
+}
+
+func TestDoBench_DialError(t *testing.T) {
+	api := &adminAPI{}
+	dial := func(context.Context, string, string) (net.Conn, error) {
+		return nil, assert.AnError
+	}
+	resp := api.doBench(context.Background(), dial, "10.0.0.1:5201", benchUpload, time.Second)
+	assert.Equal(t, assert.AnError.Error(), resp.Error)
+	assert.Zero(t, resp.Bytes)
+}
+
+func TestDoBench_CancelledRequest(t *testing.T) {
+	addr := listenTCP(t, func(conn net.Conn) {
+		_, _ = io.Copy(io.Discard, conn)
+	})
+	var d net.Dialer
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	(&adminAPI{}).doBench(ctx, d.DialContext, addr, benchDownload, 10*time.Second)
+	assert.Less(t, time.Since(start), 5*time.Second, "a cancelled request should end the benchmark")
+}
+
+func TestHandleBench_Invalid(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{name: "malformed", body: `{`, wantStatus: http.StatusBadRequest},
+		{name: "missing address", body: `{"node":"web"}`, wantStatus: http.StatusBadRequest},
+		{name: "unknown mode", body: `{"address":"10.0.0.1:5201","mode":"both"}`, wantStatus: http.StatusBadRequest},
+		{name: "negative duration", body: `{"address":"10.0.0.1:5201","duration":"-1s"}`, wantStatus: http.StatusBadRequest},
+		{name: "unknown node", body: `{"node":"missing","address":"10.0.0.1:5201"}`, wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &adminAPI{app: &App{pool: caddy.NewUsagePool()}}
+			req := httptest.NewRequest(http.MethodPost, "/netbird/bench", strings.NewReader(tt.body))
+
+			err := api.handleBench(httptest.NewRecorder(), req)
+			var apiErr caddy.APIError
+			require.ErrorAs(t, err, &apiErr)
+			assert.Equal(t, tt.wantStatus, apiErr.HTTPStatus)
+		})
+	}
+}
+
+func TestHandleBench_TooManyConcurrent(t *testing.T) {
+	a := newReloadTestApp(t, fakeSecretProvider{"WEB_KEY": "web-key", "API_KEY": "api-key"})
+	a.pingSlots = make(chan struct{}, 1)
+	release, ok := a.acquirePing()
+	require.True(t, ok)
+	defer release()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/netbird/bench", strings.NewReader(`{"node":"web","address":"100.64.0.1:5201"}`))
+	require.NoError(t, (&adminAPI{app: a}).handleAPI(rec, req))
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+}
+
+func TestBenchResponse_JSON(t *testing.T) {
+	data, err := json.Marshal(benchResponse{Bytes: 1000, Duration: time.Second, Mbps: 0.008})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"bytes":1000,"duration":1000000000,"mbps":0.008}`, string(data))
+}
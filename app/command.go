@@ -0,0 +1,108 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"slices"
+
+	"github.com/caddyserver/caddy/v2"
+	caddycmd "github.com/caddyserver/caddy/v2/cmd"
+	"golang.org/x/exp/maps"
+)
+
+// ValidateCommand returns the netbird-validate command. It isn't registered
+// by the plugin itself, so binaries that merely import it keep their command
+// set; cmd/caddy registers it with caddycmd.RegisterCommand.
+func ValidateCommand() caddycmd.Command {
+	fs := flag.NewFlagSet("netbird-validate", flag.ExitOnError)
+	fs.String("config", "", "Configuration file")
+	fs.String("adapter", "", "Name of config adapter to apply")
+
+	return caddycmd.Command{
+		Name:  "netbird-validate",
+		Usage: "--config <path> [--adapter <name>]",
+		Short: "Validates the NetBird configuration without starting clients",
+		Long: `
+Validates the netbird app of a config file without creating NetBird clients or
+touching the network, so it is safe to run in CI. Every node is checked and its
+secret:// references are resolved, and the errors of all nodes are reported at
+once. Other apps are not validated; use 'caddy validate' for those.
+`,
+		Flags: fs,
+		Func:  cmdValidate,
+	}
+}
+
+func cmdValidate(fl caddycmd.Flags) (int, error) {
+	configFile := fl.String("config")
+	if configFile == "" {
+		return caddy.ExitCodeFailedStartup, errors.New("config file required (use --config flag)")
+	}
+
+	input, _, _, err := caddycmd.LoadConfig(configFile, fl.String("adapter"))
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+
+	a, err := decodeApp(input)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	if err := a.checkConfig(); err != nil {
+		return caddy.ExitCodeFailedStartup, fmt.Errorf("invalid netbird configuration:\n%w", err)
+	}
+
+	fmt.Println("Valid NetBird configuration")
+	return caddy.ExitCodeSuccess, nil
+}
+
+// decodeApp extracts the netbird app from a JSON config.
+func decodeApp(input []byte) (*App, error) {
+	var cfg struct {
+		Apps map[string]json.RawMessage `json:"apps"`
+	}
+	if err := json.Unmarshal(caddy.RemoveMetaFields(input), &cfg); err != nil {
+		return nil, fmt.Errorf("decode config: %w", err)
+	}
+
+	raw, ok := cfg.Apps["netbird"]
+	if !ok {
+		return nil, errors.New("config has no netbird app")
+	}
+
+	a := new(App)
+	if err := caddy.StrictUnmarshalJSON(raw, a); err != nil {
+		return nil, fmt.Errorf("decode netbird app: %w", err)
+	}
+	return a, nil
+}
+
+// checkConfig validates the app and resolves all valid nodes without
+// creating clients. It returns the errors of Validate followed by those of
+// resolving the nodes, in node name order, joined.
+func (a *App) checkConfig() error {
+	var errs []error
+	if err := a.Validate(); err != nil {
+		var joined interface{ Unwrap() []error }
+		if errors.As(err, &joined) {
+			errs = append(errs, joined.Unwrap()...)
+		} else {
+			errs = append(errs, err)
+		}
+	}
+
+	names := maps.Keys(a.Nodes)
+	slices.Sort(names)
+	for _, name := range names {
+		// Invalid nodes are already reported by Validate.
+		if a.validateNode(a.Nodes[name]) != nil {
+			continue
+		}
+		if _, err := a.resolveNodeConfig(name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,96 @@
+package app
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeApp(t *testing.T) {
+	a, err := decodeApp([]byte(`{
+		"apps": {
+			"http": {"servers": {}},
+			"netbird": {
+				"management_url": "https://api.netbird.io:443",
+				"nodes": {"web": {"setup_key": "KEY", "mtu": 1400}}
+			}
+		}
+	}`))
+	require.NoError(t, err)
+	assert.Equal(t, "https://api.netbird.io:443", a.DefaultManagementURL)
+	require.Contains(t, a.Nodes, "web")
+	assert.Equal(t, uint16(1400), a.Nodes["web"].MTU)
+}
+
+func TestDecodeApp_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"invalid json", `{`},
+		{"no netbird app", `{"apps": {"http": {}}}`},
+		{"unknown field", `{"apps": {"netbird": {"bogus": true}}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeApp([]byte(tt.input))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestCheckConfig_Valid(t *testing.T) {
+	a := &App{
+		DefaultManagementURL: "https://api.netbird.io:443",
+		DefaultSetupKey:      "KEY",
+		Nodes: map[string]*Node{
+			"web": {},
+			"api": {MTU: 1400},
+		},
+	}
+	assert.NoError(t, a.checkConfig())
+}
+
+func TestCheckConfig_ReportsAllNodes(t *testing.T) {
+	a := &App{
+		Nodes: map[string]*Node{
+			"a-no-url":     {SetupKey: "KEY"},
+			"b-no-key":     {ManagementURL: "https://api.netbird.io:443"},
+			"c-bad-mtu":    {ManagementURL: "https://api.netbird.io:443", SetupKey: "KEY", MTU: 100},
+			"d-bad-secret": {ManagementURL: "https://api.netbird.io:443", SetupKey: "secret://MISSING"},
+			"e-valid":      {ManagementURL: "https://api.netbird.io:443", SetupKey: "KEY"},
+		},
+	}
+	a.SetSecretProvider(fakeSecretProvider{})
+
+	err := a.checkConfig()
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrMissingManagementURL)
+	assert.ErrorIs(t, err, ErrMissingSetupKey)
+	assert.ErrorIs(t, err, ErrInvalidMTU)
+	assert.ErrorIs(t, err, ErrSecretNotFound)
+
+	var joined interface{ Unwrap() []error }
+	require.True(t, errors.As(err, &joined))
+	errs := joined.Unwrap()
+	require.Len(t, errs, 4)
+	assert.Contains(t, errs[0].Error(), `node "a-no-url"`)
+	assert.Contains(t, errs[1].Error(), `node "b-no-key"`)
+	assert.Contains(t, errs[2].Error(), `node "c-bad-mtu"`)
+	assert.Contains(t, errs[3].Error(), `node "d-bad-secret"`)
+	assert.NotContains(t, err.Error(), "e-valid")
+}
+
+func TestCheckConfig_AppErrors(t *testing.T) {
+	a := &App{
+		DefaultManagementURL: "https://api.netbird.io:443",
+		DefaultSetupKey:      "KEY",
+		DefaultNode:          "missing",
+		Nodes:                map[string]*Node{"web": {}},
+	}
+
+	err := a.checkConfig()
+	assert.ErrorIs(t, err, ErrUnknownNode, "app-level errors of Validate should be reported")
+}
@@ -0,0 +1,139 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"time"
+
+	"golang.org/x/exp/maps"
+)
+
+const redacted = "REDACTED"
+
+// diagResponse is a support bundle combining everything needed to
+// troubleshoot the plugin in a single document.
+type diagResponse struct {
+	GeneratedAt time.Time                      `json:"generatedAt"`
+	Versions    diagVersions                   `json:"versions"`
+	Config      diagConfig                     `json:"config"`
+	Health      map[nodeName]*diagHealthResult `json:"health"`
+	Status      statusResponse                 `json:"status"`
+}
+
+type diagVersions struct {
+	Go      string `json:"go"`
+	Plugin  string `json:"plugin"`
+	Caddy   string `json:"caddy"`
+	NetBird string `json:"netbird"`
+}
+
+// diagConfig is the resolved app configuration with secrets redacted.
+type diagConfig struct {
+	ManagementURL       string             `json:"management_url,omitempty"`
+	SetupKey            string             `json:"setup_key,omitempty"`
+	LogLevel            string             `json:"log_level,omitempty"`
+	PingTimeout         string             `json:"ping_timeout,omitempty"`
+	HealthCheckInterval string             `json:"health_check_interval,omitempty"`
+	Nodes               map[nodeName]*Node `json:"nodes"`
+}
+
+type diagHealthResult struct {
+	Checked             bool      `json:"checked"`
+	ManagementConnected bool      `json:"managementConnected"`
+	SignalConnected     bool      `json:"signalConnected"`
+	CheckedAt           time.Time `json:"checkedAt,omitzero"`
+}
+
+// handleDiag returns a diagnostic bundle with versions, redacted config,
+// cached health check results, and the status of all nodes.
+func (a *adminAPI) handleDiag(w http.ResponseWriter, r *http.Request) error {
+	resp := diagResponse{
+		GeneratedAt: time.Now(),
+		Versions:    buildVersions(),
+		Config:      a.app.redactedConfig(),
+		Health:      a.app.healthResults(),
+		Status:      a.app.collectStatus(r.Context()),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(resp)
+}
+
+// buildVersions reports the versions of the main modules from the build info.
+func buildVersions() diagVersions {
+	v := diagVersions{Go: runtime.Version()}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return v
+	}
+
+	modules := append([]*debug.Module{&info.Main}, info.Deps...)
+	for _, m := range modules {
+		switch m.Path {
+		case "github.com/lixmal/caddy-netbird":
+			v.Plugin = m.Version
+		case "github.com/caddyserver/caddy/v2":
+			v.Caddy = m.Version
+		case "github.com/netbirdio/netbird":
+			v.NetBird = m.Version
+		}
+	}
+	return v
+}
+
+// redactedConfig returns the resolved configuration of all configured nodes
+// with setup keys and pre-shared keys replaced.
+func (a *App) redactedConfig() diagConfig {
+	cfg := diagConfig{
+		ManagementURL: a.DefaultManagementURL,
+		SetupKey:      redact(a.DefaultSetupKey),
+		LogLevel:      a.LogLevel,
+		Nodes:         make(map[nodeName]*Node, len(a.Nodes)),
+	}
+	if a.PingTimeout > 0 {
+		cfg.PingTimeout = time.Duration(a.PingTimeout).String()
+	}
+	if a.HealthCheckInterval > 0 {
+		cfg.HealthCheckInterval = time.Duration(a.HealthCheckInterval).String()
+	}
+
+	names := maps.Keys(a.Nodes)
+	slices.Sort(names)
+	for _, name := range names {
+		node := a.resolveNode(name)
+		node.SetupKey = redact(node.SetupKey)
+		node.PreSharedKey = redact(node.PreSharedKey)
+		cfg.Nodes[name] = &node
+	}
+	return cfg
+}
+
+// healthResults returns the cached health check result of each pooled client.
+func (a *App) healthResults() map[nodeName]*diagHealthResult {
+	results := make(map[nodeName]*diagHealthResult)
+	a.rangeNodes(func(name nodeName, mc *ManagedClient) bool {
+		health, checked := mc.Health()
+		results[name] = &diagHealthResult{
+			Checked:             checked,
+			ManagementConnected: health.ManagementConnected,
+			SignalConnected:     health.SignalConnected,
+			CheckedAt:           health.CheckedAt,
+		}
+		return true
+	})
+	return results
+}
+
+// redact hides a secret while keeping whether it was set visible.
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return redacted
+}
@@ -0,0 +1,64 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestHandleDiag(t *testing.T) {
+	api := &adminAPI{
+		app: &App{
+			DefaultManagementURL: "https://api.netbird.io:443",
+			DefaultSetupKey:      "super-secret-setup-key",
+			HealthCheckInterval:  caddy.Duration(30 * time.Second),
+			Nodes: map[string]*Node{
+				"web": {Hostname: "caddy-web", PreSharedKey: "super-secret-psk"},
+				"api": {SetupKey: "super-secret-node-key"},
+			},
+			pool: caddy.NewUsagePool(),
+		},
+		logger: zap.NewNop(),
+	}
+
+	rec := httptest.NewRecorder()
+	require.NoError(t, api.handleDiag(rec, httptest.NewRequest(http.MethodGet, "/netbird/diag", nil)))
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	body := rec.Body.String()
+	assert.NotContains(t, body, "super-secret")
+
+	var doc map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &doc))
+	for _, section := range []string{"generatedAt", "versions", "config", "health", "status"} {
+		assert.Contains(t, doc, section)
+	}
+
+	var cfg diagConfig
+	require.NoError(t, json.Unmarshal(doc["config"], &cfg))
+	assert.Equal(t, "https://api.netbird.io:443", cfg.ManagementURL)
+	assert.Equal(t, redacted, cfg.SetupKey)
+	assert.Equal(t, "30s", cfg.HealthCheckInterval)
+	require.Contains(t, cfg.Nodes, "web")
+	assert.Equal(t, "https://api.netbird.io:443", cfg.Nodes["web"].ManagementURL, "node config is resolved")
+	assert.Equal(t, redacted, cfg.Nodes["web"].SetupKey)
+	assert.Equal(t, redacted, cfg.Nodes["web"].PreSharedKey)
+	assert.Equal(t, redacted, cfg.Nodes["api"].SetupKey)
+	assert.Empty(t, cfg.Nodes["api"].PreSharedKey, "unset secrets stay empty")
+
+	var versions diagVersions
+	require.NoError(t, json.Unmarshal(doc["versions"], &versions))
+	assert.NotEmpty(t, versions.Go)
+}
+
+func TestRedact(t *testing.T) {
+	assert.Empty(t, redact(""))
+	assert.Equal(t, redacted, redact("secret"))
+}
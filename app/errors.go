@@ -0,0 +1,61 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// errorResponse is the JSON envelope for all admin API errors:
+//
+//	{"error": {"code": "not_found", "message": "node \"web\" not found"}}
+type errorResponse struct {
+	Error errorBody `json:"error"`
+}
+
+type errorBody struct {
+	// Code is a machine-readable form of the HTTP status, e.g. "bad_request".
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeError writes err as a JSON error envelope. The status code is taken
+// from a caddy.APIError and defaults to 500 for other errors.
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	message := err.Error()
+
+	var apiErr caddy.APIError
+	if errors.As(err, &apiErr) {
+		if apiErr.HTTPStatus != 0 {
+			status = apiErr.HTTPStatus
+		}
+		if apiErr.Message != "" {
+			message = apiErr.Message
+		} else if apiErr.Err != nil {
+			message = apiErr.Err.Error()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(errorResponse{
+		Error: errorBody{
+			Code:    errorCode(status),
+			Message: message,
+		},
+	})
+}
+
+// errorCode converts an HTTP status into a snake_case code, e.g. 404 to "not_found".
+func errorCode(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return "error"
+	}
+	text = strings.ToLower(strings.ReplaceAll(text, "-", " "))
+	return strings.Join(strings.Fields(text), "_")
+}
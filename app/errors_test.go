@@ -0,0 +1,111 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decodeError(t *testing.T, rec *httptest.ResponseRecorder) errorResponse {
+	t.Helper()
+
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var resp errorResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp), "body: %s", rec.Body.String())
+	return resp
+}
+
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		wantStatus  int
+		wantCode    string
+		wantMessage string
+	}{
+		{
+			name:        "api error",
+			err:         caddy.APIError{HTTPStatus: http.StatusNotFound, Err: errors.New(`node "web" not found`)},
+			wantStatus:  http.StatusNotFound,
+			wantCode:    "not_found",
+			wantMessage: `node "web" not found`,
+		},
+		{
+			name:        "api error with message",
+			err:         caddy.APIError{HTTPStatus: http.StatusBadRequest, Message: "bad input", Err: errors.New("detail")},
+			wantStatus:  http.StatusBadRequest,
+			wantCode:    "bad_request",
+			wantMessage: "bad input",
+		},
+		{
+			name:        "plain error",
+			err:         errors.New("boom"),
+			wantStatus:  http.StatusInternalServerError,
+			wantCode:    "internal_server_error",
+			wantMessage: "boom",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			writeError(rec, tt.err)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			resp := decodeError(t, rec)
+			assert.Equal(t, tt.wantCode, resp.Error.Code)
+			assert.Equal(t, tt.wantMessage, resp.Error.Message)
+		})
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	assert.Equal(t, "service_unavailable", errorCode(http.StatusServiceUnavailable))
+	assert.Equal(t, "too_many_requests", errorCode(http.StatusTooManyRequests))
+	assert.Equal(t, "multi_status", errorCode(http.StatusMultiStatus))
+	assert.Equal(t, "error", errorCode(599))
+}
+
+func TestHandleAPI_ErrorEnvelope(t *testing.T) {
+	api := &adminAPI{app: &App{pool: caddy.NewUsagePool()}}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+	}{
+		{"unknown endpoint", http.MethodGet, "/netbird/bogus", "", http.StatusNotFound},
+		{"wrong method", http.MethodGet, "/netbird/ping", "", http.StatusNotFound},
+		{"invalid json", http.MethodPost, "/netbird/ping", "{", http.StatusBadRequest},
+		{"missing address", http.MethodPost, "/netbird/ping", "{}", http.StatusBadRequest},
+		{"unknown node", http.MethodGet, "/netbird/export?node=missing", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			require.NoError(t, api.handleAPI(rec, req))
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			resp := decodeError(t, rec)
+			assert.Equal(t, errorCode(tt.wantStatus), resp.Error.Code)
+			assert.NotEmpty(t, resp.Error.Message)
+		})
+	}
+}
+
+func TestHandleAPI_NotConfigured(t *testing.T) {
+	rec := httptest.NewRecorder()
+	require.NoError(t, (&adminAPI{}).handleAPI(rec, httptest.NewRequest(http.MethodGet, "/netbird/status", nil)))
+
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "service_unavailable", decodeError(t, rec).Error.Code)
+}
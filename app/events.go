@@ -0,0 +1,226 @@
+package app
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+// eventPollInterval is how often the event stream compares a node's status
+// with the previous one. The embedded client doesn't expose its internal
+// event stream, so events are derived from status changes.
+const eventPollInterval = time.Second
+
+// Event types sent by the event stream.
+const (
+	eventManagementConnected    = "management_connected"
+	eventManagementDisconnected = "management_disconnected"
+	eventSignalConnected        = "signal_connected"
+	eventSignalDisconnected     = "signal_disconnected"
+	eventPeerAdded              = "peer_added"
+	eventPeerRemoved            = "peer_removed"
+	eventPeerConnected          = "peer_connected"
+	eventPeerDisconnected       = "peer_disconnected"
+	eventPeerRelayChanged       = "peer_relay_changed"
+	eventRouteAdded             = "route_added"
+	eventRouteRemoved           = "route_removed"
+)
+
+// nodeEvent is a change of a node's status.
+type nodeEvent struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	// Peer is the IP of the peer the event is about, unset for events
+	// about the node itself.
+	Peer     string `json:"peer,omitempty"`
+	PeerFQDN string `json:"peerFqdn,omitempty"`
+	// Route is the network of route events. Routes of the node itself
+	// have no Peer.
+	Route   string `json:"route,omitempty"`
+	Relayed *bool  `json:"relayed,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// handleEvents streams changes of a node's status as server-sent events
+// until the client disconnects.
+func (a *adminAPI) handleEvents(w http.ResponseWriter, r *http.Request) error {
+	name := r.URL.Query().Get("node")
+	if name == "" {
+		name = a.app.DefaultNodeName()
+	}
+
+	mc, ok := a.app.lookupClient(name)
+	if !ok {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("node %q not found", name),
+		}
+	}
+	if !mc.isStarted() {
+		return caddy.APIError{
+			HTTPStatus: http.StatusConflict,
+			Err:        fmt.Errorf("node %q is not started", name),
+		}
+	}
+
+	ns, err := mc.nodeStatus()
+	if err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusInternalServerError,
+			Err:        fmt.Errorf("get status of node %q: %w", name, err),
+		}
+	}
+
+	err = streamEvents(r.Context(), w, ns, eventPollInterval, mc.nodeStatus)
+	if err != nil && r.Context().Err() == nil {
+		a.logger.Debug("stream events", zap.String("node", name), zap.Error(err))
+	}
+	return nil
+}
+
+// streamEvents writes the headers of an event stream and then, every
+// interval until ctx is done, the events between the previous and the
+// current status. Failures to get the status are skipped.
+func streamEvents(ctx context.Context, w http.ResponseWriter, prev *nodeStatus, interval time.Duration, fetch func() (*nodeStatus, error)) error {
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-ticker.C:
+			cur, err := fetch()
+			if err != nil {
+				continue
+			}
+			events := diffStatus(prev, cur, now)
+			prev = cur
+			if len(events) == 0 {
+				continue
+			}
+			for _, ev := range events {
+				if err := writeEvent(w, ev); err != nil {
+					return err
+				}
+			}
+			if err := rc.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// writeEvent writes ev as a server-sent event named after its type.
+func writeEvent(w http.ResponseWriter, ev nodeEvent) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+	return err
+}
+
+// diffStatus returns the events that turn prev into cur, stamped with now.
+// Node events come first, followed by peer events in the order of cur's
+// peers and then removed peers.
+func diffStatus(prev, cur *nodeStatus, now time.Time) []nodeEvent {
+	var events []nodeEvent
+	add := func(ev nodeEvent) {
+		ev.Time = now
+		events = append(events, ev)
+	}
+
+	if prev.Management.Connected != cur.Management.Connected {
+		add(connEvent(cur.Management.Connected, eventManagementConnected, eventManagementDisconnected, cur.Management.Error))
+	}
+	if prev.Signal.Connected != cur.Signal.Connected {
+		add(connEvent(cur.Signal.Connected, eventSignalConnected, eventSignalDisconnected, cur.Signal.Error))
+	}
+	for _, ev := range diffRoutes(prev.Local.Routes, cur.Local.Routes) {
+		add(ev)
+	}
+
+	prevPeers := make(map[string]peerStatus, len(prev.Peers))
+	for _, p := range prev.Peers {
+		prevPeers[p.IP] = p
+	}
+	for _, p := range cur.Peers {
+		old, known := prevPeers[p.IP]
+		delete(prevPeers, p.IP)
+		peer := nodeEvent{Peer: p.IP, PeerFQDN: p.FQDN}
+
+		if !known {
+			ev := peer
+			ev.Type = eventPeerAdded
+			add(ev)
+		}
+		if connected := p.ConnStatus == "Connected"; connected != (old.ConnStatus == "Connected") {
+			ev := peer
+			ev.Type = eventPeerDisconnected
+			if connected {
+				ev.Type = eventPeerConnected
+				ev.Relayed = &p.Relayed
+			}
+			add(ev)
+		} else if known && connected && p.Relayed != old.Relayed {
+			ev := peer
+			ev.Type = eventPeerRelayChanged
+			ev.Relayed = &p.Relayed
+			add(ev)
+		}
+		for _, ev := range diffRoutes(old.Routes, p.Routes) {
+			ev.Peer, ev.PeerFQDN = p.IP, p.FQDN
+			add(ev)
+		}
+	}
+
+	removed := make([]peerStatus, 0, len(prevPeers))
+	for _, p := range prevPeers {
+		removed = append(removed, p)
+	}
+	slices.SortFunc(removed, func(a, b peerStatus) int { return cmp.Compare(a.IP, b.IP) })
+	for _, p := range removed {
+		add(nodeEvent{Type: eventPeerRemoved, Peer: p.IP, PeerFQDN: p.FQDN})
+	}
+	return events
+}
+
+// connEvent returns the event for a connection that went up or down.
+func connEvent(connected bool, up, down, errMsg string) nodeEvent {
+	if connected {
+		return nodeEvent{Type: up}
+	}
+	return nodeEvent{Type: down, Error: errMsg}
+}
+
+// diffRoutes returns route events for the routes added to and removed from
+// prev. Both must be sorted.
+func diffRoutes(prev, cur []string) []nodeEvent {
+	var events []nodeEvent
+	for _, route := range cur {
+		if _, found := slices.BinarySearch(prev, route); !found {
+			events = append(events, nodeEvent{Type: eventRouteAdded, Route: route})
+		}
+	}
+	for _, route := range prev {
+		if _, found := slices.BinarySearch(cur, route); !found {
+			events = append(events, nodeEvent{Type: eventRouteRemoved, Route: route})
+		}
+	}
+	return events
+}
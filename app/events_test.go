@@ -0,0 +1,125 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffStatus(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	relayed, direct := true, false
+
+	prev := &nodeStatus{
+		Management: managementStatus{Connected: true},
+		Signal:     signalStatus{Connected: true},
+		Local:      localStatus{Routes: []string{"10.0.0.0/8"}},
+		Peers: []peerStatus{
+			{IP: "100.64.0.1", FQDN: "a.netbird.cloud", ConnStatus: "Connected", Routes: []string{"192.168.1.0/24"}},
+			{IP: "100.64.0.2", FQDN: "b.netbird.cloud", ConnStatus: "Connecting"},
+			{IP: "100.64.0.3", FQDN: "c.netbird.cloud", ConnStatus: "Connected", Relayed: true},
+			{IP: "100.64.0.4", FQDN: "d.netbird.cloud", ConnStatus: "Connected"},
+		},
+	}
+	cur := &nodeStatus{
+		Management: managementStatus{Connected: false, Error: "connection refused"},
+		Signal:     signalStatus{Connected: true},
+		Local:      localStatus{Routes: []string{"172.16.0.0/12"}},
+		Peers: []peerStatus{
+			{IP: "100.64.0.1", FQDN: "a.netbird.cloud", ConnStatus: "Connected", Routes: []string{"192.168.2.0/24"}},
+			{IP: "100.64.0.2", FQDN: "b.netbird.cloud", ConnStatus: "Connected", Relayed: true},
+			{IP: "100.64.0.3", FQDN: "c.netbird.cloud", ConnStatus: "Connected"},
+			{IP: "100.64.0.5", FQDN: "e.netbird.cloud", ConnStatus: "Idle"},
+		},
+	}
+
+	assert.Equal(t, []nodeEvent{
+		{Type: eventManagementDisconnected, Time: now, Error: "connection refused"},
+		{Type: eventRouteAdded, Time: now, Route: "172.16.0.0/12"},
+		{Type: eventRouteRemoved, Time: now, Route: "10.0.0.0/8"},
+		{Type: eventRouteAdded, Time: now, Peer: "100.64.0.1", PeerFQDN: "a.netbird.cloud", Route: "192.168.2.0/24"},
+		{Type: eventRouteRemoved, Time: now, Peer: "100.64.0.1", PeerFQDN: "a.netbird.cloud", Route: "192.168.1.0/24"},
+		{Type: eventPeerConnected, Time: now, Peer: "100.64.0.2", PeerFQDN: "b.netbird.cloud", Relayed: &relayed},
+		{Type: eventPeerRelayChanged, Time: now, Peer: "100.64.0.3", PeerFQDN: "c.netbird.cloud", Relayed: &direct},
+		{Type: eventPeerAdded, Time: now, Peer: "100.64.0.5", PeerFQDN: "e.netbird.cloud"},
+		{Type: eventPeerRemoved, Time: now, Peer: "100.64.0.4", PeerFQDN: "d.netbird.cloud"},
+	}, diffStatus(prev, cur, now))
+
+	assert.Empty(t, diffStatus(cur, cur, now), "unchanged status should yield no events")
+}
+
+func TestDiffStatus_PeerAddedConnected(t *testing.T) {
+	now := time.Now()
+	relayed := false
+	cur := &nodeStatus{Peers: []peerStatus{{IP: "100.64.0.1", ConnStatus: "Connected"}}}
+
+	assert.Equal(t, []nodeEvent{
+		{Type: eventPeerAdded, Time: now, Peer: "100.64.0.1"},
+		{Type: eventPeerConnected, Time: now, Peer: "100.64.0.1", Relayed: &relayed},
+	}, diffStatus(&nodeStatus{}, cur, now))
+}
+
+func TestStreamEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	statuses := []*nodeStatus{
+		{Signal: signalStatus{Connected: true}},
+		nil,
+		{Signal: signalStatus{Connected: true}},
+		{},
+	}
+	fetch := func() (*nodeStatus, error) {
+		if len(statuses) == 0 {
+			cancel()
+			return nil, errors.New("done")
+		}
+		ns := statuses[0]
+		statuses = statuses[1:]
+		if ns == nil {
+			return nil, errors.New("status unavailable")
+		}
+		return ns, nil
+	}
+
+	rec := httptest.NewRecorder()
+	err := streamEvents(ctx, rec, &nodeStatus{}, time.Millisecond, fetch)
+	require.ErrorIs(t, err, context.Canceled)
+
+	assert.Equal(t, "text/event-stream", rec.Header().Get("Content-Type"))
+	assert.True(t, rec.Flushed)
+	body := rec.Body.String()
+	assert.Equal(t, 2, strings.Count(body, "\n\n"), body)
+	assert.Contains(t, body, "event: signal_connected\ndata: {\"type\":\"signal_connected\"")
+	assert.Contains(t, body, "event: signal_disconnected\ndata: {\"type\":\"signal_disconnected\"")
+	assert.Less(t, strings.Index(body, "signal_connected"), strings.Index(body, "signal_disconnected"))
+}
+
+func TestHandleEvents_Errors(t *testing.T) {
+	a := newReloadTestApp(t, fakeSecretProvider{"WEB_KEY": "web-key", "API_KEY": "api-key"})
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+	}{
+		{"unknown node", "?node=db", http.StatusNotFound},
+		{"default node missing", "", http.StatusNotFound},
+		{"not started", "?node=api", http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/netbird/events"+tt.query, nil)
+			require.NoError(t, (&adminAPI{app: a}).handleAPI(rec, req))
+			assert.Equal(t, tt.status, rec.Code)
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// exportSchemaVersion is the version of the export schema. Bump it on any
+// incompatible change to exportResponse or exportPeer.
+const exportSchemaVersion = 1
+
+// exportResponse is the stable, versioned peer list intended for automation.
+// Unlike statusResponse, its field set only changes together with
+// exportSchemaVersion.
+type exportResponse struct {
+	SchemaVersion int          `json:"schema_version"`
+	Node          string       `json:"node"`
+	IP            string       `json:"ip"`
+	FQDN          string       `json:"fqdn"`
+	Peers         []exportPeer `json:"peers"`
+}
+
+type exportPeer struct {
+	FQDN      string   `json:"fqdn"`
+	IP        string   `json:"ip"`
+	Connected bool     `json:"connected"`
+	Relayed   bool     `json:"relayed"`
+	Routes    []string `json:"routes"`
+}
+
+// handleExport returns the peers of a single node in the export schema.
+func (a *adminAPI) handleExport(w http.ResponseWriter, r *http.Request) error {
+	name := r.URL.Query().Get("node")
+	if name == "" {
+		name = a.app.DefaultNodeName()
+	}
+
+	mc, ok := a.app.lookupClient(name)
+	if !ok {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("node %q not found", name),
+		}
+	}
+
+	ns, err := mc.nodeStatus()
+	if err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusInternalServerError,
+			Err:        fmt.Errorf("get status of node %q: %w", name, err),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(newExport(name, ns))
+}
+
+// newExport converts a node status into the export schema. Slices are always
+// non-nil so they encode as [] rather than null.
+func newExport(name string, ns *nodeStatus) exportResponse {
+	resp := exportResponse{
+		SchemaVersion: exportSchemaVersion,
+		Node:          name,
+		IP:            ns.Local.IP,
+		FQDN:          ns.Local.FQDN,
+		Peers:         make([]exportPeer, 0, len(ns.Peers)),
+	}
+
+	for _, p := range ns.Peers {
+		routes := p.Routes
+		if routes == nil {
+			routes = []string{}
+		}
+		resp.Peers = append(resp.Peers, exportPeer{
+			FQDN:      p.FQDN,
+			IP:        p.IP,
+			Connected: p.ConnStatus == "Connected",
+			Relayed:   p.Relayed,
+			Routes:    routes,
+		})
+	}
+
+	return resp
+}
@@ -0,0 +1,56 @@
+package app
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewExport(t *testing.T) {
+	ns := &nodeStatus{
+		Local: localStatus{IP: "100.0.0.1/16", FQDN: "caddy.netbird.cloud"},
+		Peers: []peerStatus{
+			{FQDN: "a.netbird.cloud", IP: "100.0.0.2", ConnStatus: "Connected", Routes: []string{"10.0.0.0/8"}},
+			{FQDN: "b.netbird.cloud", IP: "100.0.0.3", ConnStatus: "Connected", Relayed: true},
+			{FQDN: "c.netbird.cloud", IP: "100.0.0.4", ConnStatus: "Idle"},
+		},
+	}
+
+	resp := newExport("web", ns)
+	assert.Equal(t, exportSchemaVersion, resp.SchemaVersion)
+	assert.Equal(t, "web", resp.Node)
+	require.Len(t, resp.Peers, 3)
+	assert.Equal(t, exportPeer{FQDN: "a.netbird.cloud", IP: "100.0.0.2", Connected: true, Routes: []string{"10.0.0.0/8"}}, resp.Peers[0])
+	assert.True(t, resp.Peers[1].Relayed)
+	assert.False(t, resp.Peers[2].Connected)
+}
+
+func TestNewExport_RequiredFields(t *testing.T) {
+	data, err := json.Marshal(newExport("web", &nodeStatus{
+		Peers: []peerStatus{{FQDN: "a.netbird.cloud"}},
+	}))
+	require.NoError(t, err)
+
+	var doc map[string]any
+	require.NoError(t, json.Unmarshal(data, &doc))
+	for _, key := range []string{"schema_version", "node", "ip", "fqdn", "peers"} {
+		assert.Contains(t, doc, key)
+	}
+	assert.EqualValues(t, exportSchemaVersion, doc["schema_version"])
+
+	peers := doc["peers"].([]any)
+	require.Len(t, peers, 1)
+	peer := peers[0].(map[string]any)
+	for _, key := range []string{"fqdn", "ip", "connected", "relayed", "routes"} {
+		assert.Contains(t, peer, key)
+	}
+	assert.Equal(t, []any{}, peer["routes"], "routes should encode as an empty list")
+}
+
+func TestNewExport_NoPeers(t *testing.T) {
+	data, err := json.Marshal(newExport("web", &nodeStatus{}))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"peers":[]`)
+}
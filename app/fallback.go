@@ -0,0 +1,35 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"go.uber.org/zap"
+)
+
+// FallbackDial wraps a tunnel dial of the named node so that a failed dial
+// is retried with direct, outside the NetBird network, for the
+// fallback_direct option of transports and L4 handlers. Dials aborted by
+// ctx are not retried.
+func FallbackDial(logger *zap.Logger, node string, dial, direct func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err == nil || ctx.Err() != nil {
+			return conn, err
+		}
+
+		logger.Warn("netbird dial failed, falling back to direct dial",
+			zap.String("node", node),
+			zap.String("network", network),
+			zap.String("upstream", addr),
+			zap.Error(err),
+		)
+		conn, directErr := direct(ctx, network, addr)
+		if directErr != nil {
+			return nil, errors.Join(err, fmt.Errorf("direct fallback: %w", directErr))
+		}
+		return conn, nil
+	}
+}
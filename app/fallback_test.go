@@ -0,0 +1,93 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+var errTunnelDown = errors.New("no route to peer")
+
+func failingDial(context.Context, string, string) (net.Conn, error) {
+	return nil, errTunnelDown
+}
+
+func TestFallbackDial_TunnelSucceeds(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	var directDials int
+	direct := func(context.Context, string, string) (net.Conn, error) {
+		directDials++
+		return nil, errors.New("unexpected")
+	}
+
+	tunnel := func(context.Context, string, string) (net.Conn, error) {
+		return client, nil
+	}
+
+	conn, err := FallbackDial(zap.NewNop(), "web", tunnel, direct)(context.Background(), "tcp", "backend:80")
+	require.NoError(t, err)
+	assert.Equal(t, client, conn)
+	assert.Zero(t, directDials, "direct dial should only be used if the tunnel dial fails")
+}
+
+func TestFallbackDial_FallsBack(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	core, logs := observer.New(zap.WarnLevel)
+	var dialed string
+	direct := func(_ context.Context, _, addr string) (net.Conn, error) {
+		dialed = addr
+		return client, nil
+	}
+
+	conn, err := FallbackDial(zap.New(core), "web", failingDial, direct)(context.Background(), "tcp", "backend:80")
+	require.NoError(t, err)
+	assert.Equal(t, client, conn)
+	assert.Equal(t, "backend:80", dialed)
+
+	require.Equal(t, 1, logs.Len())
+	entry := logs.All()[0]
+	assert.Equal(t, zapcore.WarnLevel, entry.Level)
+	fields := entry.ContextMap()
+	assert.Equal(t, "web", fields["node"])
+	assert.Equal(t, "backend:80", fields["upstream"])
+	assert.Equal(t, errTunnelDown.Error(), fields["error"])
+}
+
+func TestFallbackDial_BothFail(t *testing.T) {
+	errDirect := errors.New("connection refused")
+	direct := func(context.Context, string, string) (net.Conn, error) {
+		return nil, errDirect
+	}
+
+	_, err := FallbackDial(zap.NewNop(), "web", failingDial, direct)(context.Background(), "tcp", "backend:80")
+	require.ErrorIs(t, err, errTunnelDown)
+	require.ErrorIs(t, err, errDirect)
+	assert.Contains(t, err.Error(), "direct fallback")
+}
+
+func TestFallbackDial_NotAfterCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var directDials int
+	direct := func(context.Context, string, string) (net.Conn, error) {
+		directDials++
+		return nil, errors.New("unexpected")
+	}
+	_, err := FallbackDial(zap.NewNop(), "web", failingDial, direct)(ctx, "tcp", "backend:80")
+	require.ErrorIs(t, err, errTunnelDown)
+	assert.Zero(t, directDials, "aborted dials should not fall back")
+}
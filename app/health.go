@@ -0,0 +1,196 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const defaultHealthCheckInterval = 10 * time.Second
+
+// NodeHealth is the connectivity state of a node as observed by the
+// most recent health check.
+type NodeHealth struct {
+	ManagementConnected bool
+	SignalConnected     bool
+	CheckedAt           time.Time
+}
+
+// Healthy reports whether the node can reach both management and signal.
+func (h NodeHealth) Healthy() bool {
+	return h.ManagementConnected && h.SignalConnected
+}
+
+// Health returns the cached result of the last health check. The boolean is
+// false if the node has not been checked yet.
+func (mc *ManagedClient) Health() (NodeHealth, bool) {
+	h := mc.health.Load()
+	if h == nil {
+		return NodeHealth{}, false
+	}
+	return *h, true
+}
+
+// ManagementConnected reports whether the client is currently connected to
+// the management server.
+func (mc *ManagedClient) ManagementConnected() (bool, error) {
+	fullStatus, err := mc.Client().Status()
+	if err != nil {
+		return false, err
+	}
+	return fullStatus.ManagementState.Connected, nil
+}
+
+// isStarted reports whether the client has been started.
+func (mc *ManagedClient) isStarted() bool {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return mc.started
+}
+
+// checkHealth refreshes the cached status and reports whether the client
+// stayed disconnected for too long and should be restarted.
+func (mc *ManagedClient) checkHealth() bool {
+	// Check restarting first, as isStarted blocks while the client starts.
+	if mc.restarting.Load() || !mc.isStarted() {
+		return false
+	}
+
+	prev := mc.health.Load()
+	health, count, err := mc.refreshStatus()
+	if err != nil {
+		mc.logger.Debug("health check status", zap.Error(err))
+		mc.setLastError(fmt.Errorf("health check status: %w", err))
+		return false
+	}
+	mc.recordHistory(prev, *health)
+	mc.checkMinPeers(count)
+	if mc.observeConnected(health.Healthy()) {
+		mc.onConnected()
+	}
+
+	if mc.reconnect != nil && mc.reconnect.observe(health.Healthy(), health.CheckedAt) {
+		mc.logger.Info("restarting disconnected netbird client",
+			zap.Bool("management", health.ManagementConnected),
+			zap.Bool("signal", health.SignalConnected),
+			zap.Int("attempt", mc.reconnect.attempts),
+		)
+		return true
+	}
+	return false
+}
+
+// peerCount is the number of peers in a status, and how many are connected.
+type peerCount struct {
+	Total     int
+	Connected int
+}
+
+// refreshStatus queries the client status and caches the connectivity state
+// and the peer lookups used when dialing. The fresh status also replaces the
+// one shared with admin API callers.
+func (mc *ManagedClient) refreshStatus() (*NodeHealth, peerCount, error) {
+	fullStatus, err := mc.Client().Status()
+	if err != nil {
+		return nil, peerCount{}, err
+	}
+	mc.status.store(fullStatus, time.Now())
+
+	health := &NodeHealth{
+		ManagementConnected: fullStatus.ManagementState.Connected,
+		SignalConnected:     fullStatus.SignalState.Connected,
+		CheckedAt:           time.Now(),
+	}
+	mc.health.Store(health)
+	if err := fullStatus.ManagementState.Error; err != nil && !health.ManagementConnected {
+		mc.setLastError(fmt.Errorf("management: %w", err))
+	}
+	if err := fullStatus.SignalState.Error; err != nil && !health.SignalConnected {
+		mc.setLastError(fmt.Errorf("signal: %w", err))
+	}
+
+	count := peerCount{Total: len(fullStatus.Peers)}
+	peerRelayed := make(map[string]bool)
+	peerRelays := make(map[string]string)
+	peerIPs := make(map[string]string)
+	peerKeys := make(map[string]string)
+	for _, p := range fullStatus.Peers {
+		fqdn := strings.ToLower(strings.TrimSuffix(p.FQDN, "."))
+		if fqdn != "" && p.IP != "" {
+			peerIPs[fqdn] = p.IP
+		}
+		if p.IP != "" {
+			peerKeys[p.IP] = p.PubKey
+		}
+		if p.ConnStatus.String() == "Connected" {
+			count.Connected++
+			peerRelayed[p.IP] = p.Relayed
+			peerRelayed[fqdn] = p.Relayed
+			var relay string
+			if p.Relayed {
+				relay = relayHostPort(p.RelayServerAddress)
+			}
+			peerRelays[p.IP] = relay
+			peerRelays[fqdn] = relay
+		}
+	}
+	mc.peerRelayed.Store(&peerRelayed)
+	mc.peerRelays.Store(&peerRelays)
+	mc.peerIPs.Store(&peerIPs)
+	mc.peerKeys.Store(&peerKeys)
+
+	return health, count, nil
+}
+
+// peerLatencies returns the latencies of the connected peers of each
+// running client, from the status shared with admin API callers.
+func (a *App) peerLatencies() map[nodeName][]time.Duration {
+	latencies := make(map[nodeName][]time.Duration)
+	a.rangeNodes(func(name nodeName, mc *ManagedClient) bool {
+		if !mc.isStarted() {
+			return true
+		}
+		fullStatus, err := cachedStatus(&mc.status, mc.statusMaxAge, time.Now(), mc.Client().Status)
+		if err != nil {
+			mc.logger.Debug("peer latency status", zap.Error(err))
+			return true
+		}
+		for _, p := range fullStatus.Peers {
+			if p.ConnStatus.String() == "Connected" && p.Latency > 0 {
+				latencies[name] = append(latencies[name], p.Latency)
+			}
+		}
+		return true
+	})
+	return latencies
+}
+
+// runHealthChecks periodically refreshes the cached health of all pooled
+// clients until ctx is done. Clients are restarted in the background, and
+// waited for before returning.
+func (a *App) runHealthChecks(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var restarts sync.WaitGroup
+	defer restarts.Wait()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.pool.Range(func(_, val any) bool {
+				mc := val.(*ManagedClient)
+				if mc.checkHealth() {
+					mc.restartAsync(ctx, &restarts)
+				}
+				return true
+			})
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodeHealth_Healthy(t *testing.T) {
+	assert.True(t, NodeHealth{ManagementConnected: true, SignalConnected: true}.Healthy())
+	assert.False(t, NodeHealth{ManagementConnected: true}.Healthy())
+	assert.False(t, NodeHealth{SignalConnected: true}.Healthy())
+	assert.False(t, NodeHealth{}.Healthy())
+}
+
+func TestManagedClient_Health(t *testing.T) {
+	mc := &ManagedClient{}
+
+	_, checked := mc.Health()
+	assert.False(t, checked, "health should be unknown before the first check")
+
+	now := time.Now()
+	mc.health.Store(&NodeHealth{ManagementConnected: true, CheckedAt: now})
+
+	health, checked := mc.Health()
+	require.True(t, checked)
+	assert.True(t, health.ManagementConnected)
+	assert.False(t, health.SignalConnected)
+	assert.Equal(t, now, health.CheckedAt)
+}
+
+func TestManagedClient_CheckHealthSkipsStopped(t *testing.T) {
+	mc := &ManagedClient{}
+	assert.False(t, mc.checkHealth())
+
+	_, checked := mc.Health()
+	assert.False(t, checked, "stopped clients should not be checked")
+}
+
+func TestParseGlobalOption_HealthCheckInterval(t *testing.T) {
+	app := parseAndDecode(t, `netbird {
+		health_check_interval 30s
+	}`)
+	assert.Equal(t, 30*time.Second, time.Duration(app.HealthCheckInterval))
+}
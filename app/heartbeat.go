@@ -0,0 +1,82 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// heartbeat summarizes a node's health for the heartbeat log.
+type heartbeat struct {
+	Management bool
+	Signal     bool
+	Peers      peerCount
+}
+
+// fields returns the heartbeat as log fields.
+func (h heartbeat) fields() []zap.Field {
+	return []zap.Field{
+		zap.Bool("management", h.Management),
+		zap.Bool("signal", h.Signal),
+		zap.Int("peers_connected", h.Peers.Connected),
+		zap.Int("peers_total", h.Peers.Total),
+	}
+}
+
+// heartbeat returns the health summary of the client, from the status
+// shared with admin API callers.
+func (mc *ManagedClient) heartbeat(now time.Time) (heartbeat, error) {
+	fullStatus, err := cachedStatus(&mc.status, mc.statusMaxAge, now, mc.Client().Status)
+	if err != nil {
+		return heartbeat{}, err
+	}
+
+	hb := heartbeat{
+		Management: fullStatus.ManagementState.Connected,
+		Signal:     fullStatus.SignalState.Connected,
+		Peers:      peerCount{Total: len(fullStatus.Peers)},
+	}
+	for _, p := range fullStatus.Peers {
+		if p.ConnStatus.String() == "Connected" {
+			hb.Peers.Connected++
+		}
+	}
+	return hb, nil
+}
+
+// logHeartbeat logs the health summary of the client at info level.
+func (mc *ManagedClient) logHeartbeat(now time.Time) {
+	hb, err := mc.heartbeat(now)
+	if err != nil {
+		mc.logger.Info("netbird node heartbeat", zap.Error(err))
+		return
+	}
+	mc.logger.Info("netbird node heartbeat", hb.fields()...)
+}
+
+// logHeartbeats logs the health summary of every running client.
+func (a *App) logHeartbeats() {
+	now := time.Now()
+	a.pool.Range(func(_, val any) bool {
+		if mc := val.(*ManagedClient); mc.isStarted() {
+			mc.logHeartbeat(now)
+		}
+		return true
+	})
+}
+
+// runHeartbeats calls beat every interval until ctx is done.
+func runHeartbeats(ctx context.Context, interval time.Duration, beat func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			beat()
+		}
+	}
+}
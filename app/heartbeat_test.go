@@ -0,0 +1,94 @@
+package app
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestHeartbeat_Fields(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	hb := heartbeat{Management: true, Signal: false, Peers: peerCount{Total: 5, Connected: 3}}
+
+	zap.New(core).Info("netbird node heartbeat", hb.fields()...)
+
+	require.Equal(t, 1, logs.Len())
+	assert.Equal(t, map[string]any{
+		"management":      true,
+		"signal":          false,
+		"peers_connected": int64(3),
+		"peers_total":     int64(5),
+	}, logs.All()[0].ContextMap())
+}
+
+func TestRunHeartbeats(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var beats atomic.Int32
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runHeartbeats(ctx, 10*time.Millisecond, func() { beats.Add(1) })
+	}()
+
+	assert.Eventually(t, func() bool { return beats.Load() >= 3 }, time.Second, 5*time.Millisecond)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("heartbeats should stop when the context is done")
+	}
+	stopped := beats.Load()
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, stopped, beats.Load(), "no beats after stop")
+}
+
+func TestApp_StopEndsHeartbeats(t *testing.T) {
+	a := &App{
+		pool:              caddy.NewUsagePool(),
+		logger:            zap.NewNop(),
+		HeartbeatInterval: caddy.Duration(10 * time.Millisecond),
+	}
+	require.NoError(t, a.Start())
+	require.NotNil(t, a.heartbeatDone)
+
+	time.Sleep(30 * time.Millisecond)
+	require.NoError(t, a.Stop())
+
+	select {
+	case <-a.heartbeatDone:
+	default:
+		t.Fatal("heartbeat goroutine should have exited")
+	}
+}
+
+func TestApp_HeartbeatsDisabled(t *testing.T) {
+	a := &App{pool: caddy.NewUsagePool(), logger: zap.NewNop()}
+	require.NoError(t, a.Start())
+	defer a.Stop()
+
+	assert.Nil(t, a.heartbeatDone, "no heartbeat goroutine without an interval")
+}
+
+func TestParseGlobalOption_HeartbeatInterval(t *testing.T) {
+	app := parseAndDecode(t, `netbird {
+		heartbeat_interval 1m
+	}`)
+	assert.Equal(t, time.Minute, time.Duration(app.HeartbeatInterval))
+
+	for _, val := range []string{"", "0s", "-1s", "often"} {
+		d := caddyfile.NewTestDispenser(`netbird {
+			heartbeat_interval ` + val + `
+		}`)
+		_, err := parseGlobalOption(d, nil)
+		assert.Error(t, err, val)
+	}
+}
@@ -0,0 +1,105 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// historySize is the number of connectivity events kept per node.
+const historySize = 100
+
+// History event types.
+const (
+	historyConnected    = "connected"
+	historyDisconnected = "disconnected"
+)
+
+// historyEvent is a change of a node's connectivity seen by the health
+// check.
+type historyEvent struct {
+	// Type is "connected" once the node reaches both management and
+	// signal, "disconnected" once it loses either.
+	Type       string    `json:"type"`
+	Time       time.Time `json:"time"`
+	Management bool      `json:"management"`
+	Signal     bool      `json:"signal"`
+}
+
+// historyRing keeps the last historySize events. The zero value is ready
+// to use.
+type historyRing struct {
+	mu     sync.Mutex
+	events []historyEvent
+	// next is the index the next event overwrites once the ring is full.
+	next int
+}
+
+// add records ev, replacing the oldest event if the ring is full.
+func (r *historyRing) add(ev historyEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.events) < historySize {
+		r.events = append(r.events, ev)
+		return
+	}
+	r.events[r.next] = ev
+	r.next = (r.next + 1) % historySize
+}
+
+// list returns the recorded events, oldest first.
+func (r *historyRing) list() []historyEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	events := make([]historyEvent, 0, len(r.events))
+	events = append(events, r.events[r.next:]...)
+	return append(events, r.events[:r.next]...)
+}
+
+// recordHistory records a connectivity event if cur differs from prev in
+// whether the node is healthy. The first check of a node always records
+// its state.
+func (mc *ManagedClient) recordHistory(prev *NodeHealth, cur NodeHealth) {
+	if prev != nil && prev.Healthy() == cur.Healthy() {
+		return
+	}
+	typ := historyDisconnected
+	if cur.Healthy() {
+		typ = historyConnected
+	}
+	mc.history.add(historyEvent{
+		Type:       typ,
+		Time:       cur.CheckedAt,
+		Management: cur.ManagementConnected,
+		Signal:     cur.SignalConnected,
+	})
+}
+
+type historyResponse struct {
+	Node   string         `json:"node"`
+	Events []historyEvent `json:"events"`
+}
+
+// handleHistory returns the recent connectivity events of a node, oldest
+// first.
+func (a *adminAPI) handleHistory(w http.ResponseWriter, r *http.Request) error {
+	name := r.URL.Query().Get("node")
+	if name == "" {
+		name = a.app.DefaultNodeName()
+	}
+
+	mc, ok := a.app.lookupClient(name)
+	if !ok {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("node %q not found", name),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(historyResponse{Node: name, Events: mc.history.list()})
+}
@@ -0,0 +1,140 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func historyTimes(events []historyEvent) []int {
+	secs := make([]int, len(events))
+	for i, ev := range events {
+		secs[i] = int(ev.Time.Unix())
+	}
+	return secs
+}
+
+func TestHistoryRing_Empty(t *testing.T) {
+	var r historyRing
+	events := r.list()
+	assert.NotNil(t, events, "events should encode as an empty list")
+	assert.Empty(t, events)
+}
+
+func TestHistoryRing_BelowCapacity(t *testing.T) {
+	var r historyRing
+	for i := range 3 {
+		r.add(historyEvent{Time: time.Unix(int64(i), 0)})
+	}
+	assert.Equal(t, []int{0, 1, 2}, historyTimes(r.list()))
+}
+
+func TestHistoryRing_Full(t *testing.T) {
+	var r historyRing
+	for i := range historySize {
+		r.add(historyEvent{Time: time.Unix(int64(i), 0)})
+	}
+	events := r.list()
+	require.Len(t, events, historySize)
+	assert.Equal(t, 0, int(events[0].Time.Unix()))
+	assert.Equal(t, historySize-1, int(events[historySize-1].Time.Unix()))
+}
+
+func TestHistoryRing_OverwritesOldest(t *testing.T) {
+	var r historyRing
+	for i := range historySize*2 + 5 {
+		r.add(historyEvent{Time: time.Unix(int64(i), 0)})
+	}
+
+	events := r.list()
+	require.Len(t, events, historySize, "the ring should be capped")
+	for i, ev := range events {
+		assert.Equal(t, historySize+5+i, int(ev.Time.Unix()), "events should be ordered oldest first")
+	}
+}
+
+func TestHistoryRing_ListIsCopy(t *testing.T) {
+	var r historyRing
+	r.add(historyEvent{Type: historyConnected})
+	events := r.list()
+	events[0].Type = "changed"
+	assert.Equal(t, historyConnected, r.list()[0].Type)
+}
+
+func TestRecordHistory(t *testing.T) {
+	mc := &ManagedClient{}
+	now := time.Now()
+	healthy := NodeHealth{ManagementConnected: true, SignalConnected: true, CheckedAt: now}
+	signalDown := NodeHealth{ManagementConnected: true, CheckedAt: now.Add(time.Second)}
+	allDown := NodeHealth{CheckedAt: now.Add(2 * time.Second)}
+
+	mc.recordHistory(nil, healthy)
+	mc.recordHistory(&healthy, healthy)
+	mc.recordHistory(&healthy, signalDown)
+	mc.recordHistory(&signalDown, allDown)
+	mc.recordHistory(&allDown, healthy)
+
+	assert.Equal(t, []historyEvent{
+		{Type: historyConnected, Time: now, Management: true, Signal: true},
+		{Type: historyDisconnected, Time: now.Add(time.Second), Management: true},
+		{Type: historyConnected, Time: now, Management: true, Signal: true},
+	}, mc.history.list(), "only changes of whether the node is healthy should be recorded")
+}
+
+func TestRecordHistory_FirstCheckDisconnected(t *testing.T) {
+	mc := &ManagedClient{}
+	mc.recordHistory(nil, NodeHealth{ManagementConnected: true})
+
+	events := mc.history.list()
+	require.Len(t, events, 1)
+	assert.Equal(t, historyDisconnected, events[0].Type)
+	assert.True(t, events[0].Management)
+}
+
+func TestHandleHistory(t *testing.T) {
+	a := newReloadTestApp(t, fakeSecretProvider{"WEB_KEY": "web-key", "API_KEY": "api-key"})
+	mc, ok := a.lookupClient("web")
+	require.True(t, ok)
+	at := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	mc.history.add(historyEvent{Type: historyConnected, Time: at, Management: true, Signal: true})
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/netbird/history?node=web", nil)
+	require.NoError(t, (&adminAPI{app: a}).handleAPI(rec, req))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp historyResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, "web", resp.Node)
+	require.Len(t, resp.Events, 1)
+	assert.Equal(t, historyConnected, resp.Events[0].Type)
+	assert.True(t, resp.Events[0].Time.Equal(at))
+}
+
+func TestHandleHistory_UnknownNode(t *testing.T) {
+	a := newReloadTestApp(t, fakeSecretProvider{"WEB_KEY": "web-key", "API_KEY": "api-key"})
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/netbird/history?node=db", nil)
+	require.NoError(t, (&adminAPI{app: a}).handleAPI(rec, req))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestHandleHistory_DefaultNode(t *testing.T) {
+	a := newReloadTestApp(t, fakeSecretProvider{"WEB_KEY": "web-key", "API_KEY": "api-key"})
+	a.DefaultNode = "api"
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/netbird/history", nil)
+	require.NoError(t, (&adminAPI{app: a}).handleAPI(rec, req))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp historyResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, "api", resp.Node, "requests without a node should use default_node")
+}
@@ -0,0 +1,28 @@
+package app
+
+import "time"
+
+// nodeError is an error of a node's client and when it occurred.
+type nodeError struct {
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+// setLastError records err as the client's last error, so it can be shown
+// in the status after it was logged.
+func (mc *ManagedClient) setLastError(err error) {
+	mc.errMu.Lock()
+	defer mc.errMu.Unlock()
+	mc.lastErr = &nodeError{Message: err.Error(), Time: time.Now()}
+}
+
+// lastError returns the client's last error, or nil if none occurred.
+func (mc *ManagedClient) lastError() *nodeError {
+	mc.errMu.Lock()
+	defer mc.errMu.Unlock()
+	if mc.lastErr == nil {
+		return nil
+	}
+	e := *mc.lastErr
+	return &e
+}
@@ -0,0 +1,65 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/netbirdio/netbird/client/embed"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestLastError(t *testing.T) {
+	mc := &ManagedClient{}
+	assert.Nil(t, mc.lastError())
+
+	mc.setLastError(errors.New("first"))
+	mc.setLastError(errors.New("second"))
+
+	got := mc.lastError()
+	require.NotNil(t, got)
+	assert.Equal(t, "second", got.Message)
+	assert.WithinDuration(t, time.Now(), got.Time, time.Minute)
+
+	got.Message = "changed"
+	assert.Equal(t, "second", mc.lastError().Message, "callers must get a copy")
+}
+
+func TestStart_RecordsLastError(t *testing.T) {
+	errDown := errors.New("management down")
+	mc := &ManagedClient{
+		mgmtURLs:  []string{"https://api.netbird.io:443"},
+		newClient: func(string) (*embed.Client, error) { return nil, errDown },
+		logger:    zap.NewNop(),
+	}
+
+	require.ErrorIs(t, mc.Start(context.Background()), errDown)
+
+	got := mc.lastError()
+	require.NotNil(t, got)
+	assert.Contains(t, got.Message, "management down")
+}
+
+func TestNodeStatus_LastError(t *testing.T) {
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	ns := &nodeStatus{LastError: &nodeError{Message: "start netbird client: management down", Time: at}}
+
+	data, err := json.Marshal(ns)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"lastError":{"message":"start netbird client: management down","time":"2026-01-02T03:04:05Z"}`)
+
+	data, err = json.Marshal(&nodeStatus{})
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "lastError")
+
+	rec := httptest.NewRecorder()
+	require.NoError(t, (&adminAPI{}).writeStatusText(rec, statusResponse{Nodes: map[nodeName]*nodeStatus{
+		"web": {Error: "status collection timed out", LastError: ns.LastError},
+	}}, false))
+	assert.Contains(t, rec.Body.String(), "Last error:  start netbird client: management down")
+}
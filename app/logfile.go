@@ -0,0 +1,30 @@
+package app
+
+import (
+	"path/filepath"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Rotation settings of the NetBird client log file. They match NetBird's
+// own file logging, which only takes the size from the environment.
+const (
+	defaultLogFileMaxSize = 15 // MB
+	logFileMaxBackups     = 10
+	logFileMaxAge         = 30 // days
+)
+
+// newLogFile returns a writer appending to path that rotates the file once
+// it exceeds maxSize megabytes (default: 15), keeping compressed backups.
+func newLogFile(path string, maxSize int) *lumberjack.Logger {
+	if maxSize <= 0 {
+		maxSize = defaultLogFileMaxSize
+	}
+	return &lumberjack.Logger{
+		Filename:   filepath.Clean(path),
+		MaxSize:    maxSize,
+		MaxBackups: logFileMaxBackups,
+		MaxAge:     logFileMaxAge,
+		Compress:   true,
+	}
+}
@@ -0,0 +1,59 @@
+package app
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLogFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "netbird.log")
+
+	w := newLogFile(path, 0)
+	assert.Equal(t, path, w.Filename)
+	assert.Equal(t, defaultLogFileMaxSize, w.MaxSize)
+	assert.Equal(t, logFileMaxBackups, w.MaxBackups)
+	assert.True(t, w.Compress)
+
+	w = newLogFile(path, 50)
+	assert.Equal(t, 50, w.MaxSize)
+
+	_, err := w.Write([]byte("entry\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	assert.FileExists(t, path)
+}
+
+func TestValidate_LogFileMaxSize(t *testing.T) {
+	a := &App{LogFile: "/var/log/netbird.log", LogFileMaxSize: 50}
+	assert.NoError(t, a.Validate())
+
+	a = &App{LogFile: "/var/log/netbird.log", LogFileMaxSize: -1}
+	assert.ErrorContains(t, a.Validate(), "must not be negative")
+
+	a = &App{LogFileMaxSize: 50}
+	assert.ErrorContains(t, a.Validate(), "requires log_file")
+}
+
+func TestParseGlobalOption_LogFile(t *testing.T) {
+	app := parseAndDecode(t, `netbird {
+		log_file /var/log/caddy/netbird.log
+		log_file_max_size 50
+	}`)
+	assert.Equal(t, "/var/log/caddy/netbird.log", app.LogFile)
+	assert.Equal(t, 50, app.LogFileMaxSize)
+
+	for _, input := range []string{
+		"netbird {\n log_file\n}",
+		"netbird {\n log_file a.log b.log\n}",
+		"netbird {\n log_file_max_size\n}",
+		"netbird {\n log_file_max_size 15MB\n}",
+		"netbird {\n log_file_max_size 0\n}",
+	} {
+		_, err := parseGlobalOption(caddyfile.NewTestDispenser(input), nil)
+		assert.Error(t, err, input)
+	}
+}
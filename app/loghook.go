@@ -0,0 +1,95 @@
+package app
+
+import (
+	"context"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// logFieldNode is the logrus field naming the node an entry belongs to.
+const logFieldNode = "node"
+
+// nodeLogCtxKey is the context key for the node name of logrus entries
+// logged with a context.
+type nodeLogCtxKey struct{}
+
+// withLogNode returns ctx tagged with the node name for logrus entries.
+func withLogNode(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, nodeLogCtxKey{}, name)
+}
+
+// nodeLogHook adds the node field to logrus entries of the NetBird clients.
+// The clients share the process-global logrus logger and log without
+// context, so entries can't be traced to a goroutine's node in general. An
+// entry is tagged if its context names the node, or if only one node is
+// running, in which case every entry must belong to it.
+type nodeLogHook struct {
+	mu sync.Mutex
+	// running counts the started clients per node name. A node may briefly
+	// run twice while a config reload replaces the client.
+	running map[string]int
+}
+
+var (
+	nodeLogs        = &nodeLogHook{running: make(map[string]int)}
+	nodeLogsInstall sync.Once
+)
+
+// installNodeLogHook adds the node hook to the standard logrus logger once.
+func installNodeLogHook() {
+	nodeLogsInstall.Do(func() {
+		log.AddHook(nodeLogs)
+	})
+}
+
+// started records that a client of the node is running.
+func (h *nodeLogHook) started(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.running[name]++
+}
+
+// stopped records that a client of the node stopped.
+func (h *nodeLogHook) stopped(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.running[name]--; h.running[name] <= 0 {
+		delete(h.running, name)
+	}
+}
+
+// soleNode returns the name of the only running node, if there is exactly one.
+func (h *nodeLogHook) soleNode() (string, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.running) != 1 {
+		return "", false
+	}
+	for name := range h.running {
+		return name, true
+	}
+	return "", false
+}
+
+// Levels implements log.Hook.
+func (h *nodeLogHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Fire implements log.Hook.
+func (h *nodeLogHook) Fire(entry *log.Entry) error {
+	if _, ok := entry.Data[logFieldNode]; ok {
+		return nil
+	}
+	if entry.Context != nil {
+		if name, ok := entry.Context.Value(nodeLogCtxKey{}).(string); ok {
+			entry.Data[logFieldNode] = name
+			return nil
+		}
+	}
+	if name, ok := h.soleNode(); ok {
+		entry.Data[logFieldNode] = name
+	}
+	return nil
+}
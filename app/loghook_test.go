@@ -0,0 +1,78 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodeLogHook_Fire(t *testing.T) {
+	tests := []struct {
+		name     string
+		running  []string
+		ctx      context.Context
+		data     log.Fields
+		wantNode any
+	}{
+		{name: "no node running"},
+		{name: "single node", running: []string{"web"}, wantNode: "web"},
+		{name: "single node running twice during reload", running: []string{"web", "web"}, wantNode: "web"},
+		{name: "multiple nodes", running: []string{"web", "db"}},
+		{name: "node from context", running: []string{"web", "db"}, ctx: withLogNode(context.Background(), "db"), wantNode: "db"},
+		{name: "context without node", running: []string{"web"}, ctx: context.Background(), wantNode: "web"},
+		{name: "existing field kept", running: []string{"web"}, data: log.Fields{logFieldNode: "other"}, wantNode: "other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook := &nodeLogHook{running: make(map[string]int)}
+			for _, name := range tt.running {
+				hook.started(name)
+			}
+
+			entry := log.NewEntry(log.New()).WithFields(tt.data)
+			if tt.ctx != nil {
+				entry = entry.WithContext(tt.ctx)
+			}
+			require.NoError(t, hook.Fire(entry))
+			assert.Equal(t, tt.wantNode, entry.Data[logFieldNode])
+		})
+	}
+}
+
+func TestNodeLogHook_Stopped(t *testing.T) {
+	hook := &nodeLogHook{running: make(map[string]int)}
+	hook.started("web")
+	hook.started("db")
+
+	_, ok := hook.soleNode()
+	assert.False(t, ok)
+
+	hook.stopped("db")
+	name, ok := hook.soleNode()
+	require.True(t, ok)
+	assert.Equal(t, "web", name)
+
+	hook.stopped("web")
+	hook.stopped("web")
+	_, ok = hook.soleNode()
+	assert.False(t, ok)
+	assert.Empty(t, hook.running, "stopping an unknown node leaves no entry")
+}
+
+func TestNodeLogHook_Output(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New()
+	logger.SetOutput(&buf)
+	logger.SetFormatter(&log.TextFormatter{DisableTimestamp: true})
+
+	hook := &nodeLogHook{running: make(map[string]int)}
+	hook.started("web")
+	logger.AddHook(hook)
+
+	logger.Info("peer connected")
+	assert.Contains(t, buf.String(), "node=web")
+}
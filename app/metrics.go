@@ -0,0 +1,125 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// metrics holds the app's Prometheus collectors. They are registered in
+// Caddy's metrics registry and served by its metrics endpoint.
+type metrics struct {
+	dialDuration *prometheus.HistogramVec
+}
+
+// newMetrics creates the collectors and registers them with reg.
+func newMetrics(reg prometheus.Registerer) (*metrics, error) {
+	m := &metrics{
+		dialDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "caddy",
+			Subsystem: "netbird",
+			Name:      "dial_duration_seconds",
+			Help:      "Latency of dials through the NetBird tunnel.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"node", "network", "result"}),
+	}
+
+	if err := reg.Register(m.dialDuration); err != nil {
+		return nil, fmt.Errorf("register dial duration metric: %w", err)
+	}
+	return m, nil
+}
+
+// observeDial records the latency of a dial. The trace ID of the request
+// and the public key of the dialed peer are attached as exemplar, if known,
+// so a latency spike can be traced back. A nil receiver is a no-op.
+func (m *metrics) observeDial(ctx context.Context, node, network, peerKey string, d time.Duration, err error) {
+	if m == nil {
+		return
+	}
+
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	obs := m.dialDuration.WithLabelValues(node, network, result)
+
+	exemplar := dialExemplar(ctx, peerKey)
+	if eo, ok := obs.(prometheus.ExemplarObserver); ok && len(exemplar) > 0 {
+		eo.ObserveWithExemplar(d.Seconds(), exemplar)
+		return
+	}
+	obs.Observe(d.Seconds())
+}
+
+// dialExemplar returns the exemplar labels for a dial, or nil if neither a
+// trace nor the peer is known.
+func dialExemplar(ctx context.Context, peerKey string) prometheus.Labels {
+	labels := prometheus.Labels{}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		labels["trace_id"] = sc.TraceID().String()
+	}
+	if peerKey != "" {
+		labels["peer"] = peerKey
+	}
+	if len(labels) == 0 {
+		return nil
+	}
+	return labels
+}
+
+// latencyQuantiles are the peer latency percentiles exported per node.
+var latencyQuantiles = []float64{0.5, 0.95, 0.99}
+
+// peerLatencyCollector exports percentiles of the latencies of each node's
+// connected peers. They are computed from the current status on every
+// scrape, so dashboards can alert on a node's overall tunnel health.
+type peerLatencyCollector struct {
+	desc *prometheus.Desc
+	// latencies returns the latencies of the connected peers of each node.
+	latencies func() map[nodeName][]time.Duration
+}
+
+func newPeerLatencyCollector(latencies func() map[nodeName][]time.Duration) *peerLatencyCollector {
+	return &peerLatencyCollector{
+		desc: prometheus.NewDesc(
+			"caddy_netbird_peer_latency_seconds",
+			"Percentiles of the latency of connected peers, per node.",
+			[]string{"node", "quantile"}, nil,
+		),
+		latencies: latencies,
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *peerLatencyCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect implements prometheus.Collector. Nodes without connected peers
+// of known latency are left out.
+func (c *peerLatencyCollector) Collect(ch chan<- prometheus.Metric) {
+	for node, latencies := range c.latencies() {
+		if len(latencies) == 0 {
+			continue
+		}
+		slices.Sort(latencies)
+		for _, q := range latencyQuantiles {
+			ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue,
+				percentile(latencies, q).Seconds(), node, strconv.FormatFloat(q, 'f', -1, 64))
+		}
+	}
+}
+
+// percentile returns the q-th quantile (0 to 1) of sorted, using the
+// nearest-rank method. sorted must not be empty.
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	rank := int(math.Ceil(q * float64(len(sorted))))
+	return sorted[min(max(rank, 1), len(sorted))-1]
+}
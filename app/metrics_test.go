@@ -0,0 +1,184 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// gatherDialHistogram returns the dial duration histogram with the given result label.
+func gatherDialHistogram(t *testing.T, reg *prometheus.Registry, result string) *dto.Histogram {
+	t.Helper()
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	for _, mf := range families {
+		if mf.GetName() != "caddy_netbird_dial_duration_seconds" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "result" && l.GetValue() == result {
+					return m.GetHistogram()
+				}
+			}
+		}
+	}
+	t.Fatalf("no dial histogram with result %q", result)
+	return nil
+}
+
+// exemplarLabels returns the labels of all exemplars in h.
+func exemplarLabels(h *dto.Histogram) []map[string]string {
+	var out []map[string]string
+	for _, b := range h.GetBucket() {
+		if e := b.GetExemplar(); e != nil {
+			labels := make(map[string]string)
+			for _, l := range e.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			out = append(out, labels)
+		}
+	}
+	return out
+}
+
+func TestObserveDial_Exemplar(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := newMetrics(reg)
+	require.NoError(t, err)
+
+	traceID := trace.TraceID{0x01, 0x02, 0x03}
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: traceID,
+		SpanID:  trace.SpanID{0x01},
+	}))
+	m.observeDial(ctx, "web", "tcp", "peer-key", 20*time.Millisecond, nil)
+
+	h := gatherDialHistogram(t, reg, "ok")
+	assert.Equal(t, uint64(1), h.GetSampleCount())
+	assert.Equal(t, []map[string]string{{
+		"trace_id": traceID.String(),
+		"peer":     "peer-key",
+	}}, exemplarLabels(h))
+}
+
+func TestObserveDial_NoExemplarWithoutData(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := newMetrics(reg)
+	require.NoError(t, err)
+
+	m.observeDial(context.Background(), "web", "tcp", "", time.Millisecond, errors.New("refused"))
+
+	h := gatherDialHistogram(t, reg, "error")
+	assert.Equal(t, uint64(1), h.GetSampleCount())
+	assert.Empty(t, exemplarLabels(h))
+}
+
+func TestDialExemplar(t *testing.T) {
+	assert.Nil(t, dialExemplar(context.Background(), ""))
+	assert.Equal(t, prometheus.Labels{"peer": "key"}, dialExemplar(context.Background(), "key"))
+}
+
+func TestObserveDial_NilMetrics(t *testing.T) {
+	var m *metrics
+	assert.NotPanics(t, func() {
+		m.observeDial(context.Background(), "web", "tcp", "", time.Millisecond, nil)
+	})
+}
+
+func TestPeerKey(t *testing.T) {
+	mc := &ManagedClient{}
+	assert.Empty(t, mc.peerKey("100.64.0.5:443"))
+
+	mc.peerKeys.Store(&map[string]string{"100.64.0.5": "key"})
+	assert.Equal(t, "key", mc.peerKey("100.64.0.5:443"))
+	assert.Equal(t, "key", mc.peerKey("100.64.0.5"))
+	assert.Empty(t, mc.peerKey("100.64.0.6:443"))
+}
+
+func TestParseGlobalOption_Metrics(t *testing.T) {
+	a := parseAndDecode(t, `netbird {
+		metrics
+	}`)
+	assert.True(t, a.Metrics)
+}
+
+func TestPercentile(t *testing.T) {
+	ms := func(ns ...int) []time.Duration {
+		out := make([]time.Duration, len(ns))
+		for i, n := range ns {
+			out[i] = time.Duration(n) * time.Millisecond
+		}
+		return out
+	}
+
+	tests := []struct {
+		name   string
+		sorted []time.Duration
+		q      float64
+		want   time.Duration
+	}{
+		{"single", ms(7), 0.5, 7 * time.Millisecond},
+		{"single p99", ms(7), 0.99, 7 * time.Millisecond},
+		{"median of odd", ms(1, 2, 3, 4, 5), 0.5, 3 * time.Millisecond},
+		{"median of even", ms(1, 2, 3, 4), 0.5, 2 * time.Millisecond},
+		{"p95 of ten", ms(1, 2, 3, 4, 5, 6, 7, 8, 9, 10), 0.95, 10 * time.Millisecond},
+		{"p90 of ten", ms(1, 2, 3, 4, 5, 6, 7, 8, 9, 10), 0.9, 9 * time.Millisecond},
+		{"zero quantile", ms(1, 2, 3), 0, 1 * time.Millisecond},
+		{"max quantile", ms(1, 2, 3), 1, 3 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, percentile(tt.sorted, tt.q))
+		})
+	}
+
+	hundred := make([]time.Duration, 100)
+	for i := range hundred {
+		hundred[i] = time.Duration(i+1) * time.Millisecond
+	}
+	assert.Equal(t, 50*time.Millisecond, percentile(hundred, 0.5))
+	assert.Equal(t, 95*time.Millisecond, percentile(hundred, 0.95))
+	assert.Equal(t, 99*time.Millisecond, percentile(hundred, 0.99))
+}
+
+func TestPeerLatencyCollector(t *testing.T) {
+	var scrapes int
+	c := newPeerLatencyCollector(func() map[nodeName][]time.Duration {
+		scrapes++
+		return map[nodeName][]time.Duration{
+			"web":   {30 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond},
+			"empty": nil,
+		}
+	})
+	reg := prometheus.NewRegistry()
+	require.NoError(t, reg.Register(c))
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	require.Len(t, families, 1)
+	assert.Equal(t, "caddy_netbird_peer_latency_seconds", families[0].GetName())
+
+	got := make(map[string]float64)
+	for _, m := range families[0].GetMetric() {
+		labels := make(map[string]string)
+		for _, l := range m.GetLabel() {
+			labels[l.GetName()] = l.GetValue()
+		}
+		assert.Equal(t, "web", labels["node"], "nodes without latencies are left out")
+		got[labels["quantile"]] = m.GetGauge().GetValue()
+	}
+	assert.Equal(t, map[string]float64{"0.5": 0.02, "0.95": 0.03, "0.99": 0.03}, got)
+
+	_, err = reg.Gather()
+	require.NoError(t, err)
+	assert.Equal(t, 2, scrapes, "latencies are recomputed on every scrape")
+}
@@ -0,0 +1,88 @@
+package app
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// belowMinPeers reports whether connected peers fall short of minPeers. A
+// zero minPeers disables the check.
+func belowMinPeers(connected, minPeers int) bool {
+	return minPeers > 0 && connected < minPeers
+}
+
+// checkMinPeers evaluates the connected peer count of a health check against
+// the node's min_peers threshold. A warning is logged when the count drops
+// below the threshold and an info once it recovers, not on every check.
+func (mc *ManagedClient) checkMinPeers(count peerCount) {
+	if mc.minPeers <= 0 {
+		return
+	}
+	below := belowMinPeers(count.Connected, mc.minPeers)
+	if mc.peersBelowMin.Swap(below) == below {
+		return
+	}
+	if below {
+		mc.logger.Warn("connected peers below min_peers",
+			zap.Int("connected", count.Connected),
+			zap.Int("min_peers", mc.minPeers),
+		)
+		return
+	}
+	mc.logger.Info("connected peers back at min_peers",
+		zap.Int("connected", count.Connected),
+		zap.Int("min_peers", mc.minPeers),
+	)
+}
+
+// peersBelowMin returns, for each running client with min_peers set, whether
+// its connected peers were below the threshold at the last health check.
+// Clients not checked yet are left out.
+func (a *App) peersBelowMin() map[nodeName]bool {
+	below := make(map[nodeName]bool)
+	a.rangeNodes(func(name nodeName, mc *ManagedClient) bool {
+		if mc.minPeers <= 0 || !mc.isStarted() {
+			return true
+		}
+		if _, ok := mc.Health(); !ok {
+			return true
+		}
+		below[name] = mc.peersBelowMin.Load()
+		return true
+	})
+	return below
+}
+
+// minPeersCollector exports whether each node's connected peers are below
+// its min_peers threshold, so peers silently dropping off can be alerted on.
+type minPeersCollector struct {
+	desc  *prometheus.Desc
+	below func() map[nodeName]bool
+}
+
+func newMinPeersCollector(below func() map[nodeName]bool) *minPeersCollector {
+	return &minPeersCollector{
+		desc: prometheus.NewDesc(
+			"caddy_netbird_peers_below_min",
+			"1 if the node's connected peers are below min_peers at the last health check, 0 otherwise.",
+			[]string{"node"}, nil,
+		),
+		below: below,
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *minPeersCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect implements prometheus.Collector.
+func (c *minPeersCollector) Collect(ch chan<- prometheus.Metric) {
+	for node, below := range c.below() {
+		var val float64
+		if below {
+			val = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, val, node)
+	}
+}
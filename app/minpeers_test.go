@@ -0,0 +1,158 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestBelowMinPeers(t *testing.T) {
+	tests := []struct {
+		name      string
+		connected int
+		minPeers  int
+		want      bool
+	}{
+		{name: "disabled", connected: 0, minPeers: 0, want: false},
+		{name: "below", connected: 2, minPeers: 3, want: true},
+		{name: "none connected", connected: 0, minPeers: 1, want: true},
+		{name: "at threshold", connected: 3, minPeers: 3, want: false},
+		{name: "above", connected: 5, minPeers: 3, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, belowMinPeers(tt.connected, tt.minPeers))
+		})
+	}
+}
+
+func TestCheckMinPeers_LogsTransitions(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	mc := &ManagedClient{minPeers: 3, logger: zap.New(core)}
+
+	mc.checkMinPeers(peerCount{Total: 5, Connected: 4})
+	assert.Equal(t, 0, logs.Len(), "nothing to report above the threshold")
+
+	mc.checkMinPeers(peerCount{Total: 5, Connected: 2})
+	mc.checkMinPeers(peerCount{Total: 5, Connected: 1})
+	assert.True(t, mc.peersBelowMin.Load())
+	require.Equal(t, 1, logs.Len(), "warning should be logged once while below")
+	entry := logs.All()[0]
+	assert.Equal(t, zapcore.WarnLevel, entry.Level)
+	assert.Equal(t, map[string]any{"connected": int64(2), "min_peers": int64(3)}, entry.ContextMap())
+
+	mc.checkMinPeers(peerCount{Total: 5, Connected: 3})
+	assert.False(t, mc.peersBelowMin.Load())
+	require.Equal(t, 2, logs.Len())
+	assert.Equal(t, zapcore.InfoLevel, logs.All()[1].Level)
+}
+
+func TestCheckMinPeers_Disabled(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	mc := &ManagedClient{logger: zap.New(core)}
+
+	mc.checkMinPeers(peerCount{})
+	assert.False(t, mc.peersBelowMin.Load())
+	assert.Equal(t, 0, logs.Len())
+}
+
+func TestPeersBelowMin(t *testing.T) {
+	a := &App{pool: caddy.NewUsagePool()}
+	add := func(name string, minPeers int, started, checked, below bool) {
+		mc := &ManagedClient{name: name, minPeers: minPeers, started: started}
+		if checked {
+			mc.health.Store(&NodeHealth{CheckedAt: time.Now()})
+		}
+		mc.peersBelowMin.Store(below)
+		_, _, err := a.pool.LoadOrNew(name, func() (caddy.Destructor, error) { return mc, nil })
+		require.NoError(t, err)
+	}
+	add("web", 2, true, true, true)
+	add("api", 1, true, true, false)
+	add("nomin", 0, true, true, false)
+	add("stopped", 2, false, true, true)
+	add("unchecked", 2, true, false, false)
+
+	assert.Equal(t, map[nodeName]bool{"web": true, "api": false}, a.peersBelowMin())
+}
+
+func TestMinPeersCollector(t *testing.T) {
+	c := newMinPeersCollector(func() map[nodeName]bool {
+		return map[nodeName]bool{"web": true, "api": false}
+	})
+	reg := prometheus.NewRegistry()
+	require.NoError(t, reg.Register(c))
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	require.Len(t, families, 1)
+	assert.Equal(t, "caddy_netbird_peers_below_min", families[0].GetName())
+
+	got := make(map[string]float64)
+	for _, m := range families[0].GetMetric() {
+		got[m.GetLabel()[0].GetValue()] = m.GetGauge().GetValue()
+	}
+	assert.Equal(t, map[string]float64{"web": 1, "api": 0}, got)
+}
+
+func TestParseGlobalOption_MinPeers(t *testing.T) {
+	app := parseAndDecode(t, `netbird {
+		node web {
+			min_peers 3
+		}
+	}`)
+	require.Contains(t, app.Nodes, "web")
+	assert.Equal(t, 3, app.Nodes["web"].MinPeers)
+}
+
+func TestParseGlobalOption_MinPeersZero(t *testing.T) {
+	app := parseAndDecode(t, `netbird {
+		management_url https://api.netbird.io
+		setup_key key
+		node web {
+			min_peers 0
+		}
+	}`)
+	require.Contains(t, app.Nodes, "web")
+	assert.Zero(t, app.Nodes["web"].MinPeers, "zero disables the check")
+	assert.NoError(t, app.Validate())
+}
+
+func TestParseGlobalOption_NegativeMinPeers(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`netbird {
+		node web {
+			min_peers -1
+		}
+	}`)
+	_, err := parseGlobalOption(d, nil)
+	require.ErrorIs(t, err, ErrInvalidMinPeers, "parser and Validate must agree")
+}
+
+func TestParseGlobalOption_InvalidMinPeers(t *testing.T) {
+	for _, val := range []string{"", "-1", "many"} {
+		d := caddyfile.NewTestDispenser(`netbird {
+			node web {
+				min_peers ` + val + `
+			}
+		}`)
+		_, err := parseGlobalOption(d, nil)
+		require.Error(t, err, val)
+	}
+}
+
+func TestValidate_MinPeers(t *testing.T) {
+	a := &App{
+		DefaultManagementURL: "https://api.netbird.io",
+		DefaultSetupKey:      "key",
+		Nodes:                map[string]*Node{"web": {MinPeers: -1}},
+	}
+	require.ErrorIs(t, a.Validate(), ErrInvalidMinPeers)
+}
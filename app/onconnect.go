@@ -0,0 +1,86 @@
+package app
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// onConnectTimeout bounds a single run of a node's on_connect command.
+const onConnectTimeout = 30 * time.Second
+
+// observeConnected records the connectivity seen by a health check and
+// reports whether the client reached both management and signal for the
+// first time since it was started.
+func (mc *ManagedClient) observeConnected(healthy bool) bool {
+	return healthy && mc.connected.CompareAndSwap(false, true)
+}
+
+// onConnected runs the node's on_connect command, if any, in the
+// background with the node name and NetBird IP of the client.
+func (mc *ManagedClient) onConnected() {
+	if len(mc.onConnect) == 0 {
+		return
+	}
+
+	var ip, fqdn string
+	if ns, err := mc.nodeStatus(); err == nil {
+		ip = stripPrefixLen(ns.Local.IP)
+		fqdn = ns.Local.FQDN
+	} else {
+		mc.logger.Debug("get status for on_connect", zap.Error(err))
+	}
+
+	command := onConnectCommand(mc.onConnect, mc.name, ip, fqdn)
+	env := onConnectEnv(mc.name, ip, fqdn)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), onConnectTimeout)
+		defer cancel()
+
+		mc.logger.Info("running on_connect command", zap.Strings("command", command), zap.String("ip", ip))
+		if out, err := runCommand(ctx, command, env); err != nil {
+			mc.logger.Warn("on_connect command failed",
+				zap.Strings("command", command),
+				zap.ByteString("output", out),
+				zap.Error(err),
+			)
+		}
+	}()
+}
+
+// onConnectCommand returns the on_connect command with the {netbird.node},
+// {netbird.ip} and {netbird.fqdn} placeholders of its arguments replaced.
+func onConnectCommand(command []string, node, ip, fqdn string) []string {
+	r := strings.NewReplacer(
+		"{netbird.node}", node,
+		"{netbird.ip}", ip,
+		"{netbird.fqdn}", fqdn,
+	)
+	out := make([]string, len(command))
+	for i, arg := range command {
+		out[i] = r.Replace(arg)
+	}
+	return out
+}
+
+// onConnectEnv returns the environment variables passed to the on_connect
+// command, in addition to Caddy's own environment.
+func onConnectEnv(node, ip, fqdn string) []string {
+	return []string{
+		"NETBIRD_NODE=" + node,
+		"NETBIRD_IP=" + ip,
+		"NETBIRD_FQDN=" + fqdn,
+	}
+}
+
+// runCommand runs command with env added to the process environment and
+// returns its combined output.
+func runCommand(ctx context.Context, command, env []string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Env = append(os.Environ(), env...)
+	return cmd.CombinedOutput()
+}
@@ -0,0 +1,104 @@
+package app
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestObserveConnected(t *testing.T) {
+	mc := &ManagedClient{}
+
+	assert.False(t, mc.observeConnected(false), "disconnected clients don't fire")
+	assert.True(t, mc.observeConnected(true), "first connection fires")
+	assert.False(t, mc.observeConnected(true), "staying connected doesn't fire again")
+	assert.False(t, mc.observeConnected(false))
+	assert.False(t, mc.observeConnected(true), "reconnecting doesn't fire again")
+
+	// Stopping the client resets the state.
+	mc.connected.Store(false)
+	assert.True(t, mc.observeConnected(true), "first connection after a restart fires")
+}
+
+func TestOnConnectCommand(t *testing.T) {
+	command := []string{"/usr/local/bin/register", "--name={netbird.node}", "{netbird.ip}", "{netbird.fqdn}", "{other}"}
+	got := onConnectCommand(command, "web", "100.64.0.5", "web.netbird.cloud")
+	assert.Equal(t, []string{"/usr/local/bin/register", "--name=web", "100.64.0.5", "web.netbird.cloud", "{other}"}, got)
+	assert.Equal(t, "--name={netbird.node}", command[1], "the configured command should not be modified")
+}
+
+func TestOnConnectEnv(t *testing.T) {
+	assert.Equal(t, []string{
+		"NETBIRD_NODE=web",
+		"NETBIRD_IP=100.64.0.5",
+		"NETBIRD_FQDN=web.netbird.cloud",
+	}, onConnectEnv("web", "100.64.0.5", "web.netbird.cloud"))
+}
+
+func TestRunCommand(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	out, err := runCommand(ctx, []string{"sh", "-c", `echo "$NETBIRD_NODE $NETBIRD_IP $1"`, "sh", "arg"}, onConnectEnv("web", "100.64.0.5", ""))
+	require.NoError(t, err)
+	assert.Equal(t, "web 100.64.0.5 arg\n", string(out))
+
+	out, err = runCommand(ctx, []string{"sh", "-c", "echo failed; exit 3"}, nil)
+	require.Error(t, err)
+	assert.Equal(t, "failed\n", string(out))
+}
+
+func TestOnConnected_RunsCommand(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	a := newReloadTestApp(t, fakeSecretProvider{"WEB_KEY": "web-key", "API_KEY": "api-key"})
+	mc, ok := a.lookupClient("web")
+	require.True(t, ok)
+
+	out := filepath.Join(t.TempDir(), "out")
+	mc.onConnect = []string{"sh", "-c", `echo "$NETBIRD_NODE" > "$1"`, "sh", out}
+	mc.onConnected()
+
+	assert.Eventually(t, func() bool {
+		data, err := os.ReadFile(out)
+		return err == nil && string(data) == "web\n"
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestParseGlobalOption_OnConnect(t *testing.T) {
+	app := parseAndDecode(t, `netbird {
+		node web {
+			on_connect /usr/local/bin/register-dns {netbird.node} {netbird.ip}
+		}
+	}`)
+	require.Contains(t, app.Nodes, "web")
+	assert.Equal(t, []string{"/usr/local/bin/register-dns", "{netbird.node}", "{netbird.ip}"}, app.Nodes["web"].OnConnect)
+
+	d := caddyfile.NewTestDispenser(`netbird {
+		node web {
+			on_connect
+		}
+	}`)
+	_, err := parseGlobalOption(d, nil)
+	require.Error(t, err)
+}
+
+func TestValidate_OnConnect(t *testing.T) {
+	a := &App{
+		DefaultManagementURL: "https://api.netbird.io",
+		DefaultSetupKey:      "key",
+		Nodes:                map[string]*Node{"web": {OnConnect: []string{""}}},
+	}
+	require.ErrorIs(t, a.Validate(), ErrInvalidOnConnect)
+}
@@ -0,0 +1,29 @@
+package app
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// preSharedKeyLen is the length of a WireGuard pre-shared key in bytes.
+const preSharedKeyLen = 32
+
+// validatePreSharedKey checks that key is a base64-encoded WireGuard key,
+// as generated by "wg genpsk", so a malformed key fails at config load
+// rather than deep in the client. An empty key and secret:// references,
+// which are checked once resolved, are accepted. The key is never part of
+// the error.
+func validatePreSharedKey(key string) error {
+	if key == "" || strings.HasPrefix(key, secretScheme) {
+		return nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return fmt.Errorf("%w: not valid base64, generate one with \"wg genpsk\"", ErrInvalidPreSharedKey)
+	}
+	if len(raw) != preSharedKeyLen {
+		return fmt.Errorf("%w: decodes to %d bytes instead of %d, generate one with \"wg genpsk\"", ErrInvalidPreSharedKey, len(raw), preSharedKeyLen)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPreSharedKey = "ZmFrZS1wc2stZm9yLXRlc3RzLW9ubHktMzItYnl0ZXM="
+
+func TestValidatePreSharedKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		wantErr bool
+	}{
+		{name: "empty", key: ""},
+		{name: "secret reference", key: "secret://PSK"},
+		{name: "valid", key: testPreSharedKey},
+		{name: "not base64", key: "not-a-key!", wantErr: true},
+		{name: "too short", key: "c2hvcnQ=", wantErr: true},
+		{name: "unpadded", key: "ZmFrZS1wc2stZm9yLXRlc3RzLW9ubHktMzItYnl0ZXM", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validatePreSharedKey(tt.key)
+			if !tt.wantErr {
+				assert.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, ErrInvalidPreSharedKey)
+			assert.NotContains(t, err.Error(), tt.key, "error must not leak the key")
+		})
+	}
+}
+
+func TestParseGlobalOption_InvalidPreSharedKey(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`netbird {
+		node test {
+			pre_shared_key psk123
+		}
+	}`)
+	_, err := parseGlobalOption(d, nil)
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "psk123")
+}
+
+func TestValidate_PreSharedKey(t *testing.T) {
+	a := &App{
+		DefaultManagementURL: "https://api.netbird.io",
+		DefaultSetupKey:      "key",
+		Nodes:                map[string]*Node{"web": {PreSharedKey: "c2hvcnQ="}},
+	}
+	require.ErrorIs(t, a.Validate(), ErrInvalidPreSharedKey)
+}
+
+func TestResolveSecrets_InvalidPreSharedKey(t *testing.T) {
+	app := &App{}
+	app.SetSecretProvider(fakeSecretProvider{"PSK": "psk-value"})
+
+	node := Node{PreSharedKey: "secret://PSK"}
+	require.ErrorIs(t, app.resolveSecrets(&node), ErrInvalidPreSharedKey)
+	assert.Equal(t, "secret://PSK", node.PreSharedKey, "node must be left untouched on error")
+}
@@ -0,0 +1,91 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// restartTimeout bounds a single client restart triggered by the reconnect watcher.
+const restartTimeout = 30 * time.Second
+
+// reconnectBackoff returns the delay before restart attempt n (starting at 0):
+// minDelay doubled for each previous attempt, capped at maxDelay.
+func reconnectBackoff(n int, minDelay, maxDelay time.Duration) time.Duration {
+	delay := minDelay
+	for range n {
+		if delay >= maxDelay/2 {
+			return maxDelay
+		}
+		delay *= 2
+	}
+	return min(delay, maxDelay)
+}
+
+// reconnector decides when to restart a client that lost its management or
+// signal connection. It is only accessed from the health check goroutine.
+type reconnector struct {
+	minDelay time.Duration
+	maxDelay time.Duration
+
+	attempts    int
+	nextRestart time.Time
+}
+
+// observe records a health check result and reports whether the client
+// should be restarted now.
+func (r *reconnector) observe(healthy bool, now time.Time) bool {
+	if healthy {
+		r.attempts = 0
+		r.nextRestart = time.Time{}
+		return false
+	}
+
+	if r.nextRestart.IsZero() {
+		r.nextRestart = now.Add(reconnectBackoff(0, r.minDelay, r.maxDelay))
+		return false
+	}
+	if now.Before(r.nextRestart) {
+		return false
+	}
+
+	r.attempts++
+	r.nextRestart = now.Add(reconnectBackoff(r.attempts, r.minDelay, r.maxDelay))
+	return true
+}
+
+// restartAsync restarts the client in the background, tracked by wg, so a
+// slow start doesn't hold up the health checks of other nodes. It does
+// nothing if a restart of the client is already running.
+func (mc *ManagedClient) restartAsync(ctx context.Context, wg *sync.WaitGroup) {
+	if !mc.restarting.CompareAndSwap(false, true) {
+		return
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer mc.restarting.Store(false)
+		if err := mc.restart(ctx); err != nil {
+			mc.logger.Warn("reconnect netbird client", zap.Error(err))
+		}
+	}()
+}
+
+// restart stops and starts the client to force a reconnect. The start is
+// aborted once ctx is done.
+func (mc *ManagedClient) restart(ctx context.Context) error {
+	if err := mc.stop(); err != nil {
+		mc.logger.Warn("stop netbird client for reconnect", zap.Error(err))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, restartTimeout)
+	defer cancel()
+
+	if err := mc.Start(ctx); err != nil {
+		return fmt.Errorf("restart netbird client: %w", err)
+	}
+	return nil
+}
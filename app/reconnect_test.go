@@ -0,0 +1,141 @@
+package app
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/netbirdio/netbird/client/embed"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestReconnectBackoff(t *testing.T) {
+	minDelay, maxDelay := 5*time.Second, time.Minute
+
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, 5 * time.Second},
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{4, time.Minute},
+		{100, time.Minute},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, reconnectBackoff(tt.attempt, minDelay, maxDelay), "attempt %d", tt.attempt)
+	}
+}
+
+func TestReconnectBackoff_MinAboveMax(t *testing.T) {
+	assert.Equal(t, time.Second, reconnectBackoff(0, time.Minute, time.Second))
+}
+
+func TestReconnector_Observe(t *testing.T) {
+	r := &reconnector{minDelay: 10 * time.Second, maxDelay: 40 * time.Second}
+	now := time.Now()
+
+	assert.False(t, r.observe(true, now), "healthy client is never restarted")
+
+	assert.False(t, r.observe(false, now), "first failure only starts the grace period")
+	assert.False(t, r.observe(false, now.Add(5*time.Second)))
+	assert.True(t, r.observe(false, now.Add(10*time.Second)), "restart after the min delay")
+	require.Equal(t, 1, r.attempts)
+
+	// The next restart waits twice as long.
+	assert.False(t, r.observe(false, now.Add(25*time.Second)))
+	assert.True(t, r.observe(false, now.Add(30*time.Second)))
+	require.Equal(t, 2, r.attempts)
+
+	// Recovery resets the backoff.
+	assert.False(t, r.observe(true, now.Add(35*time.Second)))
+	assert.Zero(t, r.attempts)
+	assert.False(t, r.observe(false, now.Add(40*time.Second)))
+	assert.True(t, r.observe(false, now.Add(50*time.Second)))
+}
+
+// newRestartTestClient returns a started client whose starts run start
+// instead of connecting to a management server.
+func newRestartTestClient(start func(ctx context.Context) error) *ManagedClient {
+	mc := &ManagedClient{
+		name:      "web",
+		mgmtURLs:  []string{"https://a"},
+		clientURL: "https://a",
+		started:   true,
+		startClient: func(ctx context.Context, _ *embed.Client) error {
+			return start(ctx)
+		},
+		stopClient: func(context.Context, *embed.Client) error { return nil },
+		logger:     zap.NewNop(),
+	}
+	mc.client.Store(new(embed.Client))
+	return mc
+}
+
+func TestRestartAsync_NoOverlap(t *testing.T) {
+	var starts atomic.Int32
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	mc := newRestartTestClient(func(context.Context) error {
+		starts.Add(1)
+		started <- struct{}{}
+		<-release
+		return nil
+	})
+
+	var wg sync.WaitGroup
+	mc.restartAsync(context.Background(), &wg)
+	<-started
+	mc.restartAsync(context.Background(), &wg)
+	assert.False(t, mc.checkHealth(), "clients being restarted should not be checked")
+
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), starts.Load(), "a running restart should not be started again")
+	assert.False(t, mc.restarting.Load())
+	assert.True(t, mc.isStarted())
+	require.NoError(t, mc.stop())
+}
+
+func TestRestartAsync_Canceled(t *testing.T) {
+	mc := newRestartTestClient(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	mc.restartAsync(ctx, &wg)
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("restart did not stop once its context was cancelled")
+	}
+	assert.False(t, mc.isStarted())
+}
+
+func TestParseGlobalOption_Reconnect(t *testing.T) {
+	app := parseAndDecode(t, `netbird {
+		node flaky {
+			reconnect_min 5s
+			reconnect_max 2m
+		}
+	}`)
+
+	node := app.Nodes["flaky"]
+	require.NotNil(t, node)
+	assert.Equal(t, 5*time.Second, time.Duration(node.ReconnectMin))
+	assert.Equal(t, 2*time.Minute, time.Duration(node.ReconnectMax))
+}
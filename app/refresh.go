@@ -0,0 +1,65 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+type refreshRequest struct {
+	Node string `json:"node"`
+}
+
+type refreshResponse struct {
+	Node           nodeName `json:"node"`
+	Peers          int      `json:"peers"`
+	PeersConnected int      `json:"peersConnected"`
+}
+
+// handleRefresh re-polls a node's status right away instead of waiting for
+// the next health check, so peer lookups used when dialing, such as peer
+// FQDNs and relay state, reflect recent changes. The embedded client has no
+// way to force a sync with management; it applies management updates as
+// they are pushed.
+func (a *adminAPI) handleRefresh(w http.ResponseWriter, r *http.Request) error {
+	var req refreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        fmt.Errorf("decode request: %w", err),
+		}
+	}
+	if req.Node == "" {
+		req.Node = a.app.DefaultNodeName()
+	}
+
+	mc, ok := a.app.lookupClient(req.Node)
+	if !ok {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("node %q not found", req.Node),
+		}
+	}
+	if !mc.isStarted() {
+		return caddy.APIError{
+			HTTPStatus: http.StatusConflict,
+			Err:        fmt.Errorf("node %q is not started", req.Node),
+		}
+	}
+
+	_, count, err := mc.refreshStatus()
+	if err != nil {
+		return fmt.Errorf("refresh status of node %q: %w", req.Node, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(refreshResponse{
+		Node:           req.Node,
+		Peers:          count.Total,
+		PeersConnected: count.Connected,
+	})
+}
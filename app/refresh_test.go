@@ -0,0 +1,58 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleRefresh(t *testing.T) {
+	a := newReloadTestApp(t, fakeSecretProvider{"WEB_KEY": "web-key", "API_KEY": "api-key"})
+	api := &adminAPI{app: a}
+
+	mc, ok := a.lookupClient("web")
+	require.True(t, ok)
+	mc.started = true
+	t.Cleanup(func() { mc.started = false })
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/netbird/refresh", strings.NewReader(`{"node":"web"}`))
+	require.NoError(t, api.handleAPI(rec, req))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp refreshResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, refreshResponse{Node: "web"}, resp)
+
+	_, checked := mc.Health()
+	assert.True(t, checked, "refresh updates the cached health")
+	assert.NotNil(t, mc.peerIPs.Load(), "refresh updates the peer lookups")
+}
+
+func TestHandleRefresh_Errors(t *testing.T) {
+	a := newReloadTestApp(t, fakeSecretProvider{"WEB_KEY": "web-key", "API_KEY": "api-key"})
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"invalid body", `{`, http.StatusBadRequest},
+		{"unknown node", `{"node":"db"}`, http.StatusNotFound},
+		{"default node missing", ``, http.StatusNotFound},
+		{"not started", `{"node":"api"}`, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/netbird/refresh", strings.NewReader(tt.body))
+			require.NoError(t, (&adminAPI{app: a}).handleAPI(rec, req))
+			assert.Equal(t, tt.status, rec.Code)
+		})
+	}
+}
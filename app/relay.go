@@ -0,0 +1,44 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// envForceRelay is the environment variable that makes the NetBird client
+// connect to all peers via relay.
+const envForceRelay = "NB_FORCE_RELAY"
+
+// forceRelayedEnv reports whether the NetBird client is told to connect to
+// peers via relay only.
+func forceRelayedEnv() bool {
+	return strings.EqualFold(os.Getenv(envForceRelay), "true")
+}
+
+// relayHostPort returns the lowercased host:port of a relay address,
+// stripping a rel:// or rels:// scheme as reported in the peer status.
+func relayHostPort(addr string) string {
+	if _, rest, ok := strings.Cut(addr, "://"); ok {
+		addr = rest
+	}
+	return strings.ToLower(addr)
+}
+
+// validateRelayAddress checks that addr is a relay host:port, optionally
+// with a rel:// or rels:// scheme.
+func validateRelayAddress(addr string) error {
+	if scheme, _, ok := strings.Cut(addr, "://"); ok && scheme != "rel" && scheme != "rels" {
+		return fmt.Errorf("unsupported relay scheme %q", scheme)
+	}
+	host, port, err := net.SplitHostPort(relayHostPort(addr))
+	if err != nil {
+		return err
+	}
+	if host == "" || port == "" {
+		return errors.New("relay must be given as host:port")
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseGlobalOption_ForceRelay(t *testing.T) {
+	app := parseAndDecode(t, `netbird {
+		node debug {
+			force_relay relay.netbird.io:443
+		}
+	}`)
+	require.Contains(t, app.Nodes, "debug")
+	assert.Equal(t, "relay.netbird.io:443", app.Nodes["debug"].ForceRelay)
+}
+
+func TestParseGlobalOption_InvalidForceRelay(t *testing.T) {
+	for _, val := range []string{"", "relay.netbird.io", "https://relay.netbird.io:443", ":443", "relay.netbird.io:443 extra"} {
+		d := caddyfile.NewTestDispenser(`netbird {
+			node test {
+				force_relay ` + val + `
+			}
+		}`)
+		_, err := parseGlobalOption(d, nil)
+		require.Error(t, err, val)
+	}
+}
+
+func TestValidate_ForceRelay(t *testing.T) {
+	newApp := func(node *Node) *App {
+		return &App{
+			DefaultManagementURL: "https://api.netbird.io",
+			DefaultSetupKey:      "key",
+			Nodes:                map[string]*Node{"web": node},
+		}
+	}
+
+	require.NoError(t, newApp(&Node{ForceRelay: "rels://relay.netbird.io:443"}).Validate())
+	require.ErrorIs(t, newApp(&Node{ForceRelay: "relay.netbird.io:443", DisableRelays: true}).Validate(), ErrForceRelayConflict)
+	require.Error(t, newApp(&Node{ForceRelay: "relay.netbird.io"}).Validate())
+}
+
+func TestRelayHostPort(t *testing.T) {
+	assert.Equal(t, "relay.netbird.io:443", relayHostPort("rels://Relay.NetBird.io:443"))
+	assert.Equal(t, "relay.netbird.io:80", relayHostPort("rel://relay.netbird.io:80"))
+	assert.Equal(t, "relay.netbird.io:443", relayHostPort("relay.netbird.io:443"))
+}
+
+func TestCheckRelayPolicy_ForceRelay(t *testing.T) {
+	peers := map[string]string{
+		"100.0.1.10":            "",
+		"backend.netbird.cloud": "",
+		"100.0.1.20":            "relay.netbird.io:443",
+		"100.0.1.30":            "relay-eu.netbird.io:443",
+	}
+
+	mc := &ManagedClient{forceRelay: relayHostPort("rels://relay.netbird.io:443")}
+	assert.NoError(t, mc.checkRelayPolicy("100.0.1.10:443"), "unknown relay state allows the dial")
+
+	mc.peerRelays.Store(&peers)
+	assert.NoError(t, mc.checkRelayPolicy("100.0.1.20:443"))
+	require.ErrorIs(t, mc.checkRelayPolicy("100.0.1.30:443"), ErrNotForcedRelay, "other relay")
+	require.ErrorIs(t, mc.checkRelayPolicy("backend.netbird.cloud:8080"), ErrNotForcedRelay, "direct peer")
+	require.ErrorIs(t, mc.checkRelayPolicy("100.0.1.10"), ErrNotForcedRelay, "ping addresses have no port")
+	assert.NoError(t, mc.checkRelayPolicy("100.0.1.99:443"), "unknown peers are dialed normally")
+}
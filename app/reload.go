@@ -0,0 +1,148 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+
+	"github.com/netbirdio/netbird/client/embed"
+	"go.uber.org/zap"
+)
+
+// reloadResult lists the nodes whose clients were recreated by a reload and
+// the nodes that failed to reload.
+type reloadResult struct {
+	Recreated []nodeName          `json:"recreated"`
+	Errors    map[nodeName]string `json:"errors,omitempty"`
+}
+
+// handleReload re-reads the node configs and recreates changed clients.
+// Clients are restarted detached from the request, so a client disconnecting
+// doesn't leave a node stopped.
+func (a *adminAPI) handleReload(w http.ResponseWriter, _ *http.Request) error {
+	ctx, cancel := context.WithTimeout(context.Background(), restartTimeout)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(a.app.reload(ctx))
+}
+
+// reload re-resolves the config of all pooled nodes, including secret://
+// references, and recreates the clients whose resolved config changed.
+// Transports and handlers keep their ManagedClient reference and use the
+// new client transparently.
+func (a *App) reload(ctx context.Context) reloadResult {
+	var names []nodeName
+	a.rangeNodes(func(name nodeName, _ *ManagedClient) bool {
+		names = append(names, name)
+		return true
+	})
+	slices.Sort(names)
+
+	result := reloadResult{Recreated: []nodeName{}}
+	for _, name := range names {
+		recreated, err := a.reloadNode(ctx, name)
+		if err != nil {
+			if result.Errors == nil {
+				result.Errors = make(map[nodeName]string)
+			}
+			result.Errors[name] = err.Error()
+			continue
+		}
+		if recreated {
+			result.Recreated = append(result.Recreated, name)
+		}
+	}
+	return result
+}
+
+// reloadNode recreates the node's client if its resolved config changed. A
+// reference is held meanwhile so the client isn't destructed concurrently.
+func (a *App) reloadNode(ctx context.Context, name nodeName) (bool, error) {
+	mc, err := a.getClient(name)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err := a.ReleaseClient(name); err != nil {
+			a.logger.Warn("release netbird client after reload", zap.String("node", name), zap.Error(err))
+		}
+	}()
+
+	node, err := a.resolveNodeConfig(name)
+	if err != nil {
+		return false, err
+	}
+	if !mc.configChanged(node) {
+		return false, nil
+	}
+	if a.ShareIdenticalNodes {
+		return false, fmt.Errorf("node %q: config changed, but clients can't be recreated with share_identical_nodes; reload the Caddy config instead", name)
+	}
+
+	if from, changed := mc.managementChanged(node); changed {
+		a.logger.Info("management URL changed, reconnecting netbird client",
+			zap.String("node", name),
+			zap.Strings("from", from),
+			zap.Strings("to", node.managementURLs()),
+		)
+	} else {
+		a.logger.Info("recreating netbird client with changed config", zap.String("node", name))
+	}
+	if err := mc.reconfigure(ctx, node, a.clientFactory(name, node)); err != nil {
+		return false, fmt.Errorf("node %q: %w", name, err)
+	}
+	return true, nil
+}
+
+// configChanged reports whether node differs from the config the client
+// was created from.
+func (mc *ManagedClient) configChanged(node Node) bool {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return !reflect.DeepEqual(mc.node, node)
+}
+
+// managementChanged reports whether the management URLs of node differ
+// from those the client was created with, which are returned as from.
+func (mc *ManagedClient) managementChanged(node Node) (from []string, changed bool) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	from = mc.node.managementURLs()
+	return from, !slices.Equal(from, node.managementURLs())
+}
+
+// reconfigure replaces the client with one created by newClient for node.
+// If the old client was running, it is stopped and the new one started, so
+// a changed management URL takes effect right away.
+func (mc *ManagedClient) reconfigure(ctx context.Context, node Node, newClient func(mgmtURL string) (*embed.Client, error)) error {
+	mgmtURLs := node.managementURLs()
+	if len(mgmtURLs) == 0 {
+		return ErrMissingManagementURL
+	}
+	client, err := newClient(mgmtURLs[0])
+	if err != nil {
+		return err
+	}
+
+	wasStarted := mc.isStarted()
+	if err := mc.stop(); err != nil {
+		mc.logger.Warn("stop netbird client for reload", zap.Error(err))
+	}
+
+	mc.mu.Lock()
+	mc.node = node
+	mc.newClient = newClient
+	mc.mgmtURLs = mgmtURLs
+	mc.clientURL = mgmtURLs[0]
+	mc.client.Store(client)
+	mc.mu.Unlock()
+
+	if !wasStarted {
+		return nil
+	}
+	return mc.Start(ctx)
+}
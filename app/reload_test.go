@@ -0,0 +1,196 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/netbirdio/netbird/client/embed"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newReloadTestApp(t *testing.T, secrets fakeSecretProvider) *App {
+	t.Helper()
+
+	a := &App{
+		DefaultManagementURL: "https://api.netbird.io:443",
+		Nodes: map[string]*Node{
+			"web": {SetupKey: "secret://WEB_KEY"},
+			"api": {SetupKey: "secret://API_KEY"},
+		},
+		pool:   caddy.NewUsagePool(),
+		logger: zap.NewNop(),
+	}
+	a.SetSecretProvider(secrets)
+
+	for _, name := range []string{"web", "api"} {
+		_, err := a.getClient(name)
+		require.NoError(t, err)
+	}
+	t.Cleanup(func() {
+		_ = a.ReleaseClient("web")
+		_ = a.ReleaseClient("api")
+	})
+	return a
+}
+
+func TestConfigChanged(t *testing.T) {
+	port := 51820
+	mc := &ManagedClient{node: Node{ManagementURL: "https://a", SetupKey: "key", WireguardPort: &port}}
+
+	samePort := 51820
+	assert.False(t, mc.configChanged(Node{ManagementURL: "https://a", SetupKey: "key", WireguardPort: &samePort}))
+	assert.True(t, mc.configChanged(Node{ManagementURL: "https://a", SetupKey: "rotated", WireguardPort: &port}))
+	assert.True(t, mc.configChanged(Node{ManagementURL: "https://b", SetupKey: "key", WireguardPort: &port}))
+}
+
+func TestReload_Unchanged(t *testing.T) {
+	a := newReloadTestApp(t, fakeSecretProvider{"WEB_KEY": "web-key", "API_KEY": "api-key"})
+
+	web, ok := a.lookupClient("web")
+	require.True(t, ok)
+	before := web.Client()
+
+	result := a.reload(context.Background())
+	assert.Empty(t, result.Recreated)
+	assert.Empty(t, result.Errors)
+	assert.Same(t, before, web.Client())
+}
+
+func TestReload_RecreatesChangedNodesOnly(t *testing.T) {
+	secrets := fakeSecretProvider{"WEB_KEY": "web-key", "API_KEY": "api-key"}
+	a := newReloadTestApp(t, secrets)
+
+	web, _ := a.lookupClient("web")
+	api, _ := a.lookupClient("api")
+	webBefore, apiBefore := web.Client(), api.Client()
+
+	secrets["WEB_KEY"] = "rotated-key"
+	result := a.reload(context.Background())
+
+	assert.Equal(t, []nodeName{"web"}, result.Recreated)
+	assert.Empty(t, result.Errors)
+	assert.NotSame(t, webBefore, web.Client(), "changed node gets a new client")
+	assert.Same(t, apiBefore, api.Client(), "unchanged node keeps its client")
+	assert.Equal(t, "rotated-key", web.node.SetupKey)
+
+	webAfter, ok := a.lookupClient("web")
+	require.True(t, ok)
+	assert.Same(t, web, webAfter, "holders keep the same managed client")
+
+	refs, ok := a.pool.References("web")
+	require.True(t, ok)
+	assert.Equal(t, 1, refs, "reload must not leak references")
+}
+
+func TestReload_SecretError(t *testing.T) {
+	secrets := fakeSecretProvider{"WEB_KEY": "web-key", "API_KEY": "api-key"}
+	a := newReloadTestApp(t, secrets)
+
+	web, _ := a.lookupClient("web")
+	before := web.Client()
+
+	delete(secrets, "WEB_KEY")
+	secrets["API_KEY"] = "rotated-key"
+	result := a.reload(context.Background())
+
+	assert.Equal(t, []nodeName{"api"}, result.Recreated)
+	require.Contains(t, result.Errors, "web")
+	assert.Contains(t, result.Errors["web"], "WEB_KEY")
+	assert.Same(t, before, web.Client(), "node keeps its client if its config can't be resolved")
+}
+
+func TestReconfigure_NotStarted(t *testing.T) {
+	mc := &ManagedClient{logger: zap.NewNop()}
+	var urls []string
+	newClient := func(mgmtURL string) (*embed.Client, error) {
+		urls = append(urls, mgmtURL)
+		return &embed.Client{}, nil
+	}
+
+	node := Node{ManagementURL: "https://a", ManagementURLs: []string{"https://b"}}
+	require.NoError(t, mc.reconfigure(context.Background(), node, newClient))
+
+	assert.Equal(t, []string{"https://a"}, urls)
+	assert.Equal(t, []string{"https://a", "https://b"}, mc.mgmtURLs)
+	assert.Equal(t, "https://a", mc.clientURL)
+	assert.NotNil(t, mc.Client())
+	assert.False(t, mc.isStarted(), "a stopped client is not started by a reload")
+}
+
+func TestManagementChanged(t *testing.T) {
+	mc := &ManagedClient{node: Node{ManagementURL: "https://a", ManagementURLs: []string{"https://b"}}}
+
+	from, changed := mc.managementChanged(Node{ManagementURL: "https://a", ManagementURLs: []string{"https://b"}})
+	assert.False(t, changed)
+	assert.Equal(t, []string{"https://a", "https://b"}, from)
+
+	_, changed = mc.managementChanged(Node{ManagementURL: "https://a", ManagementURLs: []string{"https://b"}, SetupKey: "other"})
+	assert.False(t, changed, "other changes are not a management change")
+
+	_, changed = mc.managementChanged(Node{ManagementURL: "https://c", ManagementURLs: []string{"https://b"}})
+	assert.True(t, changed)
+
+	_, changed = mc.managementChanged(Node{ManagementURL: "https://b", ManagementURLs: []string{"https://a"}})
+	assert.True(t, changed, "failover order matters")
+}
+
+func TestReload_ManagementURLChangeRestartsClient(t *testing.T) {
+	secrets := fakeSecretProvider{"MGMT": "https://old.example.com:443"}
+	a := &App{
+		DefaultManagementURL: "secret://MGMT",
+		DefaultSetupKey:      "key",
+		Nodes:                map[string]*Node{"web": {}},
+		pool:                 caddy.NewUsagePool(),
+		logger:               zap.NewNop(),
+	}
+	a.SetSecretProvider(secrets)
+
+	web, err := a.getClient("web")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = a.ReleaseClient("web") })
+	assert.Equal(t, "https://old.example.com:443", web.clientURL)
+
+	// Pretend the client runs, and record the restart instead of
+	// connecting to a management server.
+	web.started = true
+	var startedURLs []string
+	web.startClient = func(context.Context, *embed.Client) error {
+		startedURLs = append(startedURLs, web.clientURL)
+		return nil
+	}
+
+	result := a.reload(context.Background())
+	assert.Empty(t, result.Recreated, "unchanged management URL keeps the client")
+	assert.Empty(t, startedURLs)
+
+	secrets["MGMT"] = "https://new.example.com:443"
+	before := web.Client()
+	result = a.reload(context.Background())
+
+	assert.Equal(t, []nodeName{"web"}, result.Recreated)
+	assert.Empty(t, result.Errors)
+	assert.NotSame(t, before, web.Client())
+	assert.Equal(t, []string{"https://new.example.com:443"}, startedURLs, "client should restart against the new server")
+	assert.Equal(t, []string{"https://new.example.com:443"}, web.mgmtURLs)
+	assert.True(t, web.isStarted())
+}
+
+func TestHandleReload(t *testing.T) {
+	secrets := fakeSecretProvider{"WEB_KEY": "web-key", "API_KEY": "api-key"}
+	api := &adminAPI{app: newReloadTestApp(t, secrets)}
+
+	secrets["API_KEY"] = "rotated-key"
+	rec := httptest.NewRecorder()
+	require.NoError(t, api.handleAPI(rec, httptest.NewRequest(http.MethodPost, "/netbird/reload", nil)))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var result reloadResult
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	assert.Equal(t, []nodeName{"api"}, result.Recreated)
+}
@@ -0,0 +1,219 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/exp/maps"
+)
+
+// dialRateWindow is the window dial rates are averaged over, in seconds.
+const dialRateWindow = 60
+
+// dialCounter counts the dials through a node per second over the last
+// dialRateWindow seconds.
+type dialCounter struct {
+	mu     sync.Mutex
+	counts [dialRateWindow]int64
+	// secs holds the Unix second each count belongs to, so stale counts
+	// are recognized without clearing them.
+	secs [dialRateWindow]int64
+}
+
+// add counts a dial at now. A nil counter is a no-op.
+func (c *dialCounter) add(now time.Time) {
+	if c == nil {
+		return
+	}
+	sec := now.Unix()
+	idx := sec % dialRateWindow
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.secs[idx] != sec {
+		c.secs[idx] = sec
+		c.counts[idx] = 0
+	}
+	c.counts[idx]++
+}
+
+// rate returns the dials per second over the window ending at now.
+func (c *dialCounter) rate(now time.Time) float64 {
+	sec := now.Unix()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var total int64
+	for i, s := range c.secs {
+		if s > sec-dialRateWindow && s <= sec {
+			total += c.counts[i]
+		}
+	}
+	return float64(total) / dialRateWindow
+}
+
+// dialCounter returns the dial counter of a node. It outlives the node's
+// client, so the rate isn't reset when the client is recreated.
+func (a *App) dialCounter(node string) *dialCounter {
+	val, _ := a.dials.LoadOrStore(node, new(dialCounter))
+	return val.(*dialCounter)
+}
+
+// scalingSignal is the load of a node, for autoscaling decisions.
+type scalingSignal struct {
+	// ActiveConns is the number of connections currently proxied through
+	// the node by transports and L4 handlers.
+	ActiveConns int64
+	// DialRate is the number of dials per second through the node,
+	// averaged over the last minute.
+	DialRate float64
+}
+
+// scalingSignals returns the load of every node that had connections or
+// dials since the app was provisioned.
+func (a *App) scalingSignals(now time.Time) map[nodeName]scalingSignal {
+	signals := make(map[nodeName]scalingSignal)
+	a.usage.Range(func(key, _ any) bool {
+		node := key.(string)
+		signals[node] = scalingSignal{ActiveConns: a.usageOf(node).ActiveConns}
+		return true
+	})
+	a.dials.Range(func(key, val any) bool {
+		node := key.(string)
+		sig := signals[node]
+		sig.DialRate = val.(*dialCounter).rate(now)
+		signals[node] = sig
+		return true
+	})
+	return signals
+}
+
+// scalingCollector exports the scaling signals of all nodes as gauges.
+type scalingCollector struct {
+	activeConns *prometheus.Desc
+	dialRate    *prometheus.Desc
+	signals     func(time.Time) map[nodeName]scalingSignal
+}
+
+func newScalingCollector(signals func(time.Time) map[nodeName]scalingSignal) *scalingCollector {
+	return &scalingCollector{
+		activeConns: prometheus.NewDesc(
+			"caddy_netbird_active_connections",
+			"Connections currently proxied through the node by transports and L4 handlers.",
+			[]string{"node"}, nil,
+		),
+		dialRate: prometheus.NewDesc(
+			"caddy_netbird_dial_rate",
+			"Dials per second through the node, averaged over the last minute.",
+			[]string{"node"}, nil,
+		),
+		signals: signals,
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *scalingCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.activeConns
+	ch <- c.dialRate
+}
+
+// Collect implements prometheus.Collector.
+func (c *scalingCollector) Collect(ch chan<- prometheus.Metric) {
+	for node, sig := range c.signals(time.Now()) {
+		ch <- prometheus.MustNewConstMetric(c.activeConns, prometheus.GaugeValue, float64(sig.ActiveConns), node)
+		ch <- prometheus.MustNewConstMetric(c.dialRate, prometheus.GaugeValue, sig.DialRate, node)
+	}
+}
+
+// Metric names of the scaling endpoint.
+const (
+	scalingMetricActiveConns = "netbird_active_connections"
+	scalingMetricDialRate    = "netbird_dial_rate"
+)
+
+// externalMetricValueList is the ExternalMetricValueList of the Kubernetes
+// external metrics API (external.metrics.k8s.io/v1beta1), so adapters can
+// pass the scaling signals on to the HorizontalPodAutoscaler as is.
+type externalMetricValueList struct {
+	Kind       string                `json:"kind"`
+	APIVersion string                `json:"apiVersion"`
+	Metadata   struct{}              `json:"metadata"`
+	Items      []externalMetricValue `json:"items"`
+}
+
+type externalMetricValue struct {
+	MetricName   string            `json:"metricName"`
+	MetricLabels map[string]string `json:"metricLabels"`
+	Timestamp    time.Time         `json:"timestamp"`
+	// Value is a Kubernetes quantity, e.g. "3" or "2500m".
+	Value string `json:"value"`
+}
+
+// handleScaling returns the active connections and dial rates of all nodes,
+// or of the node given by ?node=, as an external metric value list.
+// ?metric= limits the list to one metric.
+func (a *adminAPI) handleScaling(w http.ResponseWriter, r *http.Request) error {
+	metric := r.URL.Query().Get("metric")
+	if metric != "" && metric != scalingMetricActiveConns && metric != scalingMetricDialRate {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        fmt.Errorf("unknown metric %q", metric),
+		}
+	}
+
+	now := time.Now()
+	signals := a.app.scalingSignals(now)
+	if node := r.URL.Query().Get("node"); node != "" {
+		sig, ok := signals[node]
+		signals = map[nodeName]scalingSignal{}
+		if ok {
+			signals[node] = sig
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(newExternalMetrics(signals, metric, now))
+}
+
+// newExternalMetrics converts the signals into an external metric value
+// list, sorted by metric and node. An empty metric includes all metrics.
+func newExternalMetrics(signals map[nodeName]scalingSignal, metric string, now time.Time) externalMetricValueList {
+	list := externalMetricValueList{
+		Kind:       "ExternalMetricValueList",
+		APIVersion: "external.metrics.k8s.io/v1beta1",
+		Items:      []externalMetricValue{},
+	}
+
+	nodes := maps.Keys(signals)
+	slices.Sort(nodes)
+	add := func(name string, value func(scalingSignal) string) {
+		if metric != "" && metric != name {
+			return
+		}
+		for _, node := range nodes {
+			list.Items = append(list.Items, externalMetricValue{
+				MetricName:   name,
+				MetricLabels: map[string]string{"node": node},
+				Timestamp:    now.UTC().Truncate(time.Second),
+				Value:        value(signals[node]),
+			})
+		}
+	}
+	add(scalingMetricActiveConns, func(s scalingSignal) string { return strconv.FormatInt(s.ActiveConns, 10) })
+	add(scalingMetricDialRate, func(s scalingSignal) string { return milliQuantity(s.DialRate) })
+	return list
+}
+
+// milliQuantity formats v as a Kubernetes quantity in thousandths, e.g.
+// 2.5 as "2500m".
+func milliQuantity(v float64) string {
+	return strconv.FormatInt(int64(math.Round(v*1000)), 10) + "m"
+}
@@ -0,0 +1,138 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialCounter(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+	var c dialCounter
+
+	for range 30 {
+		c.add(start)
+	}
+	for range 30 {
+		c.add(start.Add(30 * time.Second))
+	}
+	assert.InDelta(t, 1.0, c.rate(start.Add(30*time.Second)), 1e-9)
+	assert.InDelta(t, 0.5, c.rate(start.Add(60*time.Second)), 1e-9, "first second left the window")
+	assert.Zero(t, c.rate(start.Add(90*time.Second)))
+
+	c.add(start.Add(120 * time.Second))
+	assert.InDelta(t, 1.0/60, c.rate(start.Add(120*time.Second)), 1e-9, "reused bucket starts over")
+}
+
+func TestDialCounter_Nil(t *testing.T) {
+	var c *dialCounter
+	c.add(time.Now())
+}
+
+func TestScalingSignals(t *testing.T) {
+	a := &App{}
+	now := time.Now()
+
+	done := a.TrackConn("web", ConnUsage{Goroutines: 2})
+	a.TrackConn("web", ConnUsage{Goroutines: 2})
+	a.TrackConn("db", ConnUsage{Goroutines: 1})()
+	for range 120 {
+		a.dialCounter("web").add(now)
+	}
+	a.dialCounter("api").add(now)
+
+	assert.Equal(t, map[nodeName]scalingSignal{
+		"web": {ActiveConns: 2, DialRate: 2},
+		"db":  {},
+		"api": {DialRate: 1.0 / 60},
+	}, a.scalingSignals(now))
+
+	done()
+	assert.Equal(t, int64(1), a.scalingSignals(now)["web"].ActiveConns)
+	assert.Same(t, a.dialCounter("web"), a.dialCounter("web"), "counter outlives clients")
+}
+
+func TestScalingCollector(t *testing.T) {
+	c := newScalingCollector(func(time.Time) map[nodeName]scalingSignal {
+		return map[nodeName]scalingSignal{"web": {ActiveConns: 3, DialRate: 0.5}}
+	})
+	reg := prometheus.NewRegistry()
+	require.NoError(t, reg.Register(c))
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+
+	got := make(map[string]float64)
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			require.Len(t, m.GetLabel(), 1)
+			assert.Equal(t, "web", m.GetLabel()[0].GetValue())
+			got[f.GetName()] = m.GetGauge().GetValue()
+		}
+	}
+	assert.Equal(t, map[string]float64{
+		"caddy_netbird_active_connections": 3,
+		"caddy_netbird_dial_rate":          0.5,
+	}, got)
+}
+
+func TestNewExternalMetrics(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 600, time.UTC)
+	signals := map[nodeName]scalingSignal{
+		"web": {ActiveConns: 3, DialRate: 2.5},
+		"api": {DialRate: 1.0 / 60},
+	}
+
+	list := newExternalMetrics(signals, "", now)
+	assert.Equal(t, "ExternalMetricValueList", list.Kind)
+	assert.Equal(t, "external.metrics.k8s.io/v1beta1", list.APIVersion)
+
+	type item struct{ metric, node, value string }
+	var got []item
+	for _, v := range list.Items {
+		assert.Equal(t, now.Truncate(time.Second), v.Timestamp)
+		got = append(got, item{v.MetricName, v.MetricLabels["node"], v.Value})
+	}
+	assert.Equal(t, []item{
+		{"netbird_active_connections", "api", "0"},
+		{"netbird_active_connections", "web", "3"},
+		{"netbird_dial_rate", "api", "17m"},
+		{"netbird_dial_rate", "web", "2500m"},
+	}, got)
+
+	assert.Len(t, newExternalMetrics(signals, scalingMetricDialRate, now).Items, 2)
+}
+
+func TestHandleScaling(t *testing.T) {
+	a := &App{}
+	a.TrackConn("web", ConnUsage{})
+	a.TrackConn("api", ConnUsage{})
+	api := &adminAPI{app: a}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/netbird/scaling?node=web&metric=netbird_active_connections", nil)
+	require.NoError(t, api.handleAPI(rec, req))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var list externalMetricValueList
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	require.Len(t, list.Items, 1)
+	assert.Equal(t, "web", list.Items[0].MetricLabels["node"])
+	assert.Equal(t, "1", list.Items[0].Value)
+
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/netbird/scaling?node=db", nil)
+	require.NoError(t, api.handleAPI(rec, req))
+	assert.JSONEq(t, `{"kind":"ExternalMetricValueList","apiVersion":"external.metrics.k8s.io/v1beta1","metadata":{},"items":[]}`, rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/netbird/scaling?metric=cpu", nil)
+	require.NoError(t, api.handleAPI(rec, req))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -0,0 +1,276 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+const (
+	// maxScanPorts bounds the number of ports of a single scan.
+	maxScanPorts = 1024
+	// scanConcurrency bounds the dials of a single scan in flight at once.
+	scanConcurrency = 32
+	// scanDialTimeout bounds the dial of each scanned port.
+	scanDialTimeout = 2 * time.Second
+)
+
+type scanRequest struct {
+	// Node is the name of the NetBird node to dial from.
+	Node string `json:"node"`
+	// Host is the IP address or peer FQDN to scan. Its IP must be in
+	// scan_allow.
+	Host string `json:"host"`
+	// Ports is a port or an inclusive range of at most 1024 ports, e.g.
+	// "8000-8100".
+	Ports string `json:"ports"`
+}
+
+type scanResponse struct {
+	// Host is the IP address the ports were dialed at.
+	Host string `json:"host"`
+	// Open lists the ports that accepted a TCP connection, in order.
+	Open []uint16 `json:"open"`
+	// Closed is the number of ports that refused the connection or failed
+	// otherwise.
+	Closed int `json:"closed"`
+	// Filtered is the number of ports whose dial timed out.
+	Filtered int `json:"filtered"`
+	// Duration is how long the scan took, in nanoseconds.
+	Duration time.Duration `json:"duration"`
+}
+
+// handleScan dials a range of TCP ports of a host through the NetBird
+// network and reports which are open, to verify what a backend exposes.
+// Only hosts in scan_allow may be scanned.
+func (a *adminAPI) handleScan(w http.ResponseWriter, r *http.Request) error {
+	var req scanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        fmt.Errorf("decode request: %w", err),
+		}
+	}
+
+	if req.Host == "" {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errors.New("host is required"),
+		}
+	}
+	first, last, err := parsePortRange(req.Ports)
+	if err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        err,
+		}
+	}
+	if req.Node == "" {
+		req.Node = a.app.DefaultNodeName()
+	}
+	if len(a.app.scanAllow) == 0 {
+		return caddy.APIError{
+			HTTPStatus: http.StatusForbidden,
+			Err:        errors.New("scanning is disabled: no scan_allow configured"),
+		}
+	}
+
+	mc, ok := a.app.lookupClient(req.Node)
+	if !ok {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("node %q not found", req.Node),
+		}
+	}
+
+	ip, err := scanTarget(mc, req.Host)
+	if err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        err,
+		}
+	}
+	if !prefixesContain(a.app.scanAllow, ip) {
+		return caddy.APIError{
+			HTTPStatus: http.StatusForbidden,
+			Err:        fmt.Errorf("host %s is not in scan_allow", ip),
+		}
+	}
+
+	release, ok := a.app.acquirePing()
+	if !ok {
+		return caddy.APIError{
+			HTTPStatus: http.StatusTooManyRequests,
+			Err:        errors.New("too many concurrent pings and scans"),
+		}
+	}
+	defer release()
+
+	resp := scanPorts(r.Context(), mc.Client().DialContext, ip, first, last, scanConcurrency, scanDialTimeout)
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(resp)
+}
+
+// parsePortRange parses a port or an inclusive port range such as
+// "8000-8100" and checks it spans at most maxScanPorts ports.
+func parsePortRange(s string) (first, last uint16, err error) {
+	if s == "" {
+		return 0, 0, errors.New("ports is required")
+	}
+	from, to, isRange := strings.Cut(s, "-")
+	if !isRange {
+		to = from
+	}
+	first, err = parsePort(from)
+	if err != nil {
+		return 0, 0, err
+	}
+	last, err = parsePort(to)
+	if err != nil {
+		return 0, 0, err
+	}
+	if first > last {
+		return 0, 0, fmt.Errorf("invalid port range %q: start after end", s)
+	}
+	if int(last)-int(first)+1 > maxScanPorts {
+		return 0, 0, fmt.Errorf("port range %q spans more than %d ports", s, maxScanPorts)
+	}
+	return first, last, nil
+}
+
+// parsePort parses a port number between 1 and 65535.
+func parsePort(s string) (uint16, error) {
+	port, err := strconv.ParseUint(strings.TrimSpace(s), 10, 16)
+	if err != nil || port == 0 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return uint16(port), nil
+}
+
+// scanTarget returns the IP address to scan for host: host itself if it is
+// an IP address, or the NetBird IP of the peer with this FQDN. Other names
+// are not resolved, so the allowlist check applies to the dialed address.
+func scanTarget(mc *ManagedClient, host string) (netip.Addr, error) {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return ip.Unmap(), nil
+	}
+
+	ns, err := mc.nodeStatus()
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("get node status: %w", err)
+	}
+	peer := matchPeer(ns.Peers, host)
+	if peer == nil {
+		return netip.Addr{}, fmt.Errorf("host %q is neither an IP address nor a known peer", host)
+	}
+	ip, err := netip.ParseAddr(peer.IP)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("parse IP of peer %q: %w", host, err)
+	}
+	return ip.Unmap(), nil
+}
+
+// parsePrefixes parses IP addresses and CIDRs into prefixes.
+func parsePrefixes(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		if ip, err := netip.ParseAddr(entry); err == nil {
+			ip = ip.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(ip, ip.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP address or CIDR %q", entry)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// prefixesContain reports whether any of prefixes contains ip.
+func prefixesContain(prefixes []netip.Prefix, ip netip.Addr) bool {
+	return slices.ContainsFunc(prefixes, func(p netip.Prefix) bool {
+		return p.Contains(ip)
+	})
+}
+
+// scanPorts dials each port from first to last of ip, with up to
+// concurrency dials in flight, each bounded by timeout.
+func scanPorts(ctx context.Context, dial pingDialFunc, ip netip.Addr, first, last uint16, concurrency int, timeout time.Duration) scanResponse {
+	start := time.Now()
+	errs := make([]error, int(last)-int(first)+1)
+
+	ports := make(chan uint16)
+	var wg sync.WaitGroup
+	for range min(concurrency, len(errs)) {
+		wg.Go(func() {
+			for port := range ports {
+				errs[port-first] = dialPort(ctx, dial, netip.AddrPortFrom(ip, port), timeout)
+			}
+		})
+	}
+	for i := range errs {
+		ports <- first + uint16(i)
+	}
+	close(ports)
+	wg.Wait()
+
+	resp := aggregateScan(first, errs)
+	resp.Host = ip.String()
+	resp.Duration = time.Since(start)
+	return resp
+}
+
+// dialPort establishes a TCP connection to addr within timeout and closes
+// it right away, returning the dial error, if any.
+func dialPort(ctx context.Context, dial pingDialFunc, addr netip.AddrPort, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	conn, err := dial(ctx, "tcp", addr.String())
+	if err != nil {
+		return err
+	}
+	_ = conn.Close()
+	return nil
+}
+
+// aggregateScan summarizes the dial results of consecutive ports starting
+// at first: ports without error are open, timeouts count as filtered and
+// other errors as closed.
+func aggregateScan(first uint16, errs []error) scanResponse {
+	resp := scanResponse{Open: []uint16{}}
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			resp.Open = append(resp.Open, first+uint16(i))
+		case isTimeoutError(err):
+			resp.Filtered++
+		default:
+			resp.Closed++
+		}
+	}
+	return resp
+}
+
+// isTimeoutError reports whether err is a dial timeout.
+func isTimeoutError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
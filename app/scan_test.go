@@ -0,0 +1,215 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePortRange(t *testing.T) {
+	tests := []struct {
+		input       string
+		first, last uint16
+		wantErr     string
+	}{
+		{input: "443", first: 443, last: 443},
+		{input: "8000-8100", first: 8000, last: 8100},
+		{input: "1-1024", first: 1, last: 1024},
+		{input: "65535", first: 65535, last: 65535},
+		{input: "", wantErr: "ports is required"},
+		{input: "0", wantErr: `invalid port "0"`},
+		{input: "65536", wantErr: `invalid port "65536"`},
+		{input: "80-", wantErr: `invalid port ""`},
+		{input: "http", wantErr: `invalid port "http"`},
+		{input: "90-80", wantErr: "start after end"},
+		{input: "1-1025", wantErr: "spans more than 1024 ports"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			first, last, err := parsePortRange(tt.input)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.first, first)
+			assert.Equal(t, tt.last, last)
+		})
+	}
+}
+
+func TestParsePrefixes(t *testing.T) {
+	prefixes, err := parsePrefixes([]string{"100.64.0.10", "10.1.2.3/16", "fd00::/64"})
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("100.64.0.10/32"),
+		netip.MustParsePrefix("10.1.0.0/16"),
+		netip.MustParsePrefix("fd00::/64"),
+	}, prefixes)
+
+	assert.True(t, prefixesContain(prefixes, netip.MustParseAddr("10.1.200.1")))
+	assert.False(t, prefixesContain(prefixes, netip.MustParseAddr("100.64.0.11")))
+
+	_, err = parsePrefixes([]string{"backend.netbird.cloud"})
+	assert.Error(t, err)
+}
+
+func TestScanPorts_DialsEachPortOnce(t *testing.T) {
+	var mu sync.Mutex
+	dialed := make(map[string]int)
+	var inFlight, maxInFlight atomic.Int32
+	dial := func(_ context.Context, network, address string) (net.Conn, error) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			peak := maxInFlight.Load()
+			if n <= peak || maxInFlight.CompareAndSwap(peak, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+
+		mu.Lock()
+		dialed[network+" "+address]++
+		mu.Unlock()
+		return nil, errors.New("connection refused")
+	}
+
+	resp := scanPorts(context.Background(), dial, netip.MustParseAddr("100.64.0.10"), 8000, 8099, 4, time.Second)
+
+	require.Len(t, dialed, 100)
+	for port := 8000; port <= 8099; port++ {
+		assert.Equal(t, 1, dialed["tcp 100.64.0.10:"+strconv.Itoa(port)], port)
+	}
+	assert.LessOrEqual(t, maxInFlight.Load(), int32(4), "concurrency must be bounded")
+	assert.Equal(t, 100, resp.Closed)
+	assert.Equal(t, "100.64.0.10", resp.Host)
+}
+
+func TestScanPorts_LastPort(t *testing.T) {
+	var dials atomic.Int32
+	dial := func(context.Context, string, string) (net.Conn, error) {
+		dials.Add(1)
+		return nil, errors.New("connection refused")
+	}
+
+	resp := scanPorts(context.Background(), dial, netip.MustParseAddr("fd00::1"), 65534, 65535, 8, time.Second)
+	assert.Equal(t, int32(2), dials.Load(), "the range must end at port 65535 without wrapping")
+	assert.Equal(t, 2, resp.Closed)
+	assert.Equal(t, "fd00::1", resp.Host)
+}
+
+func TestScanPorts_Local(t *testing.T) {
+	open := listenTCP(t, func(net.Conn) {})
+	_, portStr, err := net.SplitHostPort(open)
+	require.NoError(t, err)
+	port, err := strconv.Atoi(portStr)
+	require.NoError(t, err)
+
+	var d net.Dialer
+	resp := scanPorts(context.Background(), d.DialContext, netip.MustParseAddr("127.0.0.1"), uint16(port), uint16(port), 1, time.Second)
+	assert.Equal(t, []uint16{uint16(port)}, resp.Open)
+	assert.Zero(t, resp.Closed)
+}
+
+func TestAggregateScan(t *testing.T) {
+	refused := errors.New("connection refused")
+	resp := aggregateScan(20, []error{
+		nil,
+		refused,
+		context.DeadlineExceeded,
+		nil,
+		&net.OpError{Op: "dial", Err: os.ErrDeadlineExceeded},
+		refused,
+	})
+
+	assert.Equal(t, []uint16{20, 23}, resp.Open)
+	assert.Equal(t, 2, resp.Closed)
+	assert.Equal(t, 2, resp.Filtered)
+}
+
+func TestAggregateScan_NoneOpen(t *testing.T) {
+	resp := aggregateScan(1, []error{errors.New("connection refused")})
+	assert.NotNil(t, resp.Open, "open ports should encode as an empty list")
+	assert.Empty(t, resp.Open)
+}
+
+func TestHandleScan_Rejected(t *testing.T) {
+	a := newReloadTestApp(t, fakeSecretProvider{"WEB_KEY": "web-key", "API_KEY": "api-key"})
+	api := &adminAPI{app: a}
+
+	scan := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/netbird/scan", strings.NewReader(body))
+		require.NoError(t, api.handleAPI(rec, req))
+		return rec
+	}
+
+	rec := scan(`{"node":"web","host":"100.64.0.10","ports":"80"}`)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, rec.Body.String(), "scanning is disabled")
+
+	a.scanAllow = []netip.Prefix{netip.MustParsePrefix("100.64.0.0/24")}
+
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{"missing host", `{"node":"web","ports":"80"}`, http.StatusBadRequest, "host is required"},
+		{"range too large", `{"node":"web","host":"100.64.0.10","ports":"1-2000"}`, http.StatusBadRequest, "spans more than"},
+		{"unknown node", `{"node":"db","host":"100.64.0.10","ports":"80"}`, http.StatusNotFound, `node \"db\" not found`},
+		{"host not allowed", `{"node":"web","host":"100.64.1.10","ports":"80"}`, http.StatusForbidden, "not in scan_allow"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := scan(tt.body)
+			assert.Equal(t, tt.wantCode, rec.Code)
+			assert.Contains(t, rec.Body.String(), tt.wantBody)
+		})
+	}
+}
+
+func TestHandleScan_TokenForbidden(t *testing.T) {
+	a := newReloadTestApp(t, fakeSecretProvider{"WEB_KEY": "web-key", "API_KEY": "api-key"})
+	a.statusToken = "secret"
+	a.scanAllow = []netip.Prefix{netip.MustParsePrefix("100.64.0.0/24")}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/netbird/scan", strings.NewReader(`{"node":"web","host":"100.64.0.10","ports":"80"}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	require.NoError(t, (&adminAPI{app: a}).handleAPI(rec, req))
+	assert.Equal(t, http.StatusForbidden, rec.Code, "the status token must not grant scans")
+}
+
+func TestParseGlobalOption_ScanAllow(t *testing.T) {
+	a := parseAndDecode(t, `netbird {
+		scan_allow 100.64.0.0/16
+		scan_allow 10.0.0.5 fd00::/64
+	}`)
+	assert.Equal(t, []string{"100.64.0.0/16", "10.0.0.5", "fd00::/64"}, a.ScanAllow)
+
+	for _, input := range []string{
+		"netbird {\n scan_allow\n}",
+		"netbird {\n scan_allow backend.netbird.cloud\n}",
+	} {
+		_, err := parseGlobalOption(caddyfile.NewTestDispenser(input), nil)
+		assert.Error(t, err, input)
+	}
+}
@@ -0,0 +1,97 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// secretScheme prefixes config values that reference a secret rather than
+// containing it, e.g. "secret://NB_SETUP_KEY".
+const secretScheme = "secret://"
+
+// ErrSecretNotFound is returned when a secret reference cannot be resolved.
+var ErrSecretNotFound = errors.New("secret not found")
+
+// SecretProvider resolves secret references to their values.
+type SecretProvider interface {
+	// Resolve returns the value of the secret named ref.
+	Resolve(ref string) (string, error)
+}
+
+// EnvSecretProvider resolves secret references from environment variables.
+type EnvSecretProvider struct{}
+
+// Resolve returns the value of the environment variable named ref.
+func (EnvSecretProvider) Resolve(ref string) (string, error) {
+	val, ok := os.LookupEnv(ref)
+	if !ok {
+		return "", fmt.Errorf("environment variable %q: %w", ref, ErrSecretNotFound)
+	}
+	return val, nil
+}
+
+// SetSecretProvider replaces the provider used to resolve secret:// references.
+// It must be called before clients are created.
+func (a *App) SetSecretProvider(p SecretProvider) {
+	a.secrets = p
+}
+
+// resolveSecret returns val unchanged unless it is a secret:// reference,
+// in which case the referenced secret is looked up via p.
+func resolveSecret(p SecretProvider, val string) (string, error) {
+	ref, ok := strings.CutPrefix(val, secretScheme)
+	if !ok {
+		return val, nil
+	}
+	if ref == "" {
+		return "", fmt.Errorf("empty secret reference")
+	}
+	return p.Resolve(ref)
+}
+
+// secretProvider returns the configured provider, defaulting to environment
+// variables.
+func (a *App) secretProvider() SecretProvider {
+	if a.secrets == nil {
+		return EnvSecretProvider{}
+	}
+	return a.secrets
+}
+
+// resolveSecrets replaces secret references in the node's credentials and
+// management URLs.
+func (a *App) resolveSecrets(node *Node) error {
+	p := a.secretProvider()
+
+	mgmtURL, err := resolveSecret(p, node.ManagementURL)
+	if err != nil {
+		return fmt.Errorf("resolve management_url: %w", err)
+	}
+	var mgmtURLs []string
+	for _, u := range node.ManagementURLs {
+		resolved, err := resolveSecret(p, u)
+		if err != nil {
+			return fmt.Errorf("resolve management_urls: %w", err)
+		}
+		mgmtURLs = append(mgmtURLs, resolved)
+	}
+	setupKey, err := resolveSecret(p, node.SetupKey)
+	if err != nil {
+		return fmt.Errorf("resolve setup_key: %w", err)
+	}
+	psk, err := resolveSecret(p, node.PreSharedKey)
+	if err != nil {
+		return fmt.Errorf("resolve pre_shared_key: %w", err)
+	}
+	if err := validatePreSharedKey(psk); err != nil {
+		return err
+	}
+
+	node.ManagementURL = mgmtURL
+	node.ManagementURLs = mgmtURLs
+	node.SetupKey = setupKey
+	node.PreSharedKey = psk
+	return nil
+}
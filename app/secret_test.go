@@ -0,0 +1,95 @@
+package app
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSecretProvider map[string]string
+
+func (f fakeSecretProvider) Resolve(ref string) (string, error) {
+	val, ok := f[ref]
+	if !ok {
+		return "", fmt.Errorf("%q: %w", ref, ErrSecretNotFound)
+	}
+	return val, nil
+}
+
+func TestResolveSecret(t *testing.T) {
+	p := fakeSecretProvider{"KEY": "resolved"}
+
+	val, err := resolveSecret(p, "plain-value")
+	require.NoError(t, err)
+	assert.Equal(t, "plain-value", val, "plain values pass through")
+
+	val, err = resolveSecret(p, "secret://KEY")
+	require.NoError(t, err)
+	assert.Equal(t, "resolved", val)
+
+	_, err = resolveSecret(p, "secret://MISSING")
+	require.ErrorIs(t, err, ErrSecretNotFound)
+
+	_, err = resolveSecret(p, "secret://")
+	require.Error(t, err)
+}
+
+func TestResolveSecrets(t *testing.T) {
+	app := &App{}
+	app.SetSecretProvider(fakeSecretProvider{
+		"SETUP": "setup-key-value",
+		"PSK":   testPreSharedKey,
+	})
+
+	node := Node{SetupKey: "secret://SETUP", PreSharedKey: "secret://PSK"}
+	require.NoError(t, app.resolveSecrets(&node))
+	assert.Equal(t, "setup-key-value", node.SetupKey)
+	assert.Equal(t, testPreSharedKey, node.PreSharedKey)
+
+	node = Node{SetupKey: "secret://SETUP", PreSharedKey: "secret://MISSING"}
+	err := app.resolveSecrets(&node)
+	require.ErrorIs(t, err, ErrSecretNotFound)
+	assert.Contains(t, err.Error(), "pre_shared_key")
+}
+
+func TestResolveSecrets_ManagementURLs(t *testing.T) {
+	app := &App{}
+	app.SetSecretProvider(fakeSecretProvider{
+		"MGMT":     "https://mgmt.example.com:443",
+		"FALLBACK": "https://mgmt-2.example.com:443",
+	})
+
+	node := Node{
+		ManagementURL:  "secret://MGMT",
+		ManagementURLs: []string{"secret://FALLBACK", "https://mgmt-3.example.com:443"},
+	}
+	require.NoError(t, app.resolveSecrets(&node))
+	assert.Equal(t, "https://mgmt.example.com:443", node.ManagementURL)
+	assert.Equal(t, []string{"https://mgmt-2.example.com:443", "https://mgmt-3.example.com:443"}, node.ManagementURLs)
+
+	node = Node{ManagementURL: "secret://MISSING"}
+	err := app.resolveSecrets(&node)
+	require.ErrorIs(t, err, ErrSecretNotFound)
+	assert.Contains(t, err.Error(), "management_url")
+}
+
+func TestEnvSecretProvider(t *testing.T) {
+	t.Setenv("CADDY_NETBIRD_TEST_SECRET", "from-env")
+
+	val, err := EnvSecretProvider{}.Resolve("CADDY_NETBIRD_TEST_SECRET")
+	require.NoError(t, err)
+	assert.Equal(t, "from-env", val)
+
+	_, err = EnvSecretProvider{}.Resolve("CADDY_NETBIRD_TEST_SECRET_UNSET")
+	require.ErrorIs(t, err, ErrSecretNotFound)
+}
+
+func TestResolveSecrets_DefaultsToEnv(t *testing.T) {
+	t.Setenv("CADDY_NETBIRD_TEST_SETUP", "env-setup-key")
+
+	node := Node{SetupKey: "secret://CADDY_NETBIRD_TEST_SETUP"}
+	require.NoError(t, (&App{}).resolveSecrets(&node))
+	assert.Equal(t, "env-setup-key", node.SetupKey)
+}
@@ -0,0 +1,134 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// sharedKeyPrefix marks pool keys derived from a config hash rather than a
+// node name.
+const sharedKeyPrefix = "shared:"
+
+// sharedClients tracks which pool key each node name acquired with
+// ShareIdenticalNodes, and how many references the name holds, so names
+// sharing a client can be released and listed individually.
+type sharedClients struct {
+	mu   sync.Mutex
+	keys map[nodeName]string
+	refs map[nodeName]int
+}
+
+// key returns the pool key name holds references to, if any.
+func (s *sharedClients) key(name nodeName) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[name]
+	return key, ok
+}
+
+// acquire records a reference of name to the client under key.
+func (s *sharedClients) acquire(name nodeName, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.keys == nil {
+		s.keys = make(map[nodeName]string)
+		s.refs = make(map[nodeName]int)
+	}
+	s.keys[name] = key
+	s.refs[name]++
+}
+
+// release drops a reference of name and returns the pool key it was held
+// on. The name is forgotten once its last reference is released.
+func (s *sharedClients) release(name nodeName) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[name]
+	if !ok {
+		return "", false
+	}
+	s.refs[name]--
+	if s.refs[name] <= 0 {
+		delete(s.keys, name)
+		delete(s.refs, name)
+	}
+	return key, true
+}
+
+// names returns the node names holding references to the client under
+// key, sorted.
+func (s *sharedClients) names(key string) []nodeName {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []nodeName
+	for name, k := range s.keys {
+		if k == key {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// clientKey returns the pool key of the named node's client: the name
+// itself, or with ShareIdenticalNodes a hash of the resolved config, so
+// nodes with identical configs share a client. A name keeps the key it was
+// first acquired with while it holds references.
+func (a *App) clientKey(name nodeName) (string, error) {
+	if !a.ShareIdenticalNodes {
+		return name, nil
+	}
+	if key, ok := a.shared.key(name); ok {
+		return key, nil
+	}
+	node, err := a.resolveNodeConfig(name)
+	if err != nil {
+		return "", err
+	}
+	return configKey(name, node)
+}
+
+// configKey hashes the resolved config of a node, including the device
+// name it registers with.
+func configKey(name nodeName, node Node) (string, error) {
+	node.Hostname = deviceName(name, node)
+	data, err := json.Marshal(node)
+	if err != nil {
+		return "", fmt.Errorf("hash config of node %q: %w", name, err)
+	}
+	sum := sha256.Sum256(data)
+	return sharedKeyPrefix + hex.EncodeToString(sum[:]), nil
+}
+
+// poolKey returns the pool key the named node's client is held under.
+func (a *App) poolKey(name nodeName) string {
+	if key, ok := a.shared.key(name); ok {
+		return key
+	}
+	return name
+}
+
+// nodeNames returns the names of the nodes using the client under key.
+func (a *App) nodeNames(key string) []nodeName {
+	if names := a.shared.names(key); len(names) > 0 {
+		return names
+	}
+	return []nodeName{key}
+}
+
+// rangeNodes calls fn for each node name with a pooled client, until fn
+// returns false. Names sharing a client are each passed with it.
+func (a *App) rangeNodes(fn func(name nodeName, mc *ManagedClient) bool) {
+	a.pool.Range(func(key, val any) bool {
+		for _, name := range a.nodeNames(key.(string)) {
+			if !fn(name, val.(*ManagedClient)) {
+				return false
+			}
+		}
+		return true
+	})
+}
@@ -0,0 +1,179 @@
+package app
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// newShareTestApp returns an app sharing clients of identical nodes: "a"
+// and "b" have identical configs, "c" registers with another hostname.
+func newShareTestApp(t *testing.T) *App {
+	t.Helper()
+
+	return &App{
+		DefaultManagementURL: "https://api.netbird.io:443",
+		DefaultSetupKey:      "key",
+		ShareIdenticalNodes:  true,
+		Nodes: map[string]*Node{
+			"a": {Hostname: "caddy"},
+			"b": {Hostname: "caddy"},
+			"c": {Hostname: "caddy-other"},
+		},
+		pool:   caddy.NewUsagePool(),
+		logger: zap.NewNop(),
+	}
+}
+
+func TestConfigKey(t *testing.T) {
+	base := Node{ManagementURL: "https://api.netbird.io:443", SetupKey: "key", Hostname: "caddy"}
+
+	key, err := configKey("a", base)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(key, sharedKeyPrefix))
+
+	same, err := configKey("b", base)
+	require.NoError(t, err)
+	assert.Equal(t, key, same, "identical configs of differently named nodes should share a key")
+
+	otherKey := base
+	otherKey.SetupKey = "other"
+	different, err := configKey("a", otherKey)
+	require.NoError(t, err)
+	assert.NotEqual(t, key, different)
+
+	unnamed := base
+	unnamed.Hostname = ""
+	keyA, err := configKey("a", unnamed)
+	require.NoError(t, err)
+	keyB, err := configKey("b", unnamed)
+	require.NoError(t, err)
+	assert.NotEqual(t, keyA, keyB, "nodes without hostname register with different device names")
+}
+
+func TestClientKey_Disabled(t *testing.T) {
+	a := newShareTestApp(t)
+	a.ShareIdenticalNodes = false
+
+	key, err := a.clientKey("a")
+	require.NoError(t, err)
+	assert.Equal(t, "a", key)
+}
+
+func TestGetClient_SharesIdenticalNodes(t *testing.T) {
+	a := newShareTestApp(t)
+
+	mcA, err := a.getClient("a")
+	require.NoError(t, err)
+	mcB, err := a.getClient("b")
+	require.NoError(t, err)
+	mcC, err := a.getClient("c")
+	require.NoError(t, err)
+	defer func() { _ = a.ReleaseClient("c") }()
+
+	assert.Same(t, mcA, mcB, "identical nodes should share a client")
+	assert.NotSame(t, mcA, mcC)
+
+	looked, ok := a.lookupClient("b")
+	require.True(t, ok)
+	assert.Same(t, mcA, looked)
+
+	nodes := make(map[nodeName]*ManagedClient)
+	a.rangeNodes(func(name nodeName, mc *ManagedClient) bool {
+		nodes[name] = mc
+		return true
+	})
+	assert.Equal(t, map[nodeName]*ManagedClient{"a": mcA, "b": mcA, "c": mcC}, nodes)
+
+	require.NoError(t, a.ReleaseClient("a"))
+	_, ok = a.lookupClient("a")
+	assert.False(t, ok, "released names should not resolve to the shared client")
+	looked, ok = a.lookupClient("b")
+	require.True(t, ok, "the shared client should be kept while b holds a reference")
+	assert.Same(t, mcA, looked)
+
+	require.NoError(t, a.ReleaseClient("b"))
+	_, ok = a.lookupClient("b")
+	assert.False(t, ok)
+	key, err := configKey("a", a.resolveNode("a"))
+	require.NoError(t, err)
+	refs, _ := a.pool.References(key)
+	assert.Zero(t, refs, "the shared client should be released with its last reference")
+}
+
+func TestGetClient_SharedRefCounting(t *testing.T) {
+	a := newShareTestApp(t)
+
+	for range 2 {
+		_, err := a.getClient("a")
+		require.NoError(t, err)
+	}
+	_, err := a.getClient("b")
+	require.NoError(t, err)
+
+	key := a.poolKey("a")
+	assert.Equal(t, key, a.poolKey("b"))
+	refs, _ := a.pool.References(key)
+	assert.Equal(t, 3, refs)
+
+	require.NoError(t, a.ReleaseClient("a"))
+	assert.Equal(t, key, a.poolKey("a"), "a still holds a reference")
+	assert.Equal(t, []nodeName{"a", "b"}, a.nodeNames(key))
+
+	require.NoError(t, a.ReleaseClient("a"))
+	assert.Equal(t, "a", a.poolKey("a"))
+	assert.Equal(t, []nodeName{"b"}, a.nodeNames(key))
+
+	require.NoError(t, a.ReleaseClient("b"))
+	refs, _ = a.pool.References(key)
+	assert.Zero(t, refs)
+}
+
+func TestGetClient_KeepsKeyWhileReferenced(t *testing.T) {
+	a := newShareTestApp(t)
+	mc, err := a.getClient("a")
+	require.NoError(t, err)
+	defer func() { _ = a.ReleaseClient("a") }()
+
+	a.Nodes["a"].SetupKey = "changed"
+	again, err := a.getClient("a")
+	require.NoError(t, err)
+	defer func() { _ = a.ReleaseClient("a") }()
+	assert.Same(t, mc, again, "a name should keep its client while it holds references")
+}
+
+func TestReload_SharedConfigChanged(t *testing.T) {
+	a := newShareTestApp(t)
+	for _, name := range []string{"a", "b"} {
+		_, err := a.getClient(name)
+		require.NoError(t, err)
+		defer func() { _ = a.ReleaseClient(name) }()
+	}
+
+	result := a.reload(context.Background())
+	assert.Empty(t, result.Recreated)
+	assert.Empty(t, result.Errors)
+
+	a.Nodes["a"].SetupKey = "changed"
+	result = a.reload(context.Background())
+	assert.Empty(t, result.Recreated)
+	require.Contains(t, result.Errors, "a")
+	assert.Contains(t, result.Errors["a"], "share_identical_nodes")
+	assert.NotContains(t, result.Errors, "b")
+}
+
+func TestParseGlobalOption_ShareIdenticalNodes(t *testing.T) {
+	app := parseAndDecode(t, `netbird {
+		share_identical_nodes
+	}`)
+	assert.True(t, app.ShareIdenticalNodes)
+
+	_, err := parseGlobalOption(caddyfile.NewTestDispenser("netbird {\n share_identical_nodes yes\n}"), nil)
+	assert.Error(t, err)
+}
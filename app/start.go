@@ -0,0 +1,54 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+type startRequest struct {
+	Node string `json:"node"`
+}
+
+type startResponse struct {
+	Node    nodeName `json:"node"`
+	Started bool     `json:"started"`
+}
+
+// handleStart starts a node's client, e.g. when lazy start is disabled and
+// the start by App.Start failed. Starting a running client is a no-op.
+// Only nodes used by a transport or L4 handler can be started.
+func (a *adminAPI) handleStart(w http.ResponseWriter, r *http.Request) error {
+	var req startRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        fmt.Errorf("decode request: %w", err),
+		}
+	}
+	if req.Node == "" {
+		req.Node = a.app.DefaultNodeName()
+	}
+
+	mc, ok := a.app.lookupClient(req.Node)
+	if !ok {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("node %q not found", req.Node),
+		}
+	}
+
+	if err := mc.Start(r.Context()); err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadGateway,
+			Err:        fmt.Errorf("start node %q: %w", req.Node, err),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(startResponse{Node: req.Node, Started: true})
+}
@@ -0,0 +1,153 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/netbirdio/netbird/client/embed"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// errStartFailed fails client starts before they reach the network.
+var errStartFailed = errors.New("management down")
+
+// newEagerTestApp returns an app with lazy start disabled.
+func newEagerTestApp(t *testing.T) *App {
+	t.Helper()
+
+	lazy := false
+	a := &App{
+		DefaultManagementURL: "https://api.netbird.io:443",
+		DefaultSetupKey:      "key",
+		LazyStart:            &lazy,
+		Nodes:                map[string]*Node{"web": {}},
+		pool:                 caddy.NewUsagePool(),
+		logger:               zap.NewNop(),
+	}
+	t.Cleanup(func() { _ = a.ReleaseClient("web") })
+	return a
+}
+
+// acquireFailing acquires the client of the "web" node and makes its
+// starts fail.
+func acquireFailing(t *testing.T, a *App) *ManagedClient {
+	t.Helper()
+
+	mc, err := a.AcquireClient(context.Background(), "", "web")
+	require.NoError(t, err)
+	mc.newClient = func(string) (*embed.Client, error) { return nil, errStartFailed }
+	mc.clientURL = ""
+	return mc
+}
+
+func TestStartsLazily(t *testing.T) {
+	assert.True(t, (&App{}).StartsLazily(), "lazy by default")
+
+	lazy, eager := true, false
+	assert.True(t, (&App{LazyStart: &lazy}).StartsLazily())
+	assert.False(t, (&App{LazyStart: &eager}).StartsLazily())
+}
+
+func TestAcquireClient_NotLazy(t *testing.T) {
+	a := newEagerTestApp(t)
+
+	mc, err := a.AcquireClient(context.Background(), "", "web")
+	require.NoError(t, err)
+	assert.False(t, mc.isStarted(), "client should not be started while provisioning")
+
+	pooled, ok := a.lookupClient("web")
+	require.True(t, ok)
+	assert.Same(t, mc, pooled)
+}
+
+func TestAcquireClient_UnknownNode(t *testing.T) {
+	a := newEagerTestApp(t)
+	lazy := true
+	a.LazyStart = &lazy
+
+	_, err := a.AcquireClient(context.Background(), "", "missing")
+	require.ErrorIs(t, err, ErrUnknownNode)
+	_, err = a.AcquireListenerClient(context.Background(), "missing")
+	require.ErrorIs(t, err, ErrUnknownNode)
+
+	var pooled int
+	a.pool.Range(func(_, _ any) bool {
+		pooled++
+		return true
+	})
+	assert.Zero(t, pooled, "no client should be created or started for an unknown node")
+}
+
+func TestStartPooledClients(t *testing.T) {
+	a := newEagerTestApp(t)
+	mc := acquireFailing(t, a)
+
+	a.startPooledClients(context.Background())
+	assert.False(t, mc.isStarted())
+	require.NotNil(t, mc.lastError(), "failed start should be kept as last error")
+	assert.Contains(t, mc.lastError().Message, errStartFailed.Error())
+}
+
+func TestHandleStart(t *testing.T) {
+	a := newEagerTestApp(t)
+	acquireFailing(t, a)
+	api := &adminAPI{app: a}
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"invalid body", `{`, http.StatusBadRequest},
+		{"unknown node", `{"node":"db"}`, http.StatusNotFound},
+		{"default node missing", ``, http.StatusNotFound},
+		{"start fails", `{"node":"web"}`, http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/netbird/start", strings.NewReader(tt.body))
+			require.NoError(t, api.handleAPI(rec, req))
+			assert.Equal(t, tt.status, rec.Code)
+		})
+	}
+}
+
+func TestHandleStart_AlreadyStarted(t *testing.T) {
+	a := newEagerTestApp(t)
+	mc, err := a.AcquireClient(context.Background(), "", "web")
+	require.NoError(t, err)
+	mc.started = true
+	t.Cleanup(func() { mc.started = false })
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/netbird/start", strings.NewReader(`{"node":"web"}`))
+	require.NoError(t, (&adminAPI{app: a}).handleAPI(rec, req))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp startResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, startResponse{Node: "web", Started: true}, resp)
+}
+
+func TestParseGlobalOption_LazyStart(t *testing.T) {
+	app := parseAndDecode(t, `netbird {
+		lazy_start false
+	}`)
+	require.NotNil(t, app.LazyStart)
+	assert.False(t, app.StartsLazily())
+
+	for _, input := range []string{"netbird {\n lazy_start\n}", "netbird {\n lazy_start later\n}"} {
+		_, err := parseGlobalOption(caddyfile.NewTestDispenser(input), nil)
+		require.Error(t, err, input)
+	}
+}
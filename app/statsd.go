@@ -0,0 +1,153 @@
+package app
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// statsdFlushInterval is how often buffered metrics are sent.
+	statsdFlushInterval = time.Second
+	// statsdMaxPacket keeps packets below the typical Ethernet MTU, so they
+	// aren't fragmented on the way to the statsd server.
+	statsdMaxPacket = 1432
+	// statsdPrefix matches the namespace of the Prometheus metrics.
+	statsdPrefix = "caddy.netbird."
+)
+
+// statsdExporter sends dial metrics to a statsd server over UDP. Metrics are
+// buffered and sent in packets of newline-separated lines, when a packet is
+// full and once per flush interval.
+type statsdExporter struct {
+	conn   net.Conn
+	logger *zap.Logger
+
+	mu  sync.Mutex
+	buf []byte
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newStatsdExporter creates an exporter sending to the statsd server at addr.
+func newStatsdExporter(addr string, logger *zap.Logger) (*statsdExporter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("dial statsd %s: %w", addr, err)
+	}
+	return &statsdExporter{
+		conn:   conn,
+		logger: logger,
+		buf:    make([]byte, 0, statsdMaxPacket),
+	}, nil
+}
+
+// start sends buffered metrics every interval until stop is called.
+func (e *statsdExporter) start(interval time.Duration) {
+	e.stop = make(chan struct{})
+	e.done = make(chan struct{})
+
+	go func() {
+		defer close(e.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-e.stop:
+				return
+			case <-ticker.C:
+				e.flush()
+			}
+		}
+	}()
+}
+
+// close stops the flush loop, sends the remaining metrics and closes the
+// connection.
+func (e *statsdExporter) close() error {
+	if e.stop != nil {
+		close(e.stop)
+		<-e.done
+		e.stop = nil
+	}
+	e.flush()
+	return e.conn.Close()
+}
+
+// observeDial records the latency and result of a dial. A nil receiver is a
+// no-op.
+func (e *statsdExporter) observeDial(node, network string, d time.Duration, err error) {
+	if e == nil {
+		return
+	}
+
+	name := statsdPrefix + statsdName(node) + ".dial." + statsdName(network)
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	e.add(statsdTiming(name+".duration", d))
+	e.add(statsdCount(name+"."+result, 1))
+}
+
+// add buffers a metric line, sending the buffer first if the line doesn't
+// fit into the current packet.
+func (e *statsdExporter) add(line string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if len(e.buf) > 0 && len(e.buf)+1+len(line) > statsdMaxPacket {
+		e.sendLocked()
+	}
+	if len(e.buf) > 0 {
+		e.buf = append(e.buf, '\n')
+	}
+	e.buf = append(e.buf, line...)
+}
+
+// flush sends the buffered metrics, if any.
+func (e *statsdExporter) flush() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.sendLocked()
+}
+
+func (e *statsdExporter) sendLocked() {
+	if len(e.buf) == 0 {
+		return
+	}
+	if _, err := e.conn.Write(e.buf); err != nil {
+		e.logger.Debug("send statsd metrics", zap.Error(err))
+	}
+	e.buf = e.buf[:0]
+}
+
+// statsdTiming formats a timer in milliseconds.
+func statsdTiming(name string, d time.Duration) string {
+	ms := strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64)
+	return name + ":" + ms + "|ms"
+}
+
+// statsdCount formats a counter increment.
+func statsdCount(name string, n int) string {
+	return name + ":" + strconv.Itoa(n) + "|c"
+}
+
+// statsdName makes s safe to use as a segment of a metric name, replacing
+// dots and the characters reserved by the line format with underscores.
+func statsdName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+}
@@ -0,0 +1,108 @@
+package app
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// listenStatsd returns a UDP listener and an exporter sending to it.
+func listenStatsd(t *testing.T) (net.PacketConn, *statsdExporter) {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = pc.Close() })
+
+	e, err := newStatsdExporter(pc.LocalAddr().String(), zap.NewNop())
+	require.NoError(t, err)
+	return pc, e
+}
+
+// readPacket reads one packet from pc.
+func readPacket(t *testing.T, pc net.PacketConn) string {
+	t.Helper()
+
+	require.NoError(t, pc.SetReadDeadline(time.Now().Add(2*time.Second)))
+	buf := make([]byte, 64*1024)
+	n, _, err := pc.ReadFrom(buf)
+	require.NoError(t, err)
+	return string(buf[:n])
+}
+
+func TestStatsdFormat(t *testing.T) {
+	assert.Equal(t, "a.b:1.5|ms", statsdTiming("a.b", 1500*time.Microsecond))
+	assert.Equal(t, "a.b:3|ms", statsdTiming("a.b", 3*time.Millisecond))
+	assert.Equal(t, "a.b:2|c", statsdCount("a.b", 2))
+}
+
+func TestStatsdName(t *testing.T) {
+	assert.Equal(t, "ingress", statsdName("ingress"))
+	assert.Equal(t, "my-node_1", statsdName("my-node_1"))
+	assert.Equal(t, "a_b_c_d_e", statsdName("a.b:c|d@e"))
+}
+
+func TestStatsdObserveDial(t *testing.T) {
+	pc, e := listenStatsd(t)
+
+	e.observeDial("ingress", "tcp", 2*time.Millisecond, nil)
+	e.observeDial("ingress", "udp", time.Millisecond, errors.New("boom"))
+	require.NoError(t, e.close())
+
+	lines := strings.Split(readPacket(t, pc), "\n")
+	assert.Equal(t, []string{
+		"caddy.netbird.ingress.dial.tcp.duration:2|ms",
+		"caddy.netbird.ingress.dial.tcp.ok:1|c",
+		"caddy.netbird.ingress.dial.udp.duration:1|ms",
+		"caddy.netbird.ingress.dial.udp.error:1|c",
+	}, lines)
+}
+
+func TestStatsdObserveDial_NilExporter(t *testing.T) {
+	var e *statsdExporter
+	e.observeDial("ingress", "tcp", time.Millisecond, nil)
+}
+
+func TestStatsdBatching(t *testing.T) {
+	pc, e := listenStatsd(t)
+
+	line := strings.Repeat("x", 100) + ":1|c"
+	const count = 30
+	for range count {
+		e.add(line)
+	}
+	require.NoError(t, e.close())
+
+	var got int
+	for got < count {
+		packet := readPacket(t, pc)
+		assert.LessOrEqual(t, len(packet), statsdMaxPacket)
+		for _, l := range strings.Split(packet, "\n") {
+			assert.Equal(t, line, l)
+			got++
+		}
+	}
+	assert.Equal(t, count, got)
+}
+
+func TestStatsdFlushInterval(t *testing.T) {
+	pc, e := listenStatsd(t)
+	e.start(10 * time.Millisecond)
+	t.Cleanup(func() { _ = e.close() })
+
+	e.add("a:1|c")
+	assert.Equal(t, "a:1|c", readPacket(t, pc))
+}
+
+func TestParseGlobalOption_Statsd(t *testing.T) {
+	app := parseAndDecode(t, `netbird {
+		statsd 127.0.0.1:8125
+	}`)
+	assert.Equal(t, "127.0.0.1:8125", app.Statsd)
+}
@@ -0,0 +1,59 @@
+package app
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultStatusCacheTTL is how long a client status is shared among callers
+// by default.
+const defaultStatusCacheTTL = time.Second
+
+// statusCache shares the most recent client status among callers, so admin
+// endpoints polled by dashboards and scrapers at the same time don't each
+// query the client.
+type statusCache struct {
+	mu sync.Mutex
+	// status is a peer.FullStatus. The type lives in an internal package of
+	// the NetBird module and can't be named here.
+	status    any
+	fetchedAt time.Time
+}
+
+// cachedStatus returns the status stored in c if it was fetched less than
+// maxAge before now. Otherwise it calls fetch and stores the result.
+// Concurrent callers with a stale cache wait for a single fetch. A maxAge of
+// zero or less always fetches. Errors are not cached.
+func cachedStatus[T any](c *statusCache, maxAge time.Duration, now time.Time, fetch func() (T, error)) (T, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if status, ok := c.status.(T); ok && maxAge > 0 && now.Sub(c.fetchedAt) < maxAge {
+		return status, nil
+	}
+
+	status, err := fetch()
+	if err != nil {
+		return status, err
+	}
+	c.status = status
+	c.fetchedAt = now
+	return status, nil
+}
+
+// store replaces the cached status with one fetched at the given time.
+func (c *statusCache) store(status any, fetchedAt time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.status = status
+	c.fetchedAt = fetchedAt
+}
+
+// statusCacheTTL returns the configured status cache TTL, or the default if
+// unset. Negative values, which disable the cache, are returned as-is.
+func (a *App) statusCacheTTL() time.Duration {
+	if a.StatusCacheTTL == 0 {
+		return defaultStatusCacheTTL
+	}
+	return time.Duration(a.StatusCacheTTL)
+}
@@ -0,0 +1,100 @@
+package app
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCachedStatus(t *testing.T) {
+	var c statusCache
+	var calls int
+	fetch := func() (int, error) {
+		calls++
+		return calls, nil
+	}
+	start := time.Now()
+
+	got, err := cachedStatus(&c, time.Second, start, fetch)
+	require.NoError(t, err)
+	assert.Equal(t, 1, got, "empty cache fetches")
+
+	got, err = cachedStatus(&c, time.Second, start.Add(500*time.Millisecond), fetch)
+	require.NoError(t, err)
+	assert.Equal(t, 1, got, "fresh status is shared")
+
+	got, err = cachedStatus(&c, time.Second, start.Add(time.Second), fetch)
+	require.NoError(t, err)
+	assert.Equal(t, 2, got, "expired status is refetched")
+
+	got, err = cachedStatus(&c, 0, start.Add(time.Second), fetch)
+	require.NoError(t, err)
+	assert.Equal(t, 3, got, "zero max age always fetches")
+}
+
+func TestCachedStatus_ErrorNotCached(t *testing.T) {
+	var c statusCache
+	now := time.Now()
+
+	_, err := cachedStatus(&c, time.Minute, now, func() (int, error) { return 0, errors.New("engine down") })
+	require.Error(t, err)
+
+	got, err := cachedStatus(&c, time.Minute, now, func() (int, error) { return 7, nil })
+	require.NoError(t, err)
+	assert.Equal(t, 7, got)
+}
+
+func TestCachedStatus_Store(t *testing.T) {
+	var c statusCache
+	now := time.Now()
+	c.store(42, now)
+
+	got, err := cachedStatus(&c, time.Second, now, func() (int, error) {
+		t.Error("stored status should be used")
+		return 0, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 42, got)
+}
+
+func TestCachedStatus_ConcurrentCallersShareFetch(t *testing.T) {
+	var c statusCache
+	var calls atomic.Int32
+	now := time.Now()
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := cachedStatus(&c, time.Minute, now, func() (int, error) {
+				calls.Add(1)
+				time.Sleep(10 * time.Millisecond)
+				return 1, nil
+			})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestApp_StatusCacheTTL(t *testing.T) {
+	assert.Equal(t, defaultStatusCacheTTL, (&App{}).statusCacheTTL())
+	assert.Equal(t, 5*time.Second, (&App{StatusCacheTTL: caddy.Duration(5 * time.Second)}).statusCacheTTL())
+	assert.Negative(t, (&App{StatusCacheTTL: caddy.Duration(-1)}).statusCacheTTL())
+}
+
+func TestParseGlobalOption_StatusCacheTTL(t *testing.T) {
+	app := parseAndDecode(t, `netbird {
+		status_cache_ttl 3s
+	}`)
+	assert.Equal(t, 3*time.Second, time.Duration(app.StatusCacheTTL))
+}
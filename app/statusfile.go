@@ -0,0 +1,94 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const defaultStatusFileInterval = 10 * time.Second
+
+// statusFile is the content of the status file: the JSON status of the
+// admin API with the time it was collected, so readers can tell a stale
+// file from a current one.
+type statusFile struct {
+	UpdatedAt time.Time `json:"updatedAt"`
+	statusResponse
+}
+
+// statusFileInterval returns the configured status file interval, or the
+// default if unset.
+func (a *App) statusFileInterval() time.Duration {
+	if a.StatusFileInterval > 0 {
+		return time.Duration(a.StatusFileInterval)
+	}
+	return defaultStatusFileInterval
+}
+
+// writeStatusFile collects the status of all nodes and replaces the status
+// file with it.
+func (a *App) writeStatusFile(ctx context.Context) {
+	status := statusFile{UpdatedAt: time.Now(), statusResponse: a.collectStatus(ctx)}
+	data, err := json.Marshal(status)
+	if err != nil {
+		a.logger.Warn("encode status file", zap.Error(err))
+		return
+	}
+	if err := writeFileAtomic(a.StatusFile, append(data, '\n')); err != nil {
+		a.logger.Warn("write status file", zap.String("path", a.StatusFile), zap.Error(err))
+	}
+}
+
+// writeFileAtomic writes data to a temporary file in the directory of path
+// and renames it to path, so readers see either the old or the new content,
+// never a partial write.
+func writeFileAtomic(path string, data []byte) (err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		return fmt.Errorf("write temporary file: %w", err)
+	}
+	// CreateTemp creates the file readable by the owner only.
+	if err := tmp.Chmod(0o644); err != nil {
+		return fmt.Errorf("chmod temporary file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("sync temporary file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close temporary file: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// runStatusFile calls write right away and then every interval until ctx
+// is done, so the file exists as soon as the app is started.
+func runStatusFile(ctx context.Context, interval time.Duration, write func(context.Context)) {
+	write(ctx)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			write(ctx)
+		}
+	}
+}
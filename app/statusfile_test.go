@@ -0,0 +1,138 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "status.json")
+
+	require.NoError(t, writeFileAtomic(path, []byte("first")))
+	require.NoError(t, writeFileAtomic(path, []byte("second")))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(data))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o644), info.Mode().Perm())
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "no temporary files should be left behind")
+	assert.Equal(t, "status.json", entries[0].Name())
+}
+
+func TestWriteFileAtomic_KeepsOldContentOnError(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "status.json")
+	require.NoError(t, writeFileAtomic(path, []byte("old")))
+
+	// A directory in place of the target makes the rename fail.
+	blocked := filepath.Join(dir, "blocked")
+	require.NoError(t, os.Mkdir(blocked, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(blocked, "entry"), nil, 0o644))
+	require.Error(t, writeFileAtomic(blocked, []byte("new")))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "old", string(data))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "the temporary file should be removed")
+}
+
+func TestWriteFileAtomic_MissingDir(t *testing.T) {
+	err := writeFileAtomic(filepath.Join(t.TempDir(), "missing", "status.json"), []byte("x"))
+	assert.Error(t, err)
+}
+
+func TestWriteStatusFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "status.json")
+	a := &App{StatusFile: path, pool: caddy.NewUsagePool(), logger: zap.NewNop()}
+
+	a.writeStatusFile(context.Background())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var got struct {
+		UpdatedAt time.Time                `json:"updatedAt"`
+		Nodes     map[nodeName]*nodeStatus `json:"nodes"`
+	}
+	require.NoError(t, json.Unmarshal(data, &got))
+	assert.WithinDuration(t, time.Now(), got.UpdatedAt, time.Minute)
+	assert.NotNil(t, got.Nodes)
+}
+
+func TestRunStatusFile(t *testing.T) {
+	var writes atomic.Int32
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runStatusFile(ctx, 20*time.Millisecond, func(context.Context) {
+			writes.Add(1)
+		})
+	}()
+
+	assert.Eventually(t, func() bool { return writes.Load() >= 3 }, 2*time.Second, 5*time.Millisecond,
+		"status should be written right away and then every interval")
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("writer did not stop")
+	}
+	stopped := writes.Load()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, stopped, writes.Load(), "no writes after stop")
+}
+
+func TestRunStatusFile_WritesImmediately(t *testing.T) {
+	var writes atomic.Int32
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	runStatusFile(ctx, time.Hour, func(context.Context) { writes.Add(1) })
+	assert.Equal(t, int32(1), writes.Load())
+}
+
+func TestStatusFileInterval(t *testing.T) {
+	assert.Equal(t, defaultStatusFileInterval, (&App{}).statusFileInterval())
+	assert.Equal(t, time.Minute, (&App{StatusFileInterval: caddy.Duration(time.Minute)}).statusFileInterval())
+}
+
+func TestParseGlobalOption_StatusFile(t *testing.T) {
+	app := parseAndDecode(t, `netbird {
+		status_file /var/run/netbird-status.json
+		status_file_interval 30s
+	}`)
+	assert.Equal(t, "/var/run/netbird-status.json", app.StatusFile)
+	assert.Equal(t, 30*time.Second, time.Duration(app.StatusFileInterval))
+
+	for _, input := range []string{
+		"netbird {\n status_file\n}",
+		"netbird {\n status_file a b\n}",
+		"netbird {\n status_file_interval 0s\n}",
+		"netbird {\n status_file_interval soon\n}",
+	} {
+		_, err := parseGlobalOption(caddyfile.NewTestDispenser(input), nil)
+		assert.Error(t, err, input)
+	}
+}
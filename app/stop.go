@@ -0,0 +1,48 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// stopNodes calls stop for each node in parallel and waits for all of them
+// to return, so a new config can't start a node while the old stop still
+// holds its WireGuard port. stop must bound its own duration. A failing
+// node is reported in the joined error without keeping the others from
+// stopping.
+func stopNodes(names []nodeName, stop func(nodeName) error) error {
+	var mu sync.Mutex
+	errs := make(map[nodeName]error, len(names))
+
+	var wg sync.WaitGroup
+	for _, name := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := stop(name); err != nil {
+				mu.Lock()
+				errs[name] = err
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return joinNodeErrors(errs)
+}
+
+// joinNodeErrors joins the errors of the nodes, ordered by node name.
+func joinNodeErrors(errs map[nodeName]error) error {
+	names := make([]nodeName, 0, len(errs))
+	for name := range errs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	joined := make([]error, 0, len(names))
+	for _, name := range names {
+		joined = append(joined, fmt.Errorf("netbird node %q: %w", name, errs[name]))
+	}
+	return errors.Join(joined...)
+}
@@ -0,0 +1,85 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/netbirdio/netbird/client/embed"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestStopNodes(t *testing.T) {
+	var stopped atomic.Int32
+	err := stopNodes([]nodeName{"a", "b", "c"}, func(nodeName) error {
+		stopped.Add(1)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), stopped.Load())
+}
+
+func TestStopNodes_SlowNode(t *testing.T) {
+	const delay = 100 * time.Millisecond
+
+	var stopped atomic.Int32
+	var slowDone atomic.Bool
+	errBusy := errors.New("interface busy")
+	err := stopNodes([]nodeName{"fast", "slow", "failing"}, func(name nodeName) error {
+		switch name {
+		case "slow":
+			time.Sleep(delay)
+			slowDone.Store(true)
+		case "failing":
+			return errBusy
+		}
+		stopped.Add(1)
+		return nil
+	})
+
+	assert.True(t, slowDone.Load(), "stopNodes must wait for the slow node, which may still hold its port")
+	assert.Equal(t, int32(2), stopped.Load(), "fast node should stop despite the others")
+	require.ErrorIs(t, err, errBusy)
+	assert.Contains(t, err.Error(), `netbird node "failing"`)
+	assert.NotContains(t, err.Error(), `netbird node "slow"`)
+	assert.NotContains(t, err.Error(), `netbird node "fast"`)
+}
+
+func TestManagedClientStop_Timeout(t *testing.T) {
+	mc := &ManagedClient{
+		name:    "web",
+		started: true,
+		stopClient: func(ctx context.Context, _ *embed.Client) error {
+			_, ok := ctx.Deadline()
+			assert.True(t, ok, "stopping should be bounded")
+			return context.DeadlineExceeded
+		},
+		logger: zap.NewNop(),
+	}
+
+	err := mc.stop()
+	require.ErrorIs(t, err, ErrStopTimeout)
+	assert.False(t, mc.isStarted())
+}
+
+func TestStopNodes_Concurrent(t *testing.T) {
+	const delay = 100 * time.Millisecond
+	start := time.Now()
+	err := stopNodes([]nodeName{"a", "b", "c", "d"}, func(nodeName) error {
+		time.Sleep(delay)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Less(t, time.Since(start), 3*delay, "nodes should stop concurrently")
+}
+
+func TestStopNodes_Empty(t *testing.T) {
+	require.NoError(t, stopNodes(nil, func(nodeName) error {
+		t.Fatal("stop should not be called")
+		return nil
+	}))
+}
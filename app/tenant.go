@@ -0,0 +1,49 @@
+package app
+
+import (
+	"fmt"
+	"slices"
+
+	"golang.org/x/exp/maps"
+)
+
+// checkNodeAccess reports whether a transport or L4 handler of tenant may
+// use the named node. With tenants configured, the nodes listed for a tenant
+// are reserved for it: a tenant may only use its own nodes, and references
+// without a tenant may only use nodes no tenant owns.
+func (a *App) checkNodeAccess(tenant, nodeName string) error {
+	if tenant == "" {
+		for owner, nodes := range a.Tenants {
+			if slices.Contains(nodes, nodeName) {
+				return fmt.Errorf("%w: node %q is owned by tenant %q", ErrNodeAccessDenied, nodeName, owner)
+			}
+		}
+		return nil
+	}
+
+	nodes, ok := a.Tenants[tenant]
+	if !ok {
+		return fmt.Errorf("%w: tenant %q is not defined in the netbird app config", ErrNodeAccessDenied, tenant)
+	}
+	if !slices.Contains(nodes, nodeName) {
+		return fmt.Errorf("%w: tenant %q may not use node %q", ErrNodeAccessDenied, tenant, nodeName)
+	}
+	return nil
+}
+
+// validateTenants rejects nodes reserved for more than one tenant.
+func validateTenants(tenants map[string][]string) error {
+	names := maps.Keys(tenants)
+	slices.Sort(names)
+
+	owners := make(map[nodeName]string)
+	for _, tenant := range names {
+		for _, node := range tenants[tenant] {
+			if owner, ok := owners[node]; ok && owner != tenant {
+				return fmt.Errorf("node %q is listed for tenants %q and %q", node, owner, tenant)
+			}
+			owners[node] = tenant
+		}
+	}
+	return nil
+}
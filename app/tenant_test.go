@@ -0,0 +1,151 @@
+package app
+
+import (
+	"context"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckNodeAccess(t *testing.T) {
+	a := &App{Tenants: map[string][]string{
+		"acme":   {"acme-web", "acme-api"},
+		"globex": {"globex-web"},
+	}}
+
+	tests := []struct {
+		name    string
+		tenant  string
+		node    string
+		wantErr string
+	}{
+		{name: "own node", tenant: "acme", node: "acme-api"},
+		{name: "other tenant's node", tenant: "acme", node: "globex-web", wantErr: `tenant "acme" may not use node "globex-web"`},
+		{name: "unowned node with tenant", tenant: "acme", node: "shared", wantErr: `tenant "acme" may not use node "shared"`},
+		{name: "unknown tenant", tenant: "initech", node: "shared", wantErr: `tenant "initech" is not defined`},
+		{name: "unowned node without tenant", node: "shared"},
+		{name: "owned node without tenant", node: "globex-web", wantErr: `node "globex-web" is owned by tenant "globex"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := a.checkNodeAccess(tt.tenant, tt.node)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, ErrNodeAccessDenied)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestCheckNodeAccess_NoTenants(t *testing.T) {
+	a := &App{}
+	assert.NoError(t, a.checkNodeAccess("", "web"), "without tenants all nodes are allowed")
+	assert.ErrorIs(t, a.checkNodeAccess("acme", "web"), ErrNodeAccessDenied, "tenants must be defined")
+}
+
+func TestAcquireClient_NodeAccess(t *testing.T) {
+	a := newEagerTestApp(t)
+	a.Tenants = map[string][]string{"acme": {"web"}}
+
+	_, err := a.AcquireClient(context.Background(), "", "web")
+	require.ErrorIs(t, err, ErrNodeAccessDenied)
+	_, err = a.AcquireClient(context.Background(), "globex", "web")
+	require.ErrorIs(t, err, ErrNodeAccessDenied)
+	_, ok := a.lookupClient("web")
+	assert.False(t, ok, "denied references should not create a client")
+
+	mc, err := a.AcquireClient(context.Background(), "acme", "web")
+	require.NoError(t, err)
+	assert.NotNil(t, mc)
+}
+
+func TestGetClient_NodeAccess(t *testing.T) {
+	a := newEagerTestApp(t)
+	a.Tenants = map[string][]string{"acme": {"web"}}
+
+	_, err := a.GetClient("globex", "web")
+	require.ErrorIs(t, err, ErrNodeAccessDenied)
+	_, err = a.GetClient("", "web")
+	require.ErrorIs(t, err, ErrNodeAccessDenied)
+	_, ok := a.lookupClient("web")
+	assert.False(t, ok, "denied references should not create a client")
+
+	mc, err := a.GetClient("acme", "web")
+	require.NoError(t, err)
+
+	_, ok = a.LookupClient("globex", "web")
+	assert.False(t, ok, "other tenants must not see the node's client")
+	_, ok = a.LookupClient("", "web")
+	assert.False(t, ok)
+	found, ok := a.LookupClient("acme", "web")
+	require.True(t, ok)
+	assert.Same(t, mc, found)
+}
+
+func TestPeerIP_NodeAccess(t *testing.T) {
+	a := newEagerTestApp(t)
+	a.Tenants = map[string][]string{"acme": {"web"}}
+	mc, err := a.GetClient("acme", "web")
+	require.NoError(t, err)
+	peers := map[string]string{"db.netbird.cloud": "100.0.1.10"}
+	mc.peerIPs.Store(&peers)
+
+	_, ok := a.PeerIP("globex", "web", "db.netbird.cloud")
+	assert.False(t, ok, "other tenants must not resolve through the node")
+	ip, ok := a.PeerIP("acme", "web", "db.netbird.cloud")
+	require.True(t, ok)
+	assert.Equal(t, "100.0.1.10", ip)
+}
+
+func TestAcquireListenerClient(t *testing.T) {
+	a := newEagerTestApp(t)
+
+	_, err := a.AcquireListenerClient(context.Background(), "web")
+	require.ErrorContains(t, err, "lazy_start false")
+
+	a.Tenants = map[string][]string{"acme": {"web"}}
+	_, err = a.AcquireListenerClient(context.Background(), "web")
+	require.ErrorIs(t, err, ErrNodeAccessDenied, "listeners may not use nodes of a tenant")
+
+	_, ok := a.lookupClient("web")
+	assert.False(t, ok, "rejected listeners should not create a client")
+}
+
+func TestValidateTenants(t *testing.T) {
+	assert.NoError(t, validateTenants(nil))
+	assert.NoError(t, validateTenants(map[string][]string{
+		"acme":   {"acme-web", "acme-web"},
+		"globex": {"globex-web"},
+	}))
+
+	err := validateTenants(map[string][]string{
+		"acme":   {"shared"},
+		"globex": {"shared"},
+	})
+	require.Error(t, err)
+	assert.Equal(t, `node "shared" is listed for tenants "acme" and "globex"`, err.Error())
+}
+
+func TestParseGlobalOption_Tenant(t *testing.T) {
+	app := parseAndDecode(t, `netbird {
+		tenant acme acme-web acme-api
+		tenant acme acme-db
+		tenant globex globex-web
+	}`)
+	assert.Equal(t, map[string][]string{
+		"acme":   {"acme-web", "acme-api", "acme-db"},
+		"globex": {"globex-web"},
+	}, app.Tenants)
+
+	for _, input := range []string{
+		"netbird {\n tenant\n}",
+		"netbird {\n tenant acme\n}",
+	} {
+		_, err := parseGlobalOption(caddyfile.NewTestDispenser(input), nil)
+		assert.Error(t, err, input)
+	}
+}
@@ -0,0 +1,68 @@
+package app
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// authorize checks admin API requests once a status token is configured.
+// The token only grants the read-only GET endpoints, and those require it.
+// Anything else must be authenticated by Caddy's remote admin with a
+// verified client certificate, and is refused without one, so the API
+// isn't left open to whoever can reach it. Without a status token, access
+// is left to Caddy's admin access controls.
+func (a *adminAPI) authorize(r *http.Request) error {
+	token, hasToken := bearerToken(r)
+	if a.app.statusToken == "" {
+		if hasToken {
+			return errInvalidToken
+		}
+		return nil
+	}
+
+	if hasToken {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(a.app.statusToken)) != 1 {
+			return errInvalidToken
+		}
+		if r.Method == http.MethodGet {
+			return nil
+		}
+	}
+	if adminAuthenticated(r) {
+		return nil
+	}
+	if r.Method == http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusUnauthorized,
+			Err:        errors.New("bearer token required"),
+		}
+	}
+	return caddy.APIError{
+		HTTPStatus: http.StatusForbidden,
+		Err:        errors.New("mutating endpoints require a client certificate verified by Caddy's remote admin"),
+	}
+}
+
+var errInvalidToken = caddy.APIError{
+	HTTPStatus: http.StatusUnauthorized,
+	Err:        errors.New("invalid bearer token"),
+}
+
+// adminAuthenticated reports whether the request came through Caddy's
+// remote admin endpoint, which only accepts verified client certificates.
+func adminAuthenticated(r *http.Request) bool {
+	return r.TLS != nil && len(r.TLS.VerifiedChains) > 0
+}
+
+// bearerToken returns the token of a bearer Authorization header.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
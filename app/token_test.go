@@ -0,0 +1,113 @@
+package app
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleAPI_StatusToken(t *testing.T) {
+	api := &adminAPI{app: &App{pool: caddy.NewUsagePool(), statusToken: "s3cret"}}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		auth       string
+		wantStatus int
+	}{
+		{"status with token", http.MethodGet, "/netbird/status", "", "Bearer s3cret", http.StatusOK},
+		{"status without token", http.MethodGet, "/netbird/status", "", "", http.StatusUnauthorized},
+		{"lowercase scheme", http.MethodGet, "/netbird/status", "", "bearer s3cret", http.StatusOK},
+		{"export with token", http.MethodGet, "/netbird/export?node=missing", "", "Bearer s3cret", http.StatusNotFound},
+		{"wrong token", http.MethodGet, "/netbird/status", "", "Bearer wrong", http.StatusUnauthorized},
+		{"empty token", http.MethodGet, "/netbird/status", "", "Bearer ", http.StatusUnauthorized},
+		{"ping with token", http.MethodPost, "/netbird/ping", "{}", "Bearer s3cret", http.StatusForbidden},
+		{"log level with token", http.MethodPut, "/netbird/log-level", `{"level":"debug"}`, "Bearer s3cret", http.StatusForbidden},
+		{"reload with token", http.MethodPost, "/netbird/reload", "", "Bearer s3cret", http.StatusForbidden},
+		{"ping without token", http.MethodPost, "/netbird/ping", "{}", "", http.StatusForbidden},
+		{"log level without token", http.MethodPut, "/netbird/log-level", `{"level":"debug"}`, "", http.StatusForbidden},
+		{"start without token", http.MethodPost, "/netbird/start", "", "", http.StatusForbidden},
+		{"reload without token", http.MethodPost, "/netbird/reload", "", "", http.StatusForbidden},
+		{"ping with basic auth", http.MethodPost, "/netbird/ping", "{}", "Basic dXNlcjpwYXNz", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			require.NoError(t, api.handleAPI(rec, req))
+			assert.Equal(t, tt.wantStatus, rec.Code)
+		})
+	}
+}
+
+func TestHandleAPI_StatusTokenRemoteAdmin(t *testing.T) {
+	api := &adminAPI{app: &App{pool: caddy.NewUsagePool(), statusToken: "s3cret"}}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		auth       string
+		wantStatus int
+	}{
+		{"status", http.MethodGet, "/netbird/status", "", "", http.StatusOK},
+		{"ping", http.MethodPost, "/netbird/ping", "{}", "", http.StatusBadRequest},
+		{"ping with token", http.MethodPost, "/netbird/ping", "{}", "Bearer s3cret", http.StatusBadRequest},
+		{"ping with wrong token", http.MethodPost, "/netbird/ping", "{}", "Bearer wrong", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			require.NoError(t, api.handleAPI(rec, req))
+			assert.Equal(t, tt.wantStatus, rec.Code)
+		})
+	}
+}
+
+func TestHandleAPI_StatusTokenUnverifiedTLS(t *testing.T) {
+	api := &adminAPI{app: &App{pool: caddy.NewUsagePool(), statusToken: "s3cret"}}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/netbird/reload", nil)
+	req.TLS = &tls.ConnectionState{}
+	require.NoError(t, api.handleAPI(rec, req))
+
+	assert.Equal(t, http.StatusForbidden, rec.Code, "TLS without a verified client certificate isn't authentication")
+}
+
+func TestHandleAPI_StatusTokenNotConfigured(t *testing.T) {
+	api := &adminAPI{app: &App{pool: caddy.NewUsagePool()}}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/netbird/status", nil)
+	req.Header.Set("Authorization", "Bearer anything")
+	require.NoError(t, api.handleAPI(rec, req))
+
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, "unauthorized", decodeError(t, rec).Error.Code)
+}
+
+func TestParseGlobalOption_StatusToken(t *testing.T) {
+	app := parseAndDecode(t, `netbird {
+		status_token secret://NB_STATUS_TOKEN
+	}`)
+	assert.Equal(t, "secret://NB_STATUS_TOKEN", app.StatusToken)
+}
@@ -0,0 +1,203 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// Vertex kinds of the topology graph.
+const (
+	vertexLocal = "local"
+	vertexPeer  = "peer"
+	vertexRelay = "relay"
+)
+
+// Edge types of the topology graph. A relayed peer is connected by a relay
+// edge from the node to the relay and a relayed edge from the relay to the
+// peer.
+const (
+	edgeDirect       = "direct"
+	edgeRelay        = "relay"
+	edgeRelayed      = "relayed"
+	edgeDisconnected = "disconnected"
+)
+
+// topology is the graph of a node, its peers and the relays between them.
+type topology struct {
+	Node     string           `json:"node"`
+	Vertices []topologyVertex `json:"vertices"`
+	Edges    []topologyEdge   `json:"edges"`
+}
+
+type topologyVertex struct {
+	// ID is the NetBird IP of the node or a peer, or the relay URI.
+	ID    string `json:"id"`
+	Kind  string `json:"kind"`
+	Label string `json:"label"`
+	// Unavailable marks relays the node can't reach.
+	Unavailable bool `json:"unavailable,omitempty"`
+}
+
+type topologyEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Type string `json:"type"`
+}
+
+// handleTopology returns the peer graph of a single node as JSON, or as
+// Graphviz DOT with ?format=dot.
+func (a *adminAPI) handleTopology(w http.ResponseWriter, r *http.Request) error {
+	name := r.URL.Query().Get("node")
+	if name == "" {
+		name = a.app.DefaultNodeName()
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "dot" {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        fmt.Errorf("unsupported format %q, use json or dot", format),
+		}
+	}
+
+	mc, ok := a.app.lookupClient(name)
+	if !ok {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("node %q not found", name),
+		}
+	}
+
+	ns, err := mc.nodeStatus()
+	if err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusInternalServerError,
+			Err:        fmt.Errorf("get status of node %q: %w", name, err),
+		}
+	}
+
+	topo := newTopology(name, ns)
+	if format == "dot" {
+		w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+		return writeDOT(w, topo)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(topo)
+}
+
+// newTopology builds the graph of a node status: the node, the relays it
+// knows of, and its peers, connected directly, via their relay or not at
+// all. Slices are always non-nil so they encode as [] rather than null.
+func newTopology(name string, ns *nodeStatus) topology {
+	local := stripPrefixLen(ns.Local.IP)
+	topo := topology{
+		Node:     name,
+		Vertices: []topologyVertex{{ID: local, Kind: vertexLocal, Label: vertexLabel(ns.Local.FQDN, local)}},
+		Edges:    []topologyEdge{},
+	}
+
+	relays := make(map[string]bool)
+	for _, r := range ns.Relays {
+		relays[r.URI] = r.Available
+	}
+	for _, p := range ns.Peers {
+		if p.Relayed && p.RelayAddress != "" {
+			if _, ok := relays[p.RelayAddress]; !ok {
+				relays[p.RelayAddress] = true
+			}
+		}
+	}
+	uris := make([]string, 0, len(relays))
+	for uri := range relays {
+		uris = append(uris, uri)
+	}
+	slices.Sort(uris)
+	for _, uri := range uris {
+		topo.Vertices = append(topo.Vertices, topologyVertex{ID: uri, Kind: vertexRelay, Label: uri, Unavailable: !relays[uri]})
+		topo.Edges = append(topo.Edges, topologyEdge{From: local, To: uri, Type: edgeRelay})
+	}
+
+	for _, p := range ns.Peers {
+		topo.Vertices = append(topo.Vertices, topologyVertex{ID: p.IP, Kind: vertexPeer, Label: vertexLabel(p.FQDN, p.IP)})
+		switch {
+		case p.ConnStatus != "Connected":
+			topo.Edges = append(topo.Edges, topologyEdge{From: local, To: p.IP, Type: edgeDisconnected})
+		case p.Relayed && p.RelayAddress != "":
+			topo.Edges = append(topo.Edges, topologyEdge{From: p.RelayAddress, To: p.IP, Type: edgeRelayed})
+		case p.Relayed:
+			topo.Edges = append(topo.Edges, topologyEdge{From: local, To: p.IP, Type: edgeRelayed})
+		default:
+			topo.Edges = append(topo.Edges, topologyEdge{From: local, To: p.IP, Type: edgeDirect})
+		}
+	}
+	return topo
+}
+
+// stripPrefixLen returns the address of a CIDR such as the node's
+// "100.64.0.1/16", or s unchanged if it isn't one.
+func stripPrefixLen(s string) string {
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return s
+	}
+	return prefix.Addr().String()
+}
+
+// vertexLabel returns the label of a node or peer: its FQDN and IP, or only
+// the IP if the FQDN is unknown.
+func vertexLabel(fqdn, ip string) string {
+	if fqdn == "" {
+		return ip
+	}
+	return fqdn + "\n" + ip
+}
+
+// dotVertexAttrs and dotEdgeAttrs are the Graphviz attributes per vertex
+// kind and edge type.
+var (
+	dotVertexAttrs = map[string]string{
+		vertexLocal: "shape=box, style=bold",
+		vertexPeer:  "shape=ellipse",
+		vertexRelay: "shape=diamond",
+	}
+	dotEdgeAttrs = map[string]string{
+		edgeDirect:       "color=darkgreen",
+		edgeRelay:        "style=dashed",
+		edgeRelayed:      "style=dashed, color=orange",
+		edgeDisconnected: "style=dotted, color=gray",
+	}
+)
+
+// writeDOT writes the topology as an undirected Graphviz graph.
+func writeDOT(w io.Writer, topo topology) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "graph %s {\n", dotQuote("netbird "+topo.Node))
+	for _, v := range topo.Vertices {
+		attrs := dotVertexAttrs[v.Kind]
+		if v.Unavailable {
+			attrs += ", color=red"
+		}
+		fmt.Fprintf(&b, "\t%s [label=%s, %s];\n", dotQuote(v.ID), dotQuote(v.Label), attrs)
+	}
+	for _, e := range topo.Edges {
+		fmt.Fprintf(&b, "\t%s -- %s [%s];\n", dotQuote(e.From), dotQuote(e.To), dotEdgeAttrs[e.Type])
+	}
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// dotQuote returns s as a quoted DOT ID. Newlines become line breaks in
+// labels.
+func dotQuote(s string) string {
+	return `"` + dotEscaper.Replace(s) + `"`
+}
+
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
@@ -0,0 +1,118 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// topologyTestStatus has a direct, a relayed and a disconnected peer, and
+// an unreachable relay.
+func topologyTestStatus() *nodeStatus {
+	return &nodeStatus{
+		Local: localStatus{IP: "100.64.0.1/16", FQDN: "caddy.netbird.cloud"},
+		Relays: []relayStatus{
+			{URI: "rels://relay.netbird.io:443", Available: true},
+			{URI: "rels://backup.netbird.io:443", Available: false},
+		},
+		Peers: []peerStatus{
+			{IP: "100.64.0.2", FQDN: "api.netbird.cloud", ConnStatus: "Connected"},
+			{IP: "100.64.0.3", FQDN: "db.netbird.cloud", ConnStatus: "Connected", Relayed: true, RelayAddress: "rels://relay.netbird.io:443"},
+			{IP: "100.64.0.4", ConnStatus: "Connecting"},
+		},
+	}
+}
+
+func TestNewTopology(t *testing.T) {
+	topo := newTopology("web", topologyTestStatus())
+
+	assert.Equal(t, topology{
+		Node: "web",
+		Vertices: []topologyVertex{
+			{ID: "100.64.0.1", Kind: vertexLocal, Label: "caddy.netbird.cloud\n100.64.0.1"},
+			{ID: "rels://backup.netbird.io:443", Kind: vertexRelay, Label: "rels://backup.netbird.io:443", Unavailable: true},
+			{ID: "rels://relay.netbird.io:443", Kind: vertexRelay, Label: "rels://relay.netbird.io:443"},
+			{ID: "100.64.0.2", Kind: vertexPeer, Label: "api.netbird.cloud\n100.64.0.2"},
+			{ID: "100.64.0.3", Kind: vertexPeer, Label: "db.netbird.cloud\n100.64.0.3"},
+			{ID: "100.64.0.4", Kind: vertexPeer, Label: "100.64.0.4"},
+		},
+		Edges: []topologyEdge{
+			{From: "100.64.0.1", To: "rels://backup.netbird.io:443", Type: edgeRelay},
+			{From: "100.64.0.1", To: "rels://relay.netbird.io:443", Type: edgeRelay},
+			{From: "100.64.0.1", To: "100.64.0.2", Type: edgeDirect},
+			{From: "rels://relay.netbird.io:443", To: "100.64.0.3", Type: edgeRelayed},
+			{From: "100.64.0.1", To: "100.64.0.4", Type: edgeDisconnected},
+		},
+	}, topo)
+}
+
+func TestNewTopology_UnlistedRelay(t *testing.T) {
+	topo := newTopology("web", &nodeStatus{
+		Local: localStatus{IP: "100.64.0.1/16"},
+		Peers: []peerStatus{
+			{IP: "100.64.0.2", ConnStatus: "Connected", Relayed: true, RelayAddress: "rels://other.example.com:443"},
+			{IP: "100.64.0.3", ConnStatus: "Connected", Relayed: true},
+		},
+	})
+
+	assert.Contains(t, topo.Vertices, topologyVertex{ID: "rels://other.example.com:443", Kind: vertexRelay, Label: "rels://other.example.com:443"})
+	assert.Contains(t, topo.Edges, topologyEdge{From: "rels://other.example.com:443", To: "100.64.0.2", Type: edgeRelayed})
+	assert.Contains(t, topo.Edges, topologyEdge{From: "100.64.0.1", To: "100.64.0.3", Type: edgeRelayed}, "relay of the peer is unknown")
+}
+
+func TestWriteDOT(t *testing.T) {
+	var b strings.Builder
+	require.NoError(t, writeDOT(&b, newTopology("web", topologyTestStatus())))
+
+	assert.Equal(t, `graph "netbird web" {
+	"100.64.0.1" [label="caddy.netbird.cloud\n100.64.0.1", shape=box, style=bold];
+	"rels://backup.netbird.io:443" [label="rels://backup.netbird.io:443", shape=diamond, color=red];
+	"rels://relay.netbird.io:443" [label="rels://relay.netbird.io:443", shape=diamond];
+	"100.64.0.2" [label="api.netbird.cloud\n100.64.0.2", shape=ellipse];
+	"100.64.0.3" [label="db.netbird.cloud\n100.64.0.3", shape=ellipse];
+	"100.64.0.4" [label="100.64.0.4", shape=ellipse];
+	"100.64.0.1" -- "rels://backup.netbird.io:443" [style=dashed];
+	"100.64.0.1" -- "rels://relay.netbird.io:443" [style=dashed];
+	"100.64.0.1" -- "100.64.0.2" [color=darkgreen];
+	"rels://relay.netbird.io:443" -- "100.64.0.3" [style=dashed, color=orange];
+	"100.64.0.1" -- "100.64.0.4" [style=dotted, color=gray];
+}
+`, b.String())
+}
+
+func TestDotQuote(t *testing.T) {
+	assert.Equal(t, `"a\"b\\c\nd"`, dotQuote("a\"b\\c\nd"))
+}
+
+func TestTopology_JSONEmpty(t *testing.T) {
+	data, err := json.Marshal(newTopology("web", &nodeStatus{}))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"node":"web","vertices":[{"id":"","kind":"local","label":""}],"edges":[]}`, string(data))
+}
+
+func TestHandleTopology_Errors(t *testing.T) {
+	a := newReloadTestApp(t, fakeSecretProvider{"WEB_KEY": "web-key", "API_KEY": "api-key"})
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+	}{
+		{"unknown format", "?node=web&format=svg", http.StatusBadRequest},
+		{"unknown node", "?node=db", http.StatusNotFound},
+		{"default node missing", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/netbird/topology"+tt.query, nil)
+			require.NoError(t, (&adminAPI{app: a}).handleAPI(rec, req))
+			assert.Equal(t, tt.status, rec.Code)
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package app
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// ConnUsage is the approximate resources held by one proxied connection.
+type ConnUsage struct {
+	// Goroutines is the number of goroutines serving the connection.
+	Goroutines int
+	// BufferBytes is the size of the buffers allocated for the connection.
+	BufferBytes int
+}
+
+// nodeUsage sums the resources of the open connections through a node.
+type nodeUsage struct {
+	conns       atomic.Int64
+	goroutines  atomic.Int64
+	bufferBytes atomic.Int64
+}
+
+// usageStatus is the approximate resource usage of a node's connections.
+type usageStatus struct {
+	ActiveConns int64 `json:"activeConns"`
+	Goroutines  int64 `json:"goroutines"`
+	BufferBytes int64 `json:"bufferBytes"`
+}
+
+func (u *nodeUsage) add(usage ConnUsage, sign int64) {
+	u.conns.Add(sign)
+	u.goroutines.Add(sign * int64(usage.Goroutines))
+	u.bufferBytes.Add(sign * int64(usage.BufferBytes))
+}
+
+// TrackConn records a connection opened through the node and returns a
+// func to call once it is closed. Calling the func more than once has no
+// further effect. On a nil App, nothing is recorded.
+func (a *App) TrackConn(node string, usage ConnUsage) (done func()) {
+	if a == nil {
+		return func() {}
+	}
+
+	val, _ := a.usage.LoadOrStore(node, new(nodeUsage))
+	u := val.(*nodeUsage)
+	u.add(usage, 1)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { u.add(usage, -1) })
+	}
+}
+
+// usageOf returns the resource usage of the node's open connections.
+func (a *App) usageOf(node string) usageStatus {
+	val, ok := a.usage.Load(node)
+	if !ok {
+		return usageStatus{}
+	}
+	u := val.(*nodeUsage)
+	return usageStatus{
+		ActiveConns: u.conns.Load(),
+		Goroutines:  u.goroutines.Load(),
+		BufferBytes: u.bufferBytes.Load(),
+	}
+}
@@ -0,0 +1,75 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrackConn(t *testing.T) {
+	a := &App{}
+	usage := ConnUsage{Goroutines: 2, BufferBytes: 1024}
+
+	doneA := a.TrackConn("web", usage)
+	doneB := a.TrackConn("web", usage)
+	doneC := a.TrackConn("db", ConnUsage{Goroutines: 1})
+
+	assert.Equal(t, usageStatus{ActiveConns: 2, Goroutines: 4, BufferBytes: 2048}, a.usageOf("web"))
+	assert.Equal(t, usageStatus{ActiveConns: 1, Goroutines: 1}, a.usageOf("db"))
+	assert.Equal(t, usageStatus{}, a.usageOf("unknown"))
+
+	doneA()
+	doneA()
+	assert.Equal(t, usageStatus{ActiveConns: 1, Goroutines: 2, BufferBytes: 1024}, a.usageOf("web"), "done should only count once")
+
+	doneB()
+	doneC()
+	assert.Equal(t, usageStatus{}, a.usageOf("web"))
+	assert.Equal(t, usageStatus{}, a.usageOf("db"))
+}
+
+func TestTrackConn_Concurrent(t *testing.T) {
+	a := &App{}
+	usage := ConnUsage{Goroutines: 2, BufferBytes: 64}
+
+	const n = 100
+	dones := make(chan func(), n)
+	var wg sync.WaitGroup
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dones <- a.TrackConn("web", usage)
+		}()
+	}
+	wg.Wait()
+	close(dones)
+	assert.Equal(t, usageStatus{ActiveConns: n, Goroutines: 2 * n, BufferBytes: 64 * n}, a.usageOf("web"))
+
+	for done := range dones {
+		done()
+	}
+	assert.Equal(t, usageStatus{}, a.usageOf("web"))
+}
+
+func TestTrackConn_NilApp(t *testing.T) {
+	var a *App
+	done := a.TrackConn("web", ConnUsage{Goroutines: 1})
+	done()
+}
+
+func TestNodeStatus_Usage(t *testing.T) {
+	ns := &nodeStatus{Usage: usageStatus{ActiveConns: 3, Goroutines: 6, BufferBytes: 2048}}
+
+	data, err := json.Marshal(ns)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"usage":{"activeConns":3,"goroutines":6,"bufferBytes":2048}`)
+
+	rec := httptest.NewRecorder()
+	require.NoError(t, (&adminAPI{}).writeStatusText(rec, statusResponse{Nodes: map[nodeName]*nodeStatus{"web": ns}}, false))
+	assert.Contains(t, rec.Body.String(), "3 (6 goroutines, 2.0 KiB buffers)")
+}
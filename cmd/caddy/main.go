@@ -6,13 +6,17 @@ import (
 	caddycmd "github.com/caddyserver/caddy/v2/cmd"
 
 	_ "github.com/caddyserver/caddy/v2/modules/standard"
-	_ "github.com/lixmal/caddy-netbird/app"
+	"github.com/lixmal/caddy-netbird/app"
 	_ "github.com/lixmal/caddy-netbird/l4handler"
 	_ "github.com/lixmal/caddy-netbird/listener"
 	_ "github.com/lixmal/caddy-netbird/transport"
 	_ "github.com/mholt/caddy-l4"
 )
 
+func init() {
+	caddycmd.RegisterCommand(app.ValidateCommand())
+}
+
 func main() {
 	caddycmd.Main()
 }
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	caddycmd "github.com/caddyserver/caddy/v2/cmd"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func runValidate(t *testing.T, config string) error {
+	t.Helper()
+
+	cmd, ok := caddycmd.Commands()["netbird-validate"]
+	require.True(t, ok, "netbird-validate not registered")
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(path, []byte(config), 0o600))
+	fs := pflag.NewFlagSet(cmd.Name, pflag.ContinueOnError)
+	fs.AddGoFlagSet(cmd.Flags)
+	require.NoError(t, fs.Parse([]string{"--config", path}))
+
+	_, err := cmd.Func(caddycmd.Flags{FlagSet: fs})
+	return err
+}
+
+func TestValidateCommand_Valid(t *testing.T) {
+	err := runValidate(t, `{
+		"apps": {
+			"netbird": {
+				"management_url": "https://api.netbird.io:443",
+				"setup_key": "KEY",
+				"nodes": {"web": {}}
+			}
+		}
+	}`)
+	assert.NoError(t, err)
+}
+
+func TestValidateCommand_ReportsAllNodes(t *testing.T) {
+	err := runValidate(t, `{
+		"apps": {
+			"netbird": {
+				"nodes": {
+					"a-no-url": {"setup_key": "KEY"},
+					"b-no-key": {"management_url": "https://api.netbird.io:443"}
+				}
+			}
+		}
+	}`)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `node "a-no-url"`)
+	assert.Contains(t, err.Error(), `node "b-no-key"`)
+}
@@ -0,0 +1,73 @@
+package l4handler
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// peerAllowlist matches downstream addresses against NetBird peer IPs,
+// prefixes and FQDNs.
+type peerAllowlist struct {
+	prefixes []netip.Prefix
+	fqdns    []string
+	// lookup returns the current NetBird IP of a peer FQDN in the network
+	// of a node.
+	lookup func(node, fqdn string) (string, bool)
+}
+
+// newPeerAllowlist parses entries given as IP addresses, CIDRs or peer
+// FQDNs.
+func newPeerAllowlist(entries []string, lookup func(node, fqdn string) (string, bool)) *peerAllowlist {
+	l := &peerAllowlist{lookup: lookup}
+	for _, entry := range entries {
+		if addr, err := netip.ParseAddr(entry); err == nil {
+			addr = addr.Unmap()
+			l.prefixes = append(l.prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			l.prefixes = append(l.prefixes, prefix.Masked())
+			continue
+		}
+		l.fqdns = append(l.fqdns, strings.ToLower(strings.TrimSuffix(entry, ".")))
+	}
+	return l
+}
+
+// allows reports whether addr belongs to an allowed peer. FQDNs are resolved
+// against the peers of node on every call, so the list follows peers whose
+// IP changes.
+func (l *peerAllowlist) allows(addr net.Addr, node string) bool {
+	ip, ok := addrIP(addr)
+	if !ok {
+		return false
+	}
+	for _, prefix := range l.prefixes {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	for _, fqdn := range l.fqdns {
+		peerIP, ok := l.lookup(node, fqdn)
+		if !ok {
+			continue
+		}
+		if addr, err := netip.ParseAddr(peerIP); err == nil && addr.Unmap() == ip {
+			return true
+		}
+	}
+	return false
+}
+
+// addrIP returns the IP of a TCP or UDP address.
+func addrIP(addr net.Addr) (netip.Addr, bool) {
+	if addr == nil {
+		return netip.Addr{}, false
+	}
+	addrPort, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addrPort.Addr().Unmap(), true
+}
@@ -0,0 +1,162 @@
+package l4handler
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/mholt/caddy-l4/layer4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestPeerAllowlist(t *testing.T) {
+	peers := map[string]string{"laptop.netbird.cloud": "100.0.1.10"}
+	l := newPeerAllowlist([]string{"100.0.2.1", "100.0.3.0/24", "Laptop.NetBird.Cloud.", "gone.netbird.cloud"},
+		func(node, fqdn string) (string, bool) {
+			if node != "web" {
+				return "", false
+			}
+			ip, ok := peers[fqdn]
+			return ip, ok
+		})
+
+	tests := []struct {
+		name string
+		addr net.Addr
+		want bool
+	}{
+		{"exact ip", &net.TCPAddr{IP: net.ParseIP("100.0.2.1"), Port: 40000}, true},
+		{"other ip", &net.TCPAddr{IP: net.ParseIP("100.0.2.2"), Port: 40000}, false},
+		{"in prefix", &net.UDPAddr{IP: net.ParseIP("100.0.3.77"), Port: 53}, true},
+		{"ipv4-mapped", &net.TCPAddr{IP: net.ParseIP("::ffff:100.0.2.1"), Port: 40000}, true},
+		{"peer fqdn", &net.TCPAddr{IP: net.ParseIP("100.0.1.10"), Port: 40000}, true},
+		{"unknown", &net.TCPAddr{IP: net.ParseIP("100.0.9.9"), Port: 40000}, false},
+		{"nil", nil, false},
+		{"not an ip", pipeAddr{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, l.allows(tt.addr, "web"))
+		})
+	}
+
+	assert.False(t, l.allows(&net.TCPAddr{IP: net.ParseIP("100.0.1.10"), Port: 40000}, "db"), "FQDNs resolve against the given node only")
+
+	peers["laptop.netbird.cloud"] = "100.0.1.11"
+	assert.False(t, l.allows(&net.TCPAddr{IP: net.ParseIP("100.0.1.10"), Port: 40000}, "web"), "old IP of a peer must not match")
+	assert.True(t, l.allows(&net.TCPAddr{IP: net.ParseIP("100.0.1.11"), Port: 40000}, "web"), "new IP of a peer must match")
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+func TestUnmarshalCaddyfile_AllowPeers(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`netbird 10.0.0.1:22 {
+		allow_peers 100.0.1.10 laptop.netbird.cloud
+		allow_peers 100.0.2.0/24
+	}`)
+
+	var h Handler
+	require.NoError(t, h.UnmarshalCaddyfile(d))
+	assert.Equal(t, []string{"100.0.1.10", "laptop.netbird.cloud", "100.0.2.0/24"}, h.AllowPeers)
+}
+
+func TestUnmarshalCaddyfile_AllowPeersMissingArg(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`netbird 10.0.0.1:22 {
+		allow_peers
+	}`)
+
+	var h Handler
+	assert.Error(t, h.UnmarshalCaddyfile(d))
+}
+
+// remoteConn is a pipe end with a UDP local address and the given remote
+// address.
+type remoteConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (remoteConn) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5353}
+}
+
+func (c remoteConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func TestHandle_AllowPeers(t *testing.T) {
+	var dials atomic.Int32
+	h := &Handler{
+		Upstream: "10.0.0.1:53",
+		dial:     echoDialer(&dials),
+		allow:    newPeerAllowlist([]string{"100.0.1.10"}, func(string, string) (string, bool) { return "", false }),
+		logger:   zap.NewNop(),
+	}
+
+	t.Run("allowed", func(t *testing.T) {
+		downstream, client := net.Pipe()
+		defer downstream.Close()
+		remote := &net.UDPAddr{IP: net.ParseIP("100.0.1.10"), Port: 40000}
+		cx := layer4.WrapConnection(remoteConn{Conn: downstream, remote: remote}, nil, zap.NewNop())
+
+		done := make(chan error, 1)
+		go func() { done <- h.Handle(cx, nil) }()
+
+		_, err := client.Write([]byte("query"))
+		require.NoError(t, err)
+		buf := make([]byte, 5)
+		_, err = io.ReadFull(client, buf)
+		require.NoError(t, err)
+		assert.Equal(t, "query", string(buf))
+
+		require.NoError(t, client.Close())
+		require.NoError(t, <-done)
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		downstream, client := net.Pipe()
+		defer downstream.Close()
+		defer client.Close()
+		remote := &net.UDPAddr{IP: net.ParseIP("100.0.1.20"), Port: 40000}
+		cx := layer4.WrapConnection(remoteConn{Conn: downstream, remote: remote}, nil, zap.NewNop())
+
+		err := h.Handle(cx, nil)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "not an allowed peer")
+	})
+
+	assert.Equal(t, int32(1), dials.Load(), "rejected connections must not dial the upstream")
+}
+
+func TestHandle_AllowPeersResolvesAgainstNode(t *testing.T) {
+	var nodes []string
+	h := &Handler{
+		Node:     "{test.node}",
+		Upstream: "10.0.0.1:53",
+		allow: newPeerAllowlist([]string{"laptop.netbird.cloud"}, func(node, _ string) (string, bool) {
+			nodes = append(nodes, node)
+			return "100.0.1.10", node == "web"
+		}),
+		logger: zap.NewNop(),
+	}
+
+	downstream, client := net.Pipe()
+	defer downstream.Close()
+	defer client.Close()
+	remote := &net.UDPAddr{IP: net.ParseIP("100.0.1.10"), Port: 40000}
+	cx := layer4.WrapConnection(remoteConn{Conn: downstream, remote: remote}, nil, zap.NewNop())
+	cx.Context.Value(layer4.ReplacerCtxKey).(*caddy.Replacer).Set("test.node", "db")
+
+	err := h.Handle(cx, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not an allowed peer")
+	assert.Equal(t, []string{"db"}, nodes, "FQDNs resolve against the connection's node")
+}
@@ -0,0 +1,99 @@
+package l4handler
+
+import "sync"
+
+// WeightedUpstream is an additional upstream connections are spread to.
+type WeightedUpstream struct {
+	// Address is the host:port to dial via the NetBird network.
+	Address string `json:"address"`
+	// Weight is the upstream's share of connections relative to the other
+	// upstreams. Defaults to 1.
+	Weight int `json:"weight,omitempty"`
+}
+
+// upstreamBalancer picks upstreams by smooth weighted round-robin: each
+// upstream is picked in proportion to its weight, and picks of heavier
+// upstreams are interleaved with the others rather than sent in bursts.
+// Upstreams marked unhealthy are skipped unless all are.
+type upstreamBalancer struct {
+	mu        sync.Mutex
+	upstreams []balancedUpstream
+}
+
+type balancedUpstream struct {
+	address   string
+	weight    int
+	current   int
+	unhealthy bool
+}
+
+func newUpstreamBalancer(upstreams []WeightedUpstream) *upstreamBalancer {
+	b := &upstreamBalancer{}
+	for _, u := range upstreams {
+		weight := u.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		b.upstreams = append(b.upstreams, balancedUpstream{address: u.Address, weight: weight})
+	}
+	return b
+}
+
+// next returns the address of the upstream to dial next.
+func (b *upstreamBalancer) next() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	allUnhealthy := true
+	for _, u := range b.upstreams {
+		allUnhealthy = allUnhealthy && u.unhealthy
+	}
+
+	best, total := -1, 0
+	for i := range b.upstreams {
+		u := &b.upstreams[i]
+		if u.unhealthy && !allUnhealthy {
+			continue
+		}
+		u.current += u.weight
+		total += u.weight
+		if best < 0 || u.current > b.upstreams[best].current {
+			best = i
+		}
+	}
+	b.upstreams[best].current -= total
+	return b.upstreams[best].address
+}
+
+// addresses returns the addresses of all upstreams.
+func (b *upstreamBalancer) addresses() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	addrs := make([]string, 0, len(b.upstreams))
+	for _, u := range b.upstreams {
+		addrs = append(addrs, u.address)
+	}
+	return addrs
+}
+
+// setHealthy marks the upstream at address as healthy or unhealthy. It
+// reports whether the state changed.
+func (b *upstreamBalancer) setHealthy(address string, healthy bool) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	changed := false
+	for i := range b.upstreams {
+		u := &b.upstreams[i]
+		if u.address != address || u.unhealthy == !healthy {
+			continue
+		}
+		u.unhealthy = !healthy
+		// Start over so a recovered upstream doesn't catch up on the
+		// picks it missed.
+		u.current = 0
+		changed = true
+	}
+	return changed
+}
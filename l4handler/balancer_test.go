@@ -0,0 +1,122 @@
+package l4handler
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestUpstreamBalancer_Distribution(t *testing.T) {
+	b := newUpstreamBalancer([]WeightedUpstream{
+		{Address: "10.0.0.1:22", Weight: 3},
+		{Address: "10.0.0.2:22", Weight: 1},
+		{Address: "10.0.0.3:22"},
+	})
+
+	counts := make(map[string]int)
+	for range 50 {
+		counts[b.next()]++
+	}
+	assert.Equal(t, map[string]int{"10.0.0.1:22": 30, "10.0.0.2:22": 10, "10.0.0.3:22": 10}, counts)
+}
+
+func TestUpstreamBalancer_Interleaves(t *testing.T) {
+	b := newUpstreamBalancer([]WeightedUpstream{
+		{Address: "a:22", Weight: 2},
+		{Address: "b:22", Weight: 1},
+	})
+
+	var picks []string
+	for range 6 {
+		picks = append(picks, b.next())
+	}
+	assert.Equal(t, []string{"a:22", "b:22", "a:22", "a:22", "b:22", "a:22"}, picks)
+}
+
+func TestUpstreamBalancer_Concurrent(t *testing.T) {
+	b := newUpstreamBalancer([]WeightedUpstream{
+		{Address: "a:22", Weight: 1},
+		{Address: "b:22", Weight: 1},
+	})
+
+	var mu sync.Mutex
+	counts := make(map[string]int)
+	var wg sync.WaitGroup
+	for range 100 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			addr := b.next()
+			mu.Lock()
+			counts[addr]++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, map[string]int{"a:22": 50, "b:22": 50}, counts)
+}
+
+func TestUnmarshalCaddyfile_Upstreams(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`netbird 10.0.0.1:22 {
+		upstream 10.0.0.2:22 weight 3
+		upstream 10.0.0.3:22
+	}`)
+
+	var h Handler
+	require.NoError(t, h.UnmarshalCaddyfile(d))
+	assert.Equal(t, "10.0.0.1:22", h.Upstream)
+	assert.Equal(t, []WeightedUpstream{
+		{Address: "10.0.0.2:22", Weight: 3},
+		{Address: "10.0.0.3:22"},
+	}, h.Upstreams)
+	assert.Equal(t, []WeightedUpstream{
+		{Address: "10.0.0.1:22", Weight: 1},
+		{Address: "10.0.0.2:22", Weight: 3},
+		{Address: "10.0.0.3:22"},
+	}, h.balancedUpstreams())
+}
+
+func TestUnmarshalCaddyfile_UpstreamsInvalid(t *testing.T) {
+	for _, input := range []string{
+		"netbird 10.0.0.1:22 {\n upstream\n}",
+		"netbird 10.0.0.1:22 {\n upstream 10.0.0.2:22 weight\n}",
+		"netbird 10.0.0.1:22 {\n upstream 10.0.0.2:22 weight 0\n}",
+		"netbird 10.0.0.1:22 {\n upstream 10.0.0.2:22 weight heavy\n}",
+		"netbird 10.0.0.1:22 {\n upstream 10.0.0.2:22 priority 3\n}",
+		"netbird 10.0.0.1:22 {\n upstream 10.0.0.2:22 weight 3 extra\n}",
+	} {
+		var h Handler
+		require.Error(t, h.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)), input)
+	}
+}
+
+func TestHandle_WeightedUpstreams(t *testing.T) {
+	var dials atomic.Int32
+	echo := echoDialer(&dials)
+	var mu sync.Mutex
+	dialed := make(map[string]int)
+	h := &Handler{
+		Upstream:  "10.0.0.1:53",
+		Upstreams: []WeightedUpstream{{Address: "10.0.0.2:53", Weight: 2}},
+		dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			mu.Lock()
+			dialed[address]++
+			mu.Unlock()
+			return echo(ctx, network, address)
+		},
+		logger: zap.NewNop(),
+	}
+	h.balancer = newUpstreamBalancer(h.balancedUpstreams())
+
+	for range 6 {
+		runUDPSession(t, h)
+	}
+	assert.Equal(t, map[string]int{"10.0.0.1:53": 2, "10.0.0.2:53": 4}, dialed)
+}
@@ -0,0 +1,91 @@
+package l4handler
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mholt/caddy-l4/layer4"
+	"github.com/mholt/caddy-l4/modules/l4tls"
+	"github.com/pires/go-proxyproto"
+	"github.com/pires/go-proxyproto/tlvparse"
+)
+
+// maxTLVLen is the largest value a PROXY protocol v2 TLV can carry.
+const maxTLVLen = 1<<16 - 1
+
+// writeProxyHeader sends a PROXY protocol v2 header with the client and
+// local address of cx to w. If TLS was terminated earlier in the route, the
+// header describes the TLS connection and the client certificate.
+func writeProxyHeader(w io.Writer, cx *layer4.Connection) error {
+	header := proxyproto.HeaderProxyFromAddrs(2, cx.RemoteAddr(), cx.LocalAddr())
+	if cs := clientTLSState(cx); cs != nil {
+		tlv, err := sslTLV(cs)
+		if err != nil {
+			return fmt.Errorf("encode tls info: %w", err)
+		}
+		if err := header.SetTLVs([]proxyproto.TLV{tlv}); err != nil {
+			return fmt.Errorf("encode tls info: %w", err)
+		}
+	}
+	if _, err := header.WriteTo(w); err != nil {
+		return fmt.Errorf("write proxy protocol header: %w", err)
+	}
+	return nil
+}
+
+// clientTLSState returns the state of the innermost TLS connection that
+// caddy-l4's tls handler terminated before this handler, or nil if none.
+func clientTLSState(cx *layer4.Connection) *tls.ConnectionState {
+	states := l4tls.GetConnectionStates(cx)
+	if len(states) == 0 {
+		return nil
+	}
+	return states[len(states)-1]
+}
+
+// sslTLV describes a terminated TLS connection as PP2_TYPE_SSL TLV, with
+// the TLS version and cipher, and the common name and DER encoding of the
+// client certificate if one was presented. A certificate too large for the
+// TLV is left out.
+func sslTLV(cs *tls.ConnectionState) (proxyproto.TLV, error) {
+	// Verify is non-zero unless a client certificate was verified.
+	ssl := tlvparse.PP2SSL{Client: tlvparse.PP2_BITFIELD_CLIENT_SSL, Verify: 1}
+	ssl.TLV = []proxyproto.TLV{
+		{Type: proxyproto.PP2_SUBTYPE_SSL_VERSION, Value: []byte(tlsVersionName(cs.Version))},
+		{Type: proxyproto.PP2_SUBTYPE_SSL_CIPHER, Value: []byte(tls.CipherSuiteName(cs.CipherSuite))},
+	}
+	if len(cs.PeerCertificates) == 0 {
+		return ssl.Marshal()
+	}
+
+	cert := cs.PeerCertificates[0]
+	ssl.Client |= tlvparse.PP2_BITFIELD_CLIENT_CERT_SESS
+	if !cs.DidResume {
+		ssl.Client |= tlvparse.PP2_BITFIELD_CLIENT_CERT_CONN
+	}
+	if len(cs.VerifiedChains) > 0 {
+		ssl.Verify = 0
+	}
+	if cert.Subject.CommonName != "" {
+		ssl.TLV = append(ssl.TLV, proxyproto.TLV{Type: proxyproto.PP2_SUBTYPE_SSL_CN, Value: []byte(cert.Subject.CommonName)})
+	}
+
+	tlv, err := ssl.Marshal()
+	if err != nil {
+		return proxyproto.TLV{}, err
+	}
+	// Each sub-TLV adds a type byte and a two byte length.
+	if len(tlv.Value)+3+len(cert.Raw) > maxTLVLen {
+		return tlv, nil
+	}
+	ssl.TLV = append(ssl.TLV, proxyproto.TLV{Type: proxyproto.PP2_SUBTYPE_SSL_CLIENT_CERT, Value: cert.Raw})
+	return ssl.Marshal()
+}
+
+// tlsVersionName returns the name of a TLS version in OpenSSL's notation,
+// e.g. "TLSv1.3", as backends commonly expect it.
+func tlsVersionName(version uint16) string {
+	return strings.Replace(tls.VersionName(version), "TLS ", "TLSv", 1)
+}
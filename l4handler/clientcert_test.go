@@ -0,0 +1,292 @@
+package l4handler
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/mholt/caddy-l4/layer4"
+	"github.com/pires/go-proxyproto"
+	"github.com/pires/go-proxyproto/tlvparse"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// testCert returns a certificate for cn signed by parent, or self-signed
+// if parent is nil.
+func testCert(t *testing.T, cn string, parent *tls.Certificate) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{cn},
+	}
+	signer, signerKey := tmpl, any(key)
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// handshake terminates a TLS connection from a client presenting
+// clientCert, if any, and returns the server side connection state.
+func handshake(t *testing.T, ca, clientCert *tls.Certificate) tls.ConnectionState {
+	t.Helper()
+
+	serverCert := testCert(t, "db.netbird.cloud", ca)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	clientCfg := &tls.Config{RootCAs: pool, ServerName: "db.netbird.cloud"}
+	if clientCert != nil {
+		clientCfg.Certificates = []tls.Certificate{*clientCert}
+	}
+	go func() {
+		_ = tls.Client(clientConn, clientCfg).Handshake()
+	}()
+
+	server := tls.Server(serverConn, &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		ClientCAs:    pool,
+	})
+	require.NoError(t, server.Handshake())
+	return server.ConnectionState()
+}
+
+func TestSSLTLV_ClientCert(t *testing.T) {
+	ca := testCert(t, "ca", nil)
+	client := testCert(t, "alice", &ca)
+	cs := handshake(t, &ca, &client)
+
+	tlv, err := sslTLV(&cs)
+	require.NoError(t, err)
+	ssl, err := tlvparse.SSL(tlv)
+	require.NoError(t, err)
+
+	assert.True(t, ssl.ClientSSL())
+	assert.True(t, ssl.ClientCertConn())
+	assert.True(t, ssl.ClientCertSess())
+	assert.True(t, ssl.Verified())
+	version, _ := ssl.SSLVersion()
+	assert.Equal(t, "TLSv1.3", version)
+	cipher, _ := ssl.SSLCipher()
+	assert.Equal(t, tls.CipherSuiteName(cs.CipherSuite), cipher)
+	cn, _ := ssl.ClientCN()
+	assert.Equal(t, "alice", cn)
+	der, ok := ssl.ClientCert()
+	require.True(t, ok)
+	assert.Equal(t, client.Leaf.Raw, der)
+}
+
+func TestSSLTLV_NoClientCert(t *testing.T) {
+	ca := testCert(t, "ca", nil)
+	cs := handshake(t, &ca, nil)
+
+	tlv, err := sslTLV(&cs)
+	require.NoError(t, err)
+	ssl, err := tlvparse.SSL(tlv)
+	require.NoError(t, err)
+
+	assert.True(t, ssl.ClientSSL())
+	assert.False(t, ssl.ClientCertConn())
+	assert.False(t, ssl.Verified(), "no certificate was verified")
+	_, ok := ssl.ClientCN()
+	assert.False(t, ok)
+	_, ok = ssl.ClientCert()
+	assert.False(t, ok)
+}
+
+func TestSSLTLV_OversizedCert(t *testing.T) {
+	ca := testCert(t, "ca", nil)
+	client := testCert(t, "alice", &ca)
+	client.Leaf.Raw = make([]byte, maxTLVLen)
+	cs := tls.ConnectionState{Version: tls.VersionTLS13, PeerCertificates: []*x509.Certificate{client.Leaf}}
+
+	tlv, err := sslTLV(&cs)
+	require.NoError(t, err)
+	ssl, err := tlvparse.SSL(tlv)
+	require.NoError(t, err)
+
+	cn, _ := ssl.ClientCN()
+	assert.Equal(t, "alice", cn)
+	_, ok := ssl.ClientCert()
+	assert.False(t, ok, "a certificate too large for the TLV should be left out")
+}
+
+func TestTLSVersionName(t *testing.T) {
+	assert.Equal(t, "TLSv1.2", tlsVersionName(tls.VersionTLS12))
+	assert.Equal(t, "TLSv1.3", tlsVersionName(tls.VersionTLS13))
+}
+
+func TestUnmarshalCaddyfile_ForwardClientCert(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`netbird 10.0.0.1:5432 {
+		forward_client_cert
+	}`)
+
+	var h Handler
+	require.NoError(t, h.UnmarshalCaddyfile(d))
+	assert.True(t, h.ForwardClientCert)
+}
+
+// tcpAddrConn is one end of a net.Pipe that reports TCP addresses.
+type tcpAddrConn struct {
+	net.Conn
+}
+
+func (tcpAddrConn) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(100, 64, 0, 1), Port: 5432}
+}
+
+func (tcpAddrConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(100, 64, 0, 7), Port: 40000}
+}
+
+func TestHandle_ForwardClientCert(t *testing.T) {
+	ca := testCert(t, "ca", nil)
+	client := testCert(t, "alice", &ca)
+	cs := handshake(t, &ca, &client)
+
+	headers := make(chan *proxyproto.Header, 1)
+	h := &Handler{
+		Upstream:          "10.0.0.1:5432",
+		ForwardClientCert: true,
+		dial: func(context.Context, string, string) (net.Conn, error) {
+			conn, server := net.Pipe()
+			go func() {
+				defer server.Close()
+				r := bufio.NewReader(server)
+				header, err := proxyproto.Read(r)
+				if err != nil {
+					close(headers)
+					return
+				}
+				headers <- header
+				_, _ = io.Copy(server, r)
+			}()
+			return conn, nil
+		},
+		logger: zap.NewNop(),
+	}
+
+	downstream, peer := net.Pipe()
+	defer downstream.Close()
+	cx := layer4.WrapConnection(tcpAddrConn{downstream}, nil, zap.NewNop())
+	cx.SetVar("tls_connection_states", []*tls.ConnectionState{&cs})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- h.Handle(cx, nil)
+	}()
+
+	_, err := peer.Write([]byte("query"))
+	require.NoError(t, err)
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(peer, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "query", string(buf), "data should follow the header")
+	require.NoError(t, peer.Close())
+	require.NoError(t, <-done)
+
+	header := <-headers
+	require.NotNil(t, header)
+	src, dst, ok := header.TCPAddrs()
+	require.True(t, ok)
+	assert.Equal(t, "100.64.0.7:40000", src.String())
+	assert.Equal(t, "100.64.0.1:5432", dst.String())
+
+	tlvs, err := header.TLVs()
+	require.NoError(t, err)
+	ssl, ok := tlvparse.FindSSL(tlvs)
+	require.True(t, ok)
+	cn, _ := ssl.ClientCN()
+	assert.Equal(t, "alice", cn)
+}
+
+func TestHandle_ForwardClientCertDialRetries(t *testing.T) {
+	var dials atomic.Int32
+	received := make(chan []byte, 2)
+	h := &Handler{
+		Upstream:          "10.0.0.1:5432",
+		ForwardClientCert: true,
+		DialRetries:       1,
+		dial: func(context.Context, string, string) (net.Conn, error) {
+			n := dials.Add(1)
+			conn, server := net.Pipe()
+			go func() {
+				defer server.Close()
+				r := bufio.NewReader(server)
+				if _, err := proxyproto.Read(r); err != nil {
+					return
+				}
+				if n == 1 {
+					// Reset right after the header, so the conn is redialed.
+					return
+				}
+				data := make([]byte, 5)
+				if _, err := io.ReadFull(r, data); err != nil {
+					return
+				}
+				received <- data
+				_, _ = server.Write(data)
+			}()
+			if n == 1 {
+				return resetConn{conn}, nil
+			}
+			return conn, nil
+		},
+		logger: zap.NewNop(),
+	}
+
+	downstream, peer := net.Pipe()
+	defer downstream.Close()
+	cx := layer4.WrapConnection(tcpAddrConn{downstream}, nil, zap.NewNop())
+
+	done := make(chan error, 1)
+	go func() {
+		done <- h.Handle(cx, nil)
+	}()
+
+	_, err := peer.Write([]byte("query"))
+	require.NoError(t, err)
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(peer, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "query", string(buf))
+	require.NoError(t, peer.Close())
+	require.NoError(t, <-done)
+
+	assert.Equal(t, int32(2), dials.Load(), "the upstream must be redialed")
+	assert.Equal(t, "query", string(<-received), "the redialed conn gets a fresh header followed by the client data")
+}
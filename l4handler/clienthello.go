@@ -0,0 +1,67 @@
+package l4handler
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// errHelloRead aborts the handshake once the ClientHello was parsed.
+var errHelloRead = errors.New("client hello read")
+
+// readClientHello reads the TLS ClientHello from r and returns the bytes
+// read, unchanged, together with the requested server name. The bytes are
+// to be replayed to the upstream, so the backend sees the original
+// ClientHello. Data that isn't TLS yields the bytes read so far and an
+// empty server name.
+func readClientHello(r io.Reader) ([]byte, string, error) {
+	var buf bytes.Buffer
+	conn := &helloConn{r: io.TeeReader(r, &buf)}
+
+	var serverName string
+	var parsed bool
+	err := tls.Server(conn, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName, parsed = hello.ServerName, true
+			return nil, errHelloRead
+		},
+	}).Handshake()
+
+	switch {
+	case parsed:
+		return buf.Bytes(), serverName, nil
+	case conn.readErr != nil:
+		return nil, "", conn.readErr
+	case err == nil:
+		return nil, "", errors.New("unexpected handshake completion")
+	default:
+		// Not a TLS ClientHello.
+		return buf.Bytes(), "", nil
+	}
+}
+
+// helloConn feeds a server-side TLS handshake from a reader and discards
+// everything the handshake writes, such as alerts.
+type helloConn struct {
+	r       io.Reader
+	readErr error
+}
+
+func (c *helloConn) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if err != nil {
+		c.readErr = err
+	}
+	return n, err
+}
+
+func (c *helloConn) Write(p []byte) (int, error)      { return 0, io.ErrClosedPipe }
+func (c *helloConn) Close() error                     { return nil }
+func (c *helloConn) LocalAddr() net.Addr              { return nil }
+func (c *helloConn) RemoteAddr() net.Addr             { return nil }
+func (c *helloConn) SetDeadline(time.Time) error      { return nil }
+func (c *helloConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *helloConn) SetWriteDeadline(time.Time) error { return nil }
@@ -0,0 +1,146 @@
+package l4handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/mholt/caddy-l4/layer4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// recordingConn captures the first write of a TLS client, its ClientHello,
+// and fails it to end the handshake.
+type recordingConn struct {
+	net.Conn
+	written []byte
+}
+
+func (c *recordingConn) Write(p []byte) (int, error) {
+	c.written = append(c.written, p...)
+	return 0, errors.New("recorded")
+}
+
+// clientHello returns the ClientHello a Go TLS client sends for serverName.
+func clientHello(t *testing.T, serverName string) []byte {
+	t.Helper()
+
+	conn := &recordingConn{}
+	err := tls.Client(conn, &tls.Config{ServerName: serverName}).Handshake()
+	require.Error(t, err)
+	require.NotEmpty(t, conn.written)
+	return conn.written
+}
+
+func TestReadClientHello(t *testing.T) {
+	hello := clientHello(t, "app.example.com")
+	input := append(bytes.Clone(hello), "early data"...)
+
+	r := bytes.NewReader(input)
+	raw, name, err := readClientHello(r)
+	require.NoError(t, err)
+	assert.Equal(t, "app.example.com", name)
+
+	replayed, err := io.ReadAll(io.MultiReader(bytes.NewReader(raw), r))
+	require.NoError(t, err)
+	assert.Equal(t, input, replayed, "no bytes should be lost in the peek and replay")
+}
+
+func TestReadClientHello_NotTLS(t *testing.T) {
+	input := []byte("SSH-2.0-OpenSSH_9.6\r\n")
+
+	r := bytes.NewReader(input)
+	raw, name, err := readClientHello(r)
+	require.NoError(t, err)
+	assert.Empty(t, name)
+
+	replayed, err := io.ReadAll(io.MultiReader(bytes.NewReader(raw), r))
+	require.NoError(t, err)
+	assert.Equal(t, input, replayed)
+}
+
+func TestReadClientHello_Truncated(t *testing.T) {
+	hello := clientHello(t, "app.example.com")
+
+	_, _, err := readClientHello(bytes.NewReader(hello[:len(hello)/2]))
+	assert.Error(t, err)
+}
+
+func TestReadClientHello_Timeout(t *testing.T) {
+	hello := clientHello(t, "app.example.com")
+	downstream, client := net.Pipe()
+	defer downstream.Close()
+	defer client.Close()
+
+	// Half a ClientHello, then nothing.
+	go func() { _, _ = client.Write(hello[:len(hello)/2]) }()
+	err := readWithTimeout(downstream, 50*time.Millisecond, func() error {
+		_, _, err := readClientHello(downstream)
+		return err
+	})
+	assert.True(t, isTimeout(err), "a stalled client must time out: %v", err)
+}
+
+func TestUnmarshalCaddyfile_PeekSNI(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`netbird 10.0.0.1:443 {
+		sni app.example.com web
+		peek_sni
+	}`)
+
+	var h Handler
+	require.NoError(t, h.UnmarshalCaddyfile(d))
+	assert.True(t, h.PeekSNI)
+}
+
+func TestHandle_PeekSNI(t *testing.T) {
+	hello := clientHello(t, "app.example.com")
+
+	received := make(chan []byte, 1)
+	recordDial := func(context.Context, string, string) (net.Conn, error) {
+		conn, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			data, _ := io.ReadAll(server)
+			received <- data
+		}()
+		return conn, nil
+	}
+	failDial := func(context.Context, string, string) (net.Conn, error) {
+		return nil, errors.New("default target should not be dialed")
+	}
+
+	h := &Handler{
+		Upstream: "10.0.0.1:443",
+		PeekSNI:  true,
+		dial:     failDial,
+		routes: map[string]target{
+			"app.example.com": {node: "web", upstream: "10.0.0.2:443", dial: recordDial},
+		},
+		logger: zap.NewNop(),
+	}
+
+	downstream, client := net.Pipe()
+	cx := layer4.WrapConnection(tcpAddrConn{downstream}, nil, zap.NewNop())
+
+	done := make(chan error, 1)
+	go func() {
+		done <- h.Handle(cx, nil)
+	}()
+
+	go func() {
+		_, _ = client.Write(hello)
+		_ = client.Close()
+	}()
+	require.NoError(t, <-done)
+	downstream.Close()
+
+	assert.Equal(t, hello, <-received, "the upstream should receive the ClientHello unchanged")
+}
@@ -0,0 +1,67 @@
+package l4handler
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// maxDSCP is the largest 6-bit DSCP value.
+const maxDSCP = 63
+
+// parseDSCP parses a DSCP value given as a number from 0 to 63 or as a
+// class name such as EF, AF41 or CS5.
+func parseDSCP(s string) (int, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n < 0 || n > maxDSCP {
+			return 0, fmt.Errorf("dscp %d out of range 0-%d", n, maxDSCP)
+		}
+		return n, nil
+	}
+
+	name := strings.ToUpper(s)
+	switch {
+	case name == "EF":
+		return 46, nil
+	case len(name) == 3 && strings.HasPrefix(name, "CS") && name[2] >= '0' && name[2] <= '7':
+		return int(name[2]-'0') << 3, nil
+	case len(name) == 4 && strings.HasPrefix(name, "AF") &&
+		name[2] >= '1' && name[2] <= '4' && name[3] >= '1' && name[3] <= '3':
+		return int(name[2]-'0')<<3 | int(name[3]-'0')<<1, nil
+	}
+	return 0, fmt.Errorf("unknown dscp %q, use 0-%d or a class name like EF, AF41 or CS5", s, maxDSCP)
+}
+
+// setDSCP marks the packets sent on conn with the DSCP value, via the IPv4
+// TOS or IPv6 traffic class field. It reports false for conns that aren't
+// backed by an OS socket, such as conns inside the NetBird tunnel.
+func setDSCP(conn net.Conn, dscp int) (bool, error) {
+	if _, ok := conn.(syscall.Conn); !ok {
+		return false, nil
+	}
+
+	var ip net.IP
+	switch addr := conn.LocalAddr().(type) {
+	case *net.TCPAddr:
+		ip = addr.IP
+	case *net.UDPAddr:
+		ip = addr.IP
+	}
+
+	tos := dscp << 2
+	if ip != nil && ip.To4() == nil {
+		if err := ipv6.NewConn(conn).SetTrafficClass(tos); err != nil {
+			return false, fmt.Errorf("set traffic class: %w", err)
+		}
+		return true, nil
+	}
+	if err := ipv4.NewConn(conn).SetTOS(tos); err != nil {
+		return false, fmt.Errorf("set tos: %w", err)
+	}
+	return true, nil
+}
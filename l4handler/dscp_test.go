@@ -0,0 +1,127 @@
+package l4handler
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/net/ipv4"
+)
+
+func TestParseDSCP(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int
+		wantErr bool
+	}{
+		{"0", 0, false},
+		{"46", 46, false},
+		{"63", 63, false},
+		{"64", 0, true},
+		{"-1", 0, true},
+		{"EF", 46, false},
+		{"ef", 46, false},
+		{"CS0", 0, false},
+		{"CS5", 40, false},
+		{"CS8", 0, true},
+		{"AF11", 10, false},
+		{"AF41", 34, false},
+		{"AF43", 38, false},
+		{"AF44", 0, true},
+		{"AF51", 0, true},
+		{"BE", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseDSCP(tt.input)
+		if tt.wantErr {
+			assert.Error(t, err, tt.input)
+			continue
+		}
+		require.NoError(t, err, tt.input)
+		assert.Equal(t, tt.want, got, tt.input)
+	}
+}
+
+func TestSetDSCP_TCPConn(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			conn.Close()
+		}
+	}()
+
+	conn, err := net.Dial("tcp4", ln.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	applied, err := setDSCP(conn, 46)
+	if err != nil {
+		t.Skipf("setting TOS not permitted here: %v", err)
+	}
+	require.True(t, applied)
+
+	tos, err := ipv4.NewConn(conn).TOS()
+	require.NoError(t, err)
+	assert.Equal(t, 46<<2, tos)
+}
+
+func TestDialUpstream_DSCP(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			conn.Close()
+		}
+	}()
+
+	var d net.Dialer
+	h := &Handler{DSCP: 46, logger: zap.NewNop()}
+	up, err := h.dialUpstream(context.Background(), "tcp4", target{upstream: ln.Addr().String(), dial: d.DialContext})
+	require.NoError(t, err)
+	defer up.Close()
+
+	tos, err := ipv4.NewConn(up).TOS()
+	require.NoError(t, err)
+	if tos == 0 {
+		t.Skip("setting TOS not permitted here")
+	}
+	assert.Equal(t, 46<<2, tos, "the upstream conn must be marked")
+}
+
+func TestSetDSCP_Unsupported(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	applied, err := setDSCP(client, 46)
+	require.NoError(t, err)
+	assert.False(t, applied)
+}
+
+func TestUnmarshalCaddyfile_DSCP(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`netbird 10.0.0.1:5060 {
+		dscp EF
+	}`)
+	var h Handler
+	require.NoError(t, h.UnmarshalCaddyfile(d))
+	assert.Equal(t, 46, h.DSCP)
+
+	for _, input := range []string{
+		"netbird 10.0.0.1:5060 {\n dscp\n}",
+		"netbird 10.0.0.1:5060 {\n dscp 64\n}",
+	} {
+		var h Handler
+		assert.Error(t, h.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)), input)
+	}
+}
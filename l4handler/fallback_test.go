@@ -0,0 +1,78 @@
+package l4handler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+var errTunnelDown = errors.New("no route to peer")
+
+func failingDial(context.Context, string, string) (net.Conn, error) {
+	return nil, errTunnelDown
+}
+
+func TestDialUpstream_FallbackDirect(t *testing.T) {
+	core, logs := observer.New(zap.WarnLevel)
+	var dials atomic.Int32
+	h := &Handler{FallbackDirect: true, logger: zap.New(core), directDial: echoDialer(&dials)}
+
+	up, err := h.dialUpstream(context.Background(), "tcp", target{node: "web", upstream: "backend:5432", dial: failingDial})
+	require.NoError(t, err)
+	defer up.Close()
+	assert.Equal(t, int32(1), dials.Load())
+
+	_, err = up.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(up, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(buf))
+
+	require.Equal(t, 1, logs.Len())
+	fields := logs.All()[0].ContextMap()
+	assert.Equal(t, "web", fields["node"])
+	assert.Equal(t, "backend:5432", fields["upstream"])
+}
+
+func TestDialUpstream_NoFallbackByDefault(t *testing.T) {
+	var dials atomic.Int32
+	h := &Handler{logger: zap.NewNop(), directDial: echoDialer(&dials)}
+
+	_, err := h.dialUpstream(context.Background(), "tcp", target{node: "web", upstream: "backend:5432", dial: failingDial})
+	require.ErrorIs(t, err, errTunnelDown)
+	assert.Zero(t, dials.Load(), "direct dials are opt-in")
+}
+
+func TestDialUpstream_FallbackDirectFails(t *testing.T) {
+	errDirect := errors.New("connection refused")
+	h := &Handler{
+		FallbackDirect: true,
+		logger:         zap.NewNop(),
+		directDial: func(context.Context, string, string) (net.Conn, error) {
+			return nil, errDirect
+		},
+	}
+
+	_, err := h.dialUpstream(context.Background(), "tcp", target{node: "web", upstream: "backend:5432", dial: failingDial})
+	require.ErrorIs(t, err, errTunnelDown)
+	require.ErrorIs(t, err, errDirect)
+}
+
+func TestUnmarshalCaddyfile_FallbackDirect(t *testing.T) {
+	var h Handler
+	require.NoError(t, h.UnmarshalCaddyfile(caddyfile.NewTestDispenser("netbird backend:5432 {\n fallback_direct\n}")))
+	assert.True(t, h.FallbackDirect)
+
+	var bad Handler
+	assert.Error(t, bad.UnmarshalCaddyfile(caddyfile.NewTestDispenser("netbird backend:5432 {\n fallback_direct yes\n}")))
+}
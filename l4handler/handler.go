@@ -3,10 +3,16 @@
 package l4handler
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
@@ -23,16 +29,187 @@ func init() {
 // Handler is a layer4 handler that proxies connections through a NetBird
 // network tunnel to the configured upstream.
 type Handler struct {
-	// Upstream is the host:port to dial via the NetBird network.
-	Upstream string `json:"upstream"`
+	// Upstream is the host:port to dial via the NetBird network. Required
+	// unless OriginalDestination is set.
+	Upstream string `json:"upstream,omitempty"`
+	// Upstreams are further upstreams. Connections to the handler's
+	// upstream are spread over it, with weight 1, and these by weighted
+	// round-robin. Can't be combined with OriginalDestination.
+	Upstreams []WeightedUpstream `json:"upstreams,omitempty"`
+	// HealthCheckInterval enables dialing the handler's upstream and
+	// Upstreams through the node at this interval. Upstreams that can't
+	// be dialed are skipped by new connections until a later check
+	// succeeds. Requires Upstreams and a static Node. Checks dial over TCP,
+	// or over Network if set; a UDP dial only detects unreachable routes.
+	HealthCheckInterval caddy.Duration `json:"health_check_interval,omitempty"`
+	// HealthCheckTimeout bounds each health check dial (default: 5s).
+	HealthCheckTimeout caddy.Duration `json:"health_check_timeout,omitempty"`
+	// OriginalDestination dials the address each TCP connection was
+	// originally sent to before an iptables or nftables REDIRECT/DNAT rule
+	// sent it to Caddy, instead of a fixed upstream. This allows transparent
+	// proxying of traffic destined to NetBird ranges. Linux only.
+	OriginalDestination bool `json:"original_destination,omitempty"`
 	// Node is the name of the NetBird node to use for dialing.
 	// Must match a node defined in the top-level netbird app config.
-	// Defaults to "default" if empty.
+	// Defaults to the app's default_node, or "default", if empty. It may
+	// contain placeholders, e.g. {l4.tls.server_name}, which are resolved
+	// per connection; the resolved name must be a node defined in the app
+	// config. Clients of resolved nodes are started on first use and kept
+	// until the handler is cleaned up.
 	Node string `json:"node,omitempty"`
+	// Tenant is the tenant the handler belongs to. It may only use the
+	// nodes the netbird app reserves for this tenant, including nodes of
+	// routes and resolved placeholders.
+	Tenant string `json:"tenant,omitempty"`
+	// LogConnections logs an info-level event with structured fields when
+	// a connection is opened and closed.
+	LogConnections bool `json:"log_connections,omitempty"`
+	// TCPKeepAlive enables TCP keep-alive probes with the given period on the
+	// downstream and upstream connections. Connections that don't support
+	// keep-alive are left unchanged.
+	TCPKeepAlive caddy.Duration `json:"tcp_keepalive,omitempty"`
+	// Linger sets SO_LINGER on the downstream and upstream TCP connections,
+	// in whole seconds: closing blocks until unsent data is delivered or
+	// the period expires. Zero resets the connection on close, discarding
+	// unsent data and skipping TIME_WAIT. Unset keeps the OS default.
+	// Connections that don't support it are left unchanged.
+	Linger *caddy.Duration `json:"linger,omitempty"`
+	// DSCP marks the packets sent to the client and to the upstream with
+	// this DSCP value (0-63) so network equipment can prioritize them.
+	// Applied to conns backed by an OS socket, such as the client's conn
+	// and upstreams dialed directly with FallbackDirect; conns inside the
+	// tunnel and the WireGuard socket of the embedded client can't be
+	// marked.
+	DSCP int `json:"dscp,omitempty"`
+	// ReuseConnections keeps UDP upstream connections open after the
+	// downstream session ends and hands them to the next session of the
+	// same client instead of dialing again, so late replies can't reach
+	// another client. TCP connections are always dialed per session.
+	ReuseConnections bool `json:"reuse_connections,omitempty"`
+	// SNI routes TLS connections to other nodes and upstreams by the server
+	// name of the ClientHello. The server name is taken from caddy-l4's tls
+	// matcher, so the route must use one. Connections without a server name
+	// or without a matching route go to Node and Upstream.
+	SNI []SNIRoute `json:"sni,omitempty"`
+	// Postgres routes PostgreSQL connections to other nodes and upstreams by
+	// the database or user in the startup message. The first matching
+	// route wins; other connections go to Node and Upstream. SSL requests
+	// are declined, so clients must allow unencrypted connections, e.g.
+	// with sslmode=prefer or disable.
+	Postgres []PostgresRoute `json:"postgres,omitempty"`
+	// Ports routes connections to other upstreams and nodes by the local
+	// port they were accepted on, so one handler can serve several
+	// services. SNI and Postgres routes take precedence; connections on
+	// other ports go to Node and Upstream.
+	Ports []PortRoute `json:"ports,omitempty"`
+	// PeekSNI reads the ClientHello of TCP connections to pick the SNI
+	// route when no tls matcher recorded a server name, and forwards it to
+	// the upstream unchanged, so TLS is passed through with the original
+	// SNI. Connections that aren't TLS are forwarded unchanged to Node and
+	// Upstream. Requires SNI routes; can't be combined with Postgres.
+	PeekSNI bool `json:"peek_sni,omitempty"`
+	// DialRetries is how often a TCP upstream is redialed when dialing it
+	// fails or the connection is reset before any data went either way,
+	// e.g. while the backend restarts. Nothing is replayed: once a byte was
+	// written to or read from the upstream, or it closed cleanly, errors end
+	// the connection as usual.
+	DialRetries int `json:"dial_retries,omitempty"`
+	// FallbackDirect retries failed tunnel dials with a plain dial on the
+	// local network, for upstreams reachable both through NetBird and
+	// locally. Policies enforced at dial time, such as disable_relays, are
+	// bypassed by the fallback.
+	FallbackDirect bool `json:"fallback_direct,omitempty"`
+	// AllowPeers only accepts downstream connections from these NetBird
+	// peers, given as IP addresses, CIDRs or peer FQDNs. FQDNs are matched
+	// against the peer's NetBird IP from the last health check of the node
+	// the connection is proxied through. Other connections are closed. All
+	// sources are accepted if empty.
+	AllowPeers []string `json:"allow_peers,omitempty"`
+	// Network overrides the network the upstream is dialed with, which is
+	// otherwise inferred from the listener: tcp4, tcp6, udp4 or udp6. It
+	// pins the IP family when the upstream resolves to both.
+	Network string `json:"network,omitempty"`
+	// ForwardClientCert sends a PROXY protocol v2 header to TCP upstreams
+	// before any data. If caddy-l4's tls handler terminated TLS earlier in
+	// the route, the header carries the TLS version and cipher and the
+	// common name and certificate of the client, if it presented one, so
+	// mTLS-aware backends see the original client identity. The upstream
+	// must expect the PROXY protocol. With DialRetries, each redialed
+	// connection gets its own header.
+	ForwardClientCert bool `json:"forward_client_cert,omitempty"`
 
-	nbApp  *app.App
-	mc     *app.ManagedClient
-	logger *zap.Logger
+	nbApp *app.App
+	mc    *app.ManagedClient
+	dial  dialFunc
+	// origDst returns the original destination of a redirected conn.
+	origDst func(net.Conn) (string, error)
+	// startNode acquires the client of a node, starting it unless lazy
+	// start is disabled, and returns its dial func. The handler holds a
+	// reference to the client until releaseNode is called.
+	startNode   func(ctx context.Context, node string) (dialFunc, error)
+	releaseNode func(node string) error
+	// trackConn records a proxied connection in the per-node resource
+	// usage and returns a func to call once it is closed.
+	trackConn func(node string, usage app.ConnUsage) (done func())
+	// directDial dials on the local network for FallbackDirect.
+	directDial dialFunc
+	// ctx is the provision context, cancelled once the config is unloaded.
+	ctx context.Context
+	// nodes holds the client starts of nodes other than a static Node.
+	nodesMu  sync.Mutex
+	nodes    map[string]*nodeStart
+	routes   map[string]target
+	pgRoutes []pgRoute
+	// portRoutes holds the targets of the port routes by local port.
+	portRoutes map[uint16]target
+
+	pool     *connPool
+	balancer *upstreamBalancer
+	allow    *peerAllowlist
+	logger   *zap.Logger
+	// checkCancel stops the upstream health checks, checkDone is closed
+	// once they stopped.
+	checkCancel context.CancelFunc
+	checkDone   chan struct{}
+}
+
+// SNIRoute maps a TLS server name to the node and upstream its
+// connections are proxied through.
+type SNIRoute struct {
+	// ServerName is the server name to match, case-insensitively.
+	// A leading "*." matches exactly one label, e.g. "*.example.com"
+	// matches "app.example.com".
+	ServerName string `json:"server_name"`
+	// Node is the name of the NetBird node to use for dialing.
+	Node string `json:"node"`
+	// Upstream is the host:port to dial. Defaults to the handler's upstream.
+	Upstream string `json:"upstream,omitempty"`
+}
+
+type dialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// nodeStart is the start of a node's client by nodeDialer. dial and err are
+// set once done is closed.
+type nodeStart struct {
+	done chan struct{}
+	dial dialFunc
+	err  error
+}
+
+// connUsage approximates the resources of a proxied connection: the
+// handler's goroutine and the copy goroutine with their io.Copy buffers.
+var connUsage = app.ConnUsage{Goroutines: 2, BufferBytes: 2 * 32 << 10}
+
+// routeReadTimeout bounds reading the data a connection is routed by, such
+// as the TLS ClientHello or the postgres startup message, so clients that
+// send nothing don't hold the handler.
+const routeReadTimeout = 10 * time.Second
+
+// target is a node and upstream a connection is proxied to.
+type target struct {
+	node     string
+	upstream string
+	dial     dialFunc
 }
 
 // CaddyModule returns the Caddy module information.
@@ -48,8 +225,36 @@ func (*Handler) CaddyModule() caddy.ModuleInfo {
 func (h *Handler) Provision(ctx caddy.Context) error {
 	h.logger = ctx.Logger()
 
-	if h.Node == "" {
-		h.Node = "default"
+	if h.OriginalDestination {
+		if h.Upstream != "" || len(h.Upstreams) > 0 {
+			return errors.New("upstream and original_destination are mutually exclusive")
+		}
+		h.origDst = originalDst
+	}
+	if err := h.validateUpstreams(); err != nil {
+		return err
+	}
+	if h.PeekSNI && len(h.SNI) == 0 {
+		return errors.New("peek_sni requires sni routes")
+	}
+	if h.PeekSNI && len(h.Postgres) > 0 {
+		return errors.New("peek_sni and postgres_route are mutually exclusive")
+	}
+
+	if h.Network != "" && !validDialNetwork(h.Network) {
+		return fmt.Errorf("network must be one of tcp4, tcp6, udp4 or udp6, got %q", h.Network)
+	}
+	if h.HealthCheckInterval < 0 || h.HealthCheckTimeout < 0 {
+		return errors.New("health_check_interval and health_check_timeout must not be negative")
+	}
+	if h.HealthCheckInterval > 0 && len(h.Upstreams) == 0 {
+		return errors.New("health_check_interval requires upstreams")
+	}
+	if h.HealthCheckInterval > 0 && h.dynamicNode() {
+		return errors.New("health_check_interval requires a node without placeholders")
+	}
+	if h.Linger != nil && (*h.Linger < 0 || time.Duration(*h.Linger)%time.Second != 0) {
+		return errors.New("linger must be a non-negative number of whole seconds")
 	}
 
 	appModule, err := ctx.App("netbird")
@@ -57,14 +262,53 @@ func (h *Handler) Provision(ctx caddy.Context) error {
 		return fmt.Errorf("load netbird app module: %w", err)
 	}
 	h.nbApp = appModule.(*app.App)
+	h.ctx = ctx
+	if h.Node == "" {
+		h.Node = h.nbApp.DefaultNodeName()
+	}
+	h.startNode = func(ctx context.Context, node string) (dialFunc, error) {
+		mc, err := h.nbApp.AcquireClient(ctx, h.Tenant, node)
+		if err != nil {
+			return nil, err
+		}
+		return mc.DialContext, nil
+	}
+	h.releaseNode = h.nbApp.ReleaseClient
+	h.trackConn = h.nbApp.TrackConn
+	if h.directDial == nil {
+		var d net.Dialer
+		h.directDial = d.DialContext
+	}
 
-	h.mc, err = h.nbApp.GetClient(h.Node)
-	if err != nil {
-		return fmt.Errorf("get netbird client %q: %w", h.Node, err)
+	if !h.dynamicNode() {
+		h.mc, err = h.nbApp.AcquireClient(ctx, h.Tenant, h.Node)
+		if err != nil {
+			return err
+		}
+		h.dial = h.mc.DialContext
 	}
 
-	if err := h.mc.Start(ctx); err != nil {
-		return fmt.Errorf("start netbird client %q: %w", h.Node, err)
+	if err := h.provisionRoutes(ctx); err != nil {
+		return err
+	}
+
+	if h.ReuseConnections {
+		h.pool = newConnPool(maxIdleConnsPerKey, maxIdleConns)
+	}
+	if len(h.Upstreams) > 0 {
+		h.balancer = newUpstreamBalancer(h.balancedUpstreams())
+	}
+	if h.HealthCheckInterval > 0 {
+		timeout := time.Duration(h.HealthCheckTimeout)
+		if timeout <= 0 {
+			timeout = defaultUpstreamCheckTimeout
+		}
+		h.startUpstreamChecks(time.Duration(h.HealthCheckInterval), timeout)
+	}
+	if len(h.AllowPeers) > 0 {
+		h.allow = newPeerAllowlist(h.AllowPeers, func(node, fqdn string) (string, bool) {
+			return h.nbApp.PeerIP(h.Tenant, node, fqdn)
+		})
 	}
 
 	h.logger.Info("netbird l4 handler provisioned",
@@ -74,24 +318,364 @@ func (h *Handler) Provision(ctx caddy.Context) error {
 	return nil
 }
 
+// balancedUpstreams returns the upstreams connections to the handler's
+// upstream are spread over.
+func (h *Handler) balancedUpstreams() []WeightedUpstream {
+	var upstreams []WeightedUpstream
+	if h.Upstream != "" {
+		upstreams = append(upstreams, WeightedUpstream{Address: h.Upstream, Weight: 1})
+	}
+	return append(upstreams, h.Upstreams...)
+}
+
+// validateUpstreams checks that the upstream is set unless original
+// destinations are dialed, and that it and the route upstreams are valid
+// host:port addresses, so config mistakes fail at load time rather than
+// on every connection.
+func (h *Handler) validateUpstreams() error {
+	if h.Upstream == "" && len(h.Upstreams) == 0 && !h.OriginalDestination {
+		return errors.New("upstream is required unless original_destination is set")
+	}
+	if h.Upstream != "" {
+		if err := validateUpstream(h.Upstream); err != nil {
+			return fmt.Errorf("invalid upstream: %w", err)
+		}
+	}
+	for _, u := range h.Upstreams {
+		if err := validateUpstream(u.Address); err != nil {
+			return fmt.Errorf("invalid upstream: %w", err)
+		}
+		if u.Weight < 0 {
+			return fmt.Errorf("weight of upstream %s must not be negative", u.Address)
+		}
+	}
+	for _, route := range h.SNI {
+		if route.Upstream == "" {
+			continue
+		}
+		if err := validateUpstream(route.Upstream); err != nil {
+			return fmt.Errorf("invalid upstream of sni route %q: %w", route.ServerName, err)
+		}
+	}
+	for _, route := range h.Postgres {
+		if route.Upstream == "" {
+			continue
+		}
+		if err := validateUpstream(route.Upstream); err != nil {
+			return fmt.Errorf("invalid upstream of postgres route to node %q: %w", route.Node, err)
+		}
+	}
+	for _, route := range h.Ports {
+		if route.Upstream == "" {
+			continue
+		}
+		if err := validateUpstream(route.Upstream); err != nil {
+			return fmt.Errorf("invalid upstream of port route %d: %w", route.Port, err)
+		}
+	}
+	return nil
+}
+
+// validateUpstream checks that addr is a host:port with a numeric port.
+func validateUpstream(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "" {
+		return fmt.Errorf("%q is missing a host", addr)
+	}
+	if n, err := strconv.ParseUint(port, 10, 16); err != nil || n == 0 {
+		return fmt.Errorf("%q has an invalid port %q", addr, port)
+	}
+	return nil
+}
+
+// provisionRoutes starts the clients of the nodes used by SNI, PostgreSQL
+// and port routes, and indexes SNI routes by server name.
+func (h *Handler) provisionRoutes(ctx caddy.Context) error {
+	if len(h.SNI) > 0 {
+		h.routes = make(map[string]target, len(h.SNI))
+	}
+	for _, route := range h.SNI {
+		if route.ServerName == "" || route.Node == "" {
+			return errors.New("sni route requires a server name and a node")
+		}
+		serverName := strings.ToLower(route.ServerName)
+		if _, ok := h.routes[serverName]; ok {
+			return fmt.Errorf("duplicate sni route for %q", route.ServerName)
+		}
+
+		tgt, err := h.routeTarget(ctx, route.Node, route.Upstream)
+		if err != nil {
+			return err
+		}
+		h.routes[serverName] = tgt
+	}
+
+	for _, route := range h.Postgres {
+		if (route.Database == "") == (route.User == "") || route.Node == "" {
+			return errors.New("postgres route requires either a database or a user, and a node")
+		}
+
+		tgt, err := h.routeTarget(ctx, route.Node, route.Upstream)
+		if err != nil {
+			return err
+		}
+		h.pgRoutes = append(h.pgRoutes, pgRoute{database: route.Database, user: route.User, target: tgt})
+	}
+	return h.provisionPortRoutes(ctx)
+}
+
+// routeTarget returns the target of a route, defaulting to the handler's upstream.
+func (h *Handler) routeTarget(ctx caddy.Context, node, upstream string) (target, error) {
+	dial, err := h.nodeDialer(ctx, node)
+	if err != nil {
+		return target{}, err
+	}
+	if upstream == "" {
+		upstream = h.Upstream
+	}
+	return target{node: node, upstream: upstream, dial: dial}, nil
+}
+
+// nodeDialer returns the dial func of the named node, starting its client
+// on first use. Concurrent callers wait for a single start, which uses the
+// provision context, as the client outlives the connection asking for it.
+// Failed starts are retried by the next caller.
+func (h *Handler) nodeDialer(ctx context.Context, node string) (dialFunc, error) {
+	if node == h.Node && h.dial != nil {
+		return h.dial, nil
+	}
+
+	h.nodesMu.Lock()
+	start, ok := h.nodes[node]
+	if !ok {
+		start = &nodeStart{done: make(chan struct{})}
+		if h.nodes == nil {
+			h.nodes = make(map[string]*nodeStart)
+		}
+		h.nodes[node] = start
+	}
+	h.nodesMu.Unlock()
+
+	if ok {
+		select {
+		case <-start.done:
+			return start.dial, start.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	startCtx := h.ctx
+	if startCtx == nil {
+		startCtx = context.Background()
+	}
+	dial, err := h.startNode(startCtx, node)
+
+	h.nodesMu.Lock()
+	defer h.nodesMu.Unlock()
+	start.dial, start.err = dial, err
+	close(start.done)
+	current := h.nodes[node] == start
+	if err != nil {
+		if current {
+			delete(h.nodes, node)
+		}
+		return nil, err
+	}
+	if !current {
+		// Cleanup ran while starting and didn't take over the reference.
+		if err := h.releaseNode(node); err != nil {
+			h.logger.Debug("release node started during cleanup", zap.String("node", node), zap.Error(err))
+		}
+		return nil, fmt.Errorf("node %q: handler was cleaned up", node)
+	}
+	return dial, nil
+}
+
+// replaceNode resolves the placeholders of a dynamic node name for cx.
+func replaceNode(cx *layer4.Connection, node string) string {
+	repl, ok := cx.Context.Value(layer4.ReplacerCtxKey).(*caddy.Replacer)
+	if !ok {
+		repl = caddy.NewReplacer()
+	}
+	return repl.ReplaceAll(node, "")
+}
+
+// dynamicNode reports whether Node contains placeholders resolved per connection.
+func (h *Handler) dynamicNode() bool {
+	return strings.Contains(h.Node, "{")
+}
+
+// resolveNode resolves the placeholders of a dynamic Node for cx and
+// returns the target of the resolved node. Only nodes defined in the app
+// config are accepted, so connection metadata can't create new clients.
+func (h *Handler) resolveNode(cx *layer4.Connection, tgt target) (target, error) {
+	node := replaceNode(cx, h.Node)
+	if node == "" {
+		return tgt, fmt.Errorf("node %q resolved to an empty name", h.Node)
+	}
+	if !h.nbApp.HasNode(node) {
+		return tgt, fmt.Errorf("node %q resolved to unknown node %q", h.Node, node)
+	}
+
+	dial, err := h.nodeDialer(cx.Context, node)
+	if err != nil {
+		return tgt, err
+	}
+	tgt.node = node
+	tgt.dial = dial
+	return tgt, nil
+}
+
+// targetFor returns the target for a TLS server name, or fallback if no SNI
+// route matches. Exact routes take precedence over wildcards.
+func (h *Handler) targetFor(serverName string, fallback target) target {
+	if serverName != "" && len(h.routes) > 0 {
+		serverName = strings.ToLower(serverName)
+		if t, ok := h.routes[serverName]; ok {
+			return t
+		}
+		if _, parent, ok := strings.Cut(serverName, "."); ok {
+			if t, ok := h.routes["*."+parent]; ok {
+				return t
+			}
+		}
+	}
+	return fallback
+}
+
+// defaultTarget returns the handler's node and upstream.
+func (h *Handler) defaultTarget() target {
+	return target{node: h.Node, upstream: h.Upstream, dial: h.dial}
+}
+
+// serverName returns the TLS server name recorded by caddy-l4's tls matcher,
+// or an empty string for non-TLS connections.
+func serverName(cx *layer4.Connection) string {
+	repl, ok := cx.Context.Value(layer4.ReplacerCtxKey).(*caddy.Replacer)
+	if !ok {
+		return ""
+	}
+	name, _ := repl.GetString("l4.tls.server_name")
+	return name
+}
+
 // Handle dials the upstream through the NetBird tunnel and proxies
 // the connection bidirectionally.
 func (h *Handler) Handle(cx *layer4.Connection, _ layer4.Handler) error {
 	network := networkFromAddr(cx.LocalAddr())
+	portTgt := h.portTargetFor(cx.LocalAddr())
+	tgt := h.targetFor(serverName(cx), portTgt)
+	start := time.Now()
+
+	if h.allow != nil && !h.allow.allows(cx.RemoteAddr(), replaceNode(cx, tgt.node)) {
+		err := fmt.Errorf("source %s is not an allowed peer", cx.RemoteAddr())
+		h.logConnectionClosed(cx.RemoteAddr(), network, tgt, 0, 0, time.Since(start), err)
+		return err
+	}
+
+	// The downstream side, including bytes read to pick the target.
+	var src io.Reader = cx
+	if h.PeekSNI && network == "tcp" && serverName(cx) == "" {
+		var hello []byte
+		var name string
+		err := readWithTimeout(cx, routeReadTimeout, func() (err error) {
+			hello, name, err = readClientHello(cx)
+			return err
+		})
+		if err != nil {
+			h.logConnectionClosed(cx.RemoteAddr(), network, tgt, 0, 0, time.Since(start), err)
+			return fmt.Errorf("read tls client hello: %w", err)
+		}
+		tgt = h.targetFor(name, portTgt)
+		src = io.MultiReader(bytes.NewReader(hello), cx)
+	}
+	if len(h.pgRoutes) > 0 && network == "tcp" {
+		var startup []byte
+		var params map[string]string
+		err := readWithTimeout(cx, routeReadTimeout, func() (err error) {
+			startup, params, err = readPostgresStartup(cx)
+			return err
+		})
+		if err != nil {
+			h.logConnectionClosed(cx.RemoteAddr(), network, tgt, 0, 0, time.Since(start), err)
+			return fmt.Errorf("read postgres startup message: %w", err)
+		}
+		tgt = h.postgresTargetFor(params, tgt)
+		src = io.MultiReader(bytes.NewReader(startup), cx)
+	}
+	if tgt.dial == nil {
+		var err error
+		if tgt, err = h.resolveNode(cx, tgt); err != nil {
+			h.logConnectionClosed(cx.RemoteAddr(), network, tgt, 0, 0, time.Since(start), err)
+			return err
+		}
+	}
+	if h.balancer != nil && tgt.upstream == h.Upstream {
+		tgt.upstream = h.balancer.next()
+	}
+	if tgt.upstream == "" && h.origDst != nil {
+		dst, err := h.origDst(cx.Conn)
+		if err != nil {
+			h.logConnectionClosed(cx.RemoteAddr(), network, tgt, 0, 0, time.Since(start), err)
+			return fmt.Errorf("get original destination: %w", err)
+		}
+		tgt.upstream = dst
+	}
+	h.logConnectionOpened(cx.RemoteAddr(), network, tgt)
 
-	up, err := h.mc.Client().DialContext(cx.Context, network, h.Upstream)
+	up, err := h.acquireUpstream(cx, network, tgt)
 	if err != nil {
-		return fmt.Errorf("dial %s upstream %s via netbird: %w", network, h.Upstream, err)
+		h.logConnectionClosed(cx.RemoteAddr(), network, tgt, 0, 0, time.Since(start), err)
+		return err
+	}
+
+	if h.trackConn != nil {
+		defer h.trackConn(tgt.node, connUsage)()
+	}
+
+	reuse := h.pool != nil && network == "udp"
+	if !reuse {
+		defer up.Close()
 	}
-	defer up.Close()
 
+	// The copies below don't observe any context, so close both sides once
+	// the connection's context is cancelled or the config is unloaded.
+	ctx, cancel := h.connContext(cx)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() {
+		if err := cx.Close(); err != nil {
+			h.logger.Debug("close downstream on cancel", zap.Error(err))
+		}
+		if err := up.Close(); err != nil {
+			h.logger.Debug("close upstream on cancel", zap.Error(err))
+		}
+	})
+	defer stop()
+
+	if h.TCPKeepAlive > 0 {
+		h.enableKeepAlive(cx.Conn, "downstream")
+	}
+	if h.Linger != nil {
+		h.setLinger(cx.Conn, "downstream")
+	}
+	if h.DSCP > 0 {
+		h.markDSCP(cx.Conn, "downstream")
+	}
+
+	var bytesDown int64
+	var downErr error
 	var wg sync.WaitGroup
 	wg.Add(1)
 
 	go func() {
 		defer wg.Done()
-		if _, err := io.Copy(cx, up); err != nil {
-			h.logger.Debug("copy upstream to downstream", zap.Error(err))
+		bytesDown, downErr = io.Copy(cx, up)
+		if downErr != nil && !(reuse && isTimeout(downErr)) {
+			h.logger.Debug("copy upstream to downstream", zap.Error(downErr))
 		}
 		if cw, ok := cx.Conn.(closeWriter); ok {
 			if err := cw.CloseWrite(); err != nil {
@@ -100,10 +684,16 @@ func (h *Handler) Handle(cx *layer4.Connection, _ layer4.Handler) error {
 		}
 	}()
 
-	if _, err := io.Copy(up, cx); err != nil {
+	bytesUp, err := io.Copy(up, src)
+	if err != nil {
 		h.logger.Debug("copy downstream to upstream", zap.Error(err))
 	}
-	if cw, ok := up.(closeWriter); ok {
+	if reuse {
+		// Unblock the upstream reader without closing the pooled conn.
+		if err := up.SetReadDeadline(time.Now()); err != nil {
+			h.logger.Debug("interrupt upstream read", zap.Error(err))
+		}
+	} else if cw, ok := up.(closeWriter); ok {
 		if err := cw.CloseWrite(); err != nil {
 			h.logger.Debug("half-close upstream write side", zap.Error(err))
 		}
@@ -114,9 +704,230 @@ func (h *Handler) Handle(cx *layer4.Connection, _ layer4.Handler) error {
 	}
 
 	wg.Wait()
+	var cancelErr error
+	if !stop() {
+		// Both sides were closed on cancellation.
+		cancelErr = context.Cause(ctx)
+	} else if reuse {
+		h.releaseUpstream(network, tgt, cx.RemoteAddr().String(), up, downErr)
+	}
+	h.logConnectionClosed(cx.RemoteAddr(), network, tgt, bytesUp, bytesDown, time.Since(start), cancelErr)
 	return nil
 }
 
+// acquireUpstream returns an idle pooled UDP connection of cx's client if
+// connection reuse is enabled, and dials a new upstream connection
+// otherwise. TCP upstreams are redialed on early failures if dial retries
+// are configured. The PROXY header of ForwardClientCert is written as part
+// of each dial, so every redialed conn gets its own.
+func (h *Handler) acquireUpstream(cx *layer4.Connection, network string, tgt target) (net.Conn, error) {
+	if h.pool != nil && network == "udp" {
+		if conn := h.pool.get(poolKey(network, tgt, cx.RemoteAddr().String())); conn != nil {
+			return conn, nil
+		}
+	}
+
+	dial := func() (net.Conn, error) {
+		up, err := h.dialUpstream(cx.Context, network, tgt)
+		if err != nil {
+			return nil, err
+		}
+		if h.ForwardClientCert && network == "tcp" {
+			if err := writeProxyHeader(up, cx); err != nil {
+				_ = up.Close()
+				return nil, err
+			}
+		}
+		return up, nil
+	}
+	var up net.Conn
+	var err error
+	if h.DialRetries > 0 && network == "tcp" {
+		up, err = dialRetrying(cx.Context, dial, h.DialRetries, dialRetryDelay)
+	} else {
+		up, err = dial()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dial %s upstream %s via netbird node %q: %w", network, tgt.upstream, tgt.node, err)
+	}
+	return up, nil
+}
+
+// dialUpstream dials the target's upstream and enables keep-alive on it if configured.
+func (h *Handler) dialUpstream(ctx context.Context, network string, tgt target) (net.Conn, error) {
+	if h.Network != "" {
+		network = h.Network
+	}
+	dial := tgt.dial
+	if h.FallbackDirect {
+		dial = app.FallbackDial(h.logger, tgt.node, dial, h.directDial)
+	}
+	up, err := dial(ctx, network, tgt.upstream)
+	if err != nil {
+		return nil, err
+	}
+	if h.TCPKeepAlive > 0 {
+		h.enableKeepAlive(up, "upstream")
+	}
+	if h.Linger != nil {
+		h.setLinger(up, "upstream")
+	}
+	if h.DSCP > 0 {
+		h.markDSCP(up, "upstream")
+	}
+	return up, nil
+}
+
+// releaseUpstream returns a reusable upstream connection to the pool. The
+// conn is closed instead if reading from it failed for reasons other than
+// the deadline set to end the session, or if the pool is full.
+func (h *Handler) releaseUpstream(network string, tgt target, client string, up net.Conn, readErr error) {
+	if readErr == nil || isTimeout(readErr) {
+		if err := up.SetReadDeadline(time.Time{}); err == nil && h.pool.put(poolKey(network, tgt, client), up) {
+			return
+		}
+	}
+	if err := up.Close(); err != nil {
+		h.logger.Debug("close upstream", zap.Error(err))
+	}
+}
+
+// poolKey identifies reusable upstream connections of a target for a
+// downstream client. Conns aren't shared between clients, as replies to
+// one client may still arrive after its session ended.
+func poolKey(network string, tgt target, client string) string {
+	return tgt.node + "/" + network + "/" + tgt.upstream + "/" + client
+}
+
+// connContext returns a context cancelled together with cx.Context, and
+// once the handler's config is unloaded, e.g. on shutdown or reload. caddy-l4
+// derives cx.Context from context.Background, so it isn't cancelled then.
+func (h *Handler) connContext(cx *layer4.Connection) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(cx.Context)
+	if h.ctx == nil {
+		return ctx, func() { cancel(nil) }
+	}
+	stop := context.AfterFunc(h.ctx, func() {
+		cancel(context.Cause(h.ctx))
+	})
+	return ctx, func() {
+		stop()
+		cancel(nil)
+	}
+}
+
+// readWithTimeout runs read with a read deadline of timeout on conn, which
+// is cleared once read succeeded. Failing to clear it is left to the
+// following reads, as the client may already have closed its side after
+// sending everything.
+func readWithTimeout(conn net.Conn, timeout time.Duration, read func() error) error {
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	if err := read(); err != nil {
+		return err
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	return nil
+}
+
+// isTimeout reports whether err is a deadline or timeout error.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// markDSCP sets the DSCP value on conn if it supports it.
+func (h *Handler) markDSCP(conn net.Conn, side string) {
+	applied, err := setDSCP(conn, h.DSCP)
+	if err != nil {
+		h.logger.Debug("set dscp", zap.String("side", side), zap.Error(err))
+		return
+	}
+	if !applied {
+		h.logger.Debug("dscp not supported", zap.String("side", side))
+	}
+}
+
+// enableKeepAlive turns on TCP keep-alive for conn if it supports it.
+func (h *Handler) enableKeepAlive(conn net.Conn, side string) {
+	applied, err := setKeepAlive(conn, time.Duration(h.TCPKeepAlive))
+	if err != nil {
+		h.logger.Debug("enable tcp keep-alive", zap.String("side", side), zap.Error(err))
+		return
+	}
+	if !applied {
+		h.logger.Debug("tcp keep-alive not supported", zap.String("side", side))
+	}
+}
+
+// setKeepAlive enables keep-alive with the given period on conns that support
+// it, such as *net.TCPConn. It reports whether keep-alive was applied.
+func setKeepAlive(conn net.Conn, period time.Duration) (bool, error) {
+	ka, ok := conn.(keepAliveConn)
+	if !ok {
+		return false, nil
+	}
+	if err := ka.SetKeepAlive(true); err != nil {
+		return false, fmt.Errorf("set keep-alive: %w", err)
+	}
+	if err := ka.SetKeepAlivePeriod(period); err != nil {
+		return false, fmt.Errorf("set keep-alive period: %w", err)
+	}
+	return true, nil
+}
+
+// setLinger applies the linger period to conn if it supports it.
+func (h *Handler) setLinger(conn net.Conn, side string) {
+	lc, ok := conn.(lingerConn)
+	if !ok {
+		h.logger.Debug("linger not supported", zap.String("side", side))
+		return
+	}
+	if err := lc.SetLinger(int(time.Duration(*h.Linger) / time.Second)); err != nil {
+		h.logger.Debug("set linger", zap.String("side", side), zap.Error(err))
+	}
+}
+
+// connFields returns the structured log fields identifying a proxied connection.
+func (h *Handler) connFields(client net.Addr, network string, tgt target) []zap.Field {
+	clientAddr := ""
+	if client != nil {
+		clientAddr = client.String()
+	}
+	return []zap.Field{
+		zap.String("client", clientAddr),
+		zap.String("network", network),
+		zap.String("upstream", tgt.upstream),
+		zap.String("node", tgt.node),
+	}
+}
+
+// logConnectionOpened logs the start of a proxied connection if enabled.
+func (h *Handler) logConnectionOpened(client net.Addr, network string, tgt target) {
+	if !h.LogConnections {
+		return
+	}
+	h.logger.Info("connection opened", h.connFields(client, network, tgt)...)
+}
+
+// logConnectionClosed logs the end of a proxied connection if enabled.
+// bytesUp counts bytes sent to the upstream, bytesDown bytes sent to the client.
+func (h *Handler) logConnectionClosed(client net.Addr, network string, tgt target, bytesUp, bytesDown int64, duration time.Duration, err error) {
+	if !h.LogConnections {
+		return
+	}
+	fields := append(h.connFields(client, network, tgt),
+		zap.Int64("bytes_up", bytesUp),
+		zap.Int64("bytes_down", bytesDown),
+		zap.Duration("duration", duration),
+	)
+	if err != nil {
+		fields = append(fields, zap.Error(err))
+	}
+	h.logger.Info("connection closed", fields...)
+}
+
 // networkFromAddr returns "udp" for UDP addresses and "tcp" for everything else.
 func networkFromAddr(addr net.Addr) string {
 	if addr == nil {
@@ -130,12 +941,46 @@ func networkFromAddr(addr net.Addr) string {
 	}
 }
 
-// Cleanup releases the client reference back to the pool.
+// validDialNetwork reports whether network may be set as the dial network.
+func validDialNetwork(network string) bool {
+	switch network {
+	case "tcp4", "tcp6", "udp4", "udp6":
+		return true
+	default:
+		return false
+	}
+}
+
+// Cleanup closes pooled upstream connections and releases the client
+// reference back to the pool.
 func (h *Handler) Cleanup() error {
-	if h.nbApp != nil {
-		return h.nbApp.ReleaseClient(h.Node)
+	h.stopUpstreamChecks()
+
+	var errs []error
+	if h.pool != nil {
+		if err := h.pool.closeAll(); err != nil {
+			errs = append(errs, fmt.Errorf("close pooled upstream connections: %w", err))
+		}
 	}
-	return nil
+	if h.mc != nil {
+		if err := h.nbApp.ReleaseClient(h.Node); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	h.nodesMu.Lock()
+	defer h.nodesMu.Unlock()
+	for node, start := range h.nodes {
+		// Starts in progress release their reference once done.
+		select {
+		case <-start.done:
+			if err := h.releaseNode(node); err != nil {
+				errs = append(errs, err)
+			}
+		default:
+		}
+	}
+	h.nodes = nil
+	return errors.Join(errs...)
 }
 
 // UnmarshalCaddyfile parses the handler directive within a layer4 route block.
@@ -143,7 +988,26 @@ func (h *Handler) Cleanup() error {
 //	layer4 {
 //	    :2222 {
 //	        route {
-//	            netbird <upstream_host:port> [<node_name>]
+//	            netbird <upstream_host:port|original_destination> [<node_name>] {
+//	                upstream <host:port> [weight <n>]
+//	                health_check_interval <duration>
+//	                health_check_timeout <duration>
+//	                tenant <name>
+//	                log_connections
+//	                tcp_keepalive <interval>
+//	                linger <duration>
+//	                dscp <value>
+//	                reuse_connections
+//	                sni <server_name> <node> [<upstream>]
+//	                peek_sni
+//	                dial_retries <count>
+//	                fallback_direct
+//	                postgres_route <database|user> <name> <node> [<upstream>]
+//	                port_route <port> <upstream> [<node>]
+//	                allow_peers <ip|cidr|fqdn...>
+//	                network <tcp4|tcp6|udp4|udp6>
+//	                forward_client_cert
+//	            }
 //	        }
 //	    }
 //	}
@@ -153,14 +1017,212 @@ func (h *Handler) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 	if !d.NextArg() {
 		return d.ArgErr()
 	}
-	h.Upstream = d.Val()
+	if d.Val() == originalDestinationKeyword {
+		h.OriginalDestination = true
+	} else {
+		h.Upstream = d.Val()
+	}
 
 	if d.NextArg() {
 		h.Node = d.Val()
 	}
 
-	if d.NextBlock(0) {
-		return d.Errf("unexpected block in netbird l4 handler")
+	for d.NextBlock(0) {
+		switch d.Val() {
+		case "upstream":
+			var u WeightedUpstream
+			if !d.Args(&u.Address) {
+				return d.ArgErr()
+			}
+			if d.NextArg() {
+				if d.Val() != "weight" || !d.NextArg() {
+					return d.ArgErr()
+				}
+				weight, err := strconv.Atoi(d.Val())
+				if err != nil {
+					return d.Errf("invalid upstream weight: %v", err)
+				}
+				if weight <= 0 {
+					return d.Errf("upstream weight must be positive")
+				}
+				u.Weight = weight
+			}
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			h.Upstreams = append(h.Upstreams, u)
+
+		case "health_check_interval", "health_check_timeout":
+			opt := d.Val()
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return d.Errf("invalid %s: %v", opt, err)
+			}
+			if dur <= 0 {
+				return d.Errf("%s must be positive", opt)
+			}
+			if opt == "health_check_interval" {
+				h.HealthCheckInterval = caddy.Duration(dur)
+			} else {
+				h.HealthCheckTimeout = caddy.Duration(dur)
+			}
+
+		case "log_connections":
+			h.LogConnections = true
+
+		case "tenant":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			h.Tenant = d.Val()
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+
+		case "tcp_keepalive":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return d.Errf("invalid tcp_keepalive: %v", err)
+			}
+			if dur <= 0 {
+				return d.Errf("tcp_keepalive must be positive")
+			}
+			h.TCPKeepAlive = caddy.Duration(dur)
+
+		case "linger":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return d.Errf("invalid linger: %v", err)
+			}
+			if dur < 0 || dur%time.Second != 0 {
+				return d.Errf("linger must be a non-negative number of whole seconds")
+			}
+			linger := caddy.Duration(dur)
+			h.Linger = &linger
+
+		case "dscp":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			dscp, err := parseDSCP(d.Val())
+			if err != nil {
+				return d.Errf("invalid dscp: %v", err)
+			}
+			h.DSCP = dscp
+
+		case "reuse_connections":
+			h.ReuseConnections = true
+
+		case "sni":
+			var route SNIRoute
+			if !d.Args(&route.ServerName, &route.Node) {
+				return d.ArgErr()
+			}
+			if d.NextArg() {
+				route.Upstream = d.Val()
+			}
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			h.SNI = append(h.SNI, route)
+
+		case "postgres_route":
+			var kind, name string
+			var route PostgresRoute
+			if !d.Args(&kind, &name, &route.Node) {
+				return d.ArgErr()
+			}
+			switch kind {
+			case "database":
+				route.Database = name
+			case "user":
+				route.User = name
+			default:
+				return d.Errf("postgres_route must match database or user, got %q", kind)
+			}
+			if d.NextArg() {
+				route.Upstream = d.Val()
+			}
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			h.Postgres = append(h.Postgres, route)
+
+		case "port_route":
+			var port string
+			var route PortRoute
+			if !d.Args(&port, &route.Upstream) {
+				return d.ArgErr()
+			}
+			n, err := strconv.ParseUint(port, 10, 16)
+			if err != nil || n == 0 {
+				return d.Errf("invalid port_route port %q", port)
+			}
+			route.Port = uint16(n)
+			if d.NextArg() {
+				route.Node = d.Val()
+			}
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			h.Ports = append(h.Ports, route)
+
+		case "peek_sni":
+			h.PeekSNI = true
+
+		case "dial_retries":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			n, err := strconv.Atoi(d.Val())
+			if err != nil {
+				return d.Errf("invalid dial_retries: %v", err)
+			}
+			if n < 0 {
+				return d.Errf("dial_retries must not be negative")
+			}
+			h.DialRetries = n
+
+		case "fallback_direct":
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			h.FallbackDirect = true
+
+		case "allow_peers":
+			peers := d.RemainingArgs()
+			if len(peers) == 0 {
+				return d.ArgErr()
+			}
+			h.AllowPeers = append(h.AllowPeers, peers...)
+
+		case "forward_client_cert":
+			h.ForwardClientCert = true
+
+		case "network":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			if !validDialNetwork(d.Val()) {
+				return d.Errf("network must be one of tcp4, tcp6, udp4 or udp6, got %q", d.Val())
+			}
+			h.Network = d.Val()
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+
+		default:
+			return d.Errf("unrecognized netbird l4 handler option: %s", d.Val())
+		}
 	}
 
 	return nil
@@ -170,9 +1232,20 @@ type closeWriter interface {
 	CloseWrite() error
 }
 
+type keepAliveConn interface {
+	SetKeepAlive(keepalive bool) error
+	SetKeepAlivePeriod(d time.Duration) error
+}
+
+type lingerConn interface {
+	SetLinger(sec int) error
+}
+
 var (
 	_ layer4.NextHandler    = (*Handler)(nil)
 	_ caddy.Provisioner     = (*Handler)(nil)
 	_ caddy.CleanerUpper    = (*Handler)(nil)
 	_ caddyfile.Unmarshaler = (*Handler)(nil)
+	_ keepAliveConn         = (*net.TCPConn)(nil)
+	_ lingerConn            = (*net.TCPConn)(nil)
 )
@@ -1,12 +1,25 @@
 package l4handler
 
 import (
+	"context"
+	"errors"
+	"io"
 	"net"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/mholt/caddy-l4/layer4"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/lixmal/caddy-netbird/app"
 )
 
 func TestCaddyModule(t *testing.T) {
@@ -18,9 +31,9 @@ func TestCaddyModule(t *testing.T) {
 
 func TestNetworkFromAddr(t *testing.T) {
 	tests := []struct {
-		name    string
-		addr    net.Addr
-		want    string
+		name string
+		addr net.Addr
+		want string
 	}{
 		{"nil addr", nil, "tcp"},
 		{"tcp addr", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 80}, "tcp"},
@@ -70,3 +83,1000 @@ func TestUnmarshalCaddyfile_UnexpectedBlock(t *testing.T) {
 	err := h.UnmarshalCaddyfile(d)
 	require.Error(t, err)
 }
+
+func TestUnmarshalCaddyfile_LogConnections(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`netbird 10.0.0.1:22 sshnode {
+		log_connections
+	}`)
+
+	var h Handler
+	require.NoError(t, h.UnmarshalCaddyfile(d))
+	assert.True(t, h.LogConnections)
+}
+
+func TestLogConnectionEvents(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	h := &Handler{
+		Upstream:       "10.0.0.1:22",
+		Node:           "sshnode",
+		LogConnections: true,
+		logger:         zap.New(core),
+	}
+	client := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 50000}
+
+	tgt := h.defaultTarget()
+	h.logConnectionOpened(client, "tcp", tgt)
+	h.logConnectionClosed(client, "tcp", tgt, 1024, 2048, 3*time.Second, errors.New("boom"))
+
+	entries := logs.AllUntimed()
+	require.Len(t, entries, 2)
+
+	opened := entries[0]
+	assert.Equal(t, "connection opened", opened.Message)
+	assert.Equal(t, map[string]any{
+		"client":   "192.0.2.1:50000",
+		"network":  "tcp",
+		"upstream": "10.0.0.1:22",
+		"node":     "sshnode",
+	}, opened.ContextMap())
+
+	closed := entries[1]
+	assert.Equal(t, "connection closed", closed.Message)
+	fieldTypes := make(map[string]zapcore.FieldType)
+	for _, f := range closed.Context {
+		fieldTypes[f.Key] = f.Type
+	}
+	assert.Equal(t, zapcore.StringType, fieldTypes["client"])
+	assert.Equal(t, zapcore.Int64Type, fieldTypes["bytes_up"])
+	assert.Equal(t, zapcore.Int64Type, fieldTypes["bytes_down"])
+	assert.Equal(t, zapcore.DurationType, fieldTypes["duration"])
+	assert.Equal(t, zapcore.ErrorType, fieldTypes["error"])
+
+	ctx := closed.ContextMap()
+	assert.Equal(t, int64(1024), ctx["bytes_up"])
+	assert.Equal(t, int64(2048), ctx["bytes_down"])
+	assert.Equal(t, 3*time.Second, ctx["duration"])
+	assert.Equal(t, "boom", ctx["error"])
+}
+
+func TestLogConnectionEvents_Disabled(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	h := &Handler{logger: zap.New(core)}
+
+	h.logConnectionOpened(nil, "tcp", target{})
+	h.logConnectionClosed(nil, "tcp", target{}, 0, 0, time.Second, nil)
+
+	assert.Zero(t, logs.Len())
+}
+
+func TestUnmarshalCaddyfile_Tenant(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`netbird 10.0.0.1:22 acme-ssh {
+		tenant acme
+	}`)
+	var h Handler
+	require.NoError(t, h.UnmarshalCaddyfile(d))
+	assert.Equal(t, "acme", h.Tenant)
+
+	for _, input := range []string{
+		"netbird 10.0.0.1:22 {\n tenant\n}",
+		"netbird 10.0.0.1:22 {\n tenant acme globex\n}",
+	} {
+		var h Handler
+		assert.Error(t, h.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)), input)
+	}
+}
+
+func TestUnmarshalCaddyfile_TCPKeepAlive(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`netbird 10.0.0.1:22 {
+		tcp_keepalive 30s
+	}`)
+
+	var h Handler
+	require.NoError(t, h.UnmarshalCaddyfile(d))
+	assert.Equal(t, 30*time.Second, time.Duration(h.TCPKeepAlive))
+}
+
+func TestUnmarshalCaddyfile_TCPKeepAliveInvalid(t *testing.T) {
+	for _, input := range []string{
+		"netbird 10.0.0.1:22 {\n tcp_keepalive\n}",
+		"netbird 10.0.0.1:22 {\n tcp_keepalive soon\n}",
+		"netbird 10.0.0.1:22 {\n tcp_keepalive 0s\n}",
+	} {
+		var h Handler
+		require.Error(t, h.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)), input)
+	}
+}
+
+func TestSetKeepAlive_TCPConn(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	defer (<-accepted).Close()
+
+	require.IsType(t, &net.TCPConn{}, conn)
+	applied, err := setKeepAlive(conn, 15*time.Second)
+	require.NoError(t, err)
+	assert.True(t, applied)
+}
+
+func TestSetKeepAlive_Unsupported(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	applied, err := setKeepAlive(client, 15*time.Second)
+	require.NoError(t, err)
+	assert.False(t, applied, "pipe conns do not support keep-alive")
+}
+
+// tcpPair returns both ends of a loopback TCP connection.
+func tcpPair(t *testing.T) (*net.TCPConn, *net.TCPConn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	peer := <-accepted
+	t.Cleanup(func() {
+		conn.Close()
+		peer.Close()
+	})
+	return conn.(*net.TCPConn), peer.(*net.TCPConn)
+}
+
+func TestHandle_HalfClose(t *testing.T) {
+	up, backend := tcpPair(t)
+	go func() {
+		// Reply only once the client is done sending.
+		data, _ := io.ReadAll(backend)
+		_, _ = backend.Write(append([]byte("re: "), data...))
+		_ = backend.CloseWrite()
+	}()
+	h := &Handler{
+		Upstream: "10.0.0.1:22",
+		dial: func(context.Context, string, string) (net.Conn, error) {
+			return up, nil
+		},
+		logger: zap.NewNop(),
+	}
+
+	downstream, client := tcpPair(t)
+	cx := layer4.WrapConnection(downstream, nil, zap.NewNop())
+	done := make(chan error, 1)
+	go func() {
+		done <- h.Handle(cx, nil)
+	}()
+
+	_, err := client.Write([]byte("ping"))
+	require.NoError(t, err)
+	require.NoError(t, client.CloseWrite())
+
+	reply, err := io.ReadAll(client)
+	require.NoError(t, err)
+	assert.Equal(t, "re: ping", string(reply), "the reply must arrive after the client half-closed")
+	require.NoError(t, <-done)
+}
+
+func TestUnmarshalCaddyfile_ReuseConnections(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`netbird 10.0.0.1:53 {
+		reuse_connections
+	}`)
+
+	var h Handler
+	require.NoError(t, h.UnmarshalCaddyfile(d))
+	assert.True(t, h.ReuseConnections)
+}
+
+// udpPipeConn is one end of a net.Pipe that reports a UDP local address.
+type udpPipeConn struct {
+	net.Conn
+}
+
+func (udpPipeConn) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5353}
+}
+
+// echoDialer returns a dial func that counts dials and echoes back everything
+// written to the returned conns.
+func echoDialer(dials *atomic.Int32) func(context.Context, string, string) (net.Conn, error) {
+	return func(context.Context, string, string) (net.Conn, error) {
+		dials.Add(1)
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			_, _ = io.Copy(server, server)
+		}()
+		return client, nil
+	}
+}
+
+// udpClientConn is a udpPipeConn of a given client address.
+type udpClientConn struct {
+	udpPipeConn
+	remote net.Addr
+}
+
+func (c udpClientConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// runUDPSession proxies a single request/response exchange through h.
+func runUDPSession(t *testing.T, h *Handler) {
+	t.Helper()
+	runUDPClientSession(t, h, nil)
+}
+
+// runUDPClientSession is runUDPSession for a session of the client at
+// remote, or of the pipe's address if nil.
+func runUDPClientSession(t *testing.T, h *Handler, remote net.Addr) {
+	t.Helper()
+
+	downstream, client := net.Pipe()
+	var conn net.Conn = udpPipeConn{downstream}
+	if remote != nil {
+		conn = udpClientConn{udpPipeConn{downstream}, remote}
+	}
+	cx := layer4.WrapConnection(conn, nil, zap.NewNop())
+
+	done := make(chan error, 1)
+	go func() {
+		done <- h.Handle(cx, nil)
+	}()
+
+	_, err := client.Write([]byte("query"))
+	require.NoError(t, err)
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(client, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "query", string(buf))
+
+	require.NoError(t, client.Close())
+	require.NoError(t, <-done)
+	downstream.Close()
+}
+
+func TestHandle_ReuseConnections(t *testing.T) {
+	var dials atomic.Int32
+	h := &Handler{
+		Upstream: "10.0.0.1:53",
+		dial:     echoDialer(&dials),
+		pool:     newConnPool(maxIdleConnsPerKey, maxIdleConns),
+		logger:   zap.NewNop(),
+	}
+	defer h.pool.closeAll()
+
+	runUDPSession(t, h)
+	runUDPSession(t, h)
+	runUDPSession(t, h)
+
+	assert.Equal(t, int32(1), dials.Load(), "upstream conn should be reused across sessions")
+}
+
+func TestHandle_ReuseConnectionsPerClient(t *testing.T) {
+	var dials atomic.Int32
+	h := &Handler{
+		Upstream: "10.0.0.1:53",
+		dial:     echoDialer(&dials),
+		pool:     newConnPool(maxIdleConnsPerKey, maxIdleConns),
+		logger:   zap.NewNop(),
+	}
+	defer h.pool.closeAll()
+
+	clientA := &net.UDPAddr{IP: net.IPv4(100, 64, 0, 2), Port: 40000}
+	clientB := &net.UDPAddr{IP: net.IPv4(100, 64, 0, 3), Port: 40000}
+
+	runUDPClientSession(t, h, clientA)
+	runUDPClientSession(t, h, clientB)
+	assert.Equal(t, int32(2), dials.Load(), "clients must not share an upstream conn")
+
+	runUDPClientSession(t, h, clientA)
+	runUDPClientSession(t, h, clientB)
+	assert.Equal(t, int32(2), dials.Load(), "each client should reuse its own upstream conn")
+}
+
+func TestHandle_DialPerConnection(t *testing.T) {
+	var dials atomic.Int32
+	h := &Handler{
+		Upstream: "10.0.0.1:53",
+		dial:     echoDialer(&dials),
+		logger:   zap.NewNop(),
+	}
+
+	runUDPSession(t, h)
+	runUDPSession(t, h)
+
+	assert.Equal(t, int32(2), dials.Load(), "each session should dial a fresh upstream conn")
+}
+
+func TestReleaseUpstream_ClosesOnReadError(t *testing.T) {
+	h := &Handler{
+		Upstream: "10.0.0.1:53",
+		pool:     newConnPool(maxIdleConnsPerKey, maxIdleConns),
+		logger:   zap.NewNop(),
+	}
+
+	up, server := net.Pipe()
+	defer server.Close()
+
+	tgt := h.defaultTarget()
+	h.releaseUpstream("udp", tgt, "pipe", up, io.ErrUnexpectedEOF)
+	assert.Nil(t, h.pool.get(poolKey("udp", tgt, "pipe")), "broken conn must not be pooled")
+	_, err := up.Write([]byte("x"))
+	require.Error(t, err, "broken conn should be closed")
+}
+
+func TestCleanup_WithoutClient(t *testing.T) {
+	// A handler whose client failed to start holds no reference to release.
+	h := &Handler{Node: "default"}
+	assert.NoError(t, h.Cleanup())
+}
+
+func TestUnmarshalCaddyfile_SNI(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`netbird 10.0.0.1:443 ingress {
+		sni app.example.com web
+		sni *.api.example.com api 10.0.0.2:8443
+	}`)
+
+	var h Handler
+	require.NoError(t, h.UnmarshalCaddyfile(d))
+	assert.Equal(t, []SNIRoute{
+		{ServerName: "app.example.com", Node: "web"},
+		{ServerName: "*.api.example.com", Node: "api", Upstream: "10.0.0.2:8443"},
+	}, h.SNI)
+}
+
+func TestUnmarshalCaddyfile_SNIInvalid(t *testing.T) {
+	for _, input := range []string{
+		"netbird 10.0.0.1:443 {\n sni app.example.com\n}",
+		"netbird 10.0.0.1:443 {\n sni app.example.com web 10.0.0.2:443 extra\n}",
+	} {
+		var h Handler
+		assert.Error(t, h.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)), input)
+	}
+}
+
+func TestTargetFor(t *testing.T) {
+	h := &Handler{
+		Node:     "ingress",
+		Upstream: "10.0.0.1:443",
+		routes: map[string]target{
+			"app.example.com":   {node: "web", upstream: "10.0.0.1:443"},
+			"*.example.com":     {node: "wild", upstream: "10.0.0.3:443"},
+			"api.example.com":   {node: "api", upstream: "10.0.0.2:8443"},
+			"*.dev.example.com": {node: "dev", upstream: "10.0.0.4:443"},
+		},
+	}
+
+	tests := []struct {
+		serverName string
+		node       string
+		upstream   string
+	}{
+		{"app.example.com", "web", "10.0.0.1:443"},
+		{"API.Example.com", "api", "10.0.0.2:8443"},
+		{"other.example.com", "wild", "10.0.0.3:443"},
+		{"x.dev.example.com", "dev", "10.0.0.4:443"},
+		{"a.b.example.com", "ingress", "10.0.0.1:443"},
+		{"example.com", "ingress", "10.0.0.1:443"},
+		{"unknown.org", "ingress", "10.0.0.1:443"},
+		{"", "ingress", "10.0.0.1:443"},
+	}
+	for _, tt := range tests {
+		tgt := h.targetFor(tt.serverName, h.defaultTarget())
+		assert.Equal(t, tt.node, tgt.node, tt.serverName)
+		assert.Equal(t, tt.upstream, tgt.upstream, tt.serverName)
+	}
+}
+
+func TestHandle_SNIRoute(t *testing.T) {
+	var defaultDials, webDials atomic.Int32
+	h := &Handler{
+		Node:     "ingress",
+		Upstream: "10.0.0.1:443",
+		dial:     echoDialer(&defaultDials),
+		routes: map[string]target{
+			"app.example.com": {node: "web", upstream: "10.0.0.2:443", dial: echoDialer(&webDials)},
+		},
+		logger: zap.NewNop(),
+	}
+
+	run := func(serverName string) {
+		downstream, client := net.Pipe()
+		cx := layer4.WrapConnection(downstream, nil, zap.NewNop())
+		if serverName != "" {
+			// Set by caddy-l4's tls matcher.
+			repl := cx.Context.Value(layer4.ReplacerCtxKey).(*caddy.Replacer)
+			repl.Set("l4.tls.server_name", serverName)
+		}
+
+		done := make(chan error, 1)
+		go func() {
+			done <- h.Handle(cx, nil)
+		}()
+		_, err := client.Write([]byte("hello"))
+		require.NoError(t, err)
+		buf := make([]byte, 5)
+		_, err = io.ReadFull(client, buf)
+		require.NoError(t, err)
+		require.NoError(t, client.Close())
+		require.NoError(t, <-done)
+	}
+
+	run("app.example.com")
+	assert.Equal(t, int32(1), webDials.Load())
+	assert.Equal(t, int32(0), defaultDials.Load())
+
+	// Non-TLS connections have no server name and use the default.
+	run("")
+	assert.Equal(t, int32(1), webDials.Load())
+	assert.Equal(t, int32(1), defaultDials.Load())
+}
+
+func TestUnmarshalCaddyfile_PlaceholderNode(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`netbird 10.0.0.1:443 {l4.tls.server_name}`)
+	var h Handler
+	require.NoError(t, h.UnmarshalCaddyfile(d))
+	assert.Equal(t, "{l4.tls.server_name}", h.Node)
+	assert.True(t, h.dynamicNode())
+}
+
+func TestHandle_DynamicNode(t *testing.T) {
+	var dials atomic.Int32
+	started := make(map[string]int)
+	var released []string
+	h := &Handler{
+		Node:     "{test.node}",
+		Upstream: "10.0.0.1:443",
+		nbApp:    &app.App{Nodes: map[string]*app.Node{"web": {}, "db": {}}},
+		startNode: func(_ context.Context, node string) (dialFunc, error) {
+			started[node]++
+			return echoDialer(&dials), nil
+		},
+		releaseNode: func(node string) error {
+			released = append(released, node)
+			return nil
+		},
+		logger: zap.NewNop(),
+	}
+
+	run := func(node string) error {
+		downstream, client := net.Pipe()
+		defer client.Close()
+		cx := layer4.WrapConnection(downstream, nil, zap.NewNop())
+		repl := cx.Context.Value(layer4.ReplacerCtxKey).(*caddy.Replacer)
+		repl.Set("test.node", node)
+
+		done := make(chan error, 1)
+		go func() {
+			done <- h.Handle(cx, nil)
+			// Unblock the write below if the handler failed.
+			downstream.Close()
+		}()
+		if _, err := client.Write([]byte("hello")); err != nil {
+			return <-done
+		}
+		buf := make([]byte, 5)
+		_, err := io.ReadFull(client, buf)
+		require.NoError(t, err)
+		require.NoError(t, client.Close())
+		return <-done
+	}
+
+	require.NoError(t, run("web"))
+	require.NoError(t, run("web"))
+	require.NoError(t, run("db"))
+	assert.Equal(t, int32(3), dials.Load())
+	assert.Equal(t, map[string]int{"web": 1, "db": 1}, started, "each node is started once")
+
+	assert.ErrorContains(t, run("unknown"), `unknown node "unknown"`)
+	assert.ErrorContains(t, run(""), "empty name")
+	assert.NotContains(t, started, "unknown", "unknown nodes must not be started")
+
+	require.NoError(t, h.Cleanup())
+	assert.ElementsMatch(t, []string{"web", "db"}, released, "each started node is released once")
+	require.NoError(t, h.Cleanup())
+	assert.Len(t, released, 2, "references are released only once")
+}
+
+func TestNodeDialer_StartError(t *testing.T) {
+	h := &Handler{
+		startNode: func(context.Context, string) (dialFunc, error) {
+			return nil, errors.New("management unreachable")
+		},
+		releaseNode: func(node string) error {
+			t.Errorf("node %q was not started and must not be released", node)
+			return nil
+		},
+	}
+
+	_, err := h.nodeDialer(context.Background(), "web")
+	require.Error(t, err)
+	require.NoError(t, h.Cleanup())
+}
+
+func TestNodeDialer_ConcurrentStart(t *testing.T) {
+	var dials atomic.Int32
+	unblock := make(chan struct{})
+	var mu sync.Mutex
+	started := make(map[string]int)
+	h := &Handler{
+		startNode: func(ctx context.Context, node string) (dialFunc, error) {
+			mu.Lock()
+			started[node]++
+			mu.Unlock()
+			if node == "web" {
+				<-unblock
+			}
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return echoDialer(&dials), nil
+		},
+		releaseNode: func(string) error { return nil },
+		logger:      zap.NewNop(),
+	}
+
+	connCtx, cancelConn := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := h.nodeDialer(connCtx, "web")
+		first <- err
+	}()
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return started["web"] == 1
+	}, time.Second, time.Millisecond)
+
+	_, err := h.nodeDialer(context.Background(), "db")
+	require.NoError(t, err, "starting another node must not wait for web")
+
+	waitCtx, cancelWait := context.WithCancel(context.Background())
+	cancelWait()
+	_, err = h.nodeDialer(waitCtx, "web")
+	require.ErrorIs(t, err, context.Canceled, "waiters give up with their own context")
+
+	second := make(chan error, 1)
+	go func() {
+		_, err := h.nodeDialer(context.Background(), "web")
+		second <- err
+	}()
+
+	// The start must not depend on the connection that triggered it.
+	cancelConn()
+	close(unblock)
+	require.NoError(t, <-first)
+	require.NoError(t, <-second)
+	assert.Equal(t, map[string]int{"web": 1, "db": 1}, started, "each node is started once")
+	require.NoError(t, h.Cleanup())
+}
+
+func TestNodeDialer_CleanupDuringStart(t *testing.T) {
+	unblock := make(chan struct{})
+	startedCh := make(chan struct{})
+	var released []string
+	h := &Handler{
+		startNode: func(context.Context, string) (dialFunc, error) {
+			close(startedCh)
+			<-unblock
+			return echoDialer(new(atomic.Int32)), nil
+		},
+		releaseNode: func(node string) error {
+			released = append(released, node)
+			return nil
+		},
+		logger: zap.NewNop(),
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := h.nodeDialer(context.Background(), "web")
+		done <- err
+	}()
+	<-startedCh
+	require.NoError(t, h.Cleanup())
+	assert.Empty(t, released, "a start in progress isn't released by cleanup")
+
+	close(unblock)
+	assert.Error(t, <-done)
+	assert.Equal(t, []string{"web"}, released, "the start releases its own reference")
+}
+
+func TestUnmarshalCaddyfile_OriginalDestination(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`netbird original_destination ingress`)
+	var h Handler
+	require.NoError(t, h.UnmarshalCaddyfile(d))
+	assert.True(t, h.OriginalDestination)
+	assert.Empty(t, h.Upstream)
+	assert.Equal(t, "ingress", h.Node)
+}
+
+func TestHandle_OriginalDestination(t *testing.T) {
+	var dialed []string
+	h := &Handler{
+		Node: "ingress",
+		dial: func(_ context.Context, _, address string) (net.Conn, error) {
+			dialed = append(dialed, address)
+			client, server := net.Pipe()
+			go func() {
+				defer server.Close()
+				_, _ = io.Copy(server, server)
+			}()
+			return client, nil
+		},
+		origDst: func(net.Conn) (string, error) {
+			return "100.64.0.7:5432", nil
+		},
+		logger: zap.NewNop(),
+	}
+
+	downstream, client := net.Pipe()
+	cx := layer4.WrapConnection(downstream, nil, zap.NewNop())
+	done := make(chan error, 1)
+	go func() {
+		done <- h.Handle(cx, nil)
+	}()
+	_, err := client.Write([]byte("hello"))
+	require.NoError(t, err)
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(client, buf)
+	require.NoError(t, err)
+	require.NoError(t, client.Close())
+	require.NoError(t, <-done)
+
+	assert.Equal(t, []string{"100.64.0.7:5432"}, dialed)
+}
+
+func TestHandle_OriginalDestinationError(t *testing.T) {
+	h := &Handler{
+		Node: "ingress",
+		dial: func(context.Context, string, string) (net.Conn, error) {
+			t.Error("must not dial without a destination")
+			return nil, errors.New("unexpected dial")
+		},
+		origDst: func(net.Conn) (string, error) {
+			return "", errOriginalDstUnsupported
+		},
+		logger: zap.NewNop(),
+	}
+
+	downstream, client := net.Pipe()
+	defer client.Close()
+	defer downstream.Close()
+	err := h.Handle(layer4.WrapConnection(downstream, nil, zap.NewNop()), nil)
+	assert.ErrorIs(t, err, errOriginalDstUnsupported)
+}
+
+func TestHandle_TracksConnUsage(t *testing.T) {
+	var dials atomic.Int32
+	var open, closed []string
+	h := &Handler{
+		Node:     "web",
+		Upstream: "10.0.0.1:53",
+		dial:     echoDialer(&dials),
+		trackConn: func(node string, usage app.ConnUsage) func() {
+			assert.Equal(t, connUsage, usage)
+			open = append(open, node)
+			return func() { closed = append(closed, node) }
+		},
+		logger: zap.NewNop(),
+	}
+
+	runUDPSession(t, h)
+
+	assert.Equal(t, []string{"web"}, open)
+	assert.Equal(t, []string{"web"}, closed, "conn should be released when the session ends")
+}
+
+func TestUnmarshalCaddyfile_Linger(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`netbird 10.0.0.1:22 {
+		linger 5s
+	}`)
+
+	var h Handler
+	require.NoError(t, h.UnmarshalCaddyfile(d))
+	require.NotNil(t, h.Linger)
+	assert.Equal(t, 5*time.Second, time.Duration(*h.Linger))
+
+	d = caddyfile.NewTestDispenser(`netbird 10.0.0.1:22 {
+		linger 0s
+	}`)
+	h = Handler{}
+	require.NoError(t, h.UnmarshalCaddyfile(d))
+	require.NotNil(t, h.Linger, "zero linger must be distinguishable from unset")
+	assert.Zero(t, *h.Linger)
+}
+
+func TestUnmarshalCaddyfile_LingerInvalid(t *testing.T) {
+	for _, input := range []string{
+		"netbird 10.0.0.1:22 {\n linger\n}",
+		"netbird 10.0.0.1:22 {\n linger soon\n}",
+		"netbird 10.0.0.1:22 {\n linger -1s\n}",
+		"netbird 10.0.0.1:22 {\n linger 1500ms\n}",
+	} {
+		var h Handler
+		require.Error(t, h.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)), input)
+	}
+}
+
+func TestSetLinger(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	defer (<-accepted).Close()
+
+	core, logs := observer.New(zap.DebugLevel)
+	linger := caddy.Duration(0)
+	h := &Handler{Linger: &linger, logger: zap.New(core)}
+
+	require.Implements(t, (*lingerConn)(nil), conn)
+	h.setLinger(conn, "upstream")
+	assert.Zero(t, logs.Len(), "tcp conns support linger")
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	h.setLinger(client, "upstream")
+	require.Equal(t, 1, logs.Len())
+	assert.Equal(t, "linger not supported", logs.All()[0].Message)
+}
+
+func TestUnmarshalCaddyfile_Network(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`netbird db.netbird.cloud:5432 {
+		network tcp6
+	}`)
+
+	var h Handler
+	require.NoError(t, h.UnmarshalCaddyfile(d))
+	assert.Equal(t, "tcp6", h.Network)
+}
+
+func TestUnmarshalCaddyfile_NetworkInvalid(t *testing.T) {
+	for _, input := range []string{
+		"netbird 10.0.0.1:53 {\n network\n}",
+		"netbird 10.0.0.1:53 {\n network udp\n}",
+		"netbird 10.0.0.1:53 {\n network ip4\n}",
+		"netbird 10.0.0.1:53 {\n network udp4 udp6\n}",
+	} {
+		var h Handler
+		require.Error(t, h.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)), input)
+	}
+}
+
+func TestHandle_NetworkOverride(t *testing.T) {
+	var dials atomic.Int32
+	echo := echoDialer(&dials)
+	var network string
+	h := &Handler{
+		Upstream: "dns.netbird.cloud:53",
+		Network:  "udp6",
+		dial: func(ctx context.Context, nw, address string) (net.Conn, error) {
+			network = nw
+			return echo(ctx, nw, address)
+		},
+		logger: zap.NewNop(),
+	}
+
+	runUDPSession(t, h)
+
+	assert.Equal(t, int32(1), dials.Load())
+	assert.Equal(t, "udp6", network, "override should reach the dial call")
+}
+
+func TestValidateUpstream(t *testing.T) {
+	tests := []struct {
+		addr    string
+		wantErr bool
+	}{
+		{"10.0.0.1:22", false},
+		{"db.netbird.cloud:5432", false},
+		{"[fd00::1]:443", false},
+		{"", true},
+		{"10.0.0.1", true},
+		{":22", true},
+		{"10.0.0.1:ssh", true},
+		{"10.0.0.1:0", true},
+		{"10.0.0.1:65536", true},
+		{"fd00::1:443", true},
+	}
+	for _, tt := range tests {
+		err := validateUpstream(tt.addr)
+		if tt.wantErr {
+			assert.Error(t, err, tt.addr)
+		} else {
+			assert.NoError(t, err, tt.addr)
+		}
+	}
+}
+
+func TestValidateUpstreams(t *testing.T) {
+	tests := []struct {
+		name    string
+		h       *Handler
+		wantErr string
+	}{
+		{name: "valid", h: &Handler{Upstream: "10.0.0.1:22"}},
+		{name: "original destination", h: &Handler{OriginalDestination: true}},
+		{name: "missing", h: &Handler{}, wantErr: "upstream is required"},
+		{name: "malformed", h: &Handler{Upstream: "10.0.0.1"}, wantErr: "invalid upstream"},
+		{
+			name:    "malformed sni route",
+			h:       &Handler{Upstream: "10.0.0.1:443", SNI: []SNIRoute{{ServerName: "app.example.com", Node: "web", Upstream: "10.0.0.2"}}},
+			wantErr: `sni route "app.example.com"`,
+		},
+		{
+			name:    "malformed postgres route",
+			h:       &Handler{Upstream: "10.0.0.1:5432", Postgres: []PostgresRoute{{Database: "app", Node: "db", Upstream: "db:"}}},
+			wantErr: `postgres route to node "db"`,
+		},
+		{
+			name:    "malformed port route",
+			h:       &Handler{Upstream: "10.0.0.1:443", Ports: []PortRoute{{Port: 5432, Upstream: "db"}}},
+			wantErr: "port route 5432",
+		},
+		{
+			name: "route defaults to handler upstream",
+			h:    &Handler{Upstream: "10.0.0.1:443", SNI: []SNIRoute{{ServerName: "app.example.com", Node: "web"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.h.validateUpstreams()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+// startCancelableSession starts proxying a connection through h whose
+// context is cancelled by the returned func, after a first echo round trip.
+func startCancelableSession(t *testing.T, h *Handler, wrap func(net.Conn) net.Conn) (client net.Conn, cancel context.CancelFunc, done <-chan error) {
+	t.Helper()
+
+	downstream, client := net.Pipe()
+	t.Cleanup(func() { _ = client.Close() })
+	cx := layer4.WrapConnection(wrap(downstream), nil, zap.NewNop())
+	var ctx context.Context
+	ctx, cancel = context.WithCancel(cx.Context)
+	cx.Context = ctx
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- h.Handle(cx, nil)
+	}()
+
+	_, err := client.Write([]byte("hello"))
+	require.NoError(t, err)
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(client, buf)
+	require.NoError(t, err)
+	return client, cancel, errc
+}
+
+func TestHandle_CancelMidCopy(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	var dials atomic.Int32
+	h := &Handler{
+		Upstream:       "10.0.0.1:22",
+		dial:           echoDialer(&dials),
+		LogConnections: true,
+		logger:         zap.New(core),
+	}
+
+	client, cancel, done := startCancelableSession(t, h, func(c net.Conn) net.Conn { return c })
+	cancel()
+
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Handle did not return after the context was cancelled")
+	}
+
+	_, err := client.Read(make([]byte, 1))
+	assert.Error(t, err, "the downstream conn should be closed")
+
+	closed := logs.FilterMessage("connection closed").All()
+	require.Len(t, closed, 1)
+	assert.Equal(t, context.Canceled.Error(), closed[0].ContextMap()["error"])
+}
+
+func TestHandle_CancelOnUnload(t *testing.T) {
+	var dials atomic.Int32
+	provisionCtx, unload := context.WithCancel(context.Background())
+	h := &Handler{
+		Upstream: "10.0.0.1:22",
+		dial:     echoDialer(&dials),
+		ctx:      provisionCtx,
+		logger:   zap.NewNop(),
+	}
+
+	client, _, done := startCancelableSession(t, h, func(c net.Conn) net.Conn { return c })
+	unload()
+
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Handle did not return after the config was unloaded")
+	}
+	_, err := client.Read(make([]byte, 1))
+	assert.Error(t, err, "the downstream conn should be closed")
+}
+
+func TestHandle_CancelDoesNotPoolUpstream(t *testing.T) {
+	var dials atomic.Int32
+	h := &Handler{
+		Upstream: "10.0.0.1:53",
+		dial:     echoDialer(&dials),
+		pool:     newConnPool(maxIdleConnsPerKey, maxIdleConns),
+		logger:   zap.NewNop(),
+	}
+	defer h.pool.closeAll()
+
+	_, cancel, done := startCancelableSession(t, h, func(c net.Conn) net.Conn { return udpPipeConn{c} })
+	cancel()
+
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Handle did not return after the context was cancelled")
+	}
+	assert.Nil(t, h.pool.get(poolKey("udp", h.defaultTarget(), "pipe")), "upstream conns closed on cancel must not be pooled")
+}
+
+func TestHandle_NoCancelKeepsPooling(t *testing.T) {
+	var dials atomic.Int32
+	h := &Handler{
+		Upstream: "10.0.0.1:53",
+		dial:     echoDialer(&dials),
+		pool:     newConnPool(maxIdleConnsPerKey, maxIdleConns),
+		logger:   zap.NewNop(),
+	}
+	defer h.pool.closeAll()
+
+	client, cancel, done := startCancelableSession(t, h, func(c net.Conn) net.Conn { return udpPipeConn{c} })
+	require.NoError(t, client.Close())
+	require.NoError(t, <-done)
+	cancel()
+
+	assert.NotNil(t, h.pool.get(poolKey("udp", h.defaultTarget(), "pipe")), "cancelling after the session ended must not affect the pooled conn")
+}
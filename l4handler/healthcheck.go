@@ -0,0 +1,92 @@
+package l4handler
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// defaultUpstreamCheckTimeout bounds an upstream health check dial.
+const defaultUpstreamCheckTimeout = 5 * time.Second
+
+// startUpstreamChecks starts checking the balanced upstreams in the
+// background until Cleanup.
+func (h *Handler) startUpstreamChecks(interval, timeout time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	h.checkCancel = cancel
+	h.checkDone = make(chan struct{})
+
+	go func() {
+		defer close(h.checkDone)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				h.checkUpstreams(ctx, timeout)
+			}
+		}
+	}()
+}
+
+// stopUpstreamChecks stops the background checks, if running.
+func (h *Handler) stopUpstreamChecks() {
+	if h.checkCancel == nil {
+		return
+	}
+	h.checkCancel()
+	<-h.checkDone
+	h.checkCancel = nil
+}
+
+// checkUpstreams dials all balanced upstreams at once and marks those that
+// can't be dialed within timeout as unhealthy, and the others as healthy.
+func (h *Handler) checkUpstreams(ctx context.Context, timeout time.Duration) {
+	var wg sync.WaitGroup
+	for _, addr := range h.balancer.addresses() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := h.checkUpstream(ctx, addr, timeout)
+			if ctx.Err() != nil {
+				// Stopped while checking: the result says nothing.
+				return
+			}
+			if !h.balancer.setHealthy(addr, err == nil) {
+				return
+			}
+			if err != nil {
+				h.logger.Warn("l4 upstream unhealthy", zap.String("upstream", addr), zap.Error(err))
+			} else {
+				h.logger.Info("l4 upstream healthy again", zap.String("upstream", addr))
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// checkUpstream dials addr through the handler's node.
+func (h *Handler) checkUpstream(ctx context.Context, addr string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	conn, err := h.dial(ctx, h.checkNetwork(), addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// checkNetwork returns the network upstream health checks dial with: the
+// configured network, or TCP.
+func (h *Handler) checkNetwork() string {
+	if h.Network != "" {
+		return h.Network
+	}
+	return "tcp"
+}
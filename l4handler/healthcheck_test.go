@@ -0,0 +1,154 @@
+package l4handler
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestUpstreamBalancer_SkipsUnhealthy(t *testing.T) {
+	b := newUpstreamBalancer([]WeightedUpstream{
+		{Address: "a:22", Weight: 2},
+		{Address: "b:22", Weight: 1},
+	})
+
+	assert.True(t, b.setHealthy("a:22", false))
+	assert.False(t, b.setHealthy("a:22", false), "unchanged state should not report a change")
+	for range 3 {
+		assert.Equal(t, "b:22", b.next())
+	}
+
+	assert.True(t, b.setHealthy("a:22", true))
+	var picks []string
+	for range 3 {
+		picks = append(picks, b.next())
+	}
+	assert.Equal(t, []string{"a:22", "b:22", "a:22"}, picks)
+}
+
+func TestUpstreamBalancer_AllUnhealthy(t *testing.T) {
+	b := newUpstreamBalancer([]WeightedUpstream{
+		{Address: "a:22", Weight: 1},
+		{Address: "b:22", Weight: 1},
+	})
+	b.setHealthy("a:22", false)
+	b.setHealthy("b:22", false)
+
+	counts := make(map[string]int)
+	for range 4 {
+		counts[b.next()]++
+	}
+	assert.Equal(t, map[string]int{"a:22": 2, "b:22": 2}, counts, "should fall back to all upstreams")
+}
+
+func TestUpstreamBalancer_UnknownAddress(t *testing.T) {
+	b := newUpstreamBalancer([]WeightedUpstream{{Address: "a:22"}})
+	assert.False(t, b.setHealthy("b:22", false))
+	assert.Equal(t, []string{"a:22"}, b.addresses())
+}
+
+func TestCheckUpstreams(t *testing.T) {
+	var down atomic.Bool
+	down.Store(true)
+	h := &Handler{
+		Upstream:  "10.0.0.1:22",
+		Upstreams: []WeightedUpstream{{Address: "10.0.0.2:22"}},
+		dial: func(_ context.Context, network, address string) (net.Conn, error) {
+			assert.Equal(t, "tcp", network)
+			if address == "10.0.0.2:22" && down.Load() {
+				return nil, errors.New("no route to host")
+			}
+			c1, c2 := net.Pipe()
+			c2.Close()
+			return c1, nil
+		},
+		logger: zap.NewNop(),
+	}
+	h.balancer = newUpstreamBalancer(h.balancedUpstreams())
+
+	h.checkUpstreams(context.Background(), time.Second)
+	for range 3 {
+		assert.Equal(t, "10.0.0.1:22", h.balancer.next())
+	}
+
+	down.Store(false)
+	h.checkUpstreams(context.Background(), time.Second)
+	counts := make(map[string]int)
+	for range 4 {
+		counts[h.balancer.next()]++
+	}
+	assert.Equal(t, map[string]int{"10.0.0.1:22": 2, "10.0.0.2:22": 2}, counts)
+}
+
+func TestCheckUpstreams_Timeout(t *testing.T) {
+	h := &Handler{
+		Upstreams: []WeightedUpstream{{Address: "10.0.0.1:22"}, {Address: "10.0.0.2:22"}},
+		Network:   "tcp4",
+		dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			assert.Equal(t, "tcp4", network)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+		logger: zap.NewNop(),
+	}
+	h.balancer = newUpstreamBalancer(h.balancedUpstreams())
+
+	h.checkUpstreams(context.Background(), 10*time.Millisecond)
+	for _, u := range h.balancer.upstreams {
+		assert.True(t, u.unhealthy, u.address)
+	}
+}
+
+func TestUpstreamChecks_StopOnCleanup(t *testing.T) {
+	var checks atomic.Int32
+	h := &Handler{
+		Upstreams: []WeightedUpstream{{Address: "10.0.0.1:22"}},
+		dial: func(context.Context, string, string) (net.Conn, error) {
+			checks.Add(1)
+			return nil, errors.New("unreachable")
+		},
+		logger: zap.NewNop(),
+	}
+	h.balancer = newUpstreamBalancer(h.balancedUpstreams())
+
+	h.startUpstreamChecks(5*time.Millisecond, time.Second)
+	require.Eventually(t, func() bool { return checks.Load() >= 2 }, time.Second, 5*time.Millisecond)
+
+	require.NoError(t, h.Cleanup())
+	n := checks.Load()
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, n, checks.Load(), "checks should stop on cleanup")
+}
+
+func TestUnmarshalCaddyfile_HealthCheck(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`netbird 10.0.0.1:22 {
+		upstream 10.0.0.2:22
+		health_check_interval 10s
+		health_check_timeout 2s
+	}`)
+
+	var h Handler
+	require.NoError(t, h.UnmarshalCaddyfile(d))
+	assert.Equal(t, caddy.Duration(10*time.Second), h.HealthCheckInterval)
+	assert.Equal(t, caddy.Duration(2*time.Second), h.HealthCheckTimeout)
+}
+
+func TestUnmarshalCaddyfile_HealthCheckInvalid(t *testing.T) {
+	for _, input := range []string{
+		"netbird 10.0.0.1:22 {\n health_check_interval\n}",
+		"netbird 10.0.0.1:22 {\n health_check_interval 0s\n}",
+		"netbird 10.0.0.1:22 {\n health_check_timeout often\n}",
+	} {
+		var h Handler
+		require.Error(t, h.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)), input)
+	}
+}
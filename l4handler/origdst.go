@@ -0,0 +1,19 @@
+package l4handler
+
+import (
+	"errors"
+	"net"
+)
+
+// originalDestinationKeyword is given instead of an upstream in the
+// Caddyfile to dial the original destination of each connection.
+const originalDestinationKeyword = "original_destination"
+
+var errOriginalDstUnsupported = errors.New("original destination is only available for redirected TCP connections on Linux")
+
+// localIsIPv4 reports whether conn's local address is IPv4, including
+// IPv4-mapped IPv6 addresses of dual-stack sockets.
+func localIsIPv4(conn net.Conn) bool {
+	addr, ok := conn.LocalAddr().(*net.TCPAddr)
+	return !ok || addr.IP.To4() != nil
+}
@@ -0,0 +1,81 @@
+package l4handler
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// ip6tSoOriginalDst is IP6T_SO_ORIGINAL_DST from linux/netfilter_ipv6/ip6_tables.h.
+const ip6tSoOriginalDst = 80
+
+// originalDst returns the destination a connection redirected by an
+// iptables or nftables REDIRECT/DNAT rule was originally sent to, as
+// recorded by conntrack (SO_ORIGINAL_DST).
+func originalDst(conn net.Conn) (string, error) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return "", errOriginalDstUnsupported
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return "", fmt.Errorf("get raw conn: %w", err)
+	}
+
+	ipv4 := localIsIPv4(conn)
+	var addr netip.AddrPort
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		if ipv4 {
+			addr, sockErr = originalDstIPv4(int(fd))
+		} else {
+			addr, sockErr = originalDstIPv6(int(fd))
+		}
+	}); err != nil {
+		return "", fmt.Errorf("access socket: %w", err)
+	}
+	if sockErr != nil {
+		return "", fmt.Errorf("get original destination: %w", sockErr)
+	}
+	return addr.String(), nil
+}
+
+// originalDstIPv4 reads SO_ORIGINAL_DST, which returns a sockaddr_in. The
+// option has no getter of its own; the 16-byte ip_mreqn buffer fits it.
+func originalDstIPv4(fd int) (netip.AddrPort, error) {
+	mreq, err := unix.GetsockoptIPv6Mreq(fd, unix.SOL_IP, unix.SO_ORIGINAL_DST)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	return parseSockaddrInet4(mreq.Multiaddr), nil
+}
+
+// originalDstIPv6 reads IP6T_SO_ORIGINAL_DST, which returns a sockaddr_in6
+// at the start of the ip6_mtuinfo buffer.
+func originalDstIPv6(fd int) (netip.AddrPort, error) {
+	info, err := unix.GetsockoptIPv6MTUInfo(fd, unix.SOL_IPV6, ip6tSoOriginalDst)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	return parseSockaddrInet6(info.Addr), nil
+}
+
+// parseSockaddrInet4 decodes a raw sockaddr_in.
+func parseSockaddrInet4(sa [16]byte) netip.AddrPort {
+	port := binary.BigEndian.Uint16(sa[2:4])
+	ip := netip.AddrFrom4([4]byte(sa[4:8]))
+	return netip.AddrPortFrom(ip, port)
+}
+
+// parseSockaddrInet6 decodes a raw sockaddr_in6, whose port is in network
+// byte order.
+func parseSockaddrInet6(sa unix.RawSockaddrInet6) netip.AddrPort {
+	var port [2]byte
+	binary.NativeEndian.PutUint16(port[:], sa.Port)
+	ip := netip.AddrFrom16(sa.Addr)
+	return netip.AddrPortFrom(ip, binary.BigEndian.Uint16(port[:]))
+}
@@ -0,0 +1,63 @@
+package l4handler
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestParseSockaddrInet4(t *testing.T) {
+	var sa [16]byte
+	binary.NativeEndian.PutUint16(sa[0:2], unix.AF_INET)
+	binary.BigEndian.PutUint16(sa[2:4], 5432)
+	copy(sa[4:8], []byte{100, 64, 0, 7})
+
+	assert.Equal(t, netip.MustParseAddrPort("100.64.0.7:5432"), parseSockaddrInet4(sa))
+}
+
+func TestParseSockaddrInet6(t *testing.T) {
+	var port [2]byte
+	binary.BigEndian.PutUint16(port[:], 8443)
+	sa := unix.RawSockaddrInet6{
+		Family: unix.AF_INET6,
+		Port:   binary.NativeEndian.Uint16(port[:]),
+		Addr:   netip.MustParseAddr("fd00::7").As16(),
+	}
+
+	assert.Equal(t, netip.MustParseAddrPort("[fd00::7]:8443"), parseSockaddrInet6(sa))
+}
+
+func TestOriginalDst_NotRedirected(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			conn.Close()
+		}
+	}()
+
+	conn, err := net.Dial("tcp4", ln.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Without a conntrack entry the kernel has no original destination.
+	_, err = originalDst(conn)
+	assert.Error(t, err)
+}
+
+func TestOriginalDst_Unsupported(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	_, err := originalDst(client)
+	assert.ErrorIs(t, err, errOriginalDstUnsupported)
+}
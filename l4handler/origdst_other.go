@@ -0,0 +1,10 @@
+//go:build !linux
+
+package l4handler
+
+import "net"
+
+// originalDst is only supported on Linux.
+func originalDst(net.Conn) (string, error) {
+	return "", errOriginalDstUnsupported
+}
@@ -0,0 +1,85 @@
+package l4handler
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+// maxIdleConnsPerKey bounds the number of idle upstream connections kept per key.
+const maxIdleConnsPerKey = 4
+
+// maxIdleConns bounds the number of idle upstream connections kept across
+// all keys, as keys include the downstream client.
+const maxIdleConns = 256
+
+// connPool keeps idle upstream connections for reuse, keyed by network,
+// address and downstream client.
+type connPool struct {
+	mu       sync.Mutex
+	idle     map[string][]net.Conn
+	max      int
+	maxTotal int
+	total    int
+}
+
+func newConnPool(maxPerKey, maxTotal int) *connPool {
+	return &connPool{
+		idle:     make(map[string][]net.Conn),
+		max:      maxPerKey,
+		maxTotal: maxTotal,
+	}
+}
+
+// get returns an idle connection for key, or nil if none is available.
+func (p *connPool) get(key string) net.Conn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	conns := p.idle[key]
+	if len(conns) == 0 {
+		return nil
+	}
+	conn := conns[len(conns)-1]
+	if len(conns) == 1 {
+		delete(p.idle, key)
+	} else {
+		p.idle[key] = conns[:len(conns)-1]
+	}
+	p.total--
+	return conn
+}
+
+// put stores conn for later reuse. It reports false if the pool for key or
+// the whole pool is full, in which case the caller keeps ownership of conn.
+func (p *connPool) put(key string, conn net.Conn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.idle == nil || len(p.idle[key]) >= p.max || p.total >= p.maxTotal {
+		return false
+	}
+	p.idle[key] = append(p.idle[key], conn)
+	p.total++
+	return true
+}
+
+// closeAll closes and removes all idle connections. Connections put afterwards
+// are rejected.
+func (p *connPool) closeAll() error {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.total = 0
+	p.mu.Unlock()
+
+	var errs []error
+	for _, conns := range idle {
+		for _, conn := range conns {
+			if err := conn.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,95 @@
+package l4handler
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnPool_GetPut(t *testing.T) {
+	p := newConnPool(2, maxIdleConns)
+	assert.Nil(t, p.get("udp/a:53"), "empty pool")
+
+	c1, s1 := net.Pipe()
+	defer s1.Close()
+
+	require.True(t, p.put("udp/a:53", c1))
+	assert.Nil(t, p.get("udp/b:53"), "conns are keyed by upstream")
+	assert.Same(t, c1, p.get("udp/a:53"))
+	assert.Nil(t, p.get("udp/a:53"), "conn is removed once taken")
+}
+
+func TestConnPool_Full(t *testing.T) {
+	p := newConnPool(1, maxIdleConns)
+
+	c1, s1 := net.Pipe()
+	defer s1.Close()
+	c2, s2 := net.Pipe()
+	defer s2.Close()
+	defer c2.Close()
+
+	require.True(t, p.put("udp/a:53", c1))
+	assert.False(t, p.put("udp/a:53", c2), "pool should reject conns beyond the limit")
+}
+
+func TestConnPool_FullTotal(t *testing.T) {
+	p := newConnPool(2, 2)
+
+	for _, key := range []string{"udp/a:53/client1", "udp/a:53/client2"} {
+		c, s := net.Pipe()
+		defer s.Close()
+		require.True(t, p.put(key, c))
+	}
+
+	c3, s3 := net.Pipe()
+	defer s3.Close()
+	defer c3.Close()
+	assert.False(t, p.put("udp/a:53/client3", c3), "pool should reject conns beyond the total limit")
+
+	require.NotNil(t, p.get("udp/a:53/client1"))
+	assert.NotContains(t, p.idle, "udp/a:53/client1", "keys without idle conns should be removed")
+	assert.True(t, p.put("udp/a:53/client3", c3), "taking a conn should free room")
+}
+
+func TestConnPool_CloseAll(t *testing.T) {
+	p := newConnPool(2, maxIdleConns)
+
+	c1, s1 := net.Pipe()
+	defer s1.Close()
+	require.True(t, p.put("udp/a:53", c1))
+
+	require.NoError(t, p.closeAll())
+	_, err := c1.Write([]byte("x"))
+	require.Error(t, err, "idle conn should be closed")
+
+	c2, s2 := net.Pipe()
+	defer s2.Close()
+	defer c2.Close()
+	assert.False(t, p.put("udp/a:53", c2), "closed pool should reject conns")
+	assert.Nil(t, p.get("udp/a:53"))
+}
+
+func TestConnPool_Concurrent(t *testing.T) {
+	p := newConnPool(maxIdleConnsPerKey, maxIdleConns)
+
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c, s := net.Pipe()
+			defer s.Close()
+			if !p.put("udp/a:53", c) {
+				c.Close()
+			}
+			if conn := p.get("udp/a:53"); conn != nil {
+				conn.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	require.NoError(t, p.closeAll())
+}
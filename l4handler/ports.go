@@ -0,0 +1,84 @@
+package l4handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// PortRoute maps the local port a connection was accepted on to the
+// upstream and node its connections are proxied through, so one handler can
+// serve several services on different listener ports.
+type PortRoute struct {
+	// Port is the local port to match.
+	Port uint16 `json:"port"`
+	// Upstream is the host:port to dial.
+	Upstream string `json:"upstream"`
+	// Node is the name of the NetBird node to use for dialing. Defaults to
+	// the handler's node.
+	Node string `json:"node,omitempty"`
+}
+
+// provisionPortRoutes starts the clients of the nodes used by port routes
+// and indexes the routes by port.
+func (h *Handler) provisionPortRoutes(ctx context.Context) error {
+	if len(h.Ports) == 0 {
+		return nil
+	}
+	h.portRoutes = make(map[uint16]target, len(h.Ports))
+	for _, route := range h.Ports {
+		if route.Port == 0 || route.Upstream == "" {
+			return errors.New("port route requires a port and an upstream")
+		}
+		if _, ok := h.portRoutes[route.Port]; ok {
+			return fmt.Errorf("duplicate port route for port %d", route.Port)
+		}
+
+		tgt := h.defaultTarget()
+		tgt.upstream = route.Upstream
+		if route.Node != "" && route.Node != h.Node {
+			dial, err := h.nodeDialer(ctx, route.Node)
+			if err != nil {
+				return err
+			}
+			tgt.node = route.Node
+			tgt.dial = dial
+		}
+		h.portRoutes[route.Port] = tgt
+	}
+	return nil
+}
+
+// portTargetFor returns the target of the port route matching the local
+// address of a connection, or the handler's node and upstream.
+func (h *Handler) portTargetFor(local net.Addr) target {
+	if port, ok := localPort(local); ok && len(h.portRoutes) > 0 {
+		if tgt, ok := h.portRoutes[port]; ok {
+			return tgt
+		}
+	}
+	return h.defaultTarget()
+}
+
+// localPort returns the port of a local address, if it has one.
+func localPort(addr net.Addr) (uint16, bool) {
+	switch a := addr.(type) {
+	case nil:
+		return 0, false
+	case *net.TCPAddr:
+		return uint16(a.Port), true
+	case *net.UDPAddr:
+		return uint16(a.Port), true
+	}
+	_, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return 0, false
+	}
+	return uint16(n), true
+}
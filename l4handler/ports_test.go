@@ -0,0 +1,192 @@
+package l4handler
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/mholt/caddy-l4/layer4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// tcpPipeConn is one end of a net.Pipe that reports a TCP local address
+// with the given port.
+type tcpPipeConn struct {
+	net.Conn
+	port int
+}
+
+func (c tcpPipeConn) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: c.port}
+}
+
+func TestUnmarshalCaddyfile_PortRoute(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`netbird 10.0.0.1:443 ingress {
+		port_route 5432 10.0.0.2:5432 db
+		port_route 6379 10.0.0.3:6379
+	}`)
+
+	var h Handler
+	require.NoError(t, h.UnmarshalCaddyfile(d))
+	assert.Equal(t, []PortRoute{
+		{Port: 5432, Upstream: "10.0.0.2:5432", Node: "db"},
+		{Port: 6379, Upstream: "10.0.0.3:6379"},
+	}, h.Ports)
+}
+
+func TestUnmarshalCaddyfile_PortRouteInvalid(t *testing.T) {
+	for _, input := range []string{
+		"netbird 10.0.0.1:443 {\n port_route 5432\n}",
+		"netbird 10.0.0.1:443 {\n port_route 0 10.0.0.2:5432\n}",
+		"netbird 10.0.0.1:443 {\n port_route 70000 10.0.0.2:5432\n}",
+		"netbird 10.0.0.1:443 {\n port_route pg 10.0.0.2:5432\n}",
+		"netbird 10.0.0.1:443 {\n port_route 5432 10.0.0.2:5432 db extra\n}",
+	} {
+		var h Handler
+		assert.Error(t, h.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)), input)
+	}
+}
+
+func TestProvisionPortRoutes(t *testing.T) {
+	var started []string
+	h := &Handler{
+		Node:     "ingress",
+		Upstream: "10.0.0.1:443",
+		dial:     echoDialer(new(atomic.Int32)),
+		Ports: []PortRoute{
+			{Port: 5432, Upstream: "10.0.0.2:5432", Node: "db"},
+			{Port: 6379, Upstream: "10.0.0.3:6379"},
+			{Port: 8443, Upstream: "10.0.0.4:8443", Node: "ingress"},
+		},
+		startNode: func(_ context.Context, node string) (dialFunc, error) {
+			started = append(started, node)
+			return echoDialer(new(atomic.Int32)), nil
+		},
+	}
+	require.NoError(t, h.provisionPortRoutes(context.Background()))
+	assert.Equal(t, []string{"db"}, started, "only other nodes should be started")
+
+	tests := []struct {
+		port     int
+		node     string
+		upstream string
+	}{
+		{5432, "db", "10.0.0.2:5432"},
+		{6379, "ingress", "10.0.0.3:6379"},
+		{8443, "ingress", "10.0.0.4:8443"},
+		{443, "ingress", "10.0.0.1:443"},
+	}
+	for _, tt := range tests {
+		tgt := h.portTargetFor(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: tt.port})
+		assert.Equal(t, tt.node, tgt.node, tt.port)
+		assert.Equal(t, tt.upstream, tgt.upstream, tt.port)
+		assert.NotNil(t, tgt.dial, tt.port)
+	}
+}
+
+func TestProvisionPortRoutes_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		ports   []PortRoute
+		wantErr string
+	}{
+		{name: "missing port", ports: []PortRoute{{Upstream: "10.0.0.2:5432"}}, wantErr: "requires a port"},
+		{name: "missing upstream", ports: []PortRoute{{Port: 5432}}, wantErr: "requires a port and an upstream"},
+		{
+			name:    "duplicate",
+			ports:   []PortRoute{{Port: 5432, Upstream: "10.0.0.2:5432"}, {Port: 5432, Upstream: "10.0.0.3:5432"}},
+			wantErr: "duplicate port route for port 5432",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &Handler{Node: "ingress", Upstream: "10.0.0.1:443", Ports: tt.ports}
+			err := h.provisionPortRoutes(context.Background())
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestLocalPort(t *testing.T) {
+	port, ok := localPort(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 53})
+	assert.True(t, ok)
+	assert.Equal(t, uint16(53), port)
+
+	port, ok = localPort(&net.TCPAddr{IP: net.IPv6loopback, Port: 443})
+	assert.True(t, ok)
+	assert.Equal(t, uint16(443), port)
+
+	_, ok = localPort(nil)
+	assert.False(t, ok)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	_, ok = localPort(client.LocalAddr())
+	assert.False(t, ok, "pipe addresses have no port")
+}
+
+func TestHandle_PortRoute(t *testing.T) {
+	var defaultDials, dbDials, sniDials atomic.Int32
+	var dialed []string
+	recordDial := func(dials *atomic.Int32) dialFunc {
+		echo := echoDialer(dials)
+		return func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialed = append(dialed, addr)
+			return echo(ctx, network, addr)
+		}
+	}
+	h := &Handler{
+		Node:     "ingress",
+		Upstream: "10.0.0.1:443",
+		dial:     recordDial(&defaultDials),
+		portRoutes: map[uint16]target{
+			5432: {node: "db", upstream: "10.0.0.2:5432", dial: recordDial(&dbDials)},
+		},
+		routes: map[string]target{
+			"app.example.com": {node: "web", upstream: "10.0.0.3:443", dial: recordDial(&sniDials)},
+		},
+		logger: zap.NewNop(),
+	}
+
+	run := func(port int, serverName string) {
+		downstream, client := net.Pipe()
+		cx := layer4.WrapConnection(tcpPipeConn{Conn: downstream, port: port}, nil, zap.NewNop())
+		if serverName != "" {
+			repl := cx.Context.Value(layer4.ReplacerCtxKey).(*caddy.Replacer)
+			repl.Set("l4.tls.server_name", serverName)
+		}
+
+		done := make(chan error, 1)
+		go func() {
+			done <- h.Handle(cx, nil)
+		}()
+		_, err := client.Write([]byte("hello"))
+		require.NoError(t, err)
+		buf := make([]byte, 5)
+		_, err = io.ReadFull(client, buf)
+		require.NoError(t, err)
+		require.NoError(t, client.Close())
+		require.NoError(t, <-done)
+	}
+
+	run(5432, "")
+	assert.Equal(t, int32(1), dbDials.Load())
+	assert.Equal(t, int32(0), defaultDials.Load())
+
+	run(443, "")
+	assert.Equal(t, int32(1), defaultDials.Load(), "other ports should use the default target")
+
+	run(5432, "app.example.com")
+	assert.Equal(t, int32(1), sniDials.Load(), "sni routes should take precedence")
+	assert.Equal(t, int32(1), dbDials.Load())
+
+	assert.Equal(t, []string{"10.0.0.2:5432", "10.0.0.1:443", "10.0.0.3:443"}, dialed)
+}
@@ -0,0 +1,141 @@
+package l4handler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// pgMaxStartupLength matches the server's limit for startup packets.
+	pgMaxStartupLength = 10000
+	// pgProtocolV3 is the protocol version of regular startup messages.
+	pgProtocolV3 = 3 << 16
+
+	pgCancelRequest  = 80877102
+	pgSSLRequest     = 80877103
+	pgGSSENCRequest  = 80877104
+	pgDeclineRequest = 'N'
+)
+
+// PostgresRoute maps a PostgreSQL database or user to the node and upstream
+// its connections are proxied through. Exactly one of Database and User is set.
+type PostgresRoute struct {
+	// Database is the database name to match.
+	Database string `json:"database,omitempty"`
+	// User is the user name to match.
+	User string `json:"user,omitempty"`
+	// Node is the name of the NetBird node to use for dialing.
+	Node string `json:"node"`
+	// Upstream is the host:port to dial. Defaults to the handler's upstream.
+	Upstream string `json:"upstream,omitempty"`
+}
+
+// pgRoute is a provisioned PostgresRoute.
+type pgRoute struct {
+	database string
+	user     string
+	target   target
+}
+
+// postgresTargetFor returns the target of the first route matching the
+// startup parameters, or fallback.
+func (h *Handler) postgresTargetFor(params map[string]string, fallback target) target {
+	if params == nil {
+		return fallback
+	}
+	for _, route := range h.pgRoutes {
+		if route.database != "" && route.database == pgDatabase(params) {
+			return route.target
+		}
+		if route.user != "" && route.user == params["user"] {
+			return route.target
+		}
+	}
+	return fallback
+}
+
+// pgDatabase returns the requested database, which defaults to the user name.
+func pgDatabase(params map[string]string) string {
+	if db := params["database"]; db != "" {
+		return db
+	}
+	return params["user"]
+}
+
+// readPostgresStartup reads the startup message of a PostgreSQL connection
+// and returns it unchanged together with its parameters, so it can be
+// forwarded to the upstream. SSL and GSSAPI encryption requests are declined
+// on the client's side, as routing needs the plaintext startup message; the
+// client then sends the startup message. Cancel requests have no parameters
+// and yield nil params.
+func readPostgresStartup(rw io.ReadWriter) ([]byte, map[string]string, error) {
+	for {
+		msg, err := readPostgresMessage(rw)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		code := binary.BigEndian.Uint32(msg[4:8])
+		switch code {
+		case pgSSLRequest, pgGSSENCRequest:
+			if _, err := rw.Write([]byte{pgDeclineRequest}); err != nil {
+				return nil, nil, fmt.Errorf("decline encryption request: %w", err)
+			}
+		case pgCancelRequest:
+			return msg, nil, nil
+		case pgProtocolV3:
+			params, err := parseStartupParams(msg[8:])
+			if err != nil {
+				return nil, nil, err
+			}
+			return msg, params, nil
+		default:
+			return nil, nil, fmt.Errorf("unsupported protocol version %d.%d", code>>16, code&0xffff)
+		}
+	}
+}
+
+// readPostgresMessage reads a length-prefixed startup-phase message,
+// including its length.
+func readPostgresMessage(r io.Reader) ([]byte, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header[:4])
+	if length < uint32(len(header)) || length > pgMaxStartupLength {
+		return nil, fmt.Errorf("invalid startup message length %d", length)
+	}
+
+	msg := make([]byte, length)
+	copy(msg, header[:])
+	if _, err := io.ReadFull(r, msg[len(header):]); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// parseStartupParams parses the NUL-terminated name/value pairs of a startup
+// message, which end with an empty name.
+func parseStartupParams(data []byte) (map[string]string, error) {
+	params := make(map[string]string)
+	for {
+		name, rest, ok := bytes.Cut(data, []byte{0})
+		if !ok {
+			return nil, errors.New("unterminated startup parameter")
+		}
+		if len(name) == 0 {
+			return params, nil
+		}
+		value, rest, ok := bytes.Cut(rest, []byte{0})
+		if !ok {
+			return nil, fmt.Errorf("unterminated value of startup parameter %q", name)
+		}
+		params[string(name)] = string(value)
+		data = rest
+	}
+}
@@ -0,0 +1,241 @@
+package l4handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/mholt/caddy-l4/layer4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// pgMessage builds a startup-phase message with the given code and body.
+func pgMessage(code uint32, body []byte) []byte {
+	msg := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(msg[:4], uint32(8+len(body)))
+	binary.BigEndian.PutUint32(msg[4:8], code)
+	return append(msg, body...)
+}
+
+// pgStartup builds a protocol 3.0 startup message with the given parameters.
+func pgStartup(params ...string) []byte {
+	var body []byte
+	for _, p := range params {
+		body = append(body, p...)
+		body = append(body, 0)
+	}
+	return pgMessage(pgProtocolV3, append(body, 0))
+}
+
+// pgConn is a downstream that replays input and records what is written back.
+type pgConn struct {
+	io.Reader
+	written bytes.Buffer
+}
+
+func (c *pgConn) Write(p []byte) (int, error) {
+	return c.written.Write(p)
+}
+
+func TestReadPostgresStartup(t *testing.T) {
+	startup := pgStartup("user", "alice", "database", "orders", "application_name", "psql")
+	conn := &pgConn{Reader: bytes.NewReader(append(startup, "query"...))}
+
+	raw, params, err := readPostgresStartup(conn)
+	require.NoError(t, err)
+	assert.Equal(t, startup, raw)
+	assert.Equal(t, map[string]string{
+		"user":             "alice",
+		"database":         "orders",
+		"application_name": "psql",
+	}, params)
+	assert.Zero(t, conn.written.Len())
+
+	rest, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "query", string(rest), "only the startup message is consumed")
+}
+
+func TestReadPostgresStartup_DeclinesEncryption(t *testing.T) {
+	startup := pgStartup("user", "alice")
+	var input []byte
+	input = append(input, pgMessage(pgGSSENCRequest, nil)...)
+	input = append(input, pgMessage(pgSSLRequest, nil)...)
+	input = append(input, startup...)
+	conn := &pgConn{Reader: bytes.NewReader(input)}
+
+	raw, params, err := readPostgresStartup(conn)
+	require.NoError(t, err)
+	assert.Equal(t, startup, raw, "encryption requests are not forwarded")
+	assert.Equal(t, "alice", params["user"])
+	assert.Equal(t, "NN", conn.written.String())
+}
+
+func TestReadPostgresStartup_CancelRequest(t *testing.T) {
+	cancel := pgMessage(pgCancelRequest, []byte{0, 0, 0, 1, 0, 0, 0, 2})
+	raw, params, err := readPostgresStartup(&pgConn{Reader: bytes.NewReader(cancel)})
+	require.NoError(t, err)
+	assert.Equal(t, cancel, raw)
+	assert.Nil(t, params)
+}
+
+func TestReadPostgresStartup_Invalid(t *testing.T) {
+	tooLong := make([]byte, 8)
+	binary.BigEndian.PutUint32(tooLong, pgMaxStartupLength+1)
+
+	tests := []struct {
+		name  string
+		input []byte
+	}{
+		{"short", []byte{0, 0, 0}},
+		{"length below header", []byte{0, 0, 0, 4, 0, 3, 0, 0}},
+		{"too long", tooLong},
+		{"truncated body", pgStartup("user", "alice")[:12]},
+		{"unsupported version", pgMessage(2<<16, []byte{0})},
+		{"unterminated name", pgMessage(pgProtocolV3, []byte("user"))},
+		{"unterminated value", pgMessage(pgProtocolV3, []byte("user\x00alice"))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := readPostgresStartup(&pgConn{Reader: bytes.NewReader(tt.input)})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestPostgresTargetFor(t *testing.T) {
+	fallback := target{node: "default", upstream: "10.0.0.1:5432"}
+	h := &Handler{pgRoutes: []pgRoute{
+		{database: "orders", target: target{node: "orders"}},
+		{user: "analytics", target: target{node: "warehouse"}},
+		{database: "alice", target: target{node: "personal"}},
+	}}
+
+	tests := []struct {
+		name   string
+		params map[string]string
+		node   string
+	}{
+		{"database", map[string]string{"user": "bob", "database": "orders"}, "orders"},
+		{"first match wins", map[string]string{"user": "analytics", "database": "orders"}, "orders"},
+		{"user", map[string]string{"user": "analytics", "database": "stats"}, "warehouse"},
+		{"database defaults to user", map[string]string{"user": "alice"}, "personal"},
+		{"no match", map[string]string{"user": "bob", "database": "bob"}, "default"},
+		{"cancel request", nil, "default"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.node, h.postgresTargetFor(tt.params, fallback).node)
+		})
+	}
+}
+
+func TestUnmarshalCaddyfile_PostgresRoute(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`netbird 10.0.0.1:5432 {
+		postgres_route database orders orders
+		postgres_route user analytics warehouse 10.0.0.2:5432
+	}`)
+
+	var h Handler
+	require.NoError(t, h.UnmarshalCaddyfile(d))
+	assert.Equal(t, []PostgresRoute{
+		{Database: "orders", Node: "orders"},
+		{User: "analytics", Node: "warehouse", Upstream: "10.0.0.2:5432"},
+	}, h.Postgres)
+
+	for _, input := range []string{
+		"netbird 10.0.0.1:5432 {\n postgres_route database orders\n}",
+		"netbird 10.0.0.1:5432 {\n postgres_route schema orders orders\n}",
+		"netbird 10.0.0.1:5432 {\n postgres_route user a b 10.0.0.2:5432 extra\n}",
+	} {
+		var h Handler
+		assert.Error(t, h.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)), input)
+	}
+}
+
+func TestHandle_PostgresRoute(t *testing.T) {
+	received := make(chan []byte, 1)
+	var dialed string
+	h := &Handler{
+		Node:     "default",
+		Upstream: "10.0.0.1:5432",
+		dial: func(context.Context, string, string) (net.Conn, error) {
+			t.Error("default target must not be dialed")
+			return nil, io.EOF
+		},
+		pgRoutes: []pgRoute{{
+			database: "orders",
+			target: target{node: "orders", upstream: "10.0.0.2:5432", dial: func(_ context.Context, _, addr string) (net.Conn, error) {
+				dialed = addr
+				client, server := net.Pipe()
+				go func() {
+					defer server.Close()
+					data, _ := io.ReadAll(server)
+					received <- data
+				}()
+				return client, nil
+			}},
+		}},
+		logger: zap.NewNop(),
+	}
+
+	downstream, client := net.Pipe()
+	cx := layer4.WrapConnection(downstream, nil, zap.NewNop())
+	done := make(chan error, 1)
+	go func() {
+		done <- h.Handle(cx, nil)
+	}()
+
+	_, err := client.Write(pgMessage(pgSSLRequest, nil))
+	require.NoError(t, err)
+	reply := make([]byte, 1)
+	_, err = io.ReadFull(client, reply)
+	require.NoError(t, err)
+	assert.Equal(t, "N", string(reply))
+
+	startup := pgStartup("user", "bob", "database", "orders")
+	_, err = client.Write(append(startup, "query"...))
+	require.NoError(t, err)
+	require.NoError(t, client.Close())
+	require.NoError(t, <-done)
+
+	assert.Equal(t, "10.0.0.2:5432", dialed)
+	assert.Equal(t, append(startup, "query"...), <-received)
+}
+
+func TestReadWithTimeout(t *testing.T) {
+	downstream, client := net.Pipe()
+	defer downstream.Close()
+	defer client.Close()
+
+	err := readWithTimeout(downstream, 50*time.Millisecond, func() error {
+		_, _, err := readPostgresStartup(downstream)
+		return err
+	})
+	assert.True(t, isTimeout(err), "a silent client must time out: %v", err)
+
+	startup := pgStartup("user", "bob")
+	go func() {
+		_, _ = client.Write(startup)
+		time.Sleep(100 * time.Millisecond)
+		_, _ = client.Write([]byte("query"))
+	}()
+	var raw []byte
+	require.NoError(t, readWithTimeout(downstream, 50*time.Millisecond, func() (err error) {
+		raw, _, err = readPostgresStartup(downstream)
+		return err
+	}))
+	assert.Equal(t, startup, raw)
+
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(downstream, buf)
+	require.NoError(t, err, "the deadline must be cleared after the startup message")
+	assert.Equal(t, "query", string(buf))
+}
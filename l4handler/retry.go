@@ -0,0 +1,277 @@
+package l4handler
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// dialRetryDelay is the pause before redialing a failed upstream.
+const dialRetryDelay = 200 * time.Millisecond
+
+// retryConn is an upstream TCP connection that is transparently redialed if
+// it is reset before any data went either way, e.g. because the backend
+// reset the connection while restarting. Nothing is replayed: once a byte
+// was written to or read from the upstream, errors are returned as-is.
+type retryConn struct {
+	ctx   context.Context
+	dial  func() (net.Conn, error)
+	delay time.Duration
+
+	// forwarded is set once data went either way. From then on errors are
+	// returned as-is.
+	forwarded atomic.Bool
+
+	// writeMu serializes writes with the forwarded check of a redial, so a
+	// redial can't miss data written to the failed conn.
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	conn    net.Conn
+	retries int
+	// redialing is closed once the running redial finished, nil if none runs.
+	redialing chan struct{}
+	// writeClosed is set on CloseWrite, which is repeated on redialed conns.
+	writeClosed bool
+	// done is set on Close, after which errors are expected.
+	done bool
+}
+
+// dialRetrying dials with up to retries redials on failure. The retries not
+// used up by the dial remain available for failures of the returned conn.
+func dialRetrying(ctx context.Context, dial func() (net.Conn, error), retries int, delay time.Duration) (*retryConn, error) {
+	rc := &retryConn{ctx: ctx, dial: dial, delay: delay, retries: retries}
+
+	conn, err := dial()
+	for err != nil && rc.retries > 0 {
+		rc.retries--
+		if waitErr := sleepCtx(ctx, delay); waitErr != nil {
+			return nil, errors.Join(err, waitErr)
+		}
+		conn, err = dial()
+	}
+	if err != nil {
+		return nil, err
+	}
+	rc.conn = conn
+	return rc, nil
+}
+
+// isReset reports whether err is a connection reset or refusal. The
+// message is matched as well, since netstack errors don't wrap errnos.
+func isReset(err error) bool {
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "connection reset by peer") || strings.Contains(msg, "connection was refused")
+}
+
+// sleepCtx waits for d or until ctx is done.
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (rc *retryConn) current() net.Conn {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.conn
+}
+
+// redial replaces failed with a new conn if it was reset before any data
+// went either way and retries are left. If the other direction already replaced it, the
+// new conn is returned. Otherwise err is returned. The lock is not held
+// while waiting and dialing, so the conn can be closed meanwhile.
+func (rc *retryConn) redial(failed net.Conn, err error) (net.Conn, error) {
+	rc.mu.Lock()
+	for rc.redialing != nil {
+		wait := rc.redialing
+		rc.mu.Unlock()
+		<-wait
+		rc.mu.Lock()
+	}
+	if rc.conn != failed {
+		conn := rc.conn
+		rc.mu.Unlock()
+		return conn, nil
+	}
+	if rc.done || rc.forwarded.Load() || rc.retries == 0 || !isReset(err) {
+		rc.mu.Unlock()
+		return nil, err
+	}
+	redialing := make(chan struct{})
+	rc.redialing = redialing
+	rc.mu.Unlock()
+
+	conn, err := rc.reconnect(failed, err)
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.redialing = nil
+	close(redialing)
+	if err != nil {
+		return nil, err
+	}
+	if rc.done {
+		_ = conn.Close()
+		return nil, net.ErrClosed
+	}
+	rc.conn = conn
+	return conn, nil
+}
+
+// reconnect closes failed and dials until a new conn is established, or no
+// retries are left.
+func (rc *retryConn) reconnect(failed net.Conn, err error) (net.Conn, error) {
+	// Closing unblocks a write in progress, so writeMu can be taken.
+	_ = failed.Close()
+	rc.writeMu.Lock()
+	forwarded := rc.forwarded.Load()
+	rc.writeMu.Unlock()
+	if forwarded {
+		return nil, err
+	}
+
+	for rc.takeRetry() {
+		if waitErr := sleepCtx(rc.ctx, rc.delay); waitErr != nil {
+			return nil, errors.Join(err, waitErr)
+		}
+
+		conn, dialErr := rc.dial()
+		if dialErr != nil {
+			err = errors.Join(err, dialErr)
+			continue
+		}
+		if closeErr := rc.repeatCloseWrite(conn); closeErr != nil {
+			_ = conn.Close()
+			err = errors.Join(err, closeErr)
+			continue
+		}
+		return conn, nil
+	}
+	return nil, err
+}
+
+// takeRetry uses up a retry, reporting false if none is left or the conn
+// was closed.
+func (rc *retryConn) takeRetry() bool {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.done || rc.retries == 0 {
+		return false
+	}
+	rc.retries--
+	return true
+}
+
+// repeatCloseWrite half-closes conn if the client already did.
+func (rc *retryConn) repeatCloseWrite(conn net.Conn) error {
+	rc.mu.Lock()
+	writeClosed := rc.writeClosed
+	rc.mu.Unlock()
+	if !writeClosed {
+		return nil
+	}
+	if cw, ok := conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// Read reads from the upstream, redialing if it is reset before any data
+// went either way.
+func (rc *retryConn) Read(p []byte) (int, error) {
+	conn := rc.current()
+	for {
+		n, err := conn.Read(p)
+		if n > 0 {
+			rc.forwarded.Store(true)
+			return n, err
+		}
+		if err == nil {
+			return 0, nil
+		}
+		if conn, err = rc.redial(conn, err); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write writes to the upstream, redialing if it is reset before any data
+// went either way.
+func (rc *retryConn) Write(p []byte) (int, error) {
+	conn := rc.current()
+	for {
+		n, err := rc.write(conn, p)
+		if n > 0 {
+			return n, err
+		}
+		if err == nil {
+			return 0, nil
+		}
+		if conn, err = rc.redial(conn, err); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// write writes p to conn and marks the conn forwarded once data got out.
+func (rc *retryConn) write(conn net.Conn, p []byte) (int, error) {
+	rc.writeMu.Lock()
+	defer rc.writeMu.Unlock()
+
+	n, err := conn.Write(p)
+	if n > 0 {
+		rc.forwarded.Store(true)
+	}
+	return n, err
+}
+
+// CloseWrite half-closes the upstream if supported and closes it otherwise.
+func (rc *retryConn) CloseWrite() error {
+	rc.mu.Lock()
+	rc.writeClosed = true
+	conn := rc.conn
+	rc.mu.Unlock()
+
+	if cw, ok := conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	rc.mu.Lock()
+	rc.done = true
+	rc.mu.Unlock()
+	return conn.Close()
+}
+
+// Close closes the upstream.
+func (rc *retryConn) Close() error {
+	rc.mu.Lock()
+	rc.done = true
+	conn := rc.conn
+	rc.mu.Unlock()
+	return conn.Close()
+}
+
+func (rc *retryConn) LocalAddr() net.Addr  { return rc.current().LocalAddr() }
+func (rc *retryConn) RemoteAddr() net.Addr { return rc.current().RemoteAddr() }
+
+func (rc *retryConn) SetDeadline(t time.Time) error      { return rc.current().SetDeadline(t) }
+func (rc *retryConn) SetReadDeadline(t time.Time) error  { return rc.current().SetReadDeadline(t) }
+func (rc *retryConn) SetWriteDeadline(t time.Time) error { return rc.current().SetWriteDeadline(t) }
+
+var (
+	_ net.Conn    = (*retryConn)(nil)
+	_ closeWriter = (*retryConn)(nil)
+)
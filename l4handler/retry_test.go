@@ -0,0 +1,295 @@
+package l4handler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/mholt/caddy-l4/layer4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// resetConn reports the peer closing its end as a connection reset, like
+// a backend that sends a RST.
+type resetConn struct {
+	net.Conn
+}
+
+func (c resetConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	return n, asReset("read", err)
+}
+
+func (c resetConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	return n, asReset("write", err)
+}
+
+func asReset(op string, err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrClosedPipe) {
+		return &net.OpError{Op: op, Net: "tcp", Err: syscall.ECONNRESET}
+	}
+	return err
+}
+
+// flakyDialer returns a dial func whose first failures conns are reset by
+// the "backend" right away. Later conns echo everything back.
+func flakyDialer(dials *atomic.Int32, failures int32) func() (net.Conn, error) {
+	return func() (net.Conn, error) {
+		n := dials.Add(1)
+		client, server := net.Pipe()
+		if n <= failures {
+			server.Close()
+			return resetConn{client}, nil
+		}
+		go func() {
+			defer server.Close()
+			_, _ = io.Copy(server, server)
+		}()
+		return client, nil
+	}
+}
+
+func TestRetryConn_RedialsBeforeFirstByte(t *testing.T) {
+	var dials atomic.Int32
+	rc, err := dialRetrying(context.Background(), flakyDialer(&dials, 2), 3, 0)
+	require.NoError(t, err)
+	defer rc.Close()
+
+	_, err = rc.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(rc, buf)
+	require.NoError(t, err)
+
+	assert.Equal(t, "ping", string(buf))
+	assert.Equal(t, int32(3), dials.Load())
+}
+
+func TestRetryConn_GivesUp(t *testing.T) {
+	var dials atomic.Int32
+	rc, err := dialRetrying(context.Background(), flakyDialer(&dials, 10), 2, 0)
+	require.NoError(t, err)
+	defer rc.Close()
+
+	_, err = rc.Write([]byte("ping"))
+	require.Error(t, err)
+	assert.Equal(t, int32(3), dials.Load(), "initial dial plus two retries")
+}
+
+func TestRetryConn_NoRetryAfterForwarding(t *testing.T) {
+	var dials atomic.Int32
+	client, server := net.Pipe()
+	dial := func() (net.Conn, error) {
+		dials.Add(1)
+		return client, nil
+	}
+	rc, err := dialRetrying(context.Background(), dial, 3, 0)
+	require.NoError(t, err)
+	defer rc.Close()
+
+	go func() {
+		buf := make([]byte, 4)
+		_, _ = io.ReadFull(server, buf)
+		_, _ = server.Write([]byte("p"))
+		server.Close()
+	}()
+
+	_, err = rc.Write([]byte("ping"))
+	require.NoError(t, err)
+	_, err = rc.Read(make([]byte, 1))
+	require.NoError(t, err)
+	_, err = rc.Read(make([]byte, 4))
+	require.ErrorIs(t, err, io.EOF, "errors after the upstream sent data are returned as-is")
+	assert.Equal(t, int32(1), dials.Load())
+}
+
+func TestRetryConn_NoRetryAfterWrite(t *testing.T) {
+	var dials atomic.Int32
+	client, server := net.Pipe()
+	dial := func() (net.Conn, error) {
+		dials.Add(1)
+		return resetConn{client}, nil
+	}
+	rc, err := dialRetrying(context.Background(), dial, 3, 0)
+	require.NoError(t, err)
+	defer rc.Close()
+
+	go func() {
+		// Take the request, then fail before replying.
+		_, _ = io.ReadFull(server, make([]byte, 4))
+		server.Close()
+	}()
+
+	_, err = rc.Write([]byte("ping"))
+	require.NoError(t, err)
+	_, err = rc.Read(make([]byte, 4))
+	require.ErrorIs(t, err, syscall.ECONNRESET, "written data must not be sent twice")
+	assert.Equal(t, int32(1), dials.Load())
+}
+
+func TestRetryConn_NoRetryOnEOF(t *testing.T) {
+	var dials atomic.Int32
+	dial := func() (net.Conn, error) {
+		dials.Add(1)
+		client, server := net.Pipe()
+		server.Close()
+		return client, nil
+	}
+	rc, err := dialRetrying(context.Background(), dial, 3, 0)
+	require.NoError(t, err)
+	defer rc.Close()
+
+	_, err = rc.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF, "a clean close is not a failure")
+	assert.Equal(t, int32(1), dials.Load())
+}
+
+func TestRetryConn_CanceledDuringDelay(t *testing.T) {
+	var dials atomic.Int32
+	ctx, cancel := context.WithCancel(context.Background())
+	rc, err := dialRetrying(ctx, flakyDialer(&dials, 10), 3, time.Hour)
+	require.NoError(t, err)
+	defer rc.Close()
+
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := rc.Read(make([]byte, 1))
+		readErr <- err
+	}()
+	cancel()
+
+	select {
+	case err := <-readErr:
+		require.ErrorIs(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("redial delay ignored the canceled context")
+	}
+	assert.Equal(t, int32(1), dials.Load())
+}
+
+func TestIsReset(t *testing.T) {
+	assert.True(t, isReset(&net.OpError{Op: "read", Err: syscall.ECONNRESET}))
+	assert.True(t, isReset(&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}))
+	assert.True(t, isReset(&net.OpError{Op: "read", Err: errors.New("connection reset by peer")}), "netstack error")
+	assert.False(t, isReset(io.EOF))
+	assert.False(t, isReset(io.ErrClosedPipe))
+	assert.False(t, isReset(os.ErrDeadlineExceeded))
+}
+
+func TestRetryConn_CloseDuringRedial(t *testing.T) {
+	var dials atomic.Int32
+	rc, err := dialRetrying(context.Background(), flakyDialer(&dials, 10), 3, 200*time.Millisecond)
+	require.NoError(t, err)
+
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := rc.Read(make([]byte, 1))
+		readErr <- err
+	}()
+	require.Eventually(t, func() bool {
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+		return rc.redialing != nil
+	}, time.Second, time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		_ = rc.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("Close blocked on the running redial")
+	}
+
+	require.Error(t, <-readErr)
+	assert.LessOrEqual(t, dials.Load(), int32(2), "no retries after Close")
+}
+
+func TestRetryConn_NoRetryAfterClose(t *testing.T) {
+	var dials atomic.Int32
+	rc, err := dialRetrying(context.Background(), flakyDialer(&dials, 0), 3, 0)
+	require.NoError(t, err)
+
+	require.NoError(t, rc.Close())
+	_, err = rc.Read(make([]byte, 1))
+	require.Error(t, err)
+	assert.Equal(t, int32(1), dials.Load())
+}
+
+func TestDialRetrying_DialErrors(t *testing.T) {
+	errRefused := errors.New("connection refused")
+	var dials atomic.Int32
+	dial := func() (net.Conn, error) {
+		if dials.Add(1) < 3 {
+			return nil, errRefused
+		}
+		client, _ := net.Pipe()
+		return client, nil
+	}
+
+	rc, err := dialRetrying(context.Background(), dial, 2, 0)
+	require.NoError(t, err)
+	defer rc.Close()
+	assert.Equal(t, int32(3), dials.Load())
+	assert.Zero(t, rc.retries, "dial failures use up the retries")
+
+	dials.Store(0)
+	_, err = dialRetrying(context.Background(), dial, 1, 0)
+	require.ErrorIs(t, err, errRefused)
+}
+
+func TestHandle_DialRetries(t *testing.T) {
+	var dials atomic.Int32
+	flaky := flakyDialer(&dials, 1)
+	h := &Handler{
+		Upstream:    "10.0.0.1:22",
+		DialRetries: 1,
+		dial: func(context.Context, string, string) (net.Conn, error) {
+			return flaky()
+		},
+		logger: zap.NewNop(),
+	}
+
+	downstream, _ := net.Pipe()
+	defer downstream.Close()
+	up, err := h.acquireUpstream(layer4.WrapConnection(downstream, nil, zap.NewNop()), "tcp", h.defaultTarget())
+	require.NoError(t, err)
+	defer up.Close()
+
+	_, err = up.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(up, buf)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), dials.Load())
+}
+
+func TestUnmarshalCaddyfile_DialRetries(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`netbird 10.0.0.1:22 {
+		dial_retries 3
+	}`)
+	var h Handler
+	require.NoError(t, h.UnmarshalCaddyfile(d))
+	assert.Equal(t, 3, h.DialRetries)
+
+	for _, input := range []string{
+		"netbird 10.0.0.1:22 {\n dial_retries\n}",
+		"netbird 10.0.0.1:22 {\n dial_retries -1\n}",
+		"netbird 10.0.0.1:22 {\n dial_retries many\n}",
+	} {
+		var h Handler
+		assert.Error(t, h.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)), input)
+	}
+}
@@ -0,0 +1,45 @@
+package l4handler
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/caddyserver/caddy/v2"
+
+	"github.com/lixmal/caddy-netbird/app"
+)
+
+// Validate checks that the nodes of the handler and its routes are defined
+// in the app config. A Node with placeholders is checked per connection
+// once resolved.
+func (h *Handler) Validate() error {
+	if h.nbApp == nil {
+		return nil
+	}
+
+	var nodes []string
+	if !h.dynamicNode() {
+		nodes = append(nodes, h.Node)
+	}
+	for _, route := range h.SNI {
+		nodes = append(nodes, route.Node)
+	}
+	for _, route := range h.Postgres {
+		nodes = append(nodes, route.Node)
+	}
+	for _, route := range h.Ports {
+		if route.Node != "" {
+			nodes = append(nodes, route.Node)
+		}
+	}
+
+	var errs []error
+	for _, node := range nodes {
+		if !h.nbApp.HasNode(node) {
+			errs = append(errs, fmt.Errorf("node %q: %w", node, app.ErrUnknownNode))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+var _ caddy.Validator = (*Handler)(nil)
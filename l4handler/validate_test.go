@@ -0,0 +1,60 @@
+package l4handler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lixmal/caddy-netbird/app"
+)
+
+func TestValidate(t *testing.T) {
+	nbApp := &app.App{Nodes: map[string]*app.Node{"web": {}, "db": {}}}
+
+	tests := []struct {
+		name    string
+		h       *Handler
+		wantErr []string
+	}{
+		{name: "defined node", h: &Handler{Node: "web"}},
+		{name: "default node", h: &Handler{Node: "default"}},
+		{name: "placeholder node", h: &Handler{Node: "{l4.tls.server_name}"}},
+		{name: "unknown node", h: &Handler{Node: "cache"}, wantErr: []string{`node "cache"`}},
+		{
+			name: "unknown route nodes",
+			h: &Handler{
+				Node:     "web",
+				SNI:      []SNIRoute{{ServerName: "app.example.com", Node: "app"}},
+				Postgres: []PostgresRoute{{Database: "orders", Node: "db"}, {User: "admin", Node: "ops"}},
+			},
+			wantErr: []string{`node "app"`, `node "ops"`},
+		},
+		{
+			name: "port route nodes",
+			h: &Handler{
+				Node: "web",
+				Ports: []PortRoute{
+					{Port: 8080, Upstream: "10.0.0.1:80"},
+					{Port: 5432, Upstream: "10.0.0.2:5432", Node: "db"},
+					{Port: 6379, Upstream: "10.0.0.3:6379", Node: "cache"},
+				},
+			},
+			wantErr: []string{`node "cache"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.h.nbApp = nbApp
+			err := tt.h.Validate()
+			if len(tt.wantErr) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, app.ErrUnknownNode)
+			for _, want := range tt.wantErr {
+				assert.ErrorContains(t, err, want)
+			}
+		})
+	}
+}
@@ -55,16 +55,9 @@ func getClientAndPort(ctx context.Context, host, portRange string, portOffset ui
 		return nil, nil, 0, fmt.Errorf("parse port %q: %w", portRange, err)
 	}
 
-	mc, err := a.GetClient(host)
+	mc, err := a.AcquireListenerClient(ctx, host)
 	if err != nil {
-		return nil, nil, 0, fmt.Errorf("get netbird client %q: %w", host, err)
-	}
-
-	if err := mc.Start(ctx); err != nil {
-		if releaseErr := a.ReleaseClient(host); releaseErr != nil {
-			err = errors.Join(err, releaseErr)
-		}
-		return nil, nil, 0, fmt.Errorf("start netbird client %q: %w", host, err)
+		return nil, nil, 0, err
 	}
 
 	return a, mc, uint16(port), nil
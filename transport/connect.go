@@ -0,0 +1,42 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// connectPollInterval is how often the management connection is checked
+// while waiting for a node to connect.
+const connectPollInterval = 250 * time.Millisecond
+
+var errNotConnected = errors.New("not connected to management")
+
+// waitConnected polls connected until it reports true or timeout elapses.
+// On timeout, the last status error, if any, is included in the error.
+func waitConnected(ctx context.Context, timeout, interval time.Duration, connected func() (bool, error)) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastErr error
+	for {
+		ok, err := connected()
+		if ok {
+			return nil
+		}
+		lastErr = err
+
+		select {
+		case <-ctx.Done():
+			if lastErr != nil {
+				return fmt.Errorf("%w after %s: %w", errNotConnected, timeout, lastErr)
+			}
+			return fmt.Errorf("%w after %s", errNotConnected, timeout)
+		case <-ticker.C:
+		}
+	}
+}
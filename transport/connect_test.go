@@ -0,0 +1,121 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// simulatedStatus reports connected after the given number of polls.
+func simulatedStatus(connectAfter int, err error) (func() (bool, error), *int) {
+	polls := 0
+	return func() (bool, error) {
+		polls++
+		if connectAfter >= 0 && polls > connectAfter {
+			return true, nil
+		}
+		return false, err
+	}, &polls
+}
+
+func TestWaitConnected(t *testing.T) {
+	t.Run("already connected", func(t *testing.T) {
+		status, polls := simulatedStatus(0, nil)
+		require.NoError(t, waitConnected(context.Background(), time.Second, time.Millisecond, status))
+		assert.Equal(t, 1, *polls)
+	})
+
+	t.Run("connects after polls", func(t *testing.T) {
+		status, polls := simulatedStatus(3, nil)
+		require.NoError(t, waitConnected(context.Background(), time.Second, time.Millisecond, status))
+		assert.Equal(t, 4, *polls)
+	})
+
+	t.Run("times out", func(t *testing.T) {
+		status, _ := simulatedStatus(-1, nil)
+		err := waitConnected(context.Background(), 20*time.Millisecond, time.Millisecond, status)
+		require.ErrorIs(t, err, errNotConnected)
+		assert.Contains(t, err.Error(), "20ms")
+	})
+
+	t.Run("times out with status error", func(t *testing.T) {
+		statusErr := errors.New("engine not running")
+		status, _ := simulatedStatus(-1, statusErr)
+		err := waitConnected(context.Background(), 20*time.Millisecond, time.Millisecond, status)
+		require.ErrorIs(t, err, errNotConnected)
+		require.ErrorIs(t, err, statusErr)
+	})
+
+	t.Run("parent context canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		status, _ := simulatedStatus(-1, nil)
+		require.ErrorIs(t, waitConnected(ctx, time.Minute, time.Millisecond, status), errNotConnected)
+	})
+}
+
+func TestAwaitConnect_FailOnDisconnect(t *testing.T) {
+	tr := &Transport{
+		WaitForConnect:   caddy.Duration(20 * time.Millisecond),
+		FailOnDisconnect: true,
+		logger:           zap.NewNop(),
+	}
+	status, _ := simulatedStatus(-1, nil)
+
+	err := tr.awaitConnect(context.Background(), "web", status)
+	require.ErrorIs(t, err, errNotConnected)
+	assert.Contains(t, err.Error(), `"web"`)
+}
+
+func TestAwaitConnect_WarnOnly(t *testing.T) {
+	core, logs := observer.New(zap.WarnLevel)
+	tr := &Transport{
+		WaitForConnect: caddy.Duration(20 * time.Millisecond),
+		logger:         zap.New(core),
+	}
+	status, _ := simulatedStatus(-1, nil)
+
+	require.NoError(t, tr.awaitConnect(context.Background(), "web", status), "disconnect is not fatal by default")
+	require.Equal(t, 1, logs.Len())
+	assert.Equal(t, "web", logs.All()[0].ContextMap()["node"])
+}
+
+func TestAwaitConnect_Disabled(t *testing.T) {
+	tr := &Transport{FailOnDisconnect: true}
+	status, polls := simulatedStatus(-1, nil)
+
+	require.NoError(t, tr.awaitConnect(context.Background(), "web", status))
+	assert.Zero(t, *polls, "status is not polled without wait_for_connect")
+}
+
+func TestUnmarshalCaddyfile_WaitForConnect(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`netbird {
+		wait_for_connect 30s
+		fail_on_disconnect
+	}`)
+	var tr Transport
+	require.NoError(t, tr.UnmarshalCaddyfile(d))
+	assert.Equal(t, 30*time.Second, time.Duration(tr.WaitForConnect))
+	assert.True(t, tr.FailOnDisconnect)
+}
+
+func TestUnmarshalCaddyfile_WaitForConnectInvalid(t *testing.T) {
+	for _, input := range []string{
+		"netbird {\n wait_for_connect\n}",
+		"netbird {\n wait_for_connect soon\n}",
+		"netbird {\n wait_for_connect 0s\n}",
+		"netbird {\n fail_on_disconnect\n}",
+	} {
+		d := caddyfile.NewTestDispenser(input)
+		var tr Transport
+		assert.Error(t, tr.UnmarshalCaddyfile(d), input)
+	}
+}
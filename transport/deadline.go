@@ -0,0 +1,45 @@
+package transport
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// deadlineDial wraps the conns dialed with dial so that a read or write
+// fails once it made no progress for timeout. A zero timeout returns dial
+// unchanged.
+func deadlineDial(timeout time.Duration, dial dialFunc) dialFunc {
+	if timeout <= 0 {
+		return dial
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &deadlineConn{Conn: conn, timeout: timeout}, nil
+	}
+}
+
+// deadlineConn pushes the read or write deadline out by timeout before each
+// read or write, bounding how long a stuck upstream can hold a request.
+// Unlike an absolute deadline, a slow but steady transfer is not cut off.
+type deadlineConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *deadlineConn) Read(p []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(p)
+}
+
+func (c *deadlineConn) Write(p []byte) (int, error) {
+	if err := c.Conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(p)
+}
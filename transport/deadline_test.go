@@ -0,0 +1,103 @@
+package transport
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func pipeDial(conn net.Conn) dialFunc {
+	return func(context.Context, string, string) (net.Conn, error) {
+		return conn, nil
+	}
+}
+
+func TestDeadlineDial_ZeroTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	conn, err := deadlineDial(0, pipeDial(client))(context.Background(), "tcp", "peer:80")
+	require.NoError(t, err)
+	assert.Equal(t, client, conn, "conn should not be wrapped without timeout")
+}
+
+func TestDeadlineConn_ReadTimesOut(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	conn, err := deadlineDial(50*time.Millisecond, pipeDial(client))(context.Background(), "tcp", "peer:80")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	start := time.Now()
+	_, err = conn.Read(make([]byte, 1))
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestDeadlineConn_WriteTimesOut(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	conn, err := deadlineDial(50*time.Millisecond, pipeDial(client))(context.Background(), "tcp", "peer:80")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Nobody reads from the server side, so the write is stuck.
+	_, err = conn.Write([]byte("x"))
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+}
+
+func TestDeadlineConn_ResetsOnProgress(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	const timeout = 100 * time.Millisecond
+	conn, err := deadlineDial(timeout, pipeDial(client))(context.Background(), "tcp", "peer:80")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Trickle data for well over the timeout, with gaps below it.
+	go func() {
+		for range 6 {
+			time.Sleep(timeout / 2)
+			if _, err := server.Write([]byte("x")); err != nil {
+				return
+			}
+		}
+	}()
+
+	buf := make([]byte, 1)
+	for range 6 {
+		_, err := conn.Read(buf)
+		require.NoError(t, err, "steady transfer should not time out")
+	}
+}
+
+func TestUnmarshalCaddyfile_ConnIdleTimeout(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`netbird {
+		conn_idle_timeout 2m
+	}`)
+	var tr Transport
+	require.NoError(t, tr.UnmarshalCaddyfile(d))
+	assert.Equal(t, 2*time.Minute, time.Duration(tr.ConnIdleTimeout))
+}
+
+func TestUnmarshalCaddyfile_ConnIdleTimeoutInvalid(t *testing.T) {
+	for _, input := range []string{
+		"netbird {\n conn_idle_timeout\n}",
+		"netbird {\n conn_idle_timeout soon\n}",
+		"netbird {\n conn_idle_timeout 0s\n}",
+		"netbird {\n conn_idle_timeout 1m\n versions 3\n}",
+	} {
+		var tr Transport
+		assert.Error(t, tr.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)), input)
+	}
+}
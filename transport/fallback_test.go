@@ -0,0 +1,51 @@
+package transport
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestRoundTrip_FallbackDirectWithoutHealthyNode(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "direct")
+	}))
+	defer backend.Close()
+
+	var d net.Dialer
+	tr := &Transport{FallbackDirect: true, logger: zap.NewNop(), directDial: d.DialContext}
+	tr.direct = tr.httpTransport(tr.directDial, nil)
+	defer tr.direct.CloseIdleConnections()
+
+	req := httptest.NewRequest(http.MethodGet, backend.URL, nil)
+	req.RequestURI = ""
+	resp, err := tr.RoundTrip(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "direct", string(body))
+}
+
+func TestUnmarshalCaddyfile_FallbackDirect(t *testing.T) {
+	var tr Transport
+	require.NoError(t, tr.UnmarshalCaddyfile(caddyfile.NewTestDispenser("netbird {\n fallback_direct\n}")))
+	assert.True(t, tr.FallbackDirect)
+
+	for _, input := range []string{
+		"netbird {\n fallback_direct yes\n}",
+		"netbird {\n fallback_direct\n versions 3\n}",
+	} {
+		var tr Transport
+		assert.Error(t, tr.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)), input)
+	}
+}
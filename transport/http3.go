@@ -0,0 +1,95 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"slices"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+
+	"github.com/lixmal/caddy-netbird/app"
+)
+
+// validateVersions checks the configured HTTP versions.
+func validateVersions(versions []string) error {
+	for _, v := range versions {
+		switch v {
+		case "1.1", "2", "3":
+		default:
+			return fmt.Errorf("unsupported HTTP version %q, must be 1.1, 2 or 3", v)
+		}
+	}
+	if slices.Contains(versions, "3") && len(versions) > 1 {
+		return fmt.Errorf("HTTP version 3 can't be combined with other versions")
+	}
+	return nil
+}
+
+// useHTTP3 reports whether requests are sent over HTTP/3.
+func (t *Transport) useHTTP3() bool {
+	return slices.Contains(t.Versions, "3")
+}
+
+// newHTTP3RoundTripper builds an HTTP/3 transport whose QUIC connections run
+// over UDP sockets dialed through mc.
+func (t *Transport) newHTTP3RoundTripper(ctx caddy.Context, name string, mc *app.ManagedClient) (*http3.Transport, error) {
+	tlsConfig, err := t.tlsClientConfig(ctx, mc)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{ClientSessionCache: mc.TLSSessionCache()}
+	}
+
+	dial := t.dialer(name, mc)
+	return &http3.Transport{
+		TLSClientConfig: tlsConfig,
+		Dial: func(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (*quic.Conn, error) {
+			return dialQUIC(ctx, dial, addr, tlsCfg, cfg)
+		},
+	}, nil
+}
+
+// dialQUIC opens a QUIC connection over a UDP socket obtained from dial. The
+// socket is closed together with the QUIC connection.
+func dialQUIC(ctx context.Context, dial dialFunc, addr string, tlsCfg *tls.Config, cfg *quic.Config) (*quic.Conn, error) {
+	conn, err := dial(ctx, "udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	tr := &quic.Transport{Conn: &connectedPacketConn{Conn: conn}}
+	qconn, err := tr.DialEarly(ctx, conn.RemoteAddr(), tlsCfg, cfg)
+	if err != nil {
+		_ = tr.Close()
+		_ = conn.Close()
+		return nil, err
+	}
+
+	go func() {
+		<-qconn.Context().Done()
+		_ = tr.Close()
+		_ = conn.Close()
+	}()
+	return qconn, nil
+}
+
+// connectedPacketConn adapts a connected UDP conn, as returned by the NetBird
+// dialer, to the net.PacketConn interface needed by QUIC. All packets go to
+// and come from the connected peer.
+type connectedPacketConn struct {
+	net.Conn
+}
+
+func (c *connectedPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, err := c.Read(p)
+	return n, c.RemoteAddr(), err
+}
+
+func (c *connectedPacketConn) WriteTo(p []byte, _ net.Addr) (int, error) {
+	return c.Write(p)
+}
@@ -0,0 +1,187 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lixmal/caddy-netbird/app"
+)
+
+func TestValidateVersions(t *testing.T) {
+	tests := []struct {
+		versions []string
+		wantErr  bool
+	}{
+		{nil, false},
+		{[]string{"1.1"}, false},
+		{[]string{"1.1", "2"}, false},
+		{[]string{"3"}, false},
+		{[]string{"3", "2"}, true},
+		{[]string{"h2c"}, true},
+		{[]string{"1.0"}, true},
+	}
+	for _, tt := range tests {
+		err := validateVersions(tt.versions)
+		if tt.wantErr {
+			assert.Error(t, err, "%v", tt.versions)
+		} else {
+			assert.NoError(t, err, "%v", tt.versions)
+		}
+	}
+}
+
+func TestUnmarshalCaddyfile_Versions(t *testing.T) {
+	t.Run("h3 implies tls", func(t *testing.T) {
+		d := caddyfile.NewTestDispenser(`netbird {
+			versions h3
+		}`)
+		var tr Transport
+		require.NoError(t, tr.UnmarshalCaddyfile(d))
+		assert.Equal(t, []string{"3"}, tr.Versions)
+		assert.True(t, tr.TLSEnabled())
+	})
+
+	t.Run("http/1.1 and http/2", func(t *testing.T) {
+		d := caddyfile.NewTestDispenser(`netbird {
+			versions 1.1 2
+		}`)
+		var tr Transport
+		require.NoError(t, tr.UnmarshalCaddyfile(d))
+		assert.Equal(t, []string{"1.1", "2"}, tr.Versions)
+		assert.False(t, tr.TLSEnabled())
+	})
+
+	for _, input := range []string{
+		"netbird {\n versions\n}",
+		"netbird {\n versions h3 2\n}",
+		"netbird {\n versions spdy\n}",
+	} {
+		d := caddyfile.NewTestDispenser(input)
+		var tr Transport
+		assert.Error(t, tr.UnmarshalCaddyfile(d), input)
+	}
+}
+
+func TestRoundTripperFor(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	mc := &app.ManagedClient{}
+
+	t.Run("default", func(t *testing.T) {
+		rt, err := (&Transport{}).roundTripperFor(ctx, "web", mc)
+		require.NoError(t, err)
+		h1, ok := rt.(*http.Transport)
+		require.True(t, ok)
+		assert.False(t, h1.ForceAttemptHTTP2)
+		assert.Nil(t, h1.TLSNextProto)
+	})
+
+	t.Run("http/2", func(t *testing.T) {
+		rt, err := (&Transport{Versions: []string{"1.1", "2"}}).roundTripperFor(ctx, "web", mc)
+		require.NoError(t, err)
+		assert.True(t, rt.(*http.Transport).ForceAttemptHTTP2)
+	})
+
+	t.Run("http/1.1 only", func(t *testing.T) {
+		rt, err := (&Transport{Versions: []string{"1.1"}}).roundTripperFor(ctx, "web", mc)
+		require.NoError(t, err)
+		h1 := rt.(*http.Transport)
+		assert.False(t, h1.ForceAttemptHTTP2)
+		assert.NotNil(t, h1.TLSNextProto, "empty map disables HTTP/2")
+		assert.Empty(t, h1.TLSNextProto)
+	})
+
+	t.Run("http/3", func(t *testing.T) {
+		rt, err := (&Transport{Versions: []string{"3"}}).roundTripperFor(ctx, "web", mc)
+		require.NoError(t, err)
+		h3, ok := rt.(*http3.Transport)
+		require.True(t, ok)
+		assert.NotNil(t, h3.Dial)
+		require.NotNil(t, h3.TLSClientConfig)
+		assert.Same(t, mc.TLSSessionCache(), h3.TLSClientConfig.ClientSessionCache)
+	})
+}
+
+func TestConnectedPacketConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	pc := &connectedPacketConn{Conn: client}
+	defer pc.Close()
+
+	go func() {
+		buf := make([]byte, 4)
+		n, _ := server.Read(buf)
+		_, _ = server.Write(buf[:n])
+	}()
+
+	n, err := pc.WriteTo([]byte("ping"), &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1})
+	require.NoError(t, err)
+	assert.Equal(t, 4, n)
+
+	buf := make([]byte, 4)
+	n, addr, err := pc.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(buf[:n]))
+	assert.Equal(t, client.RemoteAddr(), addr, "packets always come from the connected peer")
+}
+
+func TestDialQUIC_RoundTrip(t *testing.T) {
+	// Borrow the self-signed certificate of an httptest TLS server.
+	ts := httptest.NewTLSServer(http.NotFoundHandler())
+	certs := ts.TLS.Certificates
+	roots := ts.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+	ts.Close()
+
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	srv := &http3.Server{
+		TLSConfig: http3.ConfigureTLSConfig(&tls.Config{Certificates: certs}),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, r.Proto)
+		}),
+	}
+	go func() { _ = srv.Serve(udpConn) }()
+	defer srv.Close()
+
+	var dialed []string
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed = append(dialed, network+" "+addr)
+		var d net.Dialer
+		return d.DialContext(ctx, network, addr)
+	}
+
+	rt := &http3.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: roots},
+		Dial: func(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (*quic.Conn, error) {
+			return dialQUIC(ctx, dial, addr, tlsCfg, cfg)
+		},
+	}
+	defer rt.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+udpConn.LocalAddr().String()+"/", nil)
+	require.NoError(t, err)
+
+	resp, err := rt.RoundTrip(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/3.0", string(body))
+	assert.Equal(t, []string{"udp " + udpConn.LocalAddr().String()}, dialed)
+}
@@ -0,0 +1,57 @@
+package transport
+
+import (
+	"sync"
+	"time"
+)
+
+// failTracker counts consecutive failed round trips through a node and
+// takes the node out of rotation for a while once they reach a limit.
+type failTracker struct {
+	mu        sync.Mutex
+	fails     int
+	downUntil time.Time
+}
+
+// record counts a failed round trip, or resets the count after a
+// successful one. It reports whether the failure reached maxFails and
+// marked the node down until failDuration from now.
+func (f *failTracker) record(failed bool, now time.Time, maxFails int, failDuration time.Duration) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !failed {
+		f.fails = 0
+		return false
+	}
+	f.fails++
+	if f.fails < maxFails {
+		return false
+	}
+	// Start over once the node is back, so a single failure doesn't
+	// take it out again.
+	f.fails = 0
+	f.downUntil = now.Add(failDuration)
+	return true
+}
+
+// available reports whether the node isn't marked down at now.
+func (f *failTracker) available(now time.Time) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return !now.Before(f.downUntil)
+}
+
+// passiveChecks reports whether failed round trips take nodes out of rotation.
+func (t *Transport) passiveChecks() bool {
+	return t.FailDuration > 0
+}
+
+// maxFails returns the number of consecutive failures that mark a node
+// down, defaulting to 1 like Caddy's passive health checks.
+func (t *Transport) maxFails() int {
+	if t.MaxFails > 0 {
+		return t.MaxFails
+	}
+	return 1
+}
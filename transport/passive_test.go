@@ -0,0 +1,152 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/lixmal/caddy-netbird/app"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestFailTracker(t *testing.T) {
+	var f failTracker
+	now := time.Now()
+
+	assert.False(t, f.record(true, now, 3, time.Minute))
+	assert.False(t, f.record(true, now, 3, time.Minute))
+	assert.False(t, f.record(false, now, 3, time.Minute), "success should reset the count")
+	assert.False(t, f.record(true, now, 3, time.Minute))
+	assert.False(t, f.record(true, now, 3, time.Minute))
+	assert.True(t, f.available(now))
+
+	assert.True(t, f.record(true, now, 3, time.Minute))
+	assert.False(t, f.available(now))
+	assert.False(t, f.available(now.Add(59*time.Second)))
+	assert.True(t, f.available(now.Add(time.Minute)), "node should recover after fail duration")
+
+	assert.False(t, f.record(true, now.Add(time.Minute), 3, time.Minute), "count should start over after recovery")
+}
+
+func TestRoundTrip_PassiveHealthCheck(t *testing.T) {
+	failing := true
+	var through []string
+	node := func(name string) *tunnelNode {
+		return &tunnelNode{name: name, mc: &app.ManagedClient{}, rt: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			through = append(through, name)
+			if name == "a" && failing {
+				return nil, errors.New("connection refused")
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+		})}
+	}
+	tr := &Transport{
+		FailDuration: caddy.Duration(time.Minute),
+		MaxFails:     2,
+		nodes:        []*tunnelNode{node("a"), node("b")},
+		logger:       zap.NewNop(),
+	}
+
+	roundTrip := func() {
+		resp, err := tr.RoundTrip(httptest.NewRequest(http.MethodGet, "http://backend:8080/", nil))
+		if err == nil {
+			resp.Body.Close()
+		}
+	}
+	for range 6 {
+		roundTrip()
+	}
+	assert.Equal(t, []string{"a", "b", "a", "b", "b", "b"}, through, "a should be skipped after two failures")
+
+	// Expire the fail duration.
+	tr.nodes[0].fails.downUntil = time.Time{}
+	failing = false
+	through = nil
+	for range 2 {
+		roundTrip()
+	}
+	assert.ElementsMatch(t, []string{"a", "b"}, through, "a should be used again once recovered")
+}
+
+func TestRoundTrip_PassiveHealthCheckAllDown(t *testing.T) {
+	var calls int
+	node := &tunnelNode{name: "a", mc: &app.ManagedClient{}, rt: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		calls++
+		if calls == 1 {
+			return nil, errors.New("connection refused")
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+	})}
+	tr := &Transport{
+		FailDuration: caddy.Duration(time.Minute),
+		nodes:        []*tunnelNode{node},
+		logger:       zap.NewNop(),
+	}
+
+	_, err := tr.RoundTrip(httptest.NewRequest(http.MethodGet, "http://backend:8080/", nil))
+	require.Error(t, err)
+	require.False(t, node.fails.available(time.Now()))
+
+	resp, err := tr.RoundTrip(httptest.NewRequest(http.MethodGet, "http://backend:8080/", nil))
+	require.NoError(t, err, "the only node must still be used while marked down")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 2, calls)
+}
+
+func TestRoundTrip_PassiveHealthCheckIgnoresCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	node := &tunnelNode{name: "a", mc: &app.ManagedClient{}, rt: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, req.Context().Err()
+	})}
+	tr := &Transport{
+		FailDuration: caddy.Duration(time.Minute),
+		nodes:        []*tunnelNode{node},
+		logger:       zap.NewNop(),
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://backend:8080/", nil).WithContext(ctx)
+	_, err := tr.RoundTrip(req)
+	require.Error(t, err)
+	assert.True(t, node.fails.available(time.Now()), "client cancellation should not count as a failure")
+}
+
+func TestUnmarshalCaddyfile_PassiveHealthCheck(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`netbird {
+		fail_duration 30s
+		max_fails 3
+	}`)
+
+	var tr Transport
+	require.NoError(t, tr.UnmarshalCaddyfile(d))
+	assert.Equal(t, caddy.Duration(30*time.Second), tr.FailDuration)
+	assert.Equal(t, 3, tr.MaxFails)
+}
+
+func TestUnmarshalCaddyfile_PassiveHealthCheckInvalid(t *testing.T) {
+	for _, input := range []string{
+		"netbird {\n fail_duration\n}",
+		"netbird {\n fail_duration 0s\n}",
+		"netbird {\n fail_duration 30s\n max_fails 0\n}",
+		"netbird {\n fail_duration 30s\n max_fails many\n}",
+		"netbird {\n max_fails 3\n}",
+	} {
+		var tr Transport
+		require.Error(t, tr.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)), input)
+	}
+}
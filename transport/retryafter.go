@@ -0,0 +1,59 @@
+package transport
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryAfterError is returned by RoundTrip instead of a 503 response that
+// carries a Retry-After header, if RetryUnavailable is enabled. Caddy's
+// reverse proxy treats it like any failed round trip, so the request is
+// retried according to its load balancing retry settings.
+type RetryAfterError struct {
+	// Delay is how long the upstream asked clients to wait before retrying.
+	Delay time.Duration
+}
+
+func (e *RetryAfterError) Error() string {
+	return fmt.Sprintf("upstream unavailable, retry after %s", e.Delay)
+}
+
+// retryAfterError returns a RetryAfterError for a 503 response with a valid
+// Retry-After header, discarding the response. It returns nil otherwise.
+func retryAfterError(resp *http.Response, now time.Time) error {
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		return nil
+	}
+	delay, ok := parseRetryAfter(resp.Header.Get("Retry-After"), now)
+	if !ok {
+		return nil
+	}
+
+	// Drain so the connection can be reused.
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	return &RetryAfterError{Delay: delay}
+}
+
+// parseRetryAfter parses a Retry-After value, given either as seconds or as
+// an HTTP date relative to now. Dates in the past yield zero.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	if secs, err := strconv.ParseUint(value, 10, 32); err == nil {
+		return time.Duration(secs) * time.Second, true
+	}
+
+	at, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	return max(at.Sub(now), 0), true
+}
@@ -0,0 +1,120 @@
+package transport
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lixmal/caddy-netbird/app"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{name: "empty", value: ""},
+		{name: "seconds", value: "120", want: 2 * time.Minute, wantOK: true},
+		{name: "zero seconds", value: "0", wantOK: true},
+		{name: "padded seconds", value: " 5 ", want: 5 * time.Second, wantOK: true},
+		{name: "negative seconds", value: "-5"},
+		{name: "fractional seconds", value: "1.5"},
+		{name: "future date", value: "Thu, 02 Jan 2025 15:05:05 GMT", want: time.Minute, wantOK: true},
+		{name: "past date", value: "Thu, 02 Jan 2025 15:00:00 GMT", wantOK: true},
+		{name: "garbage", value: "soon"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseRetryAfter(tt.value, now)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRetryAfterError(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		retryAfter string
+		wantDelay  time.Duration
+		wantErr    bool
+	}{
+		{name: "503 with retry-after", status: http.StatusServiceUnavailable, retryAfter: "30", wantDelay: 30 * time.Second, wantErr: true},
+		{name: "503 without retry-after", status: http.StatusServiceUnavailable},
+		{name: "503 with invalid retry-after", status: http.StatusServiceUnavailable, retryAfter: "later"},
+		{name: "429 with retry-after", status: http.StatusTooManyRequests, retryAfter: "30"},
+		{name: "200", status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{
+				StatusCode: tt.status,
+				Header:     http.Header{},
+				Body:       io.NopCloser(strings.NewReader("busy")),
+			}
+			if tt.retryAfter != "" {
+				resp.Header.Set("Retry-After", tt.retryAfter)
+			}
+
+			err := retryAfterError(resp, time.Now())
+			if !tt.wantErr {
+				assert.NoError(t, err)
+				return
+			}
+			var retryErr *RetryAfterError
+			require.ErrorAs(t, err, &retryErr)
+			assert.Equal(t, tt.wantDelay, retryErr.Delay)
+			assert.Contains(t, err.Error(), "retry after 30s")
+		})
+	}
+}
+
+func TestUnmarshalCaddyfile_RetryUnavailable(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`netbird {
+		retry_unavailable
+	}`)
+	var tr Transport
+	require.NoError(t, tr.UnmarshalCaddyfile(d))
+	assert.True(t, tr.RetryUnavailable)
+}
+
+func TestRoundTrip_RetryUnavailable(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "10")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer backend.Close()
+
+	for _, enabled := range []bool{false, true} {
+		tr := &Transport{
+			RetryUnavailable: enabled,
+			nodes:            []*tunnelNode{{name: "web", mc: &app.ManagedClient{}, rt: &http.Transport{}}},
+		}
+		req := httptest.NewRequest(http.MethodGet, backend.URL, nil)
+		req.RequestURI = ""
+
+		resp, err := tr.RoundTrip(req)
+		if !enabled {
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+			continue
+		}
+		assert.Nil(t, resp)
+		var retryErr *RetryAfterError
+		require.ErrorAs(t, err, &retryErr)
+		assert.Equal(t, 10*time.Second, retryErr.Delay)
+	}
+}
@@ -0,0 +1,116 @@
+package transport
+
+import (
+	"hash/fnv"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// ringReplicas is the number of points each node gets on the hash ring.
+// More points spread clients more evenly across nodes.
+const ringReplicas = 128
+
+// hashRing consistently maps keys to nodes. Points are derived from node
+// names, so a client keeps its node across config reloads, and removing a
+// node only moves the clients that were mapped to it.
+type hashRing struct {
+	points []ringPoint
+}
+
+type ringPoint struct {
+	hash uint64
+	node int
+}
+
+// newHashRing builds a ring for the named nodes. Point node values are
+// indexes into names.
+func newHashRing(names []string) *hashRing {
+	r := &hashRing{points: make([]ringPoint, 0, len(names)*ringReplicas)}
+	for i, name := range names {
+		for replica := range ringReplicas {
+			r.points = append(r.points, ringPoint{
+				hash: ringHash(name + "#" + strconv.Itoa(replica)),
+				node: i,
+			})
+		}
+	}
+	slices.SortFunc(r.points, func(a, b ringPoint) int {
+		switch {
+		case a.hash < b.hash:
+			return -1
+		case a.hash > b.hash:
+			return 1
+		}
+		return a.node - b.node
+	})
+	return r
+}
+
+// lookup returns the node owning key: the node of the first point at or after
+// the key's hash. Unusable nodes are skipped by walking further along the
+// ring, which only rehashes the clients of those nodes. It returns -1 if no
+// node is usable.
+func (r *hashRing) lookup(key string, usable func(int) bool) int {
+	if len(r.points) == 0 {
+		return -1
+	}
+
+	h := ringHash(key)
+	start, _ := slices.BinarySearchFunc(r.points, h, func(p ringPoint, h uint64) int {
+		switch {
+		case p.hash < h:
+			return -1
+		case p.hash > h:
+			return 1
+		}
+		return 0
+	})
+
+	checked := make(map[int]bool)
+	for i := range r.points {
+		node := r.points[(start+i)%len(r.points)].node
+		if ok, seen := checked[node]; seen {
+			if ok {
+				return node
+			}
+			continue
+		}
+		ok := usable(node)
+		checked[node] = ok
+		if ok {
+			return node
+		}
+	}
+	return -1
+}
+
+// ringHash hashes s with FNV-1a followed by a 64-bit finalizer, as FNV alone
+// clusters similar inputs such as "node#1" and "node#2".
+func ringHash(s string) uint64 {
+	f := fnv.New64a()
+	_, _ = f.Write([]byte(s))
+	h := f.Sum64()
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// clientIP returns the downstream client IP of req as determined by Caddy,
+// honoring trusted proxies, or the remote address if not available.
+func clientIP(req *http.Request) string {
+	if ip, ok := caddyhttp.GetVar(req.Context(), caddyhttp.ClientIPVarKey).(string); ok && ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
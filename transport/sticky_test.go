@@ -0,0 +1,132 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lixmal/caddy-netbird/app"
+)
+
+func allUsable(int) bool { return true }
+
+func clientKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("203.0.113.%d/%d", i%256, i)
+	}
+	return keys
+}
+
+func TestHashRing_Stable(t *testing.T) {
+	names := []string{"web-a", "web-b", "web-c"}
+	r1 := newHashRing(names)
+	r2 := newHashRing(names)
+
+	for _, key := range clientKeys(1000) {
+		first := r1.lookup(key, allUsable)
+		require.GreaterOrEqual(t, first, 0)
+		assert.Equal(t, first, r1.lookup(key, allUsable), "repeated lookups must agree")
+		assert.Equal(t, first, r2.lookup(key, allUsable), "rebuilt ring must agree")
+	}
+}
+
+func TestHashRing_Distribution(t *testing.T) {
+	names := []string{"web-a", "web-b", "web-c"}
+	r := newHashRing(names)
+
+	counts := make([]int, len(names))
+	keys := clientKeys(3000)
+	for _, key := range keys {
+		counts[r.lookup(key, allUsable)]++
+	}
+	for i, c := range counts {
+		share := float64(c) / float64(len(keys))
+		assert.InDelta(t, 1.0/3, share, 0.1, "node %s got %d of %d keys", names[i], c, len(keys))
+	}
+}
+
+func TestHashRing_UnusableNodeOnlyMovesItsClients(t *testing.T) {
+	r := newHashRing([]string{"web-a", "web-b", "web-c"})
+	const down = 1
+
+	for _, key := range clientKeys(1000) {
+		before := r.lookup(key, allUsable)
+		after := r.lookup(key, func(i int) bool { return i != down })
+
+		assert.NotEqual(t, down, after)
+		if before != down {
+			assert.Equal(t, before, after, "key %s moved although its node is up", key)
+		}
+	}
+}
+
+func TestHashRing_RemovedNodeOnlyMovesItsClients(t *testing.T) {
+	full := newHashRing([]string{"web-a", "web-b", "web-c"})
+	reduced := newHashRing([]string{"web-a", "web-c"})
+	reducedName := []string{"web-a", "web-c"}
+	fullName := []string{"web-a", "web-b", "web-c"}
+
+	moved := 0
+	keys := clientKeys(1000)
+	for _, key := range keys {
+		before := fullName[full.lookup(key, allUsable)]
+		after := reducedName[reduced.lookup(key, allUsable)]
+		if before == "web-b" {
+			moved++
+			continue
+		}
+		assert.Equal(t, before, after, "key %s moved although its node is still configured", key)
+	}
+	assert.Greater(t, moved, 0)
+}
+
+func TestHashRing_NoneUsable(t *testing.T) {
+	r := newHashRing([]string{"web-a", "web-b"})
+	assert.Equal(t, -1, r.lookup("203.0.113.1", func(int) bool { return false }))
+	assert.Equal(t, -1, newHashRing(nil).lookup("203.0.113.1", allUsable))
+}
+
+func TestClientIP(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "http://backend/", nil)
+	req.RemoteAddr = "198.51.100.7:51234"
+	assert.Equal(t, "198.51.100.7", clientIP(req))
+
+	vars := map[string]any{caddyhttp.ClientIPVarKey: "203.0.113.9"}
+	req = req.WithContext(context.WithValue(req.Context(), caddyhttp.VarsCtxKey, vars))
+	assert.Equal(t, "203.0.113.9", clientIP(req), "caddy's client IP honors trusted proxies")
+}
+
+func TestUnmarshalCaddyfile_Sticky(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`netbird web-a web-b {
+		sticky
+	}`)
+	var tr Transport
+	require.NoError(t, tr.UnmarshalCaddyfile(d))
+	assert.True(t, tr.Sticky)
+}
+
+func TestPickNode_Sticky(t *testing.T) {
+	names := []string{"web-a", "web-b", "web-c"}
+	tr := &Transport{Sticky: true, ring: newHashRing(names)}
+	for _, name := range names {
+		tr.nodes = append(tr.nodes, &tunnelNode{name: name, mc: &app.ManagedClient{}})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://backend/", nil)
+	req.RemoteAddr = "198.51.100.7:51234"
+
+	first := tr.pickNode(req)
+	require.NotNil(t, first)
+	for port := 40000; port < 40010; port++ {
+		req.RemoteAddr = fmt.Sprintf("198.51.100.7:%d", port)
+		assert.Same(t, first, tr.pickNode(req), "same client IP must use the same node")
+	}
+}
@@ -0,0 +1,64 @@
+package transport
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/lixmal/caddy-netbird/app"
+)
+
+const tracerName = "github.com/lixmal/caddy-netbird/transport"
+
+// startSpan starts a client span as a child of the span in ctx, using that
+// span's tracer provider. Without a span in ctx, the provider is a no-op.
+func startSpan(ctx context.Context, spanName, node, upstream string) (context.Context, trace.Span) {
+	tracer := trace.SpanFromContext(ctx).TracerProvider().Tracer(tracerName)
+	return tracer.Start(ctx, spanName,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("netbird.node", node),
+			attribute.String("netbird.upstream", upstream),
+		),
+	)
+}
+
+// endSpan records err and the peer's connection type, if known, and ends span.
+func endSpan(span trace.Span, mc *app.ManagedClient, upstream string, err error) {
+	if relayed, known := mc.PeerRelayed(upstream); known {
+		span.SetAttributes(attribute.Bool("netbird.relayed", relayed))
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// tracedDial wraps each tunnel dial through the node in a span.
+func tracedDial(node string, mc *app.ManagedClient, dial dialFunc) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		ctx, span := startSpan(ctx, "netbird.dial", node, addr)
+		conn, err := dial(ctx, network, addr)
+		endSpan(span, mc, addr, err)
+		return conn, err
+	}
+}
+
+// tracedRoundTrip sends req through node inside a span. The dial span, if
+// a new connection is needed, becomes its child.
+func tracedRoundTrip(node *tunnelNode, req *http.Request) (*http.Response, error) {
+	ctx, span := startSpan(req.Context(), "netbird.round_trip", node.name, req.URL.Host)
+	resp, err := node.rt.RoundTrip(req.WithContext(ctx))
+	if resp != nil {
+		span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	}
+	endSpan(span, node.mc, req.URL.Host, err)
+	return resp, err
+}
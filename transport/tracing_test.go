@@ -0,0 +1,127 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/lixmal/caddy-netbird/app"
+)
+
+func newTestTracer(t *testing.T) (*tracetest.SpanRecorder, *sdktrace.TracerProvider) {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	t.Cleanup(func() { _ = tp.Shutdown(context.Background()) })
+	return recorder, tp
+}
+
+func spanAttrs(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func TestTracedDial(t *testing.T) {
+	recorder, tp := newTestTracer(t)
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "request")
+
+	var dialSpanActive bool
+	dial := tracedDial("web", &app.ManagedClient{}, func(ctx context.Context, network, addr string) (net.Conn, error) {
+		assert.Empty(t, recorder.Ended(), "span must not end before the dial returns")
+		dialSpanActive = len(recorder.Started()) == 2
+		client, server := net.Pipe()
+		_ = server.Close()
+		return client, nil
+	})
+
+	conn, err := dial(ctx, "tcp", "100.0.1.10:80")
+	require.NoError(t, err)
+	_ = conn.Close()
+	parent.End()
+
+	assert.True(t, dialSpanActive, "span should be started before dialing")
+
+	ended := recorder.Ended()
+	require.Len(t, ended, 2)
+	span := ended[0]
+	assert.Equal(t, "netbird.dial", span.Name())
+	assert.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID(), "dial span should be a child of the request span")
+
+	attrs := spanAttrs(span)
+	assert.Equal(t, "web", attrs["netbird.node"].AsString())
+	assert.Equal(t, "100.0.1.10:80", attrs["netbird.upstream"].AsString())
+	_, hasRelayed := attrs["netbird.relayed"]
+	assert.False(t, hasRelayed, "connection type is unknown before a health check")
+}
+
+func TestTracedDial_Error(t *testing.T) {
+	recorder, tp := newTestTracer(t)
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "request")
+	defer parent.End()
+
+	dialErr := errors.New("no route to peer")
+	dial := tracedDial("web", &app.ManagedClient{}, func(context.Context, string, string) (net.Conn, error) {
+		return nil, dialErr
+	})
+
+	_, err := dial(ctx, "tcp", "100.0.1.10:80")
+	require.ErrorIs(t, err, dialErr)
+
+	ended := recorder.Ended()
+	require.Len(t, ended, 1)
+	assert.Equal(t, codes.Error, ended[0].Status().Code)
+	assert.Equal(t, dialErr.Error(), ended[0].Status().Description)
+}
+
+func TestTracedDial_NoParentSpan(t *testing.T) {
+	recorder, _ := newTestTracer(t)
+
+	called := false
+	dial := tracedDial("web", &app.ManagedClient{}, func(context.Context, string, string) (net.Conn, error) {
+		called = true
+		return nil, errors.New("fail")
+	})
+	_, _ = dial(context.Background(), "tcp", "100.0.1.10:80")
+
+	assert.True(t, called)
+	assert.Empty(t, recorder.Started(), "no spans are recorded without a span in the context")
+}
+
+func TestTracedRoundTrip(t *testing.T) {
+	recorder, tp := newTestTracer(t)
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "request")
+	defer parent.End()
+
+	node := &tunnelNode{
+		name: "web",
+		mc:   &app.ManagedClient{},
+		rt: &http.Transport{
+			DialContext: func(context.Context, string, string) (net.Conn, error) {
+				return nil, errors.New("unreachable")
+			},
+		},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://backend.netbird.cloud/", nil)
+	require.NoError(t, err)
+	_, err = tracedRoundTrip(node, req)
+	require.Error(t, err)
+
+	ended := recorder.Ended()
+	require.Len(t, ended, 1)
+	assert.Equal(t, "netbird.round_trip", ended[0].Name())
+	assert.Equal(t, "backend.netbird.cloud", spanAttrs(ended[0])["netbird.upstream"].AsString())
+	assert.Equal(t, codes.Error, ended[0].Status().Code)
+}
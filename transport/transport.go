@@ -3,14 +3,24 @@
 package transport
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp/reverseproxy"
 	"go.uber.org/zap"
+	"golang.org/x/net/http/httpguts"
 
 	"github.com/lixmal/caddy-netbird/app"
 )
@@ -24,9 +34,18 @@ func init() {
 type Transport struct {
 	// Node is the name of the NetBird node to use for dialing.
 	// Must match a node defined in the top-level netbird app config.
-	// Defaults to "default" if empty.
+	// Defaults to the app's default_node, or "default", if empty.
 	Node string `json:"node,omitempty"`
 
+	// Nodes lists additional NetBird nodes to dial through. Requests are
+	// spread across all configured nodes, skipping nodes whose management
+	// or signal connection was reported down by the last health check.
+	Nodes []string `json:"nodes,omitempty"`
+
+	// Tenant is the tenant the transport belongs to. It may only use the
+	// nodes the netbird app reserves for this tenant.
+	Tenant string `json:"tenant,omitempty"`
+
 	// TLS configures TLS to the upstream. Setting this to an empty struct
 	// enables TLS with reasonable defaults. This is independent of the
 	// NetBird network encryption. The upstream behind NetBird may require
@@ -34,11 +53,121 @@ type Transport struct {
 	// external HTTPS service).
 	TLS *reverseproxy.TLSConfig `json:"tls,omitempty"`
 
+	// TLSMinVersion is the minimum TLS version accepted from the upstream:
+	// "1.2" or "1.3". Defaults to the Go default, currently TLS 1.2.
+	TLSMinVersion string `json:"tls_min_version,omitempty"`
+
+	// TLSALPN lists the application protocols offered to the upstream via
+	// ALPN, e.g. "h2" or "http/1.1", for backends that require a specific
+	// protocol. "h2" requires HTTP/2 in Versions. Ignored for HTTP/3, which
+	// always negotiates "h3".
+	TLSALPN []string `json:"tls_alpn,omitempty"`
+
+	// Prewarm lists upstream host:port addresses to connect to right after
+	// provisioning, so the first proxied request can reuse an idle
+	// keep-alive connection instead of dialing through the tunnel.
+	Prewarm []string `json:"prewarm,omitempty"`
+
+	// Tracing wraps tunnel dials and round trips in OpenTelemetry spans,
+	// using the tracer provider of the span in the request context (e.g.
+	// started by Caddy's tracing handler).
+	Tracing bool `json:"tracing,omitempty"`
+
+	// HeaderUp sets headers on requests sent through the tunnel, replacing
+	// existing values. Values may contain placeholders.
+	HeaderUp http.Header `json:"header_up,omitempty"`
+
+	// NodeHeader is the name of a header set to the name of the node each
+	// request is sent through, replacing any value sent by the client, so
+	// backends can log which tunnel identity reached them. Unset by default.
+	NodeHeader string `json:"node_header,omitempty"`
+
+	// IdleTimeout is how long an idle keep-alive connection through the
+	// tunnel is kept open. Zero means no limit.
+	IdleTimeout caddy.Duration `json:"idle_timeout,omitempty"`
+
+	// WebSocketIdleTimeout closes upgraded connections (e.g. WebSockets)
+	// after no data was sent or received for this long. It is separate from
+	// IdleTimeout so long-lived connections can be given more slack. Zero
+	// means no limit.
+	WebSocketIdleTimeout caddy.Duration `json:"websocket_idle_timeout,omitempty"`
+
+	// ConnIdleTimeout fails a read or write on a connection through the
+	// tunnel that made no progress for this long, so a stuck upstream
+	// can't hold a request forever. The deadline is reset on every read
+	// and write. As it applies to the connection, it also closes idle
+	// keep-alive and upgraded connections after this long, so it should
+	// be larger than IdleTimeout and WebSocketIdleTimeout. Not supported
+	// with HTTP/3, where QUIC has its own idle timeout. Zero means no limit.
+	ConnIdleTimeout caddy.Duration `json:"conn_idle_timeout,omitempty"`
+
+	// FallbackDirect retries failed tunnel dials with a plain dial on the
+	// local network, and sends requests directly when no node is healthy,
+	// for backends reachable both through NetBird and locally. Policies
+	// enforced at dial time, such as disable_relays, are bypassed by the
+	// fallback. Not supported with HTTP/3.
+	FallbackDirect bool `json:"fallback_direct,omitempty"`
+
+	// Sticky routes all requests of a downstream client IP through the same
+	// node, using consistent hashing, for backends that tie sessions to the
+	// NetBird peer identity. If the node becomes unhealthy, its clients move
+	// to other nodes while all other clients stay put.
+	Sticky bool `json:"sticky,omitempty"`
+
+	// WaitForConnect makes provisioning wait up to this long for each node
+	// to connect to the management server. If a node doesn't connect in
+	// time, a warning is logged, or provisioning fails with FailOnDisconnect.
+	WaitForConnect caddy.Duration `json:"wait_for_connect,omitempty"`
+
+	// FailOnDisconnect fails provisioning, and with it the config load, if a
+	// node isn't connected to management within WaitForConnect.
+	FailOnDisconnect bool `json:"fail_on_disconnect,omitempty"`
+
+	// RetryUnavailable turns 503 responses with a Retry-After header into a
+	// RetryAfterError, so Caddy's reverse proxy can retry the request
+	// (subject to its lb_retries and lb_try_duration settings) instead of
+	// passing the response through.
+	RetryUnavailable bool `json:"retry_unavailable,omitempty"`
+
+	// Versions lists the HTTP versions to use with the upstream: "1.1", "2"
+	// and "3". HTTP/3 dials QUIC over UDP through the tunnel and implies
+	// TLS; it can't be combined with other versions. Defaults to HTTP/1.1.
+	Versions []string `json:"versions,omitempty"`
+
+	// FailDuration enables passive health checks: after MaxFails
+	// consecutive failed round trips through a node, requests skip the
+	// node for this long. Like Caddy's passive health checks, but scoped
+	// to the tunnel, so requests move to the other Nodes. If all nodes are
+	// down, requests use them anyway. Only errors count as failures, not
+	// HTTP error statuses.
+	FailDuration caddy.Duration `json:"fail_duration,omitempty"`
+
+	// MaxFails is the number of consecutive failed round trips after
+	// which a node is skipped for FailDuration. Defaults to 1.
+	MaxFails int `json:"max_fails,omitempty"`
+
 	nbApp  *app.App
-	mc     *app.ManagedClient
-	rt     http.RoundTripper
+	nodes  []*tunnelNode
+	ring   *hashRing
+	next   uint64
 	logger *zap.Logger
 	ctx    caddy.Context
+
+	// directDial dials on the local network for FallbackDirect, and
+	// direct sends requests that no node is healthy for.
+	directDial dialFunc
+	direct     *http.Transport
+}
+
+// defaultNodeHeader is the header set by the node_header option without a name.
+const defaultNodeHeader = "X-NetBird-Node"
+
+// tunnelNode is a NetBird client together with the HTTP transport dialing through it.
+type tunnelNode struct {
+	name  string
+	mc    *app.ManagedClient
+	rt    http.RoundTripper
+	fails failTracker
 }
 
 // CaddyModule returns the Caddy module information.
@@ -55,46 +184,295 @@ func (t *Transport) Provision(ctx caddy.Context) error {
 	t.logger = ctx.Logger()
 	t.ctx = ctx
 
-	if t.Node == "" {
-		t.Node = "default"
-	}
-
 	appModule, err := ctx.App("netbird")
 	if err != nil {
 		return fmt.Errorf("load netbird app module: %w", err)
 	}
 	t.nbApp = appModule.(*app.App)
+	if t.Node == "" {
+		t.Node = t.nbApp.DefaultNodeName()
+	}
 
-	t.mc, err = t.nbApp.GetClient(t.Node)
-	if err != nil {
-		return fmt.Errorf("get netbird client %q: %w", t.Node, err)
+	if t.FailOnDisconnect && t.WaitForConnect <= 0 {
+		return errors.New("fail_on_disconnect requires wait_for_connect")
+	}
+	if !t.nbApp.StartsLazily() && (t.WaitForConnect > 0 || len(t.Prewarm) > 0) {
+		return errors.New("wait_for_connect and prewarm can't be used with lazy_start false, as clients aren't started while provisioning")
+	}
+	if err := validateVersions(t.Versions); err != nil {
+		return err
+	}
+	if err := validateALPN(t.TLSALPN, t.Versions); err != nil {
+		return err
+	}
+	if _, err := parseTLSVersion(t.TLSMinVersion); err != nil {
+		return err
+	}
+	if t.FailDuration < 0 || t.MaxFails < 0 {
+		return errors.New("fail_duration and max_fails must not be negative")
+	}
+	if t.MaxFails > 0 && t.FailDuration == 0 {
+		return errors.New("max_fails requires fail_duration")
+	}
+	if t.ConnIdleTimeout < 0 {
+		return errors.New("conn_idle_timeout must not be negative")
+	}
+	if t.NodeHeader != "" && !httpguts.ValidHeaderFieldName(t.NodeHeader) {
+		return fmt.Errorf("invalid node_header name %q", t.NodeHeader)
+	}
+	if t.ConnIdleTimeout > 0 && t.useHTTP3() {
+		return errors.New("conn_idle_timeout can't be used with HTTP/3")
+	}
+	if t.FallbackDirect && t.useHTTP3() {
+		return errors.New("fallback_direct can't be used with HTTP/3")
+	}
+	if t.TLS == nil && t.impliesTLS() {
+		t.TLS = new(reverseproxy.TLSConfig)
 	}
 
-	if err := t.mc.Start(ctx); err != nil {
-		return fmt.Errorf("start netbird client %q: %w", t.Node, err)
+	if t.FallbackDirect && t.directDial == nil {
+		var d net.Dialer
+		t.directDial = d.DialContext
 	}
 
-	ht := &http.Transport{
-		DialContext: t.mc.Client().DialContext,
+	for _, name := range t.nodeNames() {
+		mc, err := t.nbApp.AcquireClient(ctx, t.Tenant, name)
+		if err != nil {
+			return err
+		}
+		// Track the node right away so Cleanup releases the reference
+		// if a later step fails.
+		node := &tunnelNode{name: name, mc: mc}
+		t.nodes = append(t.nodes, node)
+
+		if err := t.awaitConnect(ctx, name, mc.ManagementConnected); err != nil {
+			return err
+		}
+
+		node.rt, err = t.roundTripperFor(ctx, name, mc)
+		if err != nil {
+			return err
+		}
 	}
 
-	if t.TLS != nil {
-		tlsConfig, err := t.TLS.MakeTLSClientConfig(ctx)
+	if t.FallbackDirect {
+		t.direct, err = t.newDirectRoundTripper(ctx)
 		if err != nil {
-			return fmt.Errorf("configure upstream TLS: %w", err)
+			return err
 		}
-		ht.TLSClientConfig = tlsConfig
 	}
 
-	t.rt = ht
+	if t.Sticky {
+		t.ring = newHashRing(t.nodeNames())
+	}
+
+	for _, node := range t.nodes {
+		for _, addr := range t.Prewarm {
+			go t.prewarm(ctx, node, addr)
+		}
+	}
 
 	t.logger.Info("netbird transport provisioned",
-		zap.String("node", t.Node),
+		zap.Strings("nodes", t.nodeNames()),
 		zap.Bool("tls", t.TLS != nil),
 	)
 	return nil
 }
 
+// impliesTLS reports whether options that only apply to TLS are set, so
+// TLS is enabled even if the JSON config has no "tls" object. The
+// Caddyfile enables TLS for these options while parsing.
+func (t *Transport) impliesTLS() bool {
+	return t.useHTTP3() || t.TLSMinVersion != "" || len(t.TLSALPN) > 0
+}
+
+// awaitConnect waits for the node to connect to management if configured.
+// A node that doesn't connect in time fails provisioning with
+// FailOnDisconnect and is only logged otherwise.
+func (t *Transport) awaitConnect(ctx context.Context, name string, connected func() (bool, error)) error {
+	if t.WaitForConnect <= 0 {
+		return nil
+	}
+
+	err := waitConnected(ctx, time.Duration(t.WaitForConnect), connectPollInterval, connected)
+	if err == nil {
+		return nil
+	}
+	if t.FailOnDisconnect {
+		return fmt.Errorf("netbird node %q: %w", name, err)
+	}
+	t.logger.Warn("netbird node not connected, continuing",
+		zap.String("node", name),
+		zap.Error(err),
+	)
+	return nil
+}
+
+// roundTripperFor builds the round tripper for the configured HTTP versions.
+func (t *Transport) roundTripperFor(ctx caddy.Context, name string, mc *app.ManagedClient) (http.RoundTripper, error) {
+	if t.useHTTP3() {
+		return t.newHTTP3RoundTripper(ctx, name, mc)
+	}
+	return t.newRoundTripper(ctx, name, mc)
+}
+
+// newRoundTripper builds the HTTP/1.1 and HTTP/2 transport dialing through mc.
+func (t *Transport) newRoundTripper(ctx caddy.Context, name string, mc *app.ManagedClient) (*http.Transport, error) {
+	tlsConfig, err := t.tlsClientConfig(ctx, mc)
+	if err != nil {
+		return nil, err
+	}
+	return t.httpTransport(trackedDial(t.nbApp.TrackConn, name, httpConnUsage, t.dialer(name, mc)), tlsConfig), nil
+}
+
+// newDirectRoundTripper builds the HTTP/1.1 and HTTP/2 transport dialing
+// on the local network, for requests sent directly with FallbackDirect.
+func (t *Transport) newDirectRoundTripper(ctx caddy.Context) (*http.Transport, error) {
+	var tlsConfig *tls.Config
+	if t.TLS != nil {
+		var err error
+		if tlsConfig, err = t.makeTLSConfig(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return t.httpTransport(t.directDial, tlsConfig), nil
+}
+
+// httpTransport builds an HTTP/1.1 and HTTP/2 transport for the configured
+// versions with conns from dial.
+func (t *Transport) httpTransport(dial dialFunc, tlsConfig *tls.Config) *http.Transport {
+	rt := &http.Transport{
+		DialContext:     deadlineDial(time.Duration(t.ConnIdleTimeout), dial),
+		IdleConnTimeout: time.Duration(t.IdleTimeout),
+		TLSClientConfig: tlsConfig,
+	}
+	switch {
+	case slices.Contains(t.Versions, "2"):
+		rt.ForceAttemptHTTP2 = true
+	case len(t.Versions) > 0:
+		// Only HTTP/1.1: don't negotiate HTTP/2 via ALPN.
+		rt.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return rt
+}
+
+// dialer returns the function dialing through mc, traced if enabled, and
+// falling back to a direct dial if enabled.
+func (t *Transport) dialer(name string, mc *app.ManagedClient) dialFunc {
+	dial := dialFunc(mc.DialContext)
+	if t.Tracing {
+		dial = tracedDial(name, mc, dial)
+	}
+	if t.FallbackDirect {
+		dial = app.FallbackDial(t.logger, name, dial, t.directDial)
+	}
+	return dial
+}
+
+// tlsClientConfig builds the upstream TLS config, or nil if TLS is disabled.
+// The node's session cache is used so that TLS sessions are resumed across
+// all transports using the same node.
+func (t *Transport) tlsClientConfig(ctx caddy.Context, mc *app.ManagedClient) (*tls.Config, error) {
+	if t.TLS == nil {
+		return nil, nil
+	}
+	tlsConfig, err := t.makeTLSConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.ClientSessionCache = mc.TLSSessionCache()
+	return tlsConfig, nil
+}
+
+// makeTLSConfig builds the upstream TLS config from TLS and the transport's
+// own TLS options. t.TLS must not be nil.
+func (t *Transport) makeTLSConfig(ctx caddy.Context) (*tls.Config, error) {
+	tlsConfig, err := t.TLS.MakeTLSClientConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("configure upstream TLS: %w", err)
+	}
+	// An empty TLS config is returned as nil.
+	if tlsConfig == nil {
+		tlsConfig = new(tls.Config)
+	}
+
+	minVersion, err := parseTLSVersion(t.TLSMinVersion)
+	if err != nil {
+		return nil, err
+	}
+	if minVersion != 0 {
+		tlsConfig.MinVersion = minVersion
+	}
+	if len(t.TLSALPN) > 0 {
+		tlsConfig.NextProtos = slices.Clone(t.TLSALPN)
+	}
+	return tlsConfig, nil
+}
+
+// validateALPN rejects offering "h2" via ALPN without HTTP/2 enabled in
+// versions: the HTTP/1.1 transport can't speak HTTP/2, so the connection
+// would break if the upstream picked it. HTTP/3 ignores the list.
+func validateALPN(alpn, versions []string) error {
+	if slices.Contains(alpn, "h2") && !slices.Contains(versions, "2") && !slices.Contains(versions, "3") {
+		return errors.New(`tls_alpn "h2" requires versions to include 2`)
+	}
+	return nil
+}
+
+// parseTLSVersion maps a TLS version string to its crypto/tls constant.
+// An empty string yields 0, leaving the default.
+func parseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "":
+		return 0, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported TLS version %q, must be 1.2 or 1.3", version)
+	}
+}
+
+// prewarm opens a keep-alive connection to addr through the node's tunnel
+// and leaves it in the idle pool. Failures are logged and otherwise ignored.
+func (t *Transport) prewarm(ctx context.Context, node *tunnelNode, addr string) {
+	if err := prewarmConn(ctx, node.rt, t.TLSEnabled(), addr); err != nil {
+		t.logger.Warn("prewarm upstream connection",
+			zap.String("node", node.name),
+			zap.String("upstream", addr),
+			zap.Error(err),
+		)
+		return
+	}
+	t.logger.Debug("prewarmed upstream connection",
+		zap.String("node", node.name),
+		zap.String("upstream", addr),
+	)
+}
+
+// prewarmConn sends a HEAD request to addr so that the connection it used
+// is returned to the idle pool of rt.
+func prewarmConn(ctx context.Context, rt http.RoundTripper, useTLS bool, addr string) error {
+	scheme := "http"
+	if useTLS {
+		scheme = "https"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, scheme+"://"+addr+"/", nil)
+	if err != nil {
+		return fmt.Errorf("build request: %w", err)
+	}
+
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	// Drain so the connection can be reused.
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.Body.Close()
+}
+
 // RoundTrip sends the request through the NetBird network tunnel.
 func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 	if req.URL.Scheme == "" {
@@ -103,7 +481,171 @@ func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 			req.URL.Scheme = "https"
 		}
 	}
-	return t.rt.RoundTrip(req)
+
+	if len(t.HeaderUp) > 0 {
+		req = withHeaders(req, t.HeaderUp)
+	}
+
+	node := t.pickNode(req)
+	if node == nil {
+		if t.direct != nil {
+			t.logger.Warn("no healthy netbird node, sending request directly", zap.String("upstream", req.URL.Host))
+			return t.direct.RoundTrip(req)
+		}
+		return serviceUnavailable(req, errNoHealthyNode), nil
+	}
+	if t.NodeHeader != "" {
+		req = withHeader(req, t.NodeHeader, node.name)
+	}
+
+	var resp *http.Response
+	var err error
+	if t.Tracing {
+		resp, err = tracedRoundTrip(node, req)
+	} else {
+		resp, err = node.rt.RoundTrip(req)
+	}
+	if t.passiveChecks() {
+		t.recordRoundTrip(node, req, err)
+	}
+	if err == nil && t.RetryUnavailable {
+		if retryErr := retryAfterError(resp, time.Now()); retryErr != nil {
+			return nil, retryErr
+		}
+	}
+	if err == nil && t.WebSocketIdleTimeout > 0 {
+		resp = withUpgradeIdleTimeout(resp, time.Duration(t.WebSocketIdleTimeout))
+	}
+	return resp, err
+}
+
+// withHeader returns a shallow copy of req with the header set to value,
+// without expanding placeholders. The original request is not modified.
+func withHeader(req *http.Request, name, value string) *http.Request {
+	out := new(http.Request)
+	*out = *req
+	out.Header = req.Header.Clone()
+	if out.Header == nil {
+		out.Header = make(http.Header)
+	}
+	out.Header.Set(name, value)
+	return out
+}
+
+// withHeaders returns a shallow copy of req with headers set, expanding
+// placeholders with the request's replacer. The original request is not modified.
+func withHeaders(req *http.Request, headers http.Header) *http.Request {
+	repl, ok := req.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+	if !ok {
+		repl = caddy.NewReplacer()
+	}
+
+	out := new(http.Request)
+	*out = *req
+	out.Header = req.Header.Clone()
+	if out.Header == nil {
+		out.Header = make(http.Header)
+	}
+	for name, values := range headers {
+		out.Header.Del(name)
+		for _, v := range values {
+			out.Header.Add(name, repl.ReplaceAll(v, ""))
+		}
+	}
+	return out
+}
+
+// recordRoundTrip counts the outcome of a round trip through node. Requests
+// canceled by the client aren't held against the node.
+func (t *Transport) recordRoundTrip(node *tunnelNode, req *http.Request, err error) {
+	if err != nil && req.Context().Err() != nil {
+		return
+	}
+	if node.fails.record(err != nil, time.Now(), t.maxFails(), time.Duration(t.FailDuration)) {
+		t.logger.Warn("netbird node marked unhealthy after failed round trips",
+			zap.String("node", node.name),
+			zap.Int("max_fails", t.maxFails()),
+			zap.Duration("fail_duration", time.Duration(t.FailDuration)),
+			zap.Error(err),
+		)
+	}
+}
+
+var errNoHealthyNode = errors.New("no healthy netbird node available")
+
+// nodeNames returns the primary node followed by any additional nodes.
+func (t *Transport) nodeNames() []string {
+	return append([]string{t.Node}, t.Nodes...)
+}
+
+// pickNode selects the node for req, skipping nodes the health checker
+// reported as disconnected and nodes marked down by passive health checks.
+// If passive health checks marked all connected nodes down, they are
+// ignored, so a failing upstream behind a single node still gets requests
+// rather than a synthetic 503. Nodes are picked in round-robin order, or by
+// client IP if sticky. It returns nil if none is usable.
+func (t *Transport) pickNode(req *http.Request) *tunnelNode {
+	now := time.Now()
+	connected := func(i int) bool {
+		health, checked := t.nodes[i].mc.Health()
+		return nodeUsable(health, checked)
+	}
+	usable := func(i int) bool {
+		return connected(i) && t.nodes[i].fails.available(now)
+	}
+
+	idx := t.lookupNode(req, usable)
+	if idx < 0 && t.passiveChecks() {
+		idx = t.lookupNode(req, connected)
+	}
+	if idx < 0 {
+		return nil
+	}
+	return t.nodes[idx]
+}
+
+// lookupNode returns the index of the node for req among the usable ones,
+// or -1 if none is usable.
+func (t *Transport) lookupNode(req *http.Request, usable func(int) bool) int {
+	if t.ring != nil {
+		return t.ring.lookup(clientIP(req), usable)
+	}
+	return selectNode(len(t.nodes), atomic.AddUint64(&t.next, 1)-1, usable)
+}
+
+// selectNode returns the index of the first usable node among n nodes,
+// starting the search at offset. It returns -1 if no node is usable.
+func selectNode(n int, offset uint64, usable func(int) bool) int {
+	for i := range n {
+		idx := int((offset + uint64(i)) % uint64(n))
+		if usable(idx) {
+			return idx
+		}
+	}
+	return -1
+}
+
+// nodeUsable reports whether requests may be routed through a node. Nodes
+// that have not been health checked yet are given the benefit of the doubt.
+func nodeUsable(health app.NodeHealth, checked bool) bool {
+	return !checked || health.Healthy()
+}
+
+// serviceUnavailable builds a 503 response for requests that cannot be
+// routed through any node.
+func serviceUnavailable(req *http.Request, err error) *http.Response {
+	body := err.Error() + "\n"
+	return &http.Response{
+		Status:        "503 Service Unavailable",
+		StatusCode:    http.StatusServiceUnavailable,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"text/plain; charset=utf-8"}},
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
 }
 
 // TLSEnabled returns true if upstream TLS is configured.
@@ -125,7 +667,7 @@ func (t *Transport) TLSClientConfig() *tls.Config {
 	if t.TLS == nil {
 		return nil
 	}
-	cfg, err := t.TLS.MakeTLSClientConfig(t.ctx)
+	cfg, err := t.makeTLSConfig(t.ctx)
 	if err != nil {
 		t.logger.Debug("build TLS client config", zap.Error(err))
 		return nil
@@ -133,35 +675,56 @@ func (t *Transport) TLSClientConfig() *tls.Config {
 	return cfg
 }
 
-// Cleanup releases the client reference back to the pool and closes idle connections.
+// Cleanup releases the client references back to the pool and closes idle connections.
 func (t *Transport) Cleanup() error {
-	if ht, ok := t.rt.(*http.Transport); ok {
-		ht.CloseIdleConnections()
+	var errs []error
+	for _, node := range t.nodes {
+		switch rt := node.rt.(type) {
+		case io.Closer:
+			if err := rt.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		case interface{ CloseIdleConnections() }:
+			rt.CloseIdleConnections()
+		}
+		if err := t.nbApp.ReleaseClient(node.name); err != nil {
+			errs = append(errs, err)
+		}
 	}
-	if t.nbApp != nil {
-		return t.nbApp.ReleaseClient(t.Node)
+	if t.direct != nil {
+		t.direct.CloseIdleConnections()
 	}
-	return nil
+	return errors.Join(errs...)
 }
 
 // UnmarshalCaddyfile parses the transport subdirective within a reverse_proxy block.
 //
 //	reverse_proxy <upstream> {
-//	    transport netbird [<node>] {
+//	    transport netbird [<node>...] {
 //	        tls
 //	        tls_insecure_skip_verify
 //	        tls_server_name <name>
+//	        tls_min_version <1.2|1.3>
+//	        tls_alpn <protocol>...
+//	        prewarm <host:port>...
+//	        node_header [<name>]
+//	        fallback_direct
+//	        tenant <name>
+//	        fail_duration <duration>
+//	        max_fails <n>
 //	    }
 //	}
 //
 // TLS to the upstream is also automatically enabled when using https:// upstream
-// addresses, independent of these options.
+// addresses, independent of these options. Listing more than one node
+// spreads requests across them.
 func (t *Transport) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 	d.Next() // consume "netbird"
 
 	if d.NextArg() {
 		t.Node = d.Val()
 	}
+	t.Nodes = append(t.Nodes, d.RemainingArgs()...)
 
 	for d.NextBlock(0) {
 		switch d.Val() {
@@ -185,17 +748,204 @@ func (t *Transport) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 			}
 			t.TLS.ServerName = d.Val()
 
+		case "tls_min_version":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			if _, err := parseTLSVersion(d.Val()); err != nil {
+				return d.Errf("invalid tls_min_version: %v", err)
+			}
+			if t.TLS == nil {
+				t.TLS = new(reverseproxy.TLSConfig)
+			}
+			t.TLSMinVersion = d.Val()
+
+		case "tls_alpn":
+			protos := d.RemainingArgs()
+			if len(protos) == 0 {
+				return d.ArgErr()
+			}
+			if t.TLS == nil {
+				t.TLS = new(reverseproxy.TLSConfig)
+			}
+			t.TLSALPN = append(t.TLSALPN, protos...)
+
+		case "tracing":
+			t.Tracing = true
+
+		case "sticky":
+			t.Sticky = true
+
+		case "retry_unavailable":
+			t.RetryUnavailable = true
+
+		case "versions":
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return d.ArgErr()
+			}
+			for _, v := range args {
+				if v == "h3" {
+					v = "3"
+				}
+				t.Versions = append(t.Versions, v)
+			}
+			if err := validateVersions(t.Versions); err != nil {
+				return d.Err(err.Error())
+			}
+			if t.useHTTP3() && t.TLS == nil {
+				t.TLS = new(reverseproxy.TLSConfig)
+			}
+
+		case "wait_for_connect":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return d.Errf("invalid wait_for_connect: %v", err)
+			}
+			if dur <= 0 {
+				return d.Errf("wait_for_connect must be positive")
+			}
+			t.WaitForConnect = caddy.Duration(dur)
+
+		case "fail_on_disconnect":
+			t.FailOnDisconnect = true
+
+		case "idle_timeout":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return d.Errf("invalid idle_timeout: %v", err)
+			}
+			if dur <= 0 {
+				return d.Errf("idle_timeout must be positive")
+			}
+			t.IdleTimeout = caddy.Duration(dur)
+
+		case "websocket_idle_timeout":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return d.Errf("invalid websocket_idle_timeout: %v", err)
+			}
+			if dur <= 0 {
+				return d.Errf("websocket_idle_timeout must be positive")
+			}
+			t.WebSocketIdleTimeout = caddy.Duration(dur)
+
+		case "conn_idle_timeout":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return d.Errf("invalid conn_idle_timeout: %v", err)
+			}
+			if dur <= 0 {
+				return d.Errf("conn_idle_timeout must be positive")
+			}
+			t.ConnIdleTimeout = caddy.Duration(dur)
+
+		case "fail_duration":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return d.Errf("invalid fail_duration: %v", err)
+			}
+			if dur <= 0 {
+				return d.Errf("fail_duration must be positive")
+			}
+			t.FailDuration = caddy.Duration(dur)
+
+		case "max_fails":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			n, err := strconv.Atoi(d.Val())
+			if err != nil {
+				return d.Errf("invalid max_fails: %v", err)
+			}
+			if n <= 0 {
+				return d.Errf("max_fails must be positive")
+			}
+			t.MaxFails = n
+
+		case "tenant":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			t.Tenant = d.Val()
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+
+		case "fallback_direct":
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			t.FallbackDirect = true
+
+		case "node_header":
+			t.NodeHeader = defaultNodeHeader
+			if d.NextArg() {
+				t.NodeHeader = d.Val()
+			}
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			if !httpguts.ValidHeaderFieldName(t.NodeHeader) {
+				return d.Errf("invalid node_header name %q", t.NodeHeader)
+			}
+
+		case "header_up":
+			var name, value string
+			if !d.Args(&name, &value) {
+				return d.ArgErr()
+			}
+			if t.HeaderUp == nil {
+				t.HeaderUp = make(http.Header)
+			}
+			t.HeaderUp.Add(name, value)
+
+		case "prewarm":
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return d.ArgErr()
+			}
+			t.Prewarm = append(t.Prewarm, args...)
+
 		default:
 			return d.Errf("unrecognized netbird transport option: %s", d.Val())
 		}
 	}
 
+	if t.FailOnDisconnect && t.WaitForConnect <= 0 {
+		return d.Err("fail_on_disconnect requires wait_for_connect")
+	}
+	if t.MaxFails > 0 && t.FailDuration == 0 {
+		return d.Err("max_fails requires fail_duration")
+	}
+	if t.ConnIdleTimeout > 0 && t.useHTTP3() {
+		return d.Err("conn_idle_timeout can't be used with HTTP/3")
+	}
+	if t.FallbackDirect && t.useHTTP3() {
+		return d.Err("fallback_direct can't be used with HTTP/3")
+	}
 	return nil
 }
 
 var (
 	_ http.RoundTripper         = (*Transport)(nil)
 	_ caddy.Provisioner         = (*Transport)(nil)
+	_ caddy.Validator           = (*Transport)(nil)
 	_ caddy.CleanerUpper        = (*Transport)(nil)
 	_ reverseproxy.TLSTransport = (*Transport)(nil)
 	_ caddyfile.Unmarshaler     = (*Transport)(nil)
@@ -1,11 +1,25 @@
 package transport
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp/reverseproxy"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/lixmal/caddy-netbird/app"
 )
 
 func TestUnmarshalCaddyfile_NodeName(t *testing.T) {
@@ -83,3 +97,542 @@ func TestUnmarshalCaddyfile_UnknownOption(t *testing.T) {
 	err := tr.UnmarshalCaddyfile(d)
 	require.Error(t, err)
 }
+
+func TestUnmarshalCaddyfile_MultipleNodes(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`netbird web1 web2 web3`)
+
+	var tr Transport
+	require.NoError(t, tr.UnmarshalCaddyfile(d))
+	assert.Equal(t, "web1", tr.Node)
+	assert.Equal(t, []string{"web2", "web3"}, tr.Nodes)
+	assert.Equal(t, []string{"web1", "web2", "web3"}, tr.nodeNames())
+}
+
+func TestNodeUsable(t *testing.T) {
+	tests := []struct {
+		name    string
+		health  app.NodeHealth
+		checked bool
+		want    bool
+	}{
+		{"not checked yet", app.NodeHealth{}, false, true},
+		{"healthy", app.NodeHealth{ManagementConnected: true, SignalConnected: true}, true, true},
+		{"management down", app.NodeHealth{SignalConnected: true}, true, false},
+		{"signal down", app.NodeHealth{ManagementConnected: true}, true, false},
+		{"both down", app.NodeHealth{}, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, nodeUsable(tt.health, tt.checked))
+		})
+	}
+}
+
+func TestSelectNode(t *testing.T) {
+	tests := []struct {
+		name    string
+		healthy []bool
+		offset  uint64
+		want    int
+	}{
+		{"all healthy picks offset", []bool{true, true, true}, 1, 1},
+		{"offset wraps around", []bool{true, true, true}, 4, 1},
+		{"skips unhealthy node", []bool{true, false, true}, 1, 2},
+		{"wraps past unhealthy tail", []bool{true, false, false}, 1, 0},
+		{"single healthy among many", []bool{false, false, true, false}, 0, 2},
+		{"none healthy", []bool{false, false}, 0, -1},
+		{"no nodes", nil, 0, -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := selectNode(len(tt.healthy), tt.offset, func(i int) bool {
+				return tt.healthy[i]
+			})
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRoundTrip_NoHealthyNode(t *testing.T) {
+	tr := &Transport{}
+	req := httptest.NewRequest(http.MethodGet, "http://backend:8080/", nil)
+
+	resp, err := tr.RoundTrip(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), errNoHealthyNode.Error())
+}
+
+func TestUnmarshalCaddyfile_Prewarm(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`netbird mynode {
+		prewarm backend:8080
+		prewarm api:9000 api:9001
+	}`)
+
+	var tr Transport
+	require.NoError(t, tr.UnmarshalCaddyfile(d))
+	assert.Equal(t, []string{"backend:8080", "api:9000", "api:9001"}, tr.Prewarm)
+}
+
+func TestUnmarshalCaddyfile_PrewarmMissingArg(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`netbird mynode {
+		prewarm
+	}`)
+
+	var tr Transport
+	require.Error(t, tr.UnmarshalCaddyfile(d))
+}
+
+func TestPrewarmConn(t *testing.T) {
+	var newConns atomic.Int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodHead, r.Method)
+		w.WriteHeader(http.StatusOK)
+	}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			newConns.Add(1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	rt := &http.Transport{}
+	defer rt.CloseIdleConnections()
+
+	addr := strings.TrimPrefix(srv.URL, "http://")
+	require.NoError(t, prewarmConn(context.Background(), rt, false, addr))
+	assert.Equal(t, int32(1), newConns.Load())
+
+	// A subsequent request reuses the prewarmed connection.
+	resp, err := rt.RoundTrip(httptest.NewRequest(http.MethodHead, srv.URL+"/", nil))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, int32(1), newConns.Load(), "request should reuse the idle connection")
+}
+
+func TestPrewarmConn_Unreachable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	require.NoError(t, ln.Close())
+
+	require.Error(t, prewarmConn(context.Background(), &http.Transport{}, false, addr))
+}
+
+func TestNewRoundTripper_SharedTLSSessionCache(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	mc := &app.ManagedClient{}
+	other := &app.ManagedClient{}
+
+	first := &Transport{TLS: &reverseproxy.TLSConfig{}}
+	second := &Transport{TLS: &reverseproxy.TLSConfig{ServerName: "backend.internal"}}
+
+	rt1, err := first.newRoundTripper(ctx, "web", mc)
+	require.NoError(t, err)
+	rt2, err := second.newRoundTripper(ctx, "web", mc)
+	require.NoError(t, err)
+	rt3, err := first.newRoundTripper(ctx, "api", other)
+	require.NoError(t, err)
+
+	require.NotNil(t, rt1.TLSClientConfig.ClientSessionCache)
+	assert.Same(t, rt1.TLSClientConfig.ClientSessionCache, rt2.TLSClientConfig.ClientSessionCache, "same node should share the cache")
+	assert.NotSame(t, rt1.TLSClientConfig.ClientSessionCache, rt3.TLSClientConfig.ClientSessionCache, "different nodes should not share the cache")
+}
+
+func TestNewRoundTripper_NoTLS(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	rt, err := (&Transport{}).newRoundTripper(ctx, "web", &app.ManagedClient{})
+	require.NoError(t, err)
+	assert.Nil(t, rt.TLSClientConfig)
+	assert.NotNil(t, rt.DialContext)
+}
+
+func TestUnmarshalCaddyfile_Tracing(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`netbird {
+		tracing
+	}`)
+	var tr Transport
+	require.NoError(t, tr.UnmarshalCaddyfile(d))
+	assert.True(t, tr.Tracing)
+}
+
+func TestUnmarshalCaddyfile_HeaderUp(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`netbird {
+		header_up X-Internal-Token secret123
+		header_up X-Client-IP {http.request.remote.host}
+		header_up X-Multi a
+		header_up X-Multi b
+	}`)
+	var tr Transport
+	require.NoError(t, tr.UnmarshalCaddyfile(d))
+	assert.Equal(t, "secret123", tr.HeaderUp.Get("X-Internal-Token"))
+	assert.Equal(t, "{http.request.remote.host}", tr.HeaderUp.Get("X-Client-IP"))
+	assert.Equal(t, []string{"a", "b"}, tr.HeaderUp.Values("X-Multi"))
+}
+
+func TestUnmarshalCaddyfile_HeaderUpMissingValue(t *testing.T) {
+	for _, input := range []string{
+		"netbird {\n header_up X-Token\n}",
+		"netbird {\n header_up X-Token a b\n}",
+	} {
+		d := caddyfile.NewTestDispenser(input)
+		var tr Transport
+		assert.Error(t, tr.UnmarshalCaddyfile(d), input)
+	}
+}
+
+func TestRoundTrip_HeaderUp(t *testing.T) {
+	received := make(chan http.Header, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+	}))
+	defer backend.Close()
+
+	tr := &Transport{
+		HeaderUp: http.Header{
+			"X-Internal-Token": {"secret123"},
+			"X-Request-Path":   {"{http.request.uri.path}"},
+		},
+		nodes: []*tunnelNode{{name: "web", mc: &app.ManagedClient{}, rt: &http.Transport{}}},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, backend.URL+"/api/items", nil)
+	req.RequestURI = ""
+	req.Header.Set("X-Internal-Token", "client-supplied")
+	repl := caddy.NewReplacer()
+	repl.Set("http.request.uri.path", "/api/items")
+	req = req.WithContext(context.WithValue(req.Context(), caddy.ReplacerCtxKey, repl))
+
+	resp, err := tr.RoundTrip(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	headers := <-received
+	assert.Equal(t, []string{"secret123"}, headers.Values("X-Internal-Token"), "configured value replaces the client's")
+	assert.Equal(t, "/api/items", headers.Get("X-Request-Path"))
+	assert.Equal(t, "client-supplied", req.Header.Get("X-Internal-Token"), "original request is not modified")
+}
+
+func TestUnmarshalCaddyfile_Tenant(t *testing.T) {
+	var tr Transport
+	require.NoError(t, tr.UnmarshalCaddyfile(caddyfile.NewTestDispenser("netbird acme-web {\n tenant acme\n}")))
+	assert.Equal(t, "acme", tr.Tenant)
+	assert.Equal(t, "acme-web", tr.Node)
+
+	for _, input := range []string{
+		"netbird {\n tenant\n}",
+		"netbird {\n tenant acme globex\n}",
+	} {
+		var tr Transport
+		assert.Error(t, tr.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)), input)
+	}
+}
+
+func TestUnmarshalCaddyfile_NodeHeader(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"netbird {\n node_header\n}", "X-NetBird-Node"},
+		{"netbird {\n node_header X-Tunnel-Identity\n}", "X-Tunnel-Identity"},
+	}
+	for _, tt := range tests {
+		var tr Transport
+		require.NoError(t, tr.UnmarshalCaddyfile(caddyfile.NewTestDispenser(tt.input)), tt.input)
+		assert.Equal(t, tt.want, tr.NodeHeader)
+	}
+
+	for _, input := range []string{
+		"netbird {\n node_header X-A X-B\n}",
+		"netbird {\n node_header \"Bad Header\"\n}",
+	} {
+		var tr Transport
+		assert.Error(t, tr.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)), input)
+	}
+}
+
+func TestRoundTrip_NodeHeader(t *testing.T) {
+	received := make(map[string]http.Header)
+	capture := func(node string) http.RoundTripper {
+		return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			received[node] = req.Header.Clone()
+			return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+		})
+	}
+
+	tr := &Transport{
+		NodeHeader: "X-NetBird-Node",
+		nodes: []*tunnelNode{
+			{name: "web", mc: &app.ManagedClient{}, rt: capture("web")},
+			{name: "api", mc: &app.ManagedClient{}, rt: capture("api")},
+		},
+	}
+
+	for range 2 {
+		req := httptest.NewRequest(http.MethodGet, "http://backend.netbird.cloud/", nil)
+		req.Header.Set("X-NetBird-Node", "spoofed")
+		resp, err := tr.RoundTrip(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, "spoofed", req.Header.Get("X-NetBird-Node"), "original request is not modified")
+	}
+
+	require.Len(t, received, 2, "requests should be spread over both nodes")
+	for node, headers := range received {
+		assert.Equal(t, []string{node}, headers.Values("X-NetBird-Node"), "header names the node, replacing the client's value")
+	}
+}
+
+func TestRoundTrip_NoNodeHeaderByDefault(t *testing.T) {
+	var headers http.Header
+	tr := &Transport{
+		nodes: []*tunnelNode{{name: "web", mc: &app.ManagedClient{}, rt: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			headers = req.Header.Clone()
+			return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+		})}},
+	}
+
+	resp, err := tr.RoundTrip(httptest.NewRequest(http.MethodGet, "http://backend.netbird.cloud/", nil))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Empty(t, headers.Get("X-NetBird-Node"))
+}
+
+func TestUnmarshalCaddyfile_IdleTimeouts(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`netbird {
+		idle_timeout 30s
+		websocket_idle_timeout 1h
+	}`)
+	var tr Transport
+	require.NoError(t, tr.UnmarshalCaddyfile(d))
+	assert.Equal(t, 30*time.Second, time.Duration(tr.IdleTimeout))
+	assert.Equal(t, time.Hour, time.Duration(tr.WebSocketIdleTimeout))
+}
+
+func TestUnmarshalCaddyfile_IdleTimeoutsInvalid(t *testing.T) {
+	for _, input := range []string{
+		"netbird {\n idle_timeout\n}",
+		"netbird {\n idle_timeout bogus\n}",
+		"netbird {\n idle_timeout 0s\n}",
+		"netbird {\n websocket_idle_timeout\n}",
+		"netbird {\n websocket_idle_timeout -1m\n}",
+	} {
+		d := caddyfile.NewTestDispenser(input)
+		var tr Transport
+		assert.Error(t, tr.UnmarshalCaddyfile(d), input)
+	}
+}
+
+func TestNewRoundTripper_IdleTimeout(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	rt, err := (&Transport{IdleTimeout: caddy.Duration(30 * time.Second)}).newRoundTripper(ctx, "web", &app.ManagedClient{})
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Second, rt.IdleConnTimeout)
+}
+
+func TestUnmarshalCaddyfile_TLSMinVersion(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`netbird {
+		tls_min_version 1.3
+	}`)
+
+	var tr Transport
+	require.NoError(t, tr.UnmarshalCaddyfile(d))
+	require.NotNil(t, tr.TLS, "tls_min_version should enable TLS")
+	assert.Equal(t, "1.3", tr.TLSMinVersion)
+}
+
+func TestUnmarshalCaddyfile_TLSMinVersionInvalid(t *testing.T) {
+	for _, input := range []string{
+		"netbird {\n tls_min_version\n}",
+		"netbird {\n tls_min_version 1.1\n}",
+		"netbird {\n tls_min_version tls13\n}",
+	} {
+		var tr Transport
+		assert.Error(t, tr.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)), input)
+	}
+}
+
+func TestNewRoundTripper_TLSMinVersion(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	tests := []struct {
+		version string
+		want    uint16
+	}{
+		{"", 0},
+		{"1.2", tls.VersionTLS12},
+		{"1.3", tls.VersionTLS13},
+	}
+	for _, tt := range tests {
+		tr := &Transport{TLS: &reverseproxy.TLSConfig{}, TLSMinVersion: tt.version}
+		rt, err := tr.newRoundTripper(ctx, "web", &app.ManagedClient{})
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, rt.TLSClientConfig.MinVersion, tt.version)
+	}
+
+	_, err := (&Transport{TLS: &reverseproxy.TLSConfig{}, TLSMinVersion: "1.0"}).newRoundTripper(ctx, "web", &app.ManagedClient{})
+	assert.Error(t, err)
+}
+
+func TestUnmarshalCaddyfile_TLSALPN(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`netbird {
+		tls_alpn h2 http/1.1
+		tls_alpn acme-tls/1
+	}`)
+
+	var tr Transport
+	require.NoError(t, tr.UnmarshalCaddyfile(d))
+	require.NotNil(t, tr.TLS, "tls_alpn should enable TLS")
+	assert.Equal(t, []string{"h2", "http/1.1", "acme-tls/1"}, tr.TLSALPN)
+}
+
+func TestUnmarshalCaddyfile_TLSALPNMissingArg(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`netbird {
+		tls_alpn
+	}`)
+
+	var tr Transport
+	assert.Error(t, tr.UnmarshalCaddyfile(d))
+}
+
+func TestValidateALPN(t *testing.T) {
+	tests := []struct {
+		alpn     []string
+		versions []string
+		wantErr  bool
+	}{
+		{nil, nil, false},
+		{[]string{"http/1.1"}, nil, false},
+		{[]string{"h2", "http/1.1"}, nil, true},
+		{[]string{"h2"}, []string{"1.1"}, true},
+		{[]string{"h2", "http/1.1"}, []string{"1.1", "2"}, false},
+		{[]string{"h2"}, []string{"3"}, false},
+	}
+	for _, tt := range tests {
+		err := validateALPN(tt.alpn, tt.versions)
+		if tt.wantErr {
+			assert.Error(t, err, "%v %v", tt.alpn, tt.versions)
+		} else {
+			assert.NoError(t, err, "%v %v", tt.alpn, tt.versions)
+		}
+	}
+}
+
+func TestNewRoundTripper_TLSALPN(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	tr := &Transport{TLS: &reverseproxy.TLSConfig{}, TLSALPN: []string{"custom/1", "http/1.1"}}
+	rt, err := tr.newRoundTripper(ctx, "web", &app.ManagedClient{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"custom/1", "http/1.1"}, rt.TLSClientConfig.NextProtos)
+
+	rt, err = (&Transport{TLS: &reverseproxy.TLSConfig{}}).newRoundTripper(ctx, "web", &app.ManagedClient{})
+	require.NoError(t, err)
+	assert.Empty(t, rt.TLSClientConfig.NextProtos)
+}
+
+func TestTransport_JSONRoundTrip(t *testing.T) {
+	want := Transport{
+		Node:  "ingress",
+		Nodes: []string{"ingress-b"},
+		TLS: &reverseproxy.TLSConfig{
+			ServerName:         "backend.internal",
+			InsecureSkipVerify: true,
+			RootCAPEMFiles:     []string{"/etc/ssl/backend.pem"},
+		},
+		TLSMinVersion:        "1.3",
+		TLSALPN:              []string{"h2"},
+		Prewarm:              []string{"10.0.0.1:443"},
+		Tracing:              true,
+		HeaderUp:             http.Header{"X-Node": []string{"ingress"}},
+		IdleTimeout:          caddy.Duration(30 * time.Second),
+		WebSocketIdleTimeout: caddy.Duration(time.Hour),
+		Sticky:               true,
+		WaitForConnect:       caddy.Duration(10 * time.Second),
+		FailOnDisconnect:     true,
+		RetryUnavailable:     true,
+		Versions:             []string{"1.1", "2"},
+	}
+
+	data, err := json.Marshal(want)
+	require.NoError(t, err)
+
+	var got Transport
+	require.NoError(t, json.Unmarshal(data, &got))
+	assert.Equal(t, want, got)
+}
+
+func TestTransport_JSONFieldNames(t *testing.T) {
+	var tr Transport
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"node": "ingress",
+		"tls": {"server_name": "backend.internal", "insecure_skip_verify": true},
+		"tls_min_version": "1.2",
+		"tls_alpn": ["http/1.1"],
+		"idle_timeout": "1m",
+		"versions": ["2"]
+	}`), &tr))
+
+	assert.Equal(t, "ingress", tr.Node)
+	require.NotNil(t, tr.TLS)
+	assert.Equal(t, "backend.internal", tr.TLS.ServerName)
+	assert.True(t, tr.TLS.InsecureSkipVerify)
+	assert.Equal(t, "1.2", tr.TLSMinVersion)
+	assert.Equal(t, []string{"http/1.1"}, tr.TLSALPN)
+	assert.Equal(t, caddy.Duration(time.Minute), tr.IdleTimeout)
+	assert.Equal(t, []string{"2"}, tr.Versions)
+}
+
+func TestTransport_JSONEmptyTLS(t *testing.T) {
+	data, err := json.Marshal(Transport{TLS: &reverseproxy.TLSConfig{}})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"tls":{}}`, string(data), "an empty tls object enables TLS and must be kept")
+
+	var tr Transport
+	require.NoError(t, json.Unmarshal(data, &tr))
+	assert.NotNil(t, tr.TLS)
+}
+
+func TestUnmarshalCaddyfile_JSONRoundTrip(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`netbird ingress {
+		tls_server_name backend.internal
+		tls_insecure_skip_verify
+		tls_min_version 1.3
+		tls_alpn h2
+		header_up X-Node ingress
+		idle_timeout 30s
+		versions 1.1 2
+	}`)
+
+	var want Transport
+	require.NoError(t, want.UnmarshalCaddyfile(d))
+
+	data, err := json.Marshal(want)
+	require.NoError(t, err)
+	var got Transport
+	require.NoError(t, json.Unmarshal(data, &got))
+	assert.Equal(t, want, got)
+}
+
+func TestImpliesTLS(t *testing.T) {
+	assert.False(t, (&Transport{}).impliesTLS())
+	assert.False(t, (&Transport{Versions: []string{"2"}}).impliesTLS())
+	assert.True(t, (&Transport{Versions: []string{"3"}}).impliesTLS())
+	assert.True(t, (&Transport{TLSMinVersion: "1.3"}).impliesTLS(), "tls_min_version set via JSON without a tls object")
+	assert.True(t, (&Transport{TLSALPN: []string{"h2"}}).impliesTLS(), "tls_alpn set via JSON without a tls object")
+}
@@ -0,0 +1,71 @@
+package transport
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// withUpgradeIdleTimeout wraps the connection of an upgraded response (e.g.
+// a WebSocket) so that it is closed after timeout without traffic. Other
+// responses are returned unchanged.
+func withUpgradeIdleTimeout(resp *http.Response, timeout time.Duration) *http.Response {
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return resp
+	}
+	rwc, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		return resp
+	}
+	resp.Body = newIdleTimeoutConn(rwc, timeout)
+	return resp
+}
+
+// idleTimeoutConn closes the wrapped connection once no data was read or
+// written for the idle timeout.
+type idleTimeoutConn struct {
+	io.ReadWriteCloser
+	timeout time.Duration
+	timer   *time.Timer
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func newIdleTimeoutConn(rwc io.ReadWriteCloser, timeout time.Duration) *idleTimeoutConn {
+	c := &idleTimeoutConn{ReadWriteCloser: rwc, timeout: timeout}
+	// Close reads the timer, so assign it before the callback can run.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.timer = time.AfterFunc(timeout, func() { _ = c.Close() })
+	return c
+}
+
+func (c *idleTimeoutConn) Read(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(p)
+	if n > 0 {
+		c.timer.Reset(c.timeout)
+	}
+	return n, err
+}
+
+func (c *idleTimeoutConn) Write(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Write(p)
+	if n > 0 {
+		c.timer.Reset(c.timeout)
+	}
+	return n, err
+}
+
+// Close stops the idle timer and closes the connection once.
+func (c *idleTimeoutConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	c.timer.Stop()
+	return c.ReadWriteCloser.Close()
+}
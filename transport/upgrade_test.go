@@ -0,0 +1,157 @@
+package transport
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lixmal/caddy-netbird/app"
+)
+
+func TestWithUpgradeIdleTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	tests := []struct {
+		name        string
+		resp        *http.Response
+		wantWrapped bool
+	}{
+		{
+			name: "normal response",
+			resp: &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("ok"))},
+		},
+		{
+			name: "switching protocols without read-write body",
+			resp: &http.Response{StatusCode: http.StatusSwitchingProtocols, Body: io.NopCloser(strings.NewReader(""))},
+		},
+		{
+			name:        "upgraded connection",
+			resp:        &http.Response{StatusCode: http.StatusSwitchingProtocols, Body: client},
+			wantWrapped: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := tt.resp.Body
+			resp := withUpgradeIdleTimeout(tt.resp, time.Minute)
+
+			conn, wrapped := resp.Body.(*idleTimeoutConn)
+			assert.Equal(t, tt.wantWrapped, wrapped)
+			if wrapped {
+				defer conn.Close()
+				assert.Equal(t, body, conn.ReadWriteCloser)
+			} else {
+				assert.Equal(t, body, resp.Body)
+			}
+		})
+	}
+}
+
+func TestIdleTimeoutConn_ClosesWhenIdle(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	conn := newIdleTimeoutConn(client, 50*time.Millisecond)
+
+	_, err := conn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.ErrClosedPipe, "idle connection should be closed")
+}
+
+func TestIdleTimeoutConn_ActivityResetsTimer(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	conn := newIdleTimeoutConn(client, 100*time.Millisecond)
+	defer conn.Close()
+
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if _, err := server.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	for range 5 {
+		time.Sleep(40 * time.Millisecond)
+		_, err := conn.Write([]byte("x"))
+		require.NoError(t, err, "active connection should stay open")
+	}
+}
+
+func TestIdleTimeoutConn_CloseTwice(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	conn := newIdleTimeoutConn(client, time.Minute)
+	require.NoError(t, conn.Close())
+	assert.NoError(t, conn.Close())
+}
+
+func TestRoundTrip_WebSocketIdleTimeout(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "websocket" {
+			_, _ = io.WriteString(w, "plain")
+			return
+		}
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+		_ = buf.Flush()
+		_, _ = io.Copy(io.Discard, bufio.NewReader(conn))
+	}))
+	defer backend.Close()
+
+	tr := &Transport{
+		WebSocketIdleTimeout: caddy.Duration(50 * time.Millisecond),
+		nodes:                []*tunnelNode{{name: "web", mc: &app.ManagedClient{}, rt: &http.Transport{}}},
+	}
+
+	t.Run("upgrade", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, backend.URL+"/ws", nil)
+		req.RequestURI = ""
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+
+		resp, err := tr.RoundTrip(req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+		conn, ok := resp.Body.(*idleTimeoutConn)
+		require.True(t, ok, "upgraded connection should get the websocket idle timeout")
+
+		_, err = conn.Read(make([]byte, 1))
+		assert.Error(t, err, "idle upgraded connection should be closed")
+	})
+
+	t.Run("normal request", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, backend.URL+"/", nil)
+		req.RequestURI = ""
+
+		resp, err := tr.RoundTrip(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		_, wrapped := resp.Body.(*idleTimeoutConn)
+		assert.False(t, wrapped, "normal responses keep the standard behavior")
+
+		time.Sleep(100 * time.Millisecond)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "plain", string(body))
+	})
+}
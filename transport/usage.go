@@ -0,0 +1,40 @@
+package transport
+
+import (
+	"context"
+	"net"
+
+	"github.com/lixmal/caddy-netbird/app"
+)
+
+// httpConnUsage approximates the resources of an HTTP/1.1 upstream
+// connection: the read and write loop goroutines of net/http and their
+// default 4 KiB buffers.
+var httpConnUsage = app.ConnUsage{Goroutines: 2, BufferBytes: 8 << 10}
+
+// trackConnFunc records an open conn and returns a func to call once it is
+// closed, like app.App.TrackConn.
+type trackConnFunc func(node string, usage app.ConnUsage) (done func())
+
+// trackedDial records the conns dialed through node with track until they
+// are closed, so they are counted in the node's resource usage.
+func trackedDial(track trackConnFunc, node string, usage app.ConnUsage, dial dialFunc) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &trackedConn{Conn: conn, done: track(node, usage)}, nil
+	}
+}
+
+// trackedConn reports its closing to the usage tracker.
+type trackedConn struct {
+	net.Conn
+	done func()
+}
+
+func (c *trackedConn) Close() error {
+	c.done()
+	return c.Conn.Close()
+}
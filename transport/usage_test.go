@@ -0,0 +1,60 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lixmal/caddy-netbird/app"
+)
+
+// countingTracker counts the open conns per node.
+type countingTracker map[string]int
+
+func (c countingTracker) track(node string, _ app.ConnUsage) func() {
+	c[node]++
+	return func() { c[node]-- }
+}
+
+func TestTrackedDial(t *testing.T) {
+	open := countingTracker{}
+	var inner []net.Conn
+	dial := trackedDial(open.track, "web", httpConnUsage, func(context.Context, string, string) (net.Conn, error) {
+		client, server := net.Pipe()
+		t.Cleanup(func() { _ = server.Close() })
+		inner = append(inner, client)
+		return client, nil
+	})
+
+	connA, err := dial(context.Background(), "tcp", "10.0.0.1:80")
+	require.NoError(t, err)
+	connB, err := dial(context.Background(), "tcp", "10.0.0.1:80")
+	require.NoError(t, err)
+	assert.Equal(t, 2, open["web"])
+
+	require.NoError(t, connA.Close())
+	assert.Equal(t, 1, open["web"])
+	_, err = inner[0].Write([]byte("x"))
+	assert.ErrorIs(t, err, io.ErrClosedPipe, "inner conn should be closed")
+
+	require.NoError(t, connB.Close())
+	assert.Equal(t, 0, open["web"])
+}
+
+func TestTrackedDial_Error(t *testing.T) {
+	open := countingTracker{}
+	dialErr := errors.New("unreachable")
+	dial := trackedDial(open.track, "web", httpConnUsage, func(context.Context, string, string) (net.Conn, error) {
+		return nil, dialErr
+	})
+
+	conn, err := dial(context.Background(), "tcp", "10.0.0.1:80")
+	assert.ErrorIs(t, err, dialErr)
+	assert.Nil(t, conn)
+	assert.Zero(t, open["web"], "failed dials should not be counted")
+}
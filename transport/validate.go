@@ -0,0 +1,37 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp/reverseproxy"
+
+	"github.com/lixmal/caddy-netbird/app"
+)
+
+// Validate checks that the transport's nodes are defined in the app config
+// and that its TLS options don't contradict each other.
+func (t *Transport) Validate() error {
+	var errs []error
+	for _, name := range t.nodeNames() {
+		if t.nbApp != nil && !t.nbApp.HasNode(name) {
+			errs = append(errs, fmt.Errorf("node %q: %w", name, app.ErrUnknownNode))
+		}
+	}
+	if err := validateTLS(t.TLS); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// validateTLS rejects skipping certificate verification together with a
+// trusted CA, as the CA would silently go unused.
+func validateTLS(cfg *reverseproxy.TLSConfig) error {
+	if cfg == nil || !cfg.InsecureSkipVerify {
+		return nil
+	}
+	if len(cfg.CARaw) > 0 || len(cfg.RootCAPool) > 0 || len(cfg.RootCAPEMFiles) > 0 {
+		return errors.New("tls_insecure_skip_verify can't be combined with a trusted CA")
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package transport
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp/reverseproxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lixmal/caddy-netbird/app"
+)
+
+func TestValidate_Nodes(t *testing.T) {
+	nbApp := &app.App{Nodes: map[string]*app.Node{"web": {}, "edge": {}}}
+
+	tr := Transport{Node: "web", Nodes: []string{"edge", "default"}, nbApp: nbApp}
+	assert.NoError(t, tr.Validate())
+
+	tr = Transport{Node: "web", Nodes: []string{"cache", "db"}, nbApp: nbApp}
+	err := tr.Validate()
+	require.ErrorIs(t, err, app.ErrUnknownNode)
+	assert.ErrorContains(t, err, `node "cache"`)
+	assert.ErrorContains(t, err, `node "db"`)
+	assert.NotContains(t, err.Error(), `node "web"`)
+}
+
+func TestValidate_TLS(t *testing.T) {
+	tests := []struct {
+		name    string
+		tls     *reverseproxy.TLSConfig
+		wantErr bool
+	}{
+		{name: "no tls"},
+		{name: "defaults", tls: &reverseproxy.TLSConfig{}},
+		{name: "insecure", tls: &reverseproxy.TLSConfig{InsecureSkipVerify: true}},
+		{name: "trusted ca", tls: &reverseproxy.TLSConfig{RootCAPEMFiles: []string{"/etc/ssl/internal.pem"}}},
+		{
+			name:    "insecure with ca pem files",
+			tls:     &reverseproxy.TLSConfig{InsecureSkipVerify: true, RootCAPEMFiles: []string{"/etc/ssl/internal.pem"}},
+			wantErr: true,
+		},
+		{
+			name:    "insecure with ca pool",
+			tls:     &reverseproxy.TLSConfig{InsecureSkipVerify: true, RootCAPool: []string{"MIIB"}},
+			wantErr: true,
+		},
+		{
+			name:    "insecure with ca module",
+			tls:     &reverseproxy.TLSConfig{InsecureSkipVerify: true, CARaw: json.RawMessage(`{"provider":"file"}`)},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := Transport{Node: "default", TLS: tt.tls}
+			err := tr.Validate()
+			if tt.wantErr {
+				assert.ErrorContains(t, err, "tls_insecure_skip_verify")
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}